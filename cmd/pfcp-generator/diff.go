@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+
+	"pfcp-generator/internal/session"
+)
+
+func newDiffCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Show how each request in the pcap would be modified",
+		Long: `Run every request in the pcap through the modification pipeline, as in
+--dry-run, and print a diff of each message's IE tree before and after
+modification: SEIDs, UE IPs, Node ID, sequence numbers and any other
+rewritten IEs. No network traffic is sent.`,
+		Args: cobra.NoArgs,
+		RunE: runDiff,
+	}
+
+	// The subset of root flags that affect modification
+	cmd.Flags().StringVar(&cfgFile, "config", "", "Configuration file path (default: config.yaml)")
+	cmd.Flags().String("pcap", "", "Input PCAP file path, or a comma-separated list replayed in order")
+	cmd.Flags().String("script", "", "Build the requests from a YAML/JSON script instead of a pcap")
+	cmd.Flags().String("smf-ip", "", "Local SMF IP address")
+	cmd.Flags().String("smf-interface", "", "Use the address of this network interface as the SMF IP")
+	cmd.Flags().String("ue-pool", "", "UE IPv4 address pool (CIDR)")
+	cmd.Flags().Uint64("seid-start", 0, "Starting SEID value")
+	cmd.Flags().String("seid-strategy", "", "SEID allocation strategy (sequential|random)")
+	cmd.Flags().String("log-level", "", "Log level (debug|info|warn|error)")
+	cmd.Flags().Int("pfcp-port", 0, "UDP port carrying PFCP in the input pcap")
+	cmd.Flags().Bool("no-association", false, "Disable PFCP Association Setup")
+	cmd.Flags().Bool("strip-ipv6", true, "Strip IPv6 from UE IP Address IEs")
+	cmd.Flags().Bool("stream", false, "Replay while reading the pcap instead of loading it into memory")
+	cmd.Flags().StringSlice("only", nil, "Replay only these message types, e.g. SessionEstablishmentRequest")
+	cmd.Flags().StringSlice("skip", nil, "Do not replay these message types, e.g. HeartbeatRequest")
+	return cmd
+}
+
+func runDiff(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+	setupLogging(cfg)
+
+	if err := resolveSMFInterface(cfg); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	parser := newParser(cfg)
+	if err := inferEndpoints(ctx, cfg, parser); err != nil {
+		return err
+	}
+
+	if err := cfg.ValidateDryRun(); err != nil {
+		return err
+	}
+	parseResult, err := parsePcap(ctx, cfg, parser)
+	if err != nil {
+		return err
+	}
+
+	return runDryRun(ctx, cfg, parser, parseResult, session.DryRunDiff)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/wmnsk/go-pfcp/message"
+
+	"pfcp-generator/internal/pcap"
+	"pfcp-generator/internal/pfcp"
+)
+
+func newDumpCmd() *cobra.Command {
+	var pcapFile, msgType string
+	var pfcpPort int
+
+	cmd := &cobra.Command{
+		Use:   "dump [hex]",
+		Short: "Print the header and IE tree of a PFCP message",
+		Long: `Decode a single PFCP message and print its header and IE tree. The message
+is given as a hex string, or read from a pcap with --pcap: the first request
+in the pcap, or the first request of the type given with --type.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var msg message.Message
+			var err error
+			switch {
+			case len(args) == 1 && pcapFile == "":
+				msg, err = decodeHex(args[0])
+			case len(args) == 0 && pcapFile != "":
+				msg, err = firstRequest(pcapFile, uint16(pfcpPort), msgType)
+			default:
+				return fmt.Errorf("specify either a hex string or --pcap")
+			}
+			if err != nil {
+				return err
+			}
+
+			fmt.Print(pfcp.DumpMessage(msg))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&pcapFile, "pcap", "", "Read the message from a pcap file")
+	cmd.Flags().StringVar(&msgType, "type", "", "Message type to dump from the pcap, e.g. SessionEstablishmentRequest")
+	cmd.Flags().IntVar(&pfcpPort, "pfcp-port", 8805, "UDP port carrying PFCP in the pcap")
+	return cmd
+}
+
+// decodeHex decodes a PFCP message from a hex string. Whitespace, colons and a
+// 0x prefix are ignored, so Wireshark's "Copy as Hex Stream" and hex dumps both work.
+func decodeHex(s string) (message.Message, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "0x")
+	s = strings.Map(func(r rune) rune {
+		if r == ':' || r == ' ' || r == '\n' || r == '\t' {
+			return -1
+		}
+		return r
+	}, s)
+
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex string: %w", err)
+	}
+	return pfcp.Decode(data)
+}
+
+// firstRequest returns the first request in a pcap, or the first of the named
+// message type. The pcap is only read up to the matching message.
+func firstRequest(filename string, port uint16, msgType string) (message.Message, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	parser := pcap.NewParser()
+	parser.SetPorts([]uint16{port})
+	stream, err := parser.Stream(ctx, filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse pcap: %w", err)
+	}
+
+	for raw := range stream {
+		msg, err := pfcp.Decode(raw.Data)
+		if err != nil {
+			continue
+		}
+		if msgType == "" || strings.EqualFold(pfcp.MessageTypeName(msg.MessageType()), msgType) {
+			return msg, nil
+		}
+	}
+
+	if msgType != "" {
+		return nil, fmt.Errorf("no %s found in %s", msgType, filename)
+	}
+	return nil, fmt.Errorf("no PFCP request messages found in %s", filename)
+}
@@ -2,30 +2,67 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/wmnsk/go-pfcp/message"
 
 	"pfcp-generator/internal/config"
 	"pfcp-generator/internal/network"
 	"pfcp-generator/internal/pcap"
+	"pfcp-generator/internal/pfcp"
+	"pfcp-generator/internal/script"
 	"pfcp-generator/internal/session"
 	"pfcp-generator/internal/stats"
+	"pfcp-generator/pkg/types"
 )
 
 var (
-	version   = "1.0.0"
-	cfgFile   string
-	dryRun    bool
-	statsOnly bool
+	version       = "1.0.0"
+	cfgFile       string
+	dryRun        bool
+	dryRunVerbose bool
+	statsOnly     bool
+	writePcap     string
+	eventsFile    string
+	verifyEncode  bool
+	multiply      int
+	repeat        int
+	maxSessions   int
+	sessionFilter []string
+	failOnError   bool
+	maxFailures   int
+	quiet         bool
+	verbosity     int
 )
 
+// console receives the informational output of a replay (banner, config
+// summary, message counts); --quiet discards it. Reports and dry-run output
+// are always printed.
+var console io.Writer = os.Stdout
+
+// errTooManyFailures is returned by run when the replay had more failures than
+// --max-failures allows; the process then exits with exitFailures.
+var errTooManyFailures = errors.New("too many failures")
+
+// errAssertionsFailed is returned by run when a check of the assertions
+// section failed; the process then exits with exitFailures too.
+var errAssertionsFailed = errors.New("assertions failed")
+
+const exitFailures = 2
+
 func main() {
 	rootCmd := &cobra.Command{
 		Use:   "pfcp-generator",
@@ -40,8 +77,10 @@ file, modifying session-specific identifiers, and replaying them to a target UPF
 	rootCmd.Flags().StringVar(&cfgFile, "config", "", "Configuration file path (default: config.yaml)")
 
 	// CLI overrides
-	rootCmd.Flags().String("pcap", "", "Input PCAP file path")
+	rootCmd.Flags().String("pcap", "", "Input PCAP file path, or a comma-separated list replayed in order")
+	rootCmd.Flags().String("script", "", "Build the requests from a YAML/JSON script instead of a pcap")
 	rootCmd.Flags().String("smf-ip", "", "Local SMF IP address")
+	rootCmd.Flags().String("smf-interface", "", "Use the address of this network interface as the SMF IP")
 	rootCmd.Flags().String("upf-ip", "", "Target UPF IP address")
 	rootCmd.Flags().Int("upf-port", 0, "Target UPF port")
 	rootCmd.Flags().String("ue-pool", "", "UE IPv4 address pool (CIDR)")
@@ -51,16 +90,39 @@ file, modifying session-specific identifiers, and replaying them to a target UPF
 	rootCmd.Flags().Int("timeout", 0, "Response timeout in ms")
 	rootCmd.Flags().Int("max-retries", -1, "Max retransmission attempts")
 	rootCmd.Flags().String("log-level", "", "Log level (debug|info|warn|error)")
+	rootCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Only print errors and the final report")
+	rootCmd.Flags().CountVarP(&verbosity, "verbose", "v", "Log at debug level; -vv also prints the IE tree of every request and response")
+	rootCmd.MarkFlagsMutuallyExclusive("quiet", "verbose")
+	rootCmd.Flags().Int("pfcp-port", 0, "UDP port carrying PFCP in the input pcap")
+	rootCmd.Flags().String("transport", "", "PFCP transport to the UPF (udp|tcp)")
 	rootCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Parse and modify only, do not send to UPF")
+	rootCmd.Flags().BoolVar(&dryRunVerbose, "dry-run-verbose", false, "Like --dry-run, also printing the IE tree of each message")
 	rootCmd.Flags().BoolVar(&statsOnly, "stats-only", false, "Show pcap statistics only, do not replay")
+	rootCmd.Flags().StringVar(&writePcap, "write-pcap", "", "Write all sent and received PFCP packets to a pcap file")
+	rootCmd.Flags().StringVar(&eventsFile, "events-file", "", "Write a JSON line per request and its outcome to a file")
+	rootCmd.Flags().BoolVar(&verifyEncode, "verify-encode", false, "Decode each modified message after encoding and fail on mismatch")
+	rootCmd.Flags().IntVar(&multiply, "multiply", 1, "Replay every session in the pcap N times, each with its own SEIDs and UE IP")
+	rootCmd.Flags().IntVar(&repeat, "repeat", 1, "Replay the whole pcap N times, each with fresh SEIDs and UE IPs (0 = until interrupted)")
+	rootCmd.Flags().IntVar(&maxSessions, "max-sessions", 0, "Replay only the first N sessions in the pcap (0 = all)")
+	rootCmd.Flags().StringSliceVar(&sessionFilter, "session-filter", nil, "Replay only the sessions with these original CP SEIDs, e.g. 0x10,0x2a")
+	rootCmd.Flags().BoolVar(&failOnError, "fail-on-error", false, "Exit with status 2 if any session or request failed (same as --max-failures 0)")
+	rootCmd.Flags().IntVar(&maxFailures, "max-failures", -1, "Exit with status 2 if more than N sessions or requests failed (-1 = never)")
 	rootCmd.Flags().Bool("cleanup", false, "Delete all sessions on exit")
+	rootCmd.Flags().Bool("stream", false, "Replay while reading the pcap instead of loading it into memory")
+	rootCmd.Flags().StringSlice("only", nil, "Replay only these message types, e.g. SessionEstablishmentRequest")
+	rootCmd.Flags().StringSlice("skip", nil, "Do not replay these message types, e.g. HeartbeatRequest")
+	rootCmd.Flags().Bool("soak", false, "Establish, hold and delete batches of sessions in a loop (see soak.* settings)")
 	rootCmd.Flags().Bool("no-association", false, "Disable PFCP Association Setup")
+	rootCmd.Flags().Bool("probe", false, "Check that the UPF answers a Heartbeat before sending anything else")
 	rootCmd.Flags().Bool("strip-ipv6", true, "Strip IPv6 from UE IP Address IEs")
+	rootCmd.Flags().Int("warmup", 0, "Report the first N seconds of the replay separately from the statistics")
 
 	// Bind CLI flags to viper
 	v := viper.New()
 	bindFlag(v, rootCmd, "pcap", "input.pcap_file")
+	bindFlag(v, rootCmd, "script", "input.script_file")
 	bindFlag(v, rootCmd, "smf-ip", "smf.address")
+	bindFlag(v, rootCmd, "smf-interface", "smf.interface")
 	bindFlag(v, rootCmd, "upf-ip", "upf.address")
 	bindFlag(v, rootCmd, "upf-port", "upf.port")
 	bindFlag(v, rootCmd, "ue-pool", "session.ue_ip_pool")
@@ -70,10 +132,26 @@ file, modifying session-specific identifiers, and replaying them to a target UPF
 	bindFlag(v, rootCmd, "timeout", "timing.response_timeout_ms")
 	bindFlag(v, rootCmd, "max-retries", "timing.max_retries")
 	bindFlag(v, rootCmd, "log-level", "logging.level")
+	bindFlag(v, rootCmd, "pfcp-port", "input.pfcp_port")
+	bindFlag(v, rootCmd, "transport", "network.transport")
 	bindFlag(v, rootCmd, "cleanup", "session.cleanup_on_exit")
+	bindFlag(v, rootCmd, "stream", "input.stream")
+	bindFlag(v, rootCmd, "soak", "soak.enabled")
+	bindFlag(v, rootCmd, "only", "input.include_types")
+	bindFlag(v, rootCmd, "skip", "input.exclude_types")
 	bindFlag(v, rootCmd, "strip-ipv6", "session.strip_ipv6")
+	bindFlag(v, rootCmd, "probe", "association.probe_first")
+	bindFlag(v, rootCmd, "warmup", "stats.warmup_sec")
+
+	rootCmd.AddCommand(newDumpCmd())
+	rootCmd.AddCommand(newDiffCmd())
+	rootCmd.AddCommand(newValidatePcapCmd())
+	rootCmd.AddCommand(newSendCmd())
 
 	if err := rootCmd.Execute(); err != nil {
+		if errors.Is(err, errTooManyFailures) || errors.Is(err, errAssertionsFailed) {
+			os.Exit(exitFailures)
+		}
 		os.Exit(1)
 	}
 }
@@ -82,8 +160,8 @@ func bindFlag(v *viper.Viper, cmd *cobra.Command, flagName, configKey string) {
 	_ = v.BindPFlag(configKey, cmd.Flags().Lookup(flagName))
 }
 
-func run(cmd *cobra.Command, args []string) error {
-	// Load configuration
+// loadConfig reads the config file and applies the command's CLI flags on top.
+func loadConfig(cmd *cobra.Command) (*config.Config, error) {
 	v := viper.New()
 	config.SetDefaults(v)
 
@@ -98,7 +176,7 @@ func run(cmd *cobra.Command, args []string) error {
 
 	if err := v.ReadInConfig(); err != nil {
 		if cfgFile != "" {
-			return fmt.Errorf("failed to read config file: %w", err)
+			return nil, fmt.Errorf("failed to read config file: %w", err)
 		}
 		// Config file not found is OK if using CLI flags
 		log.Debug("No config file found, using defaults and CLI flags")
@@ -114,60 +192,207 @@ func run(cmd *cobra.Command, args []string) error {
 
 	cfg, err := config.LoadWithViper(v)
 	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
+		return nil, fmt.Errorf("failed to load config: %w", err)
 	}
+	return cfg, nil
+}
 
-	// Setup logging
-	setupLogging(cfg)
+// newParser creates a pcap parser for the configured input.
+func newParser(cfg *config.Config) *pcap.Parser {
+	parser := pcap.NewParser()
+	parser.SetPorts(cfg.Input.Ports())
+	parser.SetDecapGTPU(cfg.Input.DecapGTPU)
+	parser.SetProgressInterval(progressInterval(cfg))
+	return parser
+}
 
-	fmt.Printf("PFCP Message Generator v%s\n", version)
-	fmt.Println("==============================")
-	fmt.Print(cfg.Summary())
-	fmt.Println()
+// progressInterval returns how often to log parsing and replay progress: 0
+// when disabled or when stdout is not a terminal (e.g. redirected to a file).
+func progressInterval(cfg *config.Config) time.Duration {
+	if cfg.Stats.ProgressIntervalSec <= 0 {
+		return 0
+	}
+	if info, err := os.Stdout.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return 0
+	}
+	return time.Duration(cfg.Stats.ProgressIntervalSec) * time.Second
+}
 
-	// Stats-only mode
-	if statsOnly {
-		return showStats(cfg)
+// resolveSMFInterface sets smf.address to the address of smf.interface, if
+// set, of the UPF address family (IPv4 if the UPF address is inferred later).
+func resolveSMFInterface(cfg *config.Config) error {
+	if cfg.SMF.Interface == "" {
+		return nil
+	}
+	upfIP := net.ParseIP(cfg.UPF.Address)
+	ip, err := network.InterfaceAddress(cfg.SMF.Interface, upfIP != nil && upfIP.To4() == nil)
+	if err != nil {
+		return fmt.Errorf("smf.interface: %w", err)
 	}
 
-	// Validate config
-	if !dryRun {
-		if err := cfg.Validate(); err != nil {
-			return err
-		}
-	} else {
-		// In dry-run mode, skip network-related validation
-		if cfg.Input.PcapFile == "" {
-			return fmt.Errorf("input.pcap_file must be specified")
+	fields := log.Fields{"interface": cfg.SMF.Interface, "smf": ip}
+	if cfg.SMF.Address != "" && cfg.SMF.Address != ip.String() {
+		fields["replaces"] = cfg.SMF.Address
+	}
+	cfg.SMF.Address = ip.String()
+	log.WithFields(fields).Info("Using SMF address of interface")
+	return nil
+}
+
+// inferSampleSize is the number of requests read from the pcap to infer the
+// SMF and UPF addresses.
+const inferSampleSize = 1000
+
+// inferEndpoints fills in smf.address and upf.address from the pcap when they
+// are not configured. Ambiguous captures must be configured explicitly.
+func inferEndpoints(ctx context.Context, cfg *config.Config, parser *pcap.Parser) error {
+	if (cfg.SMF.Address != "" && cfg.UPF.Address != "") || cfg.Input.ScriptFile != "" {
+		return nil
+	}
+	pcapFiles, err := cfg.Input.Files()
+	if err != nil || len(pcapFiles) == 0 {
+		return nil // Reported by config validation
+	}
+	for _, file := range pcapFiles {
+		if _, err := os.Stat(file); err != nil {
+			return nil // Reported by config validation
 		}
 	}
 
-	// Parse PCAP
-	parser := pcap.NewParser()
-	parseResult, err := parser.ParseWithMappings(cfg.Input.PcapFile)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := parser.Stream(ctx, pcapFiles...)
 	if err != nil {
 		return fmt.Errorf("failed to parse pcap: %w", err)
 	}
+	var sample []types.RawPFCPMessage
+	for raw := range stream {
+		sample = append(sample, raw)
+		if len(sample) == inferSampleSize {
+			break
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("pcap parsing interrupted: %w", err)
+	}
 
-	messages := parseResult.Messages
+	smfIP, upfIP, err := parser.InferEndpoints(sample)
+	if err != nil {
+		return fmt.Errorf("%w; set smf.address (--smf-ip) and upf.address (--upf-ip)", err)
+	}
 
-	if len(messages) == 0 {
-		return fmt.Errorf("no PFCP request messages found in pcap file")
+	fields := log.Fields{}
+	if cfg.SMF.Address == "" {
+		cfg.SMF.Address = smfIP.String()
+		fields["smf"] = cfg.SMF.Address
+	}
+	if cfg.UPF.Address == "" {
+		cfg.UPF.Address = upfIP.String()
+		fields["upf"] = cfg.UPF.Address
+	}
+	log.WithFields(fields).Info("Inferred endpoint addresses from pcap")
+	return nil
+}
+
+// parsePcap parses the whole pcap and checks it contains Session Establishment
+// Requests. In streaming mode the pcap is parsed while replaying, so it is not
+// checked up front and nil is returned.
+func parsePcap(ctx context.Context, cfg *config.Config, parser *pcap.Parser) (*pcap.ParseResult, error) {
+	if cfg.Input.Stream {
+		return nil, nil
+	}
+	if cfg.Input.ScriptFile != "" {
+		parseResult, err := loadScript(cfg.Input.ScriptFile)
+		if err != nil {
+			return nil, err
+		}
+		return parseResult, addTemplateAssociation(ctx, cfg, parser, parseResult)
+	}
+
+	pcapFiles, err := cfg.Input.Files()
+	if err != nil {
+		return nil, err
+	}
+	parseResult, err := parser.ParseWithMappings(ctx, pcapFiles...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse pcap: %w", err)
+	}
+
+	if len(parseResult.Messages) == 0 {
+		return nil, parseResult.Counts.NoRequestsError()
 	}
 
 	// Validate pcap has establishment requests
-	if err := parser.ValidateHasEstablishment(messages); err != nil {
+	if err := parser.ValidateHasEstablishment(parseResult.Messages); err != nil {
+		return nil, err
+	}
+	if err := addTemplateAssociation(ctx, cfg, parser, parseResult); err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(console, "Found %d PFCP request messages\n\n", len(parseResult.Messages))
+	return parseResult, nil
+}
+
+// templateAssociation returns the Association Setup Request of
+// association.template_pcap, or nil if none is configured.
+func templateAssociation(ctx context.Context, cfg *config.Config, parser *pcap.Parser) (*types.RawPFCPMessage, error) {
+	if cfg.Association.TemplatePcap == "" {
+		return nil, nil
+	}
+	assoc, err := parser.AssociationSetupRequest(ctx, cfg.Association.TemplatePcap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read association.template_pcap: %w", err)
+	}
+	return &assoc, nil
+}
+
+// addTemplateAssociation prepends the template Association Setup Request to
+// the requests unless they have their own.
+func addTemplateAssociation(ctx context.Context, cfg *config.Config, parser *pcap.Parser, parseResult *pcap.ParseResult) error {
+	assoc, err := templateAssociation(ctx, cfg, parser)
+	if err != nil || assoc == nil {
 		return err
 	}
+	if parseResult.PrependAssociation(*assoc) {
+		log.WithField("template", cfg.Association.TemplatePcap).Info("Using Association Setup Request from template pcap")
+	} else {
+		log.WithField("template", cfg.Association.TemplatePcap).Info("Input has its own Association Setup Request, template pcap not used")
+	}
+	return nil
+}
 
-	fmt.Printf("Found %d PFCP request messages\n\n", len(messages))
+// loadScript builds the requests of a script file, in place of a parsed pcap.
+func loadScript(filename string) (*pcap.ParseResult, error) {
+	s, err := script.Load(filename)
+	if err != nil {
+		return nil, err
+	}
+	messages, mappings, err := s.Build()
+	if err != nil {
+		return nil, fmt.Errorf("invalid script %s: %w", filename, err)
+	}
 
-	if dryRun {
-		fmt.Println("Dry-run mode: skipping network transmission")
-		return nil
+	fmt.Fprintf(console, "Built %d PFCP request messages from script\n\n", len(messages))
+	return &pcap.ParseResult{Messages: messages, SEIDMappings: mappings}, nil
+}
+
+func run(cmd *cobra.Command, args []string) error {
+	// Load configuration
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
 	}
 
-	// Setup context with signal handling
+	// Setup logging
+	setupLogging(cfg)
+	if quiet {
+		console = io.Discard
+		cfg.Stats.ReportIntervalSec = 0
+	}
+
+	// Setup context with signal handling before the pcap is read, so that
+	// parsing a large pcap can be interrupted
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -177,74 +402,357 @@ func run(cmd *cobra.Command, args []string) error {
 		sig := <-sigCh
 		log.WithField("signal", sig).Info("Received shutdown signal")
 		cancel()
+		for sig := range sigCh {
+			log.WithField("signal", sig).Warn("Shutdown in progress, waiting for session cleanup to finish")
+		}
 	}()
 
+	if err := resolveSMFInterface(cfg); err != nil {
+		return err
+	}
+	parser := newParser(cfg)
+	if !statsOnly {
+		if err := inferEndpoints(ctx, cfg, parser); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(console, "PFCP Message Generator v%s\n", version)
+	fmt.Fprintln(console, "==============================")
+	fmt.Fprint(console, cfg.Summary())
+	fmt.Fprintln(console)
+
+	// Verbose dry-run implies dry-run
+	if dryRunVerbose {
+		dryRun = true
+	}
+
+	// Stats-only mode
+	if statsOnly {
+		return showStats(ctx, cfg)
+	}
+
+	// Validate config
+	validate := cfg.Validate
+	if dryRun {
+		// In dry-run mode, skip network-related validation
+		validate = cfg.ValidateDryRun
+	}
+	if err := validate(); err != nil {
+		return err
+	}
+	if multiply < 1 {
+		return fmt.Errorf("--multiply must be >= 1, got %d", multiply)
+	}
+	if dryRun && cfg.Soak.Enabled {
+		return fmt.Errorf("soak mode sends to the UPF and cannot be combined with --dry-run")
+	}
+	if multiply > 1 && cfg.Input.Stream {
+		return fmt.Errorf("--multiply needs the whole pcap in memory and cannot be combined with streaming")
+	}
+	if repeat < 0 {
+		return fmt.Errorf("--repeat must be >= 0, got %d", repeat)
+	}
+	if repeat != 1 && cfg.Input.Stream {
+		return fmt.Errorf("--repeat needs the whole pcap in memory and cannot be combined with streaming")
+	}
+	if repeat != 1 && dryRun {
+		return fmt.Errorf("--repeat sends to the UPF and cannot be combined with --dry-run")
+	}
+	if repeat != 1 && cfg.Soak.Enabled {
+		return fmt.Errorf("--repeat cannot be combined with soak mode, see soak.iterations")
+	}
+	if maxSessions < 0 {
+		return fmt.Errorf("--max-sessions must be >= 0, got %d", maxSessions)
+	}
+	filterSEIDs, err := parseSEIDs(sessionFilter)
+	if err != nil {
+		return fmt.Errorf("invalid --session-filter: %w", err)
+	}
+	if (maxSessions > 0 || len(filterSEIDs) > 0) && cfg.Input.Stream {
+		return fmt.Errorf("--max-sessions and --session-filter need the whole pcap in memory and cannot be combined with streaming")
+	}
+
+	// Parse PCAP
+	parseResult, err := parsePcap(ctx, cfg, parser)
+	if err != nil {
+		return err
+	}
+	if maxSessions > 0 || len(filterSEIDs) > 0 {
+		selected := parseResult.SelectSessions(maxSessions, filterSEIDs)
+		if selected == 0 {
+			return fmt.Errorf("no session in the pcap matches --max-sessions/--session-filter")
+		}
+		fmt.Fprintf(console, "Selected %d sessions: %d PFCP request messages\n\n", selected, len(parseResult.Messages))
+	}
+	if multiply > 1 {
+		if err := parseResult.Multiply(multiply); err != nil {
+			return fmt.Errorf("failed to multiply sessions: %w", err)
+		}
+		fmt.Fprintf(console, "Multiplied each session %d times: %d PFCP request messages\n\n", multiply, len(parseResult.Messages))
+	}
+
+	if dryRun {
+		output := session.DryRunSummary
+		if dryRunVerbose || verbosity >= 2 {
+			output = session.DryRunVerbose
+		}
+		return runDryRun(ctx, cfg, parser, parseResult, output)
+	}
+
+	// The receiver and transaction tracker outlive ctx so that session cleanup
+	// can still exchange messages with the UPF after a shutdown signal
+	netCtx, netCancel := context.WithCancel(context.Background())
+	defer netCancel()
+
 	// Create network client
-	client, err := network.NewUDPClient(cfg.SMF.Address, cfg.SMF.Port, cfg.UPF.Address, cfg.UPF.Port)
+	client, err := network.NewTransport(cfg.Network.Transport, cfg.SMF.BindAddress(), cfg.SMF.Port, cfg.UPF.Address, cfg.UPF.Port)
 	if err != nil {
-		return fmt.Errorf("failed to create UDP client: %w", err)
+		return fmt.Errorf("failed to create %s client: %w", cfg.Network.Transport, err)
 	}
 	defer client.Close()
 
-	log.WithField("local_addr", client.LocalAddr()).Info("UDP client started")
+	localFields := log.Fields{
+		"transport":  cfg.Network.Transport,
+		"local_addr": client.LocalAddr(),
+	}
+	if _, port, err := net.SplitHostPort(client.LocalAddr().String()); err == nil {
+		localFields["local_port"] = port
+	}
+	log.WithFields(localFields).Info("Network client started")
 
 	// Create receiver
-	receiver := network.NewReceiver(client.Conn())
-	receiver.Start(ctx)
+	receiver := network.NewReceiver(client.Conn(), cfg.Network.ReceiveBuffer)
+
+	// Explicit socket buffer sizes replace the read buffer sized by the receiver
+	network.SetSocketBuffers(client.Conn(), cfg.Network.SocketReadBuffer, cfg.Network.SocketWriteBuffer)
+
+	// Record outgoing and incoming traffic if requested
+	if writePcap != "" {
+		pcapWriter, err := pcap.NewWriter(writePcap, net.ParseIP(cfg.SMF.Address))
+		if err != nil {
+			return fmt.Errorf("failed to create output pcap: %w", err)
+		}
+		defer pcapWriter.Close()
+		client.SetRecorder(pcapWriter)
+		receiver.SetRecorder(pcapWriter)
+		log.WithField("file", writePcap).Info("Recording PFCP traffic to pcap")
+	}
 
 	// Create transaction tracker
 	tracker := network.NewTransactionTracker(client, cfg.Timing.ResponseTimeoutMs, cfg.Timing.MaxRetries)
-	tracker.StartTimeoutMonitor(ctx)
+	tracker.SetBackoff(cfg.Timing.RetryBackoff, cfg.Timing.RetryBackoffMultiplier)
+	tracker.SetMaxInFlight(cfg.Timing.MaxInFlight)
+	tracker.StartTimeoutMonitor(netCtx)
 
 	// Create stats collector and reporter
 	statsCollector := stats.NewCollector()
+	statsCollector.SetInFlightSource(tracker.PendingCount)
+	statsCollector.SetResponseTimeSamples(cfg.Stats.ResponseTimeSamples)
+	receiver.SetDropHandler(statsCollector.RecordReceiveDrop)
+	receiver.Start(netCtx)
 	reporter := stats.NewReporter(statsCollector, cfg.Stats.ReportIntervalSec, cfg.Stats.ExportFile)
+	reporter.SetPromFile(cfg.Stats.PromFile)
 	if cfg.Stats.Enabled {
 		reporter.StartPeriodicReport(ctx)
 	}
 
 	// Create session manager
-	mgr, err := session.NewManager(cfg, client, receiver, tracker, statsCollector)
+	mgr, err := session.NewManager(cfg, client, receiver, tracker, statsCollector, nil, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create session manager: %w", err)
 	}
 
-	// Register original SEID mappings from pcap
-	if len(parseResult.SEIDMappings) > 0 {
-		mgr.SetSEIDMappings(parseResult.SEIDMappings)
+	mgr.SetVerifyEncode(verifyEncode)
+	mgr.SetProgressInterval(progressInterval(cfg))
+	if verbosity >= 2 {
+		mgr.SetHooks(messageDumpHooks(os.Stdout))
+	}
+
+	if eventsFile != "" {
+		events, err := stats.NewEventWriter(eventsFile)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := events.Close(); err != nil {
+				log.WithError(err).Warn("Failed to write events file")
+			}
+		}()
+		mgr.SetEventWriter(events)
+		log.WithField("file", eventsFile).Info("Writing transaction events")
+	}
+
+	// Fail fast on a wrong UPF address or port, or an unreachable UPF
+	if cfg.Association.ProbeFirst {
+		if err := mgr.Probe(ctx, time.Duration(cfg.Association.ProbeTimeoutMs)*time.Millisecond); err != nil {
+			return err
+		}
+	}
+
+	// Resume allocations from a previous run and snapshot them periodically
+	if cfg.Session.StateFile != "" {
+		mgr.LoadState(cfg.Session.StateFile)
+		go mgr.RunStateSaver(ctx, cfg.Session.StateFile, time.Duration(cfg.Session.StateIntervalSec)*time.Second)
 	}
 
 	// Run replay
-	fmt.Println("Sending messages to UPF...")
-	if err := mgr.Replay(ctx, messages); err != nil {
+	fmt.Fprintln(console, "Sending messages to UPF...")
+	replayErr := replay(ctx, cfg, mgr, parser, parseResult)
+	if replayErr != nil {
 		if ctx.Err() != nil {
 			log.Info("Replay interrupted by shutdown")
+			replayErr = nil
 		} else {
-			log.WithError(err).Error("Replay failed")
+			log.WithError(replayErr).Error("Replay failed")
 		}
 	}
 
-	// Cleanup sessions if configured
-	if cfg.Session.CleanupOnExit {
-		cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// Cleanup sessions if configured; soak mode always deletes the batch it holds
+	if cfg.Session.CleanupOnExit || cfg.Soak.Enabled {
+		cleanupCtx, cleanupCancel := context.WithTimeout(netCtx, time.Duration(cfg.Session.CleanupTimeoutSec)*time.Second)
 		mgr.CleanupSessions(cleanupCtx)
 		cleanupCancel()
 	}
 
+	if cfg.Session.StateFile != "" {
+		if err := mgr.SaveState(cfg.Session.StateFile); err != nil {
+			log.WithError(err).Warn("Failed to save session state")
+		}
+	}
+
 	// Print final statistics
 	if cfg.Stats.Enabled {
 		reporter.PrintFinalReport()
 		if err := reporter.ExportJSON(); err != nil {
 			log.WithError(err).Warn("Failed to export statistics")
 		}
+		if err := reporter.ExportPrometheus(); err != nil {
+			log.WithError(err).Warn("Failed to export statistics")
+		}
+	}
+
+	assertErr := checkAssertions(cmd, cfg, statsCollector)
+	if err := checkFailures(cmd, statsCollector, replayErr); err != nil {
+		return err
+	}
+	return assertErr
+}
+
+// checkAssertions prints the outcome of the checks in the assertions section,
+// if any is enabled, and returns errAssertionsFailed if one of them failed.
+func checkAssertions(cmd *cobra.Command, cfg *config.Config, collector *stats.Collector) error {
+	a := cfg.Assertions
+	if !a.IsSet() {
+		return nil
 	}
+	results := stats.CheckExpectations(collector.Snapshot(), stats.Expectations{
+		MinEstablished: uint64(a.MinEstablished),
+		MaxFailed:      a.MaxFailed,
+		MaxP99:         time.Duration(a.MaxP99Ms * float64(time.Millisecond)),
+		MinThroughput:  a.MinThroughput,
+	})
+	fmt.Print(stats.FormatAssertions(results))
 
+	var failed []string
+	for _, r := range results {
+		if !r.Passed {
+			failed = append(failed, fmt.Sprintf("%s %s (expected %s)", r.Name, r.Actual, r.Expected))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	// The failure is reported by the error; usage help would only hide it
+	cmd.SilenceUsage = true
+	return fmt.Errorf("%w: %s", errAssertionsFailed, strings.Join(failed, ", "))
+}
+
+// checkFailures returns errTooManyFailures when --fail-on-error or
+// --max-failures is set and the replay was aborted or had more failures than
+// allowed.
+func checkFailures(cmd *cobra.Command, collector *stats.Collector, replayErr error) error {
+	limit := maxFailures
+	if failOnError {
+		limit = 0
+	}
+	if limit < 0 {
+		return nil
+	}
+
+	// The failure is reported by the error; usage help would only hide it
+	cmd.SilenceUsage = true
+	if replayErr != nil {
+		return fmt.Errorf("%w: replay aborted: %v", errTooManyFailures, replayErr)
+	}
+	if failures := collector.Failures(); failures > uint64(limit) {
+		return fmt.Errorf("%w: %d failed sessions and requests (allowed: %d)", errTooManyFailures, failures, limit)
+	}
 	return nil
 }
 
-func showStats(cfg *config.Config) error {
-	parser := pcap.NewParser()
-	counts, err := parser.CountMessages(cfg.Input.PcapFile)
+// replay runs the pcap's requests through mgr, either from parseResult or,
+// in streaming mode, as they are read from the pcap. In soak mode the pcap's
+// Establishment Requests are cloned into batches instead. With --repeat the
+// parsed messages are replayed that many times.
+func replay(ctx context.Context, cfg *config.Config, mgr *session.Manager, parser *pcap.Parser, parseResult *pcap.ParseResult) error {
+	if cfg.Soak.Enabled {
+		return mgr.Soak(ctx, parseResult.Messages)
+	}
+	if cfg.Input.Stream {
+		// SEID mappings are registered as their responses are read from the pcap
+		parser.SetSEIDMappingHandler(mgr.AddSEIDMapping)
+		pcapFiles, err := cfg.Input.Files()
+		if err != nil {
+			return err
+		}
+		assoc, err := templateAssociation(ctx, cfg, parser)
+		if err != nil {
+			return err
+		}
+		stream, err := parser.Stream(ctx, pcapFiles...)
+		if err != nil {
+			return fmt.Errorf("failed to parse pcap: %w", err)
+		}
+		if assoc != nil {
+			stream = pcap.PrependAssociationToStream(ctx, stream, *assoc)
+		}
+		return mgr.ReplayStream(ctx, stream)
+	}
+
+	// Register original SEID mappings from pcap
+	if len(parseResult.SEIDMappings) > 0 {
+		mgr.SetSEIDMappings(parseResult.SEIDMappings)
+	}
+	if repeat != 1 {
+		return mgr.Repeat(ctx, parseResult.Messages, repeat)
+	}
+	return mgr.Replay(ctx, parseResult.Messages)
+}
+
+// runDryRun runs every request through the modification pipeline and prints
+// a summary of each resulting message, without any network I/O.
+func runDryRun(ctx context.Context, cfg *config.Config, parser *pcap.Parser, parseResult *pcap.ParseResult, output session.DryRunOutput) error {
+	fmt.Println("Dry-run mode: skipping network transmission")
+	fmt.Println()
+
+	mgr, err := session.NewManager(cfg, nil, nil, nil, stats.NewCollector(), nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create session manager: %w", err)
+	}
+	mgr.SetDryRun(true, output)
+	mgr.SetVerifyEncode(verifyEncode)
+
+	if err := replay(ctx, cfg, mgr, parser, parseResult); err != nil {
+		return err
+	}
+
+	fmt.Printf("\nDry-run complete: %d sessions active at end of pcap\n", mgr.ActiveSessionCount())
+	return nil
+}
+
+func showStats(ctx context.Context, cfg *config.Config) error {
+	counts, err := countMessages(ctx, cfg)
 	if err != nil {
 		return fmt.Errorf("failed to count messages: %w", err)
 	}
@@ -259,24 +767,97 @@ func showStats(cfg *config.Config) error {
 	return nil
 }
 
+// countMessages counts the message types of the pcap, or of the script's
+// requests.
+func countMessages(ctx context.Context, cfg *config.Config) (map[string]int, error) {
+	if cfg.Input.ScriptFile == "" {
+		pcapFiles, err := cfg.Input.Files()
+		if err != nil {
+			return nil, err
+		}
+		return newParser(cfg).CountMessages(ctx, pcapFiles...)
+	}
+
+	s, err := script.Load(cfg.Input.ScriptFile)
+	if err != nil {
+		return nil, err
+	}
+	messages, _, err := s.Build()
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, raw := range messages {
+		counts[pfcp.MessageTypeName(raw.Data[1])]++
+	}
+	return counts, nil
+}
+
+// messageDumpHooks returns session hooks that print the IE tree of every
+// request sent and every response received to w, for -vv.
+func messageDumpHooks(w io.Writer) session.Hooks {
+	var mu sync.Mutex
+	dump := func(direction string, msg message.Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, "%s %s\n", direction, pfcp.DumpMessage(msg))
+	}
+	return session.Hooks{
+		BeforeSend: func(msg message.Message) error {
+			dump("-->", msg)
+			return nil
+		},
+		AfterResponse: func(_, resp message.Message, _ time.Duration) {
+			if resp != nil {
+				dump("<--", resp)
+			}
+		},
+	}
+}
+
 func setupLogging(cfg *config.Config) {
 	level, err := log.ParseLevel(cfg.Logging.Level)
 	if err != nil {
 		level = log.InfoLevel
 	}
+	// --quiet and --verbose take precedence over logging.level
+	switch {
+	case quiet:
+		level = log.ErrorLevel
+	case verbosity > 0 && level < log.DebugLevel:
+		level = log.DebugLevel
+	}
 	log.SetLevel(level)
-	log.SetFormatter(&log.TextFormatter{
-		FullTimestamp:   true,
-		TimestampFormat: "2006-01-02 15:04:05.000",
-	})
 
-	if cfg.Logging.File != "" {
-		f, err := os.OpenFile(cfg.Logging.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-		if err != nil {
-			log.WithError(err).Warn("Failed to open log file, using console only")
-		} else {
-			log.SetOutput(f)
+	timestampFormat := cfg.Logging.TimestampFormat
+	if timestampFormat == "" {
+		timestampFormat = "2006-01-02 15:04:05.000"
+	}
+	if cfg.Logging.Format == "json" {
+		log.SetFormatter(&log.JSONFormatter{TimestampFormat: timestampFormat})
+	} else {
+		log.SetFormatter(&log.TextFormatter{
+			FullTimestamp:   true,
+			TimestampFormat: timestampFormat,
+		})
+	}
+
+	// Without a file the console is the only place logs can go
+	if cfg.Logging.File == "" {
+		if !cfg.Logging.Console {
+			log.Warn("logging.console is false but logging.file is not set, logging to the console")
 		}
+		return
+	}
+
+	f, err := os.OpenFile(cfg.Logging.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	switch {
+	case err != nil:
+		log.WithError(err).Warn("Failed to open log file, using console only")
+	case cfg.Logging.Console:
+		log.SetOutput(io.MultiWriter(os.Stderr, f))
+	default:
+		log.SetOutput(f)
 	}
 }
 
@@ -284,10 +865,23 @@ func bindViperFlags(v *viper.Viper, cmd *cobra.Command) {
 	if cmd.Flags().Changed("pcap") {
 		val, _ := cmd.Flags().GetString("pcap")
 		v.Set("input.pcap_file", val)
+		v.Set("input.pcap_files", []string{})
+		v.Set("input.script_file", "")
+	}
+	if cmd.Flags().Changed("script") {
+		val, _ := cmd.Flags().GetString("script")
+		v.Set("input.script_file", val)
+		v.Set("input.pcap_file", "")
+		v.Set("input.pcap_files", []string{})
 	}
 	if cmd.Flags().Changed("smf-ip") {
 		val, _ := cmd.Flags().GetString("smf-ip")
 		v.Set("smf.address", val)
+		v.Set("smf.interface", "")
+	}
+	if cmd.Flags().Changed("smf-interface") {
+		val, _ := cmd.Flags().GetString("smf-interface")
+		v.Set("smf.interface", val)
 	}
 	if cmd.Flags().Changed("upf-ip") {
 		val, _ := cmd.Flags().GetString("upf-ip")
@@ -325,6 +919,14 @@ func bindViperFlags(v *viper.Viper, cmd *cobra.Command) {
 		val, _ := cmd.Flags().GetString("log-level")
 		v.Set("logging.level", val)
 	}
+	if cmd.Flags().Changed("pfcp-port") {
+		val, _ := cmd.Flags().GetInt("pfcp-port")
+		v.Set("input.pfcp_port", val)
+	}
+	if cmd.Flags().Changed("transport") {
+		val, _ := cmd.Flags().GetString("transport")
+		v.Set("network.transport", val)
+	}
 	if cmd.Flags().Changed("cleanup") {
 		val, _ := cmd.Flags().GetBool("cleanup")
 		v.Set("session.cleanup_on_exit", val)
@@ -333,4 +935,41 @@ func bindViperFlags(v *viper.Viper, cmd *cobra.Command) {
 		val, _ := cmd.Flags().GetBool("strip-ipv6")
 		v.Set("session.strip_ipv6", val)
 	}
+	if cmd.Flags().Changed("probe") {
+		val, _ := cmd.Flags().GetBool("probe")
+		v.Set("association.probe_first", val)
+	}
+	if cmd.Flags().Changed("warmup") {
+		val, _ := cmd.Flags().GetInt("warmup")
+		v.Set("stats.warmup_sec", val)
+	}
+	if cmd.Flags().Changed("stream") {
+		val, _ := cmd.Flags().GetBool("stream")
+		v.Set("input.stream", val)
+	}
+	if cmd.Flags().Changed("soak") {
+		val, _ := cmd.Flags().GetBool("soak")
+		v.Set("soak.enabled", val)
+	}
+	if cmd.Flags().Changed("only") {
+		val, _ := cmd.Flags().GetStringSlice("only")
+		v.Set("input.include_types", val)
+	}
+	if cmd.Flags().Changed("skip") {
+		val, _ := cmd.Flags().GetStringSlice("skip")
+		v.Set("input.exclude_types", val)
+	}
+}
+
+// parseSEIDs parses SEIDs given in decimal or, with a 0x prefix, in hex.
+func parseSEIDs(values []string) ([]uint64, error) {
+	var seids []uint64
+	for _, value := range values {
+		seid, err := strconv.ParseUint(strings.TrimSpace(value), 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SEID %q", value)
+		}
+		seids = append(seids, seid)
+	}
+	return seids, nil
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"pfcp-generator/internal/network"
+	"pfcp-generator/internal/pfcp"
+)
+
+func newSendCmd() *cobra.Command {
+	var hexMsg string
+	var seq uint32
+
+	cmd := &cobra.Command{
+		Use:   "send",
+		Short: "Send a single hand-crafted PFCP message and print the response",
+		Long: `Send one PFCP message, given as a hex string, to the UPF and print the IE
+tree of the request and of the response. The message is decoded before it is
+sent, so only well-formed PFCP is sent; --seq replaces its sequence number.
+Requests are retransmitted like in a replay and the command fails if the UPF
+does not answer in time. Responses are sent without waiting for an answer.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSend(cmd, hexMsg, seq)
+		},
+	}
+
+	cmd.Flags().StringVar(&hexMsg, "hex", "", "The message to send, as a hex string")
+	cmd.Flags().Uint32Var(&seq, "seq", 0, "Replace the sequence number of the message")
+	_ = cmd.MarkFlagRequired("hex")
+
+	// The subset of root flags that affect sending
+	cmd.Flags().StringVar(&cfgFile, "config", "", "Configuration file path (default: config.yaml)")
+	cmd.Flags().String("smf-ip", "", "Local SMF IP address")
+	cmd.Flags().String("smf-interface", "", "Use the address of this network interface as the SMF IP")
+	cmd.Flags().String("upf-ip", "", "Target UPF IP address")
+	cmd.Flags().Int("upf-port", 0, "Target UPF port")
+	cmd.Flags().String("transport", "", "PFCP transport to the UPF (udp|tcp)")
+	cmd.Flags().Int("timeout", 0, "Response timeout in ms")
+	cmd.Flags().Int("max-retries", -1, "Max retransmission attempts")
+	cmd.Flags().String("log-level", "", "Log level (debug|info|warn|error)")
+	return cmd
+}
+
+func runSend(cmd *cobra.Command, hexMsg string, seq uint32) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+	setupLogging(cfg)
+
+	if err := resolveSMFInterface(cfg); err != nil {
+		return err
+	}
+	if net.ParseIP(cfg.UPF.Address) == nil {
+		return fmt.Errorf("a valid UPF address is required (--upf-ip or upf.address)")
+	}
+
+	msg, err := decodeHex(hexMsg)
+	if err != nil {
+		return err
+	}
+	if cmd.Flags().Changed("seq") {
+		msg.SetSequenceNumber(seq)
+	}
+	data, err := pfcp.Encode(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, err := network.NewTransport(cfg.Network.Transport, cfg.SMF.BindAddress(), cfg.SMF.Port, cfg.UPF.Address, cfg.UPF.Port)
+	if err != nil {
+		return fmt.Errorf("failed to create %s client: %w", cfg.Network.Transport, err)
+	}
+	defer client.Close()
+
+	addr := net.JoinHostPort(cfg.UPF.Address, strconv.Itoa(cfg.UPF.Port))
+	fmt.Printf("--> %s\n%s", addr, pfcp.DumpMessage(msg))
+
+	if !pfcp.IsRequest(msg) {
+		if err := client.Send(data); err != nil {
+			return fmt.Errorf("failed to send %s: %w", msg.MessageTypeName(), err)
+		}
+		return nil
+	}
+
+	receiver := network.NewReceiver(client.Conn(), cfg.Network.ReceiveBuffer)
+	receiver.Start(ctx)
+	tracker := network.NewTransactionTracker(client, cfg.Timing.ResponseTimeoutMs, cfg.Timing.MaxRetries)
+	tracker.SetBackoff(cfg.Timing.RetryBackoff, cfg.Timing.RetryBackoffMultiplier)
+	tracker.StartTimeoutMonitor(ctx)
+
+	// Resolve the request with the first response carrying its sequence
+	// number; anything else the UPF sends meanwhile is logged and ignored
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case received, ok := <-receiver.Messages():
+				if !ok {
+					return
+				}
+				if !pfcp.IsResponse(received.Message) || received.Message.Sequence() != msg.Sequence() {
+					log.WithFields(log.Fields{
+						"type": received.Message.MessageTypeName(),
+						"seq":  received.Message.Sequence(),
+					}).Debug("Ignoring unrelated message from UPF")
+					continue
+				}
+				tracker.Resolve(received.Message.Sequence(), received.Message, received.Data)
+			}
+		}
+	}()
+
+	resultCh, err := tracker.Track(ctx, msg.Sequence(), msg.MessageType(), data)
+	if err != nil {
+		return fmt.Errorf("failed to send %s: %w", msg.MessageTypeName(), err)
+	}
+	if err := client.Send(data); err != nil {
+		return fmt.Errorf("failed to send %s: %w", msg.MessageTypeName(), err)
+	}
+
+	result := <-resultCh
+	if result.Error != nil {
+		return fmt.Errorf("no response from UPF at %s: %w", addr, result.Error)
+	}
+	resp, err := pfcp.Decode(result.Response)
+	if err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	fmt.Printf("<-- %s (%v)\n%s", addr, result.ResponseTime.Round(time.Microsecond), pfcp.DumpMessage(resp))
+	if cause, err := pfcp.ResponseCause(resp); err == nil {
+		fmt.Printf("Cause: %d (%s)\n", cause, pfcp.CauseDescription(cause))
+	}
+	return nil
+}
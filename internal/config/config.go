@@ -2,9 +2,13 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"path/filepath"
 	"strings"
 
 	"github.com/spf13/viper"
+
+	"pfcp-generator/internal/pfcp"
 )
 
 // Config holds all configuration for the PFCP generator.
@@ -14,15 +18,42 @@ type Config struct {
 	Association AssociationConfig `yaml:"association" mapstructure:"association"`
 	Session     SessionConfig     `yaml:"session"     mapstructure:"session"`
 	Timing      TimingConfig      `yaml:"timing"      mapstructure:"timing"`
+	Report      ReportConfig      `yaml:"report"      mapstructure:"report"`
+	Soak        SoakConfig        `yaml:"soak"        mapstructure:"soak"`
+	Network     NetworkConfig     `yaml:"network"     mapstructure:"network"`
 	Input       InputConfig       `yaml:"input"       mapstructure:"input"`
 	Logging     LoggingConfig     `yaml:"logging"     mapstructure:"logging"`
 	Stats       StatsConfig       `yaml:"stats"       mapstructure:"stats"`
+	Assertions  AssertionsConfig  `yaml:"assertions"  mapstructure:"assertions"`
 }
 
 type SMFConfig struct {
-	Address string `yaml:"address" mapstructure:"address"`
-	Port    int    `yaml:"port"    mapstructure:"port"`
-	NodeID  string `yaml:"node_id" mapstructure:"node_id"`
+	Address   string `yaml:"address"   mapstructure:"address"`
+	Interface string `yaml:"interface" mapstructure:"interface"` // Overrides address with the interface's IP
+	Port      int    `yaml:"port"      mapstructure:"port"`
+	NodeID    string `yaml:"node_id"   mapstructure:"node_id"`
+	BindAny   bool   `yaml:"bind_any"  mapstructure:"bind_any"`
+}
+
+// BindAddress returns the local address to bind. With bind_any set, this is the
+// wildcard address of the SMF address family instead of the SMF IP itself.
+func (s SMFConfig) BindAddress() string {
+	if !s.BindAny {
+		return s.Address
+	}
+	if ip := net.ParseIP(s.Address); ip != nil && ip.To4() == nil {
+		return "::"
+	}
+	return "0.0.0.0"
+}
+
+// NodeIDValue returns the Node ID sent to the UPF: node_id if set, otherwise
+// the SMF address.
+func (s SMFConfig) NodeIDValue() string {
+	if s.NodeID != "" {
+		return s.NodeID
+	}
+	return s.Address
 }
 
 type UPFConfig struct {
@@ -31,55 +62,279 @@ type UPFConfig struct {
 }
 
 type AssociationConfig struct {
-	Enabled bool `yaml:"enabled" mapstructure:"enabled"`
+	Enabled              bool `yaml:"enabled"                mapstructure:"enabled"`
+	HeartbeatIntervalSec int  `yaml:"heartbeat_interval_sec" mapstructure:"heartbeat_interval_sec"`
+	ReconnectOnRestart   bool `yaml:"reconnect_on_restart"   mapstructure:"reconnect_on_restart"`
+
+	MaxSetupRetries      int    `yaml:"max_setup_retries"       mapstructure:"max_setup_retries"`
+	SetupRetryIntervalMs int    `yaml:"setup_retry_interval_ms" mapstructure:"setup_retry_interval_ms"`
+	OnSetupFailure       string `yaml:"on_setup_failure"        mapstructure:"on_setup_failure"`
+
+	// Heartbeat the UPF before anything is sent, failing fast if it does not answer
+	ProbeFirst     bool `yaml:"probe_first"      mapstructure:"probe_first"`
+	ProbeTimeoutMs int  `yaml:"probe_timeout_ms" mapstructure:"probe_timeout_ms"`
+
+	// Bitmask ("0x03") or flag names ("LOAD,OVRL"); empty keeps the pcap's value
+	CPFunctionFeatures string `yaml:"cp_function_features" mapstructure:"cp_function_features"`
+
+	// Pcap whose Association Setup Request is sent first when the input has none
+	TemplatePcap string `yaml:"template_pcap" mapstructure:"template_pcap"`
 }
 
 type SessionConfig struct {
-	SEIDStart     uint64 `yaml:"seid_start"      mapstructure:"seid_start"`
-	SEIDStrategy  string `yaml:"seid_strategy"   mapstructure:"seid_strategy"`
-	UEIPPool      string `yaml:"ue_ip_pool"      mapstructure:"ue_ip_pool"`
-	StripIPv6     bool   `yaml:"strip_ipv6"      mapstructure:"strip_ipv6"`
-	CleanupOnExit bool   `yaml:"cleanup_on_exit" mapstructure:"cleanup_on_exit"`
+	SEIDStart          uint64            `yaml:"seid_start"           mapstructure:"seid_start"`
+	SEIDRangeEnd       uint64            `yaml:"seid_range_end"       mapstructure:"seid_range_end"`
+	SEIDStrategy       string            `yaml:"seid_strategy"        mapstructure:"seid_strategy"`
+	UEIPPool           string            `yaml:"ue_ip_pool"           mapstructure:"ue_ip_pool"`
+	UEIPPools          map[string]string `yaml:"ue_ip_pools"          mapstructure:"ue_ip_pools"`
+	UEIPStrategy       string            `yaml:"ue_ip_strategy"       mapstructure:"ue_ip_strategy"`
+	UEIPExclude        []string          `yaml:"ue_ip_exclude"        mapstructure:"ue_ip_exclude"`
+	UEIPSkipBroadcast  bool              `yaml:"ue_ip_skip_broadcast" mapstructure:"ue_ip_skip_broadcast"`
+	UEMACPool          string            `yaml:"ue_mac_pool"          mapstructure:"ue_mac_pool"`
+	StripIPv6          bool              `yaml:"strip_ipv6"           mapstructure:"strip_ipv6"`
+	CleanupOnExit      bool              `yaml:"cleanup_on_exit"      mapstructure:"cleanup_on_exit"`
+	CleanupTimeoutSec  int               `yaml:"cleanup_timeout_sec"  mapstructure:"cleanup_timeout_sec"`
+	CleanupConcurrency int               `yaml:"cleanup_concurrency"  mapstructure:"cleanup_concurrency"`
+	RewriteTEID        bool              `yaml:"rewrite_teid"         mapstructure:"rewrite_teid"`
+	StateFile          string            `yaml:"state_file"           mapstructure:"state_file"`
+	StateIntervalSec   int               `yaml:"state_interval_sec"   mapstructure:"state_interval_sec"`
+
+	NetworkInstanceOverride string            `yaml:"network_instance_override" mapstructure:"network_instance_override"`
+	NetworkInstanceMap      map[string]string `yaml:"network_instance_map"      mapstructure:"network_instance_map"`
+
+	GTPPeerOverride GTPPeerConfig `yaml:"gtp_peer_override" mapstructure:"gtp_peer_override"`
+
+	// Create PDR values forced in every Session Establishment Request
+	PDROverride PDROverrideConfig `yaml:"pdr_override" mapstructure:"pdr_override"`
+
+	// QER bit rates forced in every Create/Update QER
+	QEROverride QEROverrideConfig `yaml:"qer_override" mapstructure:"qer_override"`
+
+	// Bitmask ("0x01") or flag names ("DROP", "BUFF,NOCP"); empty keeps the pcap's values
+	ApplyActionOverride string `yaml:"apply_action_override" mapstructure:"apply_action_override"`
+
+	// Set the MP flag and message_priority in session request headers; false
+	// keeps the pcap's header
+	SetMessagePriority bool `yaml:"set_message_priority" mapstructure:"set_message_priority"`
+	MessagePriority    int  `yaml:"message_priority"     mapstructure:"message_priority"`
+}
+
+// GTPPeerConfig redirects GTP-U Outer Header Creation in FARs to another peer.
+type GTPPeerConfig struct {
+	IP       string `yaml:"ip"        mapstructure:"ip"`
+	TEIDBase uint32 `yaml:"teid_base" mapstructure:"teid_base"`
+}
+
+// PDROverrideConfig forces Create PDR values in every Session Establishment
+// Request. Unset values keep the pcap's.
+type PDROverrideConfig struct {
+	Precedence         *uint32 `yaml:"precedence"           mapstructure:"precedence"`
+	SourceInterface    string  `yaml:"source_interface"     mapstructure:"source_interface"`     // access, core, sgi-lan or cp-function
+	OuterHeaderRemoval string  `yaml:"outer_header_removal" mapstructure:"outer_header_removal"` // gtpu-udp-ipv4, gtpu-udp-ipv6 or gtpu-udp-ip
+}
+
+// IsSet reports whether any Create PDR value is overridden.
+func (o PDROverrideConfig) IsSet() bool {
+	return o.Precedence != nil || o.SourceInterface != "" || o.OuterHeaderRemoval != ""
+}
+
+// QEROverrideConfig forces MBR and GBR bit rates, in kbps, in every Create
+// and Update QER. Unset rates keep the pcap's.
+type QEROverrideConfig struct {
+	MBRUplink   *uint64 `yaml:"mbr_ul" mapstructure:"mbr_ul"`
+	MBRDownlink *uint64 `yaml:"mbr_dl" mapstructure:"mbr_dl"`
+	GBRUplink   *uint64 `yaml:"gbr_ul" mapstructure:"gbr_ul"`
+	GBRDownlink *uint64 `yaml:"gbr_dl" mapstructure:"gbr_dl"`
+}
+
+// IsSet reports whether any QER bit rate is overridden.
+func (o QEROverrideConfig) IsSet() bool {
+	return o.MBRUplink != nil || o.MBRDownlink != nil || o.GBRUplink != nil || o.GBRDownlink != nil
 }
 
 type TimingConfig struct {
-	MessageIntervalMs int `yaml:"message_interval_ms" mapstructure:"message_interval_ms"`
-	ResponseTimeoutMs int `yaml:"response_timeout_ms" mapstructure:"response_timeout_ms"`
-	MaxRetries        int `yaml:"max_retries"         mapstructure:"max_retries"`
+	MessageIntervalMs      int     `yaml:"message_interval_ms"      mapstructure:"message_interval_ms"`
+	ResponseTimeoutMs      int     `yaml:"response_timeout_ms"      mapstructure:"response_timeout_ms"`
+	MaxRetries             int     `yaml:"max_retries"              mapstructure:"max_retries"`
+	RetryBackoff           string  `yaml:"retry_backoff"            mapstructure:"retry_backoff"`
+	RetryBackoffMultiplier float64 `yaml:"retry_backoff_multiplier" mapstructure:"retry_backoff_multiplier"`
+	MaxInFlight            int     `yaml:"max_in_flight"            mapstructure:"max_in_flight"`
+}
+
+// ReportConfig controls how Session Report Requests from the UPF are answered.
+type ReportConfig struct {
+	AutoRespond   bool `yaml:"auto_respond"   mapstructure:"auto_respond"`
+	ResponseCause int  `yaml:"response_cause" mapstructure:"response_cause"`
+}
+
+// SoakConfig controls soak mode, which establishes, holds and deletes batches
+// of sessions in a loop.
+type SoakConfig struct {
+	Enabled    bool `yaml:"enabled"    mapstructure:"enabled"`
+	BatchSize  int  `yaml:"batch_size" mapstructure:"batch_size"`
+	HoldSec    int  `yaml:"hold_sec"   mapstructure:"hold_sec"`
+	Iterations int  `yaml:"iterations" mapstructure:"iterations"`
+}
+
+type NetworkConfig struct {
+	Transport         string `yaml:"transport"           mapstructure:"transport"`
+	ReceiveBuffer     int    `yaml:"receive_buffer"      mapstructure:"receive_buffer"`
+	SocketReadBuffer  int    `yaml:"socket_read_buffer"  mapstructure:"socket_read_buffer"`
+	SocketWriteBuffer int    `yaml:"socket_write_buffer" mapstructure:"socket_write_buffer"`
+	SendBatch         int    `yaml:"send_batch"          mapstructure:"send_batch"`
 }
 
 type InputConfig struct {
-	PcapFile string `yaml:"pcap_file" mapstructure:"pcap_file"`
+	PcapFile     string   `yaml:"pcap_file"     mapstructure:"pcap_file"`
+	PcapFiles    []string `yaml:"pcap_files"    mapstructure:"pcap_files"`
+	ScriptFile   string   `yaml:"script_file"   mapstructure:"script_file"`
+	PFCPPort     int      `yaml:"pfcp_port"     mapstructure:"pfcp_port"`
+	PFCPPorts    []int    `yaml:"pfcp_ports"    mapstructure:"pfcp_ports"`
+	DecapGTPU    bool     `yaml:"decap_gtpu"    mapstructure:"decap_gtpu"`
+	Stream       bool     `yaml:"stream"        mapstructure:"stream"`
+	IncludeTypes []string `yaml:"include_types" mapstructure:"include_types"`
+	ExcludeTypes []string `yaml:"exclude_types" mapstructure:"exclude_types"`
+}
+
+// Files returns the pcap files to replay, in order: pcap_files if set,
+// otherwise pcap_file, which may hold a comma-separated list. Glob patterns
+// are expanded in lexical order, and a pattern that matches no file is an
+// error.
+func (i InputConfig) Files() ([]string, error) {
+	entries := i.PcapFiles
+	if len(entries) == 0 && i.PcapFile != "" {
+		entries = strings.Split(i.PcapFile, ",")
+	}
+
+	var files []string
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.ContainsAny(entry, "*?[") {
+			files = append(files, entry)
+			continue
+		}
+		matches, err := filepath.Glob(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pcap file pattern %q: %w", entry, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no pcap file matches %q", entry)
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// Ports returns the UDP ports that carry PFCP in the input pcap: pfcp_port
+// plus any additional pfcp_ports, without duplicates.
+func (i InputConfig) Ports() []uint16 {
+	seen := make(map[int]bool)
+	var ports []uint16
+	for _, port := range append([]int{i.PFCPPort}, i.PFCPPorts...) {
+		if port <= 0 || port > 65535 || seen[port] {
+			continue
+		}
+		seen[port] = true
+		ports = append(ports, uint16(port))
+	}
+	return ports
 }
 
 type LoggingConfig struct {
-	Level   string `yaml:"level"   mapstructure:"level"`
-	File    string `yaml:"file"    mapstructure:"file"`
-	Console bool   `yaml:"console" mapstructure:"console"`
+	Level           string `yaml:"level"            mapstructure:"level"`
+	File            string `yaml:"file"             mapstructure:"file"`
+	Console         bool   `yaml:"console"          mapstructure:"console"`
+	Format          string `yaml:"format"           mapstructure:"format"`           // text or json
+	TimestampFormat string `yaml:"timestamp_format" mapstructure:"timestamp_format"` // Go time layout
+	PerMessage      bool   `yaml:"per_message"      mapstructure:"per_message"`      // Info logs for every request and response
 }
 
 type StatsConfig struct {
-	Enabled           bool   `yaml:"enabled"             mapstructure:"enabled"`
-	ReportIntervalSec int    `yaml:"report_interval_sec" mapstructure:"report_interval_sec"`
-	ExportFile        string `yaml:"export_file"         mapstructure:"export_file"`
+	Enabled             bool   `yaml:"enabled"               mapstructure:"enabled"`
+	ReportIntervalSec   int    `yaml:"report_interval_sec"   mapstructure:"report_interval_sec"`
+	ExportFile          string `yaml:"export_file"           mapstructure:"export_file"`
+	ProgressIntervalSec int    `yaml:"progress_interval_sec" mapstructure:"progress_interval_sec"`
+	ResponseTimeSamples int    `yaml:"response_time_samples" mapstructure:"response_time_samples"`
+	WarmupSec           int    `yaml:"warmup_sec"            mapstructure:"warmup_sec"`
+	PromFile            string `yaml:"prom_file"             mapstructure:"prom_file"`
+}
+
+// AssertionsConfig declares the expected outcome of a replay, checked against
+// the final statistics. If any check fails, the process exits with status 2.
+type AssertionsConfig struct {
+	MinEstablished int     `yaml:"min_established" mapstructure:"min_established"` // 0 = no check
+	MaxFailed      int     `yaml:"max_failed"      mapstructure:"max_failed"`      // Failed sessions, -1 = no check
+	MaxP99Ms       float64 `yaml:"max_p99_ms"      mapstructure:"max_p99_ms"`      // 0 = no check
+	MinThroughput  float64 `yaml:"min_throughput"  mapstructure:"min_throughput"`  // msg/s, 0 = no check
+}
+
+// IsSet reports whether any assertion is enabled.
+func (a AssertionsConfig) IsSet() bool {
+	return a.MinEstablished > 0 || a.MaxFailed >= 0 || a.MaxP99Ms > 0 || a.MinThroughput > 0
 }
 
 // SetDefaults configures default values for the configuration.
 func SetDefaults(v *viper.Viper) {
 	v.SetDefault("smf.port", 8805)
+	v.SetDefault("smf.bind_any", false)
 	v.SetDefault("upf.port", 8805)
 	v.SetDefault("association.enabled", true)
+	v.SetDefault("association.heartbeat_interval_sec", 0)
+	v.SetDefault("association.reconnect_on_restart", false)
+	v.SetDefault("association.max_setup_retries", 0)
+	v.SetDefault("association.setup_retry_interval_ms", 1000)
+	v.SetDefault("association.on_setup_failure", "continue")
+	v.SetDefault("association.probe_first", false)
+	v.SetDefault("association.probe_timeout_ms", 1000)
 	v.SetDefault("session.seid_start", 1)
 	v.SetDefault("session.seid_strategy", "sequential")
+	v.SetDefault("session.ue_ip_strategy", "sequential")
+	v.SetDefault("session.ue_ip_skip_broadcast", false)
 	v.SetDefault("session.strip_ipv6", true)
 	v.SetDefault("session.cleanup_on_exit", false)
+	v.SetDefault("session.cleanup_timeout_sec", 30)
+	v.SetDefault("session.cleanup_concurrency", 8)
+	v.SetDefault("session.rewrite_teid", true)
+	v.SetDefault("session.state_interval_sec", 10)
+	v.SetDefault("session.set_message_priority", false)
+	v.SetDefault("session.message_priority", 0)
 	v.SetDefault("timing.message_interval_ms", 100)
 	v.SetDefault("timing.response_timeout_ms", 5000)
 	v.SetDefault("timing.max_retries", 3)
+	v.SetDefault("timing.retry_backoff", "fixed")
+	v.SetDefault("timing.retry_backoff_multiplier", 2.0)
+	v.SetDefault("timing.max_in_flight", 0)
+	v.SetDefault("report.auto_respond", true)
+	v.SetDefault("report.response_cause", 1)
+	v.SetDefault("soak.enabled", false)
+	v.SetDefault("soak.batch_size", 100)
+	v.SetDefault("soak.hold_sec", 60)
+	v.SetDefault("soak.iterations", 0)
+	v.SetDefault("network.transport", "udp")
+	v.SetDefault("network.receive_buffer", 1000)
+	v.SetDefault("network.socket_read_buffer", 0)
+	v.SetDefault("network.socket_write_buffer", 0)
+	v.SetDefault("network.send_batch", 0)
+	v.SetDefault("input.pfcp_port", 8805)
+	v.SetDefault("input.decap_gtpu", false)
+	v.SetDefault("input.stream", false)
 	v.SetDefault("logging.level", "info")
 	v.SetDefault("logging.console", true)
+	v.SetDefault("logging.format", "text")
+	v.SetDefault("logging.per_message", true)
+	v.SetDefault("logging.timestamp_format", "2006-01-02 15:04:05.000")
 	v.SetDefault("stats.enabled", true)
 	v.SetDefault("stats.report_interval_sec", 10)
+	v.SetDefault("stats.progress_interval_sec", 5)
+	v.SetDefault("stats.response_time_samples", 10000)
+	v.SetDefault("stats.warmup_sec", 0)
+	v.SetDefault("assertions.min_established", 0)
+	v.SetDefault("assertions.max_failed", -1)
+	v.SetDefault("assertions.max_p99_ms", 0)
+	v.SetDefault("assertions.min_throughput", 0)
 }
 
 // Load reads configuration from a YAML file and returns a Config.
@@ -115,15 +370,159 @@ func LoadWithViper(v *viper.Viper) (*Config, error) {
 func (c *Config) Summary() string {
 	var sb strings.Builder
 	sb.WriteString("Configuration:\n")
-	sb.WriteString(fmt.Sprintf("  SMF:           %s:%d\n", c.SMF.Address, c.SMF.Port))
+	sb.WriteString(fmt.Sprintf("  SMF:           %s:%d (bind %s)\n", c.SMF.Address, c.SMF.Port, c.SMF.BindAddress()))
+	if c.SMF.Interface != "" {
+		sb.WriteString(fmt.Sprintf("  SMF Interface: %s\n", c.SMF.Interface))
+	}
+	if c.SMF.NodeID != "" {
+		sb.WriteString(fmt.Sprintf("  Node ID:       %s\n", c.SMF.NodeID))
+	}
 	sb.WriteString(fmt.Sprintf("  UPF:           %s:%d\n", c.UPF.Address, c.UPF.Port))
-	sb.WriteString(fmt.Sprintf("  Association:   enabled=%v\n", c.Association.Enabled))
-	sb.WriteString(fmt.Sprintf("  PCAP:          %s\n", c.Input.PcapFile))
-	sb.WriteString(fmt.Sprintf("  UE Pool:       %s\n", c.Session.UEIPPool))
+	sb.WriteString(fmt.Sprintf("  Transport:     %s (receive buffer: %d)\n", c.Network.Transport, c.Network.ReceiveBuffer))
+	if c.Network.SocketReadBuffer > 0 || c.Network.SocketWriteBuffer > 0 {
+		sb.WriteString(fmt.Sprintf("  Socket Bufs:   read=%d write=%d bytes (0 = default)\n", c.Network.SocketReadBuffer, c.Network.SocketWriteBuffer))
+	}
+	if c.Network.SendBatch > 1 {
+		sb.WriteString(fmt.Sprintf("  Send Batch:    %d cleanup deletions\n", c.Network.SendBatch))
+	}
+	sb.WriteString(fmt.Sprintf("  Association:   enabled=%v reconnect_on_restart=%v\n", c.Association.Enabled, c.Association.ReconnectOnRestart))
+	if c.Association.MaxSetupRetries > 0 {
+		sb.WriteString(fmt.Sprintf("  Assoc Retry:   %d every %dms, then %s\n", c.Association.MaxSetupRetries, c.Association.SetupRetryIntervalMs, c.Association.OnSetupFailure))
+	}
+	if c.Association.ProbeFirst {
+		sb.WriteString(fmt.Sprintf("  Probe:         Heartbeat first, %dms timeout\n", c.Association.ProbeTimeoutMs))
+	}
+	if c.Association.HeartbeatIntervalSec > 0 {
+		sb.WriteString(fmt.Sprintf("  Heartbeat:     every %ds\n", c.Association.HeartbeatIntervalSec))
+	}
+	if c.Association.CPFunctionFeatures != "" {
+		sb.WriteString(fmt.Sprintf("  CP Features:   %s\n", c.Association.CPFunctionFeatures))
+	}
+	if c.Association.TemplatePcap != "" {
+		sb.WriteString(fmt.Sprintf("  Assoc From:    %s (if the input has none)\n", c.Association.TemplatePcap))
+	}
+	if c.Input.ScriptFile != "" {
+		sb.WriteString(fmt.Sprintf("  Script:        %s\n", c.Input.ScriptFile))
+	} else {
+		pcapFiles, _ := c.Input.Files()
+		sb.WriteString(fmt.Sprintf("  PCAP:          %s (PFCP ports %v)\n", strings.Join(pcapFiles, ", "), c.Input.Ports()))
+	}
+	if c.Input.DecapGTPU {
+		sb.WriteString("  GTP-U decap:   true\n")
+	}
+	if c.Input.Stream {
+		sb.WriteString("  Streaming:     true\n")
+	}
+	if len(c.Input.IncludeTypes) > 0 {
+		sb.WriteString(fmt.Sprintf("  Only Types:    %s\n", strings.Join(c.Input.IncludeTypes, ", ")))
+	}
+	if len(c.Input.ExcludeTypes) > 0 {
+		sb.WriteString(fmt.Sprintf("  Skip Types:    %s\n", strings.Join(c.Input.ExcludeTypes, ", ")))
+	}
+	sb.WriteString(fmt.Sprintf("  UE Pool:       %s (%s)\n", c.Session.UEIPPool, c.Session.UEIPStrategy))
+	if len(c.Session.UEIPPools) > 0 {
+		sb.WriteString(fmt.Sprintf("  UE DNN Pools:  %d pool(s)\n", len(c.Session.UEIPPools)))
+	}
+	if len(c.Session.UEIPExclude) > 0 || c.Session.UEIPSkipBroadcast {
+		sb.WriteString(fmt.Sprintf("  UE Exclude:    %s (skip broadcast: %v)\n", strings.Join(c.Session.UEIPExclude, ", "), c.Session.UEIPSkipBroadcast))
+	}
+	if c.Session.UEMACPool != "" {
+		sb.WriteString(fmt.Sprintf("  UE MAC Pool:   %s\n", c.Session.UEMACPool))
+	}
 	sb.WriteString(fmt.Sprintf("  Strip IPv6:    %v\n", c.Session.StripIPv6))
-	sb.WriteString(fmt.Sprintf("  SEID Start:    %d (%s)\n", c.Session.SEIDStart, c.Session.SEIDStrategy))
+	if c.Session.SEIDRangeEnd != 0 {
+		sb.WriteString(fmt.Sprintf("  SEID Range:    %d-%d (%s)\n", c.Session.SEIDStart, c.Session.SEIDRangeEnd, c.Session.SEIDStrategy))
+	} else {
+		sb.WriteString(fmt.Sprintf("  SEID Start:    %d (%s)\n", c.Session.SEIDStart, c.Session.SEIDStrategy))
+	}
+	sb.WriteString(fmt.Sprintf("  Rewrite TEID:  %v\n", c.Session.RewriteTEID))
+	if c.Session.NetworkInstanceOverride != "" {
+		sb.WriteString(fmt.Sprintf("  Network Inst:  %s (override)\n", c.Session.NetworkInstanceOverride))
+	} else if len(c.Session.NetworkInstanceMap) > 0 {
+		sb.WriteString(fmt.Sprintf("  Network Inst:  %d mapping(s)\n", len(c.Session.NetworkInstanceMap)))
+	}
 	sb.WriteString(fmt.Sprintf("  Msg Interval:  %dms\n", c.Timing.MessageIntervalMs))
-	sb.WriteString(fmt.Sprintf("  Timeout:       %dms (retries: %d)\n", c.Timing.ResponseTimeoutMs, c.Timing.MaxRetries))
-	sb.WriteString(fmt.Sprintf("  Cleanup:       %v\n", c.Session.CleanupOnExit))
+	sb.WriteString(fmt.Sprintf("  Timeout:       %dms (retries: %d, backoff: %s)\n", c.Timing.ResponseTimeoutMs, c.Timing.MaxRetries, c.Timing.RetryBackoff))
+	if c.Timing.MaxInFlight > 0 {
+		sb.WriteString(fmt.Sprintf("  In Flight:     max %d\n", c.Timing.MaxInFlight))
+	}
+	if c.Report.AutoRespond {
+		sb.WriteString(fmt.Sprintf("  Reports:       answer with %s\n", pfcp.CauseName(uint8(c.Report.ResponseCause))))
+	} else {
+		sb.WriteString("  Reports:       not answered\n")
+	}
+	if peer := c.Session.GTPPeerOverride; peer.IP != "" || peer.TEIDBase != 0 {
+		sb.WriteString(fmt.Sprintf("  GTP-U Peer:    ip=%s teid_base=%d\n", peer.IP, peer.TEIDBase))
+	}
+	if o := c.Session.PDROverride; o.IsSet() {
+		var fields []string
+		if o.Precedence != nil {
+			fields = append(fields, fmt.Sprintf("precedence=%d", *o.Precedence))
+		}
+		if o.SourceInterface != "" {
+			fields = append(fields, "source_interface="+o.SourceInterface)
+		}
+		if o.OuterHeaderRemoval != "" {
+			fields = append(fields, "outer_header_removal="+o.OuterHeaderRemoval)
+		}
+		sb.WriteString(fmt.Sprintf("  PDR Override:  %s (all Create PDRs)\n", strings.Join(fields, " ")))
+	}
+	if o := c.Session.QEROverride; o.IsSet() {
+		var fields []string
+		for _, r := range []struct {
+			name string
+			kbps *uint64
+		}{
+			{"mbr_ul", o.MBRUplink}, {"mbr_dl", o.MBRDownlink}, {"gbr_ul", o.GBRUplink}, {"gbr_dl", o.GBRDownlink},
+		} {
+			if r.kbps != nil {
+				fields = append(fields, fmt.Sprintf("%s=%d", r.name, *r.kbps))
+			}
+		}
+		sb.WriteString(fmt.Sprintf("  QER Override:  %s kbps (all QERs)\n", strings.Join(fields, " ")))
+	}
+	if c.Session.ApplyActionOverride != "" {
+		sb.WriteString(fmt.Sprintf("  Apply Action:  %s (all FARs)\n", c.Session.ApplyActionOverride))
+	}
+	if c.Session.SetMessagePriority {
+		sb.WriteString(fmt.Sprintf("  Msg Priority:  %d (session requests)\n", c.Session.MessagePriority))
+	}
+	if c.Session.CleanupOnExit {
+		sb.WriteString(fmt.Sprintf("  Cleanup:       true (timeout %ds, %d concurrent)\n", c.Session.CleanupTimeoutSec, c.Session.CleanupConcurrency))
+	} else {
+		sb.WriteString("  Cleanup:       false\n")
+	}
+	if c.Soak.Enabled {
+		iterations := "until stopped"
+		if c.Soak.Iterations > 0 {
+			iterations = fmt.Sprintf("%d cycles", c.Soak.Iterations)
+		}
+		sb.WriteString(fmt.Sprintf("  Soak:          %d sessions held %ds, %s\n", c.Soak.BatchSize, c.Soak.HoldSec, iterations))
+	}
+	if c.Session.StateFile != "" {
+		sb.WriteString(fmt.Sprintf("  State File:    %s (every %ds)\n", c.Session.StateFile, c.Session.StateIntervalSec))
+	}
+	if c.Stats.WarmupSec > 0 {
+		sb.WriteString(fmt.Sprintf("  Warm-up:       %ds (reported separately)\n", c.Stats.WarmupSec))
+	}
+	if c.Stats.PromFile != "" {
+		sb.WriteString(fmt.Sprintf("  Prometheus:    %s\n", c.Stats.PromFile))
+	}
+	if a := c.Assertions; a.IsSet() {
+		var checks []string
+		if a.MinEstablished > 0 {
+			checks = append(checks, fmt.Sprintf("established>=%d", a.MinEstablished))
+		}
+		if a.MaxFailed >= 0 {
+			checks = append(checks, fmt.Sprintf("failed<=%d", a.MaxFailed))
+		}
+		if a.MaxP99Ms > 0 {
+			checks = append(checks, fmt.Sprintf("p99<=%gms", a.MaxP99Ms))
+		}
+		if a.MinThroughput > 0 {
+			checks = append(checks, fmt.Sprintf("throughput>=%g msg/s", a.MinThroughput))
+		}
+		sb.WriteString(fmt.Sprintf("  Assertions:    %s\n", strings.Join(checks, " ")))
+	}
 	return sb.String()
 }
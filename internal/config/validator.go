@@ -4,11 +4,27 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"sort"
 	"strings"
+
+	"pfcp-generator/internal/pfcp"
 )
 
+// maxBitRate is the largest value of the 40-bit MBR and GBR fields, in kbps.
+const maxBitRate = 1<<40 - 1
+
 // Validate checks that the configuration is valid.
 func (c *Config) Validate() error {
+	return c.validate(false)
+}
+
+// ValidateDryRun checks the settings used by a dry run, which rewrites
+// messages but does not talk to the UPF.
+func (c *Config) ValidateDryRun() error {
+	return c.validate(true)
+}
+
+func (c *Config) validate(dryRun bool) error {
 	var errs []string
 
 	// SMF address must be a valid IP
@@ -16,33 +32,158 @@ func (c *Config) Validate() error {
 		errs = append(errs, fmt.Sprintf("smf.address must be a valid IP address, got %q", c.SMF.Address))
 	}
 
-	// SMF port must be valid
-	if c.SMF.Port <= 0 || c.SMF.Port > 65535 {
-		errs = append(errs, fmt.Sprintf("smf.port must be between 1 and 65535, got %d", c.SMF.Port))
+	// CP Function Features must be a bitmask or known flag names
+	if f := c.Association.CPFunctionFeatures; f != "" {
+		if _, err := pfcp.ParseCPFunctionFeatures(f); err != nil {
+			errs = append(errs, fmt.Sprintf("association.cp_function_features: %v", err))
+		}
 	}
 
-	// UPF address must be a valid IP
-	if net.ParseIP(c.UPF.Address) == nil {
-		errs = append(errs, fmt.Sprintf("upf.address must be a valid IP address, got %q", c.UPF.Address))
+	// Node ID, if set, must be either an IP address or a valid FQDN
+	if c.SMF.NodeID != "" && net.ParseIP(c.SMF.NodeID) == nil && !isValidFQDN(c.SMF.NodeID) {
+		errs = append(errs, fmt.Sprintf("smf.node_id must be an IP address or a valid FQDN, got %q", c.SMF.NodeID))
 	}
 
-	// UPF port must be valid
-	if c.UPF.Port <= 0 || c.UPF.Port > 65535 {
-		errs = append(errs, fmt.Sprintf("upf.port must be between 1 and 65535, got %d", c.UPF.Port))
+	// Progress interval must be non-negative (0 = disabled)
+	if c.Stats.ProgressIntervalSec < 0 {
+		errs = append(errs, "stats.progress_interval_sec must be >= 0")
+	}
+	if c.Stats.ResponseTimeSamples < 1 {
+		errs = append(errs, fmt.Sprintf("stats.response_time_samples must be >= 1, got %d", c.Stats.ResponseTimeSamples))
+	}
+	if c.Stats.WarmupSec < 0 {
+		errs = append(errs, "stats.warmup_sec must be >= 0")
+	}
+	// node_exporter's textfile collector only reads *.prom files
+	if c.Stats.PromFile != "" && !strings.HasSuffix(c.Stats.PromFile, ".prom") {
+		errs = append(errs, fmt.Sprintf("stats.prom_file must end in .prom, got %q", c.Stats.PromFile))
+	}
+
+	// Assertions: 0, or -1 for max_failed, disables a check
+	if c.Assertions.MinEstablished < 0 {
+		errs = append(errs, "assertions.min_established must be >= 0")
+	}
+	if c.Assertions.MaxFailed < -1 {
+		errs = append(errs, "assertions.max_failed must be >= 0, or -1 for no check")
+	}
+	if c.Assertions.MaxP99Ms < 0 {
+		errs = append(errs, "assertions.max_p99_ms must be >= 0")
+	}
+	if c.Assertions.MinThroughput < 0 {
+		errs = append(errs, "assertions.min_throughput must be >= 0")
+	}
+
+	// Network and timing settings are not used in a dry run
+	if !dryRun {
+		errs = append(errs, c.networkErrors()...)
+	}
+
+	// PCAP files or the script file must exist
+	if c.Input.PcapFile != "" && len(c.Input.PcapFiles) > 0 {
+		errs = append(errs, "set only one of input.pcap_file and input.pcap_files")
+	}
+	if c.Input.ScriptFile != "" {
+		if c.Input.PcapFile != "" || len(c.Input.PcapFiles) > 0 {
+			errs = append(errs, "input.script_file cannot be combined with input.pcap_file or input.pcap_files")
+		}
+		if c.Input.Stream {
+			errs = append(errs, "input.stream cannot be combined with input.script_file")
+		}
+		if _, err := os.Stat(c.Input.ScriptFile); os.IsNotExist(err) {
+			errs = append(errs, fmt.Sprintf("script file not found: %s", c.Input.ScriptFile))
+		}
+	} else if pcapFiles, err := c.Input.Files(); err != nil {
+		errs = append(errs, err.Error())
+	} else if len(pcapFiles) == 0 {
+		errs = append(errs, "input.pcap_file, input.pcap_files or input.script_file must be specified")
+	} else {
+		for _, file := range pcapFiles {
+			if _, err := os.Stat(file); os.IsNotExist(err) {
+				errs = append(errs, fmt.Sprintf("pcap file not found: %s", file))
+			}
+		}
+	}
+
+	// Association template pcap must exist if set
+	if t := c.Association.TemplatePcap; t != "" {
+		if _, err := os.Stat(t); os.IsNotExist(err) {
+			errs = append(errs, fmt.Sprintf("association.template_pcap not found: %s", t))
+		}
 	}
 
-	// PCAP file must exist
-	if c.Input.PcapFile == "" {
-		errs = append(errs, "input.pcap_file must be specified")
-	} else if _, err := os.Stat(c.Input.PcapFile); os.IsNotExist(err) {
-		errs = append(errs, fmt.Sprintf("pcap file not found: %s", c.Input.PcapFile))
+	// PFCP ports in the pcap must be valid
+	for _, port := range append([]int{c.Input.PFCPPort}, c.Input.PFCPPorts...) {
+		if port <= 0 || port > 65535 {
+			errs = append(errs, fmt.Sprintf("input.pfcp_port/pfcp_ports must be between 1 and 65535, got %d", port))
+		}
+	}
+
+	// Message type filters must name known message types
+	if _, err := pfcp.ParseMessageTypes(c.Input.IncludeTypes); err != nil {
+		errs = append(errs, fmt.Sprintf("input.include_types: %v", err))
+	}
+	if _, err := pfcp.ParseMessageTypes(c.Input.ExcludeTypes); err != nil {
+		errs = append(errs, fmt.Sprintf("input.exclude_types: %v", err))
 	}
 
 	// UE IP pool must be valid CIDR
 	if c.Session.UEIPPool == "" {
 		errs = append(errs, "session.ue_ip_pool must be specified")
-	} else if _, _, err := net.ParseCIDR(c.Session.UEIPPool); err != nil {
+	} else if _, pool, err := net.ParseCIDR(c.Session.UEIPPool); err != nil {
 		errs = append(errs, fmt.Sprintf("invalid UE IP pool CIDR %q: %v", c.Session.UEIPPool, err))
+	} else if pool.IP.To4() == nil {
+		errs = append(errs, c.ipv6PoolError("session.ue_ip_pool", pool))
+	} else {
+		// Per-DNN pools must be valid and must not overlap any other pool
+		pools := []*net.IPNet{pool}
+		dnns := make([]string, 0, len(c.Session.UEIPPools))
+		for dnn := range c.Session.UEIPPools {
+			dnns = append(dnns, dnn)
+		}
+		sort.Strings(dnns)
+		for _, dnn := range dnns {
+			_, dnnPool, err := net.ParseCIDR(c.Session.UEIPPools[dnn])
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("invalid session.ue_ip_pools[%s] CIDR %q: %v", dnn, c.Session.UEIPPools[dnn], err))
+				continue
+			}
+			if dnnPool.IP.To4() == nil {
+				errs = append(errs, c.ipv6PoolError(fmt.Sprintf("session.ue_ip_pools[%s]", dnn), dnnPool))
+				continue
+			}
+			for _, other := range pools {
+				if other.Contains(dnnPool.IP) || dnnPool.Contains(other.IP) {
+					errs = append(errs, fmt.Sprintf("session.ue_ip_pools[%s] %s overlaps UE IP pool %s", dnn, dnnPool, other))
+				}
+			}
+			pools = append(pools, dnnPool)
+		}
+
+		// Excluded addresses must be IPs or CIDRs within a pool
+		for _, entry := range c.Session.UEIPExclude {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				var err error
+				if ip, _, err = net.ParseCIDR(entry); err != nil {
+					errs = append(errs, fmt.Sprintf("invalid session.ue_ip_exclude entry %q: must be an IP or CIDR", entry))
+					continue
+				}
+			}
+			inPool := false
+			for _, p := range pools {
+				inPool = inPool || p.Contains(ip)
+			}
+			if !inPool {
+				errs = append(errs, fmt.Sprintf("session.ue_ip_exclude entry %q is outside the UE IP pools", entry))
+			}
+		}
+	}
+
+	// UE MAC pool, for Ethernet PDU sessions, must be a MAC prefix
+	if c.Session.UEMACPool != "" {
+		if _, _, err := pfcp.ParseMACPool(c.Session.UEMACPool); err != nil {
+			errs = append(errs, fmt.Sprintf("session.ue_mac_pool: %v", err))
+		}
 	}
 
 	// SEID start must be > 0
@@ -50,19 +191,63 @@ func (c *Config) Validate() error {
 		errs = append(errs, "session.seid_start must be > 0")
 	}
 
+	// SEID range end, if set, must not be below the start
+	if c.Session.SEIDRangeEnd != 0 && c.Session.SEIDRangeEnd < c.Session.SEIDStart {
+		errs = append(errs, fmt.Sprintf("session.seid_range_end (%d) must be >= session.seid_start (%d)", c.Session.SEIDRangeEnd, c.Session.SEIDStart))
+	}
+
 	// SEID strategy must be known
 	if c.Session.SEIDStrategy != "sequential" && c.Session.SEIDStrategy != "random" {
 		errs = append(errs, fmt.Sprintf("session.seid_strategy must be 'sequential' or 'random', got %q", c.Session.SEIDStrategy))
 	}
 
-	// Response timeout must be positive
-	if c.Timing.ResponseTimeoutMs <= 0 {
-		errs = append(errs, "timing.response_timeout_ms must be > 0")
+	// GTP-U peer override IP must be valid if set
+	if ip := c.Session.GTPPeerOverride.IP; ip != "" && net.ParseIP(ip) == nil {
+		errs = append(errs, fmt.Sprintf("session.gtp_peer_override.ip must be a valid IP address, got %q", ip))
 	}
 
-	// Max retries must be non-negative
-	if c.Timing.MaxRetries < 0 {
-		errs = append(errs, "timing.max_retries must be >= 0")
+	// PDR override values must be known names
+	if name := c.Session.PDROverride.SourceInterface; name != "" {
+		if _, err := pfcp.ParseInterface(name); err != nil {
+			errs = append(errs, fmt.Sprintf("session.pdr_override.source_interface: %v", err))
+		}
+	}
+	if name := c.Session.PDROverride.OuterHeaderRemoval; name != "" {
+		if _, err := pfcp.ParseOuterHeaderRemoval(name); err != nil {
+			errs = append(errs, fmt.Sprintf("session.pdr_override.outer_header_removal: %v", err))
+		}
+	}
+
+	// QER bit rates are 40-bit fields
+	for _, r := range []struct {
+		key  string
+		kbps *uint64
+	}{
+		{"mbr_ul", c.Session.QEROverride.MBRUplink},
+		{"mbr_dl", c.Session.QEROverride.MBRDownlink},
+		{"gbr_ul", c.Session.QEROverride.GBRUplink},
+		{"gbr_dl", c.Session.QEROverride.GBRDownlink},
+	} {
+		if r.kbps != nil && *r.kbps > maxBitRate {
+			errs = append(errs, fmt.Sprintf("session.qer_override.%s must be <= %d kbps, got %d", r.key, uint64(maxBitRate), *r.kbps))
+		}
+	}
+
+	// Apply Action override must be a legal flag combination
+	if a := c.Session.ApplyActionOverride; a != "" {
+		if _, err := pfcp.ParseApplyAction(a); err != nil {
+			errs = append(errs, fmt.Sprintf("session.apply_action_override: %v", err))
+		}
+	}
+
+	// Message priority is a 4-bit header field
+	if c.Session.MessagePriority < 0 || c.Session.MessagePriority > 15 {
+		errs = append(errs, fmt.Sprintf("session.message_priority must be between 0 and 15, got %d", c.Session.MessagePriority))
+	}
+
+	// UE IP strategy must be known
+	if c.Session.UEIPStrategy != "sequential" && c.Session.UEIPStrategy != "deterministic" {
+		errs = append(errs, fmt.Sprintf("session.ue_ip_strategy must be 'sequential' or 'deterministic', got %q", c.Session.UEIPStrategy))
 	}
 
 	// Log level must be valid
@@ -70,9 +255,181 @@ func (c *Config) Validate() error {
 	if !validLevels[c.Logging.Level] {
 		errs = append(errs, fmt.Sprintf("logging.level must be one of debug/info/warn/error, got %q", c.Logging.Level))
 	}
+	if c.Logging.Format != "text" && c.Logging.Format != "json" {
+		errs = append(errs, fmt.Sprintf("logging.format must be 'text' or 'json', got %q", c.Logging.Format))
+	}
 
 	if len(errs) > 0 {
 		return fmt.Errorf("configuration errors:\n  - %s", strings.Join(errs, "\n  - "))
 	}
 	return nil
 }
+
+// ipFamily returns "IPv4" or "IPv6" for ip.
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "IPv4"
+	}
+	return "IPv6"
+}
+
+// ipv6PoolError reports an IPv6 UE IP pool: pool addresses replace the IPv4
+// address of UE IP Address IEs, and the IPv6 one is kept or stripped.
+func (c *Config) ipv6PoolError(key string, pool *net.IPNet) string {
+	msg := fmt.Sprintf("%s %s is IPv6, but UE IP pools must be IPv4: they replace the IPv4 address of UE IP Address IEs", key, pool)
+	if c.Session.StripIPv6 {
+		msg += ", and session.strip_ipv6 removes the IPv6 one"
+	}
+	return msg
+}
+
+// networkErrors checks the UPF, transport and timing settings.
+func (c *Config) networkErrors() []string {
+	var errs []string
+
+	// SMF port must be valid (0 = ephemeral port chosen by the OS)
+	if c.SMF.Port < 0 || c.SMF.Port > 65535 {
+		errs = append(errs, fmt.Sprintf("smf.port must be between 0 and 65535, got %d", c.SMF.Port))
+	}
+
+	// UPF address must be a valid IP, of the family of the SMF address that
+	// the socket is bound to
+	if upfIP := net.ParseIP(c.UPF.Address); upfIP == nil {
+		errs = append(errs, fmt.Sprintf("upf.address must be a valid IP address, got %q", c.UPF.Address))
+	} else if smfIP := net.ParseIP(c.SMF.Address); smfIP != nil && (smfIP.To4() == nil) != (upfIP.To4() == nil) {
+		errs = append(errs, fmt.Sprintf("smf.address %s (%s) and upf.address %s (%s) must be of the same address family",
+			smfIP, ipFamily(smfIP), upfIP, ipFamily(upfIP)))
+	}
+
+	// UPF port must be valid
+	if c.UPF.Port <= 0 || c.UPF.Port > 65535 {
+		errs = append(errs, fmt.Sprintf("upf.port must be between 1 and 65535, got %d", c.UPF.Port))
+	}
+
+	// Transport must be known
+	if c.Network.Transport != "udp" && c.Network.Transport != "tcp" {
+		errs = append(errs, fmt.Sprintf("network.transport must be 'udp' or 'tcp', got %q", c.Network.Transport))
+	}
+
+	// Receive buffer must hold at least one message
+	if c.Network.ReceiveBuffer <= 0 {
+		errs = append(errs, fmt.Sprintf("network.receive_buffer must be > 0, got %d", c.Network.ReceiveBuffer))
+	}
+
+	// Socket buffer sizes must be non-negative (0 = OS default)
+	if c.Network.SocketReadBuffer < 0 {
+		errs = append(errs, fmt.Sprintf("network.socket_read_buffer must be >= 0, got %d", c.Network.SocketReadBuffer))
+	}
+	if c.Network.SocketWriteBuffer < 0 {
+		errs = append(errs, fmt.Sprintf("network.socket_write_buffer must be >= 0, got %d", c.Network.SocketWriteBuffer))
+	}
+
+	// A batch is tracked in full before it is sent, so it must fit the in-flight window
+	if c.Network.SendBatch < 0 {
+		errs = append(errs, fmt.Sprintf("network.send_batch must be >= 0, got %d", c.Network.SendBatch))
+	} else if c.Timing.MaxInFlight > 0 && c.Network.SendBatch > c.Timing.MaxInFlight {
+		errs = append(errs, fmt.Sprintf("network.send_batch (%d) must not exceed timing.max_in_flight (%d)", c.Network.SendBatch, c.Timing.MaxInFlight))
+	}
+
+	// Heartbeat interval must be non-negative (0 = disabled)
+	if c.Association.HeartbeatIntervalSec < 0 {
+		errs = append(errs, "association.heartbeat_interval_sec must be >= 0")
+	}
+
+	// Association Setup retries must be non-negative with a positive interval
+	if c.Association.MaxSetupRetries < 0 {
+		errs = append(errs, "association.max_setup_retries must be >= 0")
+	}
+	if c.Association.MaxSetupRetries > 0 && c.Association.SetupRetryIntervalMs <= 0 {
+		errs = append(errs, "association.setup_retry_interval_ms must be > 0")
+	}
+	if c.Association.ProbeFirst && c.Association.ProbeTimeoutMs <= 0 {
+		errs = append(errs, "association.probe_timeout_ms must be > 0")
+	}
+	if c.Association.OnSetupFailure != "abort" && c.Association.OnSetupFailure != "continue" {
+		errs = append(errs, fmt.Sprintf("association.on_setup_failure must be 'abort' or 'continue', got %q", c.Association.OnSetupFailure))
+	}
+
+	// Cleanup timeout and concurrency must be positive when cleanup is enabled
+	if c.Session.CleanupOnExit && c.Session.CleanupTimeoutSec <= 0 {
+		errs = append(errs, "session.cleanup_timeout_sec must be > 0")
+	}
+	if c.Session.CleanupOnExit && c.Session.CleanupConcurrency <= 0 {
+		errs = append(errs, "session.cleanup_concurrency must be > 0")
+	}
+
+	// State snapshot interval must be positive when a state file is set
+	if c.Session.StateFile != "" && c.Session.StateIntervalSec <= 0 {
+		errs = append(errs, "session.state_interval_sec must be > 0")
+	}
+
+	// Session Report response Cause must fit the one-octet Cause IE
+	if c.Report.AutoRespond && (c.Report.ResponseCause < 1 || c.Report.ResponseCause > 255) {
+		errs = append(errs, fmt.Sprintf("report.response_cause must be between 1 and 255, got %d", c.Report.ResponseCause))
+	}
+
+	// Soak cycles need at least one session and a non-negative hold time
+	if c.Soak.Enabled {
+		if c.Soak.BatchSize <= 0 {
+			errs = append(errs, "soak.batch_size must be > 0")
+		}
+		if c.Soak.HoldSec < 0 {
+			errs = append(errs, "soak.hold_sec must be >= 0")
+		}
+		if c.Soak.Iterations < 0 {
+			errs = append(errs, "soak.iterations must be >= 0 (0 = until stopped)")
+		}
+		if c.Input.Stream {
+			errs = append(errs, "soak mode needs the whole pcap in memory and cannot be combined with input.stream")
+		}
+	}
+
+	// Response timeout must be positive
+	if c.Timing.ResponseTimeoutMs <= 0 {
+		errs = append(errs, "timing.response_timeout_ms must be > 0")
+	}
+
+	// Max retries must be non-negative
+	if c.Timing.MaxRetries < 0 {
+		errs = append(errs, "timing.max_retries must be >= 0")
+	}
+
+	// Retry backoff must be known
+	switch c.Timing.RetryBackoff {
+	case "fixed":
+	case "exponential":
+		if c.Timing.RetryBackoffMultiplier < 1 {
+			errs = append(errs, fmt.Sprintf("timing.retry_backoff_multiplier must be >= 1, got %g", c.Timing.RetryBackoffMultiplier))
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("timing.retry_backoff must be 'fixed' or 'exponential', got %q", c.Timing.RetryBackoff))
+	}
+
+	// In-flight window must be non-negative (0 = unlimited)
+	if c.Timing.MaxInFlight < 0 {
+		errs = append(errs, "timing.max_in_flight must be >= 0")
+	}
+
+	return errs
+}
+
+// isValidFQDN reports whether s is a valid domain name: dot-separated labels of
+// 1-63 letters, digits and hyphens, not starting or ending with a hyphen, at
+// most 255 bytes in total. A trailing dot is allowed.
+func isValidFQDN(s string) bool {
+	s = strings.TrimSuffix(s, ".")
+	if s == "" || len(s) > 255 {
+		return false
+	}
+	for _, label := range strings.Split(s, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
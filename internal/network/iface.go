@@ -0,0 +1,35 @@
+package network
+
+import (
+	"fmt"
+	"net"
+)
+
+// InterfaceAddress returns the first IPv4 address of the named network
+// interface, or its first IPv6 address if ipv6 is set, for binding the SMF
+// to an interface rather than an IP. Link-local addresses are skipped.
+func InterfaceAddress(name string, ipv6 bool) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("interface %q not found: %w", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses of interface %q: %w", name, err)
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP
+		if (ip.To4() == nil) == ipv6 && !ip.IsLinkLocalUnicast() {
+			return ip, nil
+		}
+	}
+	family := "IPv4"
+	if ipv6 {
+		family = "IPv6"
+	}
+	return nil, fmt.Errorf("interface %q has no %s address", name, family)
+}
@@ -0,0 +1,31 @@
+package network
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterfaceAddress(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	require.NoError(t, err)
+	var loopback string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			loopback = iface.Name
+			break
+		}
+	}
+	if loopback == "" {
+		t.Skip("no loopback interface")
+	}
+
+	ip, err := InterfaceAddress(loopback, false)
+	require.NoError(t, err)
+	assert.True(t, ip.Equal(net.IPv4(127, 0, 0, 1)), "got %s", ip)
+
+	_, err = InterfaceAddress("no-such-iface0", false)
+	assert.ErrorContains(t, err, `interface "no-such-iface0" not found`)
+}
@@ -1,39 +1,89 @@
 package network
 
 import (
+	"bufio"
 	"context"
+	"encoding/binary"
+	"errors"
+	"io"
 	"net"
+	"sync/atomic"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/wmnsk/go-pfcp/message"
 )
 
+// pfcpHeaderPrefixLen is the number of octets preceding the PFCP length field's coverage
+// (flags, message type, and the 2-octet length itself).
+const pfcpHeaderPrefixLen = 4
+
+// DefaultReceiveBuffer is the number of received messages queued for the
+// response handler when no size is configured.
+const DefaultReceiveBuffer = 1000
+
+// socketBytesPerMessage is the socket read buffer reserved per queued message,
+// enough for a typical PFCP message in a single Ethernet frame.
+const socketBytesPerMessage = 1500
+
 // ReceivedMessage represents a PFCP message received from the UPF.
 type ReceivedMessage struct {
 	Message message.Message
 	Data    []byte
-	From    *net.UDPAddr
+	From    net.Addr
 }
 
 // Receiver listens for PFCP responses from the UPF.
 type Receiver struct {
-	conn    *net.UDPConn
-	msgChan chan ReceivedMessage
+	conn     net.Conn
+	msgChan  chan ReceivedMessage
+	recorder PacketRecorder
+	dropped  atomic.Uint64
+	onDrop   func()
 }
 
-// NewReceiver creates a new receiver using the same UDP connection as the sender.
-func NewReceiver(conn *net.UDPConn) *Receiver {
+// NewReceiver creates a new receiver using the same connection as the sender,
+// queueing up to bufferSize received messages (DefaultReceiveBuffer if <= 0).
+// UDP connections are read per datagram, and their socket read buffer is
+// enlarged to hold about as many datagrams as the queue; stream connections
+// (TCP) are framed using the PFCP header length.
+func NewReceiver(conn net.Conn, bufferSize int) *Receiver {
+	if bufferSize <= 0 {
+		bufferSize = DefaultReceiveBuffer
+	}
+	if udpConn, ok := conn.(*net.UDPConn); ok {
+		if err := udpConn.SetReadBuffer(bufferSize * socketBytesPerMessage); err != nil {
+			log.WithError(err).Warn("Failed to set UDP socket read buffer")
+		}
+	}
 	return &Receiver{
 		conn:    conn,
-		msgChan: make(chan ReceivedMessage, 1000),
+		msgChan: make(chan ReceivedMessage, bufferSize),
 	}
 }
 
+// SetDropHandler registers a callback invoked each time a received message is
+// dropped because the queue is full. It must be called before Start.
+func (r *Receiver) SetDropHandler(fn func()) {
+	r.onDrop = fn
+}
+
+// Dropped returns the number of received messages dropped because the queue was full.
+func (r *Receiver) Dropped() uint64 {
+	return r.dropped.Load()
+}
+
 // Start begins listening for incoming PFCP messages in a goroutine.
 func (r *Receiver) Start(ctx context.Context) {
 	go r.listen(ctx)
 }
 
+// SetRecorder installs a recorder that receives a copy of every received packet.
+// It must be called before Start.
+func (r *Receiver) SetRecorder(rec PacketRecorder) {
+	r.recorder = rec
+}
+
 // Messages returns the channel of received messages.
 func (r *Receiver) Messages() <-chan ReceivedMessage {
 	return r.msgChan
@@ -42,7 +92,11 @@ func (r *Receiver) Messages() <-chan ReceivedMessage {
 func (r *Receiver) listen(ctx context.Context) {
 	defer close(r.msgChan)
 
-	buf := make([]byte, 65535)
+	read := r.streamReader()
+	if udpConn, ok := r.conn.(*net.UDPConn); ok {
+		read = datagramReader(udpConn)
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -50,17 +104,25 @@ func (r *Receiver) listen(ctx context.Context) {
 		default:
 		}
 
-		n, addr, err := r.conn.ReadFromUDP(buf)
+		data, addr, err := read()
 		if err != nil {
 			if ctx.Err() != nil {
 				return // Context cancelled, normal shutdown
 			}
-			log.WithError(err).Warn("Error reading from UDP")
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				log.WithError(err).Warn("Connection to UPF closed")
+				return
+			}
+			log.WithError(err).Warn("Error reading from connection")
 			continue
 		}
+		receivedAt := time.Now()
 
-		data := make([]byte, n)
-		copy(data, buf[:n])
+		if r.recorder != nil {
+			if err := r.recorder.WritePacket(toUDPAddr(addr), toUDPAddr(r.conn.LocalAddr()), data, receivedAt); err != nil {
+				log.WithError(err).Warn("Failed to record received packet")
+			}
+		}
 
 		msg, err := message.Parse(data)
 		if err != nil {
@@ -68,6 +130,8 @@ func (r *Receiver) listen(ctx context.Context) {
 			continue
 		}
 
+		// A full queue drops the message instead of blocking the read loop, so
+		// the overflow is counted rather than lost silently in the socket buffer
 		select {
 		case r.msgChan <- ReceivedMessage{
 			Message: msg,
@@ -76,6 +140,49 @@ func (r *Receiver) listen(ctx context.Context) {
 		}:
 		case <-ctx.Done():
 			return
+		default:
+			dropped := r.dropped.Add(1)
+			log.WithFields(log.Fields{
+				"seq_num": msg.Sequence(),
+				"dropped": dropped,
+			}).Warn("Receive buffer full, dropping message")
+			if r.onDrop != nil {
+				r.onDrop()
+			}
+		}
+	}
+}
+
+// datagramReader returns a reader yielding one PFCP message per UDP datagram.
+func datagramReader(conn *net.UDPConn) func() ([]byte, net.Addr, error) {
+	buf := make([]byte, 65535)
+	return func() ([]byte, net.Addr, error) {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		return data, addr, nil
+	}
+}
+
+// streamReader returns a reader that splits a byte stream into PFCP messages
+// using the length field of each PFCP header.
+func (r *Receiver) streamReader() func() ([]byte, net.Addr, error) {
+	br := bufio.NewReader(r.conn)
+	return func() ([]byte, net.Addr, error) {
+		header := make([]byte, pfcpHeaderPrefixLen)
+		if _, err := io.ReadFull(br, header); err != nil {
+			return nil, nil, err
+		}
+
+		length := int(binary.BigEndian.Uint16(header[2:4]))
+		data := make([]byte, pfcpHeaderPrefixLen+length)
+		copy(data, header)
+		if _, err := io.ReadFull(br, data[pfcpHeaderPrefixLen:]); err != nil {
+			return nil, nil, err
 		}
+		return data, r.conn.RemoteAddr(), nil
 	}
 }
@@ -0,0 +1,91 @@
+package network
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+)
+
+func TestUDPClient_EphemeralPort_ReceivesResponses(t *testing.T) {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	defer peer.Close()
+	peerAddr := peer.LocalAddr().(*net.UDPAddr)
+
+	client, err := NewUDPClient("127.0.0.1", 0, "127.0.0.1", peerAddr.Port)
+	require.NoError(t, err)
+	defer client.Close()
+
+	localAddr := client.LocalAddr().(*net.UDPAddr)
+	assert.NotZero(t, localAddr.Port, "OS should assign an ephemeral port")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	receiver := NewReceiver(client.Conn(), 0)
+	receiver.Start(ctx)
+
+	req := message.NewHeartbeatRequest(7, ie.NewRecoveryTimeStamp(time.Now()), nil)
+	reqData := make([]byte, req.MarshalLen())
+	require.NoError(t, req.MarshalTo(reqData))
+	require.NoError(t, client.Send(reqData))
+
+	// Peer answers to whatever source address the request came from
+	buf := make([]byte, 1500)
+	require.NoError(t, peer.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, from, err := peer.ReadFromUDP(buf)
+	require.NoError(t, err)
+	assert.Equal(t, localAddr.Port, from.Port)
+
+	resp := message.NewHeartbeatResponse(7, ie.NewRecoveryTimeStamp(time.Now()))
+	respData := make([]byte, resp.MarshalLen())
+	require.NoError(t, resp.MarshalTo(respData))
+	_, err = peer.WriteToUDP(respData, from)
+	require.NoError(t, err)
+
+	select {
+	case received := <-receiver.Messages():
+		assert.Equal(t, message.MsgTypeHeartbeatResponse, received.Message.MessageType())
+		assert.Equal(t, uint32(7), received.Message.Sequence())
+	case <-time.After(2 * time.Second):
+		t.Fatal("receiver did not deliver the response")
+	}
+}
+
+func TestReceiver_DropsWhenBufferFull(t *testing.T) {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	defer peer.Close()
+
+	client, err := NewUDPClient("127.0.0.1", 0, "127.0.0.1", peer.LocalAddr().(*net.UDPAddr).Port)
+	require.NoError(t, err)
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	receiver := NewReceiver(client.Conn(), 1)
+	var drops atomic.Int32
+	receiver.SetDropHandler(func() { drops.Add(1) })
+	receiver.Start(ctx)
+
+	// Nothing drains the queue: the first message is queued, the rest dropped
+	for seq := uint32(1); seq <= 3; seq++ {
+		resp := message.NewHeartbeatResponse(seq, ie.NewRecoveryTimeStamp(time.Now()))
+		data := make([]byte, resp.MarshalLen())
+		require.NoError(t, resp.MarshalTo(data))
+		_, err := peer.WriteToUDP(data, client.LocalAddr().(*net.UDPAddr))
+		require.NoError(t, err)
+	}
+
+	require.Eventually(t, func() bool { return receiver.Dropped() == 2 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(2), drops.Load())
+
+	received := <-receiver.Messages()
+	assert.Equal(t, uint32(1), received.Message.Sequence())
+}
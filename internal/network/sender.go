@@ -4,13 +4,37 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
+// BatchSender is a transport that can send several messages at once. Only
+// session cleanup uses it; the replay sends one message at a time. SendBatch
+// returns how many messages, from the start of batch, were sent, also when it
+// fails part way.
+type BatchSender interface {
+	SendBatch(batch [][]byte) (int, error)
+}
+
+// batchWriter sends several datagrams per system call (sendmmsg on Linux).
+// ipv4.Message and ipv6.Message are the same type.
+type batchWriter interface {
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
 // UDPClient handles UDP communication with the UPF.
 type UDPClient struct {
-	conn    *net.UDPConn
-	upfAddr *net.UDPAddr
-	mu      sync.Mutex
+	conn     *net.UDPConn
+	upfAddr  *net.UDPAddr
+	recorder PacketRecorder
+	mu       sync.Mutex
+
+	// batch is nil when the socket and the UPF address are of different IP
+	// families, which WriteBatch cannot map like WriteToUDP does
+	batch batchWriter
 }
 
 // NewUDPClient creates a new UDP client bound to the SMF address and targeting the UPF.
@@ -30,26 +54,98 @@ func NewUDPClient(smfAddr string, smfPort int, upfAddr string, upfPort int) (*UD
 		return nil, fmt.Errorf("failed to bind UDP to %s:%d: %w", smfAddr, smfPort, err)
 	}
 
-	return &UDPClient{
+	client := &UDPClient{
 		conn:    conn,
 		upfAddr: remoteAddr,
-	}, nil
+	}
+	localIP := conn.LocalAddr().(*net.UDPAddr).IP
+	switch {
+	case localIP.To4() != nil && remoteAddr.IP.To4() != nil:
+		client.batch = ipv4.NewPacketConn(conn)
+	case localIP.To4() == nil && remoteAddr.IP.To4() == nil:
+		client.batch = ipv6.NewPacketConn(conn)
+	}
+	return client, nil
 }
 
 // Send transmits data to the UPF.
 func (c *UDPClient) Send(data []byte) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	return c.send(data)
+}
 
+// send transmits a single message; c.mu must be held.
+func (c *UDPClient) send(data []byte) error {
+	sentAt := time.Now()
 	_, err := c.conn.WriteToUDP(data, c.upfAddr)
 	if err != nil {
 		return fmt.Errorf("failed to send to UPF %s: %w", c.upfAddr, err)
 	}
+	c.record(data, sentAt)
 	return nil
 }
 
+// record passes a sent packet to the recorder, if any; c.mu must be held.
+func (c *UDPClient) record(data []byte, sentAt time.Time) {
+	if c.recorder == nil {
+		return
+	}
+	localAddr, _ := c.conn.LocalAddr().(*net.UDPAddr)
+	if err := c.recorder.WritePacket(localAddr, c.upfAddr, data, sentAt); err != nil {
+		log.WithError(err).Warn("Failed to record sent packet")
+	}
+}
+
+// SendBatch transmits several messages to the UPF in as few system calls as
+// the OS allows: sendmmsg on Linux, one call per message elsewhere. Messages
+// are sent in order, and it returns how many were sent; on error, the
+// messages before the failed one were sent and recorded.
+func (c *UDPClient) SendBatch(batch [][]byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.batch == nil {
+		for i, data := range batch {
+			if err := c.send(data); err != nil {
+				return i, err
+			}
+		}
+		return len(batch), nil
+	}
+
+	msgs := make([]ipv4.Message, len(batch))
+	for i, data := range batch {
+		msgs[i] = ipv4.Message{Buffers: [][]byte{data}, Addr: c.upfAddr}
+	}
+
+	sentAt := time.Now()
+	sent := 0
+	var err error
+	for sent < len(msgs) {
+		var n int
+		if n, err = c.batch.WriteBatch(msgs[sent:], 0); err != nil {
+			err = fmt.Errorf("failed to send batch to UPF %s: %w", c.upfAddr, err)
+			break
+		}
+		sent += n
+	}
+
+	for _, data := range batch[:sent] {
+		c.record(data, sentAt)
+	}
+	return sent, err
+}
+
+// SetRecorder installs a recorder that receives a copy of every sent packet.
+func (c *UDPClient) SetRecorder(r PacketRecorder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.recorder = r
+}
+
 // Conn returns the underlying UDP connection (for the receiver to read from).
-func (c *UDPClient) Conn() *net.UDPConn {
+func (c *UDPClient) Conn() net.Conn {
 	return c.conn
 }
 
@@ -0,0 +1,123 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+	"golang.org/x/net/ipv4"
+)
+
+// newHeartbeats returns n encoded Heartbeat Requests with sequence numbers 1..n.
+func newHeartbeats(t testing.TB, n int) [][]byte {
+	batch := make([][]byte, n)
+	for i := range batch {
+		req := message.NewHeartbeatRequest(uint32(i+1), ie.NewRecoveryTimeStamp(time.Now()), nil)
+		batch[i] = make([]byte, req.MarshalLen())
+		require.NoError(t, req.MarshalTo(batch[i]))
+	}
+	return batch
+}
+
+// newLoopbackClient returns a UDP client sending to a local peer socket.
+func newLoopbackClient(t testing.TB) (*UDPClient, *net.UDPConn) {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	t.Cleanup(func() { peer.Close() })
+
+	client, err := NewUDPClient("127.0.0.1", 0, "127.0.0.1", peer.LocalAddr().(*net.UDPAddr).Port)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return client, peer
+}
+
+func TestUDPClient_SendBatch(t *testing.T) {
+	client, peer := newLoopbackClient(t)
+	require.NotNil(t, client.batch, "IPv4 to IPv4 should use batched writes")
+
+	sent, err := client.SendBatch(newHeartbeats(t, 3))
+	require.NoError(t, err)
+	assert.Equal(t, 3, sent)
+
+	buf := make([]byte, 1500)
+	require.NoError(t, peer.SetReadDeadline(time.Now().Add(2*time.Second)))
+	for seq := uint32(1); seq <= 3; seq++ {
+		n, _, err := peer.ReadFromUDP(buf)
+		require.NoError(t, err)
+		msg, err := message.Parse(buf[:n])
+		require.NoError(t, err)
+		assert.Equal(t, seq, msg.Sequence())
+	}
+}
+
+// failingBatch sends the first ok messages of a batch and then fails.
+type failingBatch struct {
+	ok int
+}
+
+func (f *failingBatch) WriteBatch(ms []ipv4.Message, flags int) (int, error) {
+	if f.ok == 0 {
+		return 0, errors.New("no buffer space available")
+	}
+	n := min(f.ok, len(ms))
+	f.ok -= n
+	return n, nil
+}
+
+// packetLog is a PacketRecorder that keeps the recorded payloads.
+type packetLog struct {
+	payloads [][]byte
+}
+
+func (l *packetLog) WritePacket(src, dst *net.UDPAddr, payload []byte, ts time.Time) error {
+	l.payloads = append(l.payloads, payload)
+	return nil
+}
+
+func TestUDPClient_SendBatchPartialFailure(t *testing.T) {
+	client, _ := newLoopbackClient(t)
+	client.batch = &failingBatch{ok: 2}
+	recorder := &packetLog{}
+	client.SetRecorder(recorder)
+
+	batch := newHeartbeats(t, 4)
+	sent, err := client.SendBatch(batch)
+	require.Error(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Equal(t, batch[:2], recorder.payloads)
+}
+
+// The batched send needs one sendmmsg system call per batch on Linux instead
+// of one sendto per message; compare ns/msg of the two benchmarks.
+func BenchmarkUDPClient_Send(b *testing.B) {
+	client, _ := newLoopbackClient(b)
+	batch := newHeartbeats(b, 64)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, data := range batch {
+			if err := client.Send(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(batch)), "ns/msg")
+}
+
+func BenchmarkUDPClient_SendBatch(b *testing.B) {
+	client, _ := newLoopbackClient(b)
+	batch := newHeartbeats(b, 64)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.SendBatch(batch); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(batch)), "ns/msg")
+}
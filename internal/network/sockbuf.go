@@ -0,0 +1,65 @@
+package network
+
+import (
+	"net"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// bufferedConn is a connection whose kernel socket buffers can be sized,
+// such as *net.UDPConn and *net.TCPConn.
+type bufferedConn interface {
+	SetReadBuffer(bytes int) error
+	SetWriteBuffer(bytes int) error
+	SyscallConn() (syscall.RawConn, error)
+}
+
+// SetSocketBuffers sets the kernel read and write buffer sizes of conn in
+// bytes; a size of 0 keeps the current one. The OS may cap the requested
+// sizes (net.core.rmem_max and wmem_max on Linux), so the sizes in effect
+// afterwards are logged, with a warning when one is smaller than requested.
+func SetSocketBuffers(conn net.Conn, readBytes, writeBytes int) {
+	if readBytes <= 0 && writeBytes <= 0 {
+		return
+	}
+	bc, ok := conn.(bufferedConn)
+	if !ok {
+		log.WithField("conn", conn.LocalAddr()).Warn("Socket buffer sizes cannot be set on this connection")
+		return
+	}
+
+	if readBytes > 0 {
+		if err := bc.SetReadBuffer(readBytes); err != nil {
+			log.WithError(err).WithField("bytes", readBytes).Warn("Failed to set socket read buffer")
+		}
+	}
+	if writeBytes > 0 {
+		if err := bc.SetWriteBuffer(writeBytes); err != nil {
+			log.WithError(err).WithField("bytes", writeBytes).Warn("Failed to set socket write buffer")
+		}
+	}
+
+	read, write, err := socketBufferSizes(bc)
+	if err != nil {
+		log.WithError(err).Warn("Failed to read socket buffer sizes")
+		return
+	}
+	log.WithFields(log.Fields{
+		"read_bytes":  read,
+		"write_bytes": write,
+	}).Info("Socket buffers set")
+
+	if read < readBytes {
+		log.WithFields(log.Fields{
+			"requested": readBytes,
+			"actual":    read,
+		}).Warn("Socket read buffer is smaller than requested, raise net.core.rmem_max")
+	}
+	if write < writeBytes {
+		log.WithFields(log.Fields{
+			"requested": writeBytes,
+			"actual":    write,
+		}).Warn("Socket write buffer is smaller than requested, raise net.core.wmem_max")
+	}
+}
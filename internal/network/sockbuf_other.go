@@ -0,0 +1,13 @@
+//go:build !unix
+
+package network
+
+import (
+	"errors"
+	"syscall"
+)
+
+// socketBufferSizes is not supported on this platform.
+func socketBufferSizes(conn syscall.Conn) (read, write int, err error) {
+	return 0, 0, errors.New("reading socket buffer sizes is not supported on this platform")
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package network
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetSocketBuffers(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	SetSocketBuffers(conn, 64*1024, 32*1024)
+
+	read, write, err := socketBufferSizes(conn)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, read, 64*1024)
+	assert.GreaterOrEqual(t, write, 32*1024)
+}
@@ -0,0 +1,32 @@
+//go:build unix
+
+package network
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// socketBufferSizes returns the kernel read and write buffer sizes of conn.
+// Linux reports twice the size that was set, the extra half being its
+// bookkeeping overhead.
+func socketBufferSizes(conn syscall.Conn) (read, write int, err error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to access socket: %w", err)
+	}
+	ctrlErr := raw.Control(func(fd uintptr) {
+		read, err = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+		if err != nil {
+			return
+		}
+		write, err = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	})
+	if ctrlErr != nil {
+		return 0, 0, fmt.Errorf("failed to access socket: %w", ctrlErr)
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read socket buffer sizes: %w", err)
+	}
+	return read, write, nil
+}
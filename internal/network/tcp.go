@@ -0,0 +1,80 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TCPClient carries PFCP over a TCP stream to the UPF (e.g. via a TCP relay).
+// Messages are framed by the length field in the PFCP header.
+type TCPClient struct {
+	conn     *net.TCPConn
+	recorder PacketRecorder
+	mu       sync.Mutex
+}
+
+// NewTCPClient connects from the SMF address to the UPF over TCP.
+func NewTCPClient(smfAddr string, smfPort int, upfAddr string, upfPort int) (*TCPClient, error) {
+	localAddr := &net.TCPAddr{
+		IP:   net.ParseIP(smfAddr),
+		Port: smfPort,
+	}
+
+	remoteAddr := &net.TCPAddr{
+		IP:   net.ParseIP(upfAddr),
+		Port: upfPort,
+	}
+
+	conn, err := net.DialTCP("tcp", localAddr, remoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect TCP from %s:%d to %s: %w", smfAddr, smfPort, remoteAddr, err)
+	}
+
+	return &TCPClient{
+		conn: conn,
+	}, nil
+}
+
+// Send writes a single PFCP message to the TCP stream.
+func (c *TCPClient) Send(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sentAt := time.Now()
+	if _, err := c.conn.Write(data); err != nil {
+		return fmt.Errorf("failed to send to UPF %s: %w", c.conn.RemoteAddr(), err)
+	}
+
+	if c.recorder != nil {
+		if err := c.recorder.WritePacket(toUDPAddr(c.conn.LocalAddr()), toUDPAddr(c.conn.RemoteAddr()), data, sentAt); err != nil {
+			log.WithError(err).Warn("Failed to record sent packet")
+		}
+	}
+	return nil
+}
+
+// SetRecorder installs a recorder that receives a copy of every sent packet.
+func (c *TCPClient) SetRecorder(r PacketRecorder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.recorder = r
+}
+
+// Conn returns the underlying TCP connection (for the receiver to read from).
+func (c *TCPClient) Conn() net.Conn {
+	return c.conn
+}
+
+// Close closes the TCP connection.
+func (c *TCPClient) Close() error {
+	return c.conn.Close()
+}
+
+// LocalAddr returns the local address the client is bound to.
+func (c *TCPClient) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
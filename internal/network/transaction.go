@@ -3,22 +3,26 @@ package network
 import (
 	"context"
 	"fmt"
+	"math"
 	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/wmnsk/go-pfcp/message"
 
+	"pfcp-generator/internal/pfcp"
 	"pfcp-generator/pkg/types"
 )
 
 // PendingTransaction represents a request awaiting a response.
 type PendingTransaction struct {
-	SeqNum      uint32
-	RequestData []byte
-	SentAt      time.Time
-	RetryCount  int
-	ResultCh    chan types.TransactionResult
+	SeqNum       uint32
+	MsgType      uint8
+	ExpectedResp uint8
+	RequestData  []byte
+	SentAt       time.Time
+	RetryCount   int
+	ResultCh     chan types.TransactionResult
 }
 
 // TransactionTracker manages pending PFCP transactions.
@@ -27,45 +31,165 @@ type TransactionTracker struct {
 	mu         sync.Mutex
 	timeout    time.Duration
 	maxRetries int
-	sender     *UDPClient
+	sender     Transport
+
+	// backoffMultiplier scales the timeout per retransmission (1 = fixed timeout)
+	backoffMultiplier float64
+	onRetransmit      func(msgType uint8)
+
+	// onUnexpected is called for responses that match no pending request
+	onUnexpected func(msgType uint8)
+
+	// window holds one slot per outstanding transaction when max_in_flight is set
+	window chan struct{}
 }
 
 // NewTransactionTracker creates a new transaction tracker.
-func NewTransactionTracker(sender *UDPClient, timeoutMs int, maxRetries int) *TransactionTracker {
+func NewTransactionTracker(sender Transport, timeoutMs int, maxRetries int) *TransactionTracker {
 	return &TransactionTracker{
-		pending:    make(map[uint32]*PendingTransaction),
-		timeout:    time.Duration(timeoutMs) * time.Millisecond,
-		maxRetries: maxRetries,
-		sender:     sender,
+		pending:           make(map[uint32]*PendingTransaction),
+		timeout:           time.Duration(timeoutMs) * time.Millisecond,
+		maxRetries:        maxRetries,
+		sender:            sender,
+		backoffMultiplier: 1,
+	}
+}
+
+// SetBackoff configures the retransmission backoff. With "exponential", the timeout
+// before the nth retransmission is timeout * multiplier^n; "fixed" keeps it constant.
+func (t *TransactionTracker) SetBackoff(strategy string, multiplier float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if strategy == "exponential" && multiplier > 1 {
+		t.backoffMultiplier = multiplier
+	} else {
+		t.backoffMultiplier = 1
+	}
+}
+
+// SetRetransmitHandler registers a callback invoked with the request message type
+// each time a transaction is retransmitted.
+func (t *TransactionTracker) SetRetransmitHandler(fn func(msgType uint8)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onRetransmit = fn
+}
+
+// SetUnexpectedResponseHandler registers a callback invoked with the response
+// message type each time a response is dropped because it does not echo the
+// sequence number and type of a pending request.
+func (t *TransactionTracker) SetUnexpectedResponseHandler(fn func(msgType uint8)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onUnexpected = fn
+}
+
+// IsPending reports whether a transaction with the given sequence number is
+// awaiting its response.
+func (t *TransactionTracker) IsPending(seqNum uint32) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, exists := t.pending[seqNum]
+	return exists
+}
+
+// SetMaxInFlight limits how many transactions can be pending at once. Once n
+// are pending, Track blocks until one of them is resolved or times out, or
+// until its context is done. n <= 0 removes the limit. It must be called
+// before the first Track.
+func (t *TransactionTracker) SetMaxInFlight(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if n > 0 {
+		t.window = make(chan struct{}, n)
+	} else {
+		t.window = nil
 	}
 }
 
+// release frees the window slot of a transaction that is no longer pending.
+func (t *TransactionTracker) release() {
+	if t.window != nil {
+		<-t.window
+	}
+}
+
+// timeoutFor returns how long to wait for a response after the given number of retransmissions.
+func (t *TransactionTracker) timeoutFor(retryCount int) time.Duration {
+	if t.backoffMultiplier <= 1 || retryCount == 0 {
+		return t.timeout
+	}
+	return time.Duration(float64(t.timeout) * math.Pow(t.backoffMultiplier, float64(retryCount)))
+}
+
 // Track registers a new pending transaction and returns a channel for the result.
-func (t *TransactionTracker) Track(seqNum uint32, requestData []byte) <-chan types.TransactionResult {
+// With a max_in_flight window, it blocks while the window is full, and returns
+// ctx's error without tracking the transaction if ctx is done first.
+func (t *TransactionTracker) Track(ctx context.Context, seqNum uint32, msgType uint8, requestData []byte) (<-chan types.TransactionResult, error) {
+	if t.window != nil {
+		select {
+		case t.window <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
+	// A transaction tracked again under the same sequence number replaces the
+	// old one and must not hold a second slot
+	if _, exists := t.pending[seqNum]; exists {
+		t.release()
+	}
+
 	resultCh := make(chan types.TransactionResult, 1)
 	t.pending[seqNum] = &PendingTransaction{
-		SeqNum:      seqNum,
-		RequestData: requestData,
-		SentAt:      time.Now(),
-		ResultCh:    resultCh,
+		SeqNum:       seqNum,
+		MsgType:      msgType,
+		ExpectedResp: pfcp.ExpectedResponseType(msgType),
+		RequestData:  requestData,
+		SentAt:       time.Now(),
+		ResultCh:     resultCh,
 	}
 
-	return resultCh
+	return resultCh, nil
 }
 
-// Resolve matches a received response to a pending transaction.
+// Resolve matches a received response to a pending transaction. The response must
+// carry both the pending sequence number and the response type expected for the
+// request; mismatches are logged and dropped so a stray or duplicate response
+// cannot complete the wrong transaction.
 func (t *TransactionTracker) Resolve(seqNum uint32, response message.Message, responseData []byte) {
+	respType := response.MessageType()
 	t.mu.Lock()
+	onUnexpected := t.onUnexpected
 	tx, exists := t.pending[seqNum]
 	if !exists {
 		t.mu.Unlock()
-		log.WithField("seq_num", seqNum).Warn("Received response for unknown transaction")
+		log.WithFields(log.Fields{
+			"seq_num":  seqNum,
+			"msg_type": pfcp.MessageTypeName(respType),
+		}).Warn("Received response for unknown transaction")
+		if onUnexpected != nil {
+			onUnexpected(respType)
+		}
+		return
+	}
+	if respType != tx.ExpectedResp && respType != message.MsgTypeVersionNotSupportedResponse {
+		t.mu.Unlock()
+		log.WithFields(log.Fields{
+			"seq_num":  seqNum,
+			"expected": pfcp.MessageTypeName(tx.ExpectedResp),
+			"received": pfcp.MessageTypeName(respType),
+		}).Warn("Response type does not match pending request, dropping")
+		if onUnexpected != nil {
+			onUnexpected(respType)
+		}
 		return
 	}
 	delete(t.pending, seqNum)
+	t.release()
 	t.mu.Unlock()
 
 	responseTime := time.Since(tx.SentAt)
@@ -99,7 +223,7 @@ func (t *TransactionTracker) checkTimeouts() {
 	now := time.Now()
 
 	for _, tx := range t.pending {
-		if now.Sub(tx.SentAt) > t.timeout {
+		if now.Sub(tx.SentAt) > t.timeoutFor(tx.RetryCount) {
 			timedOut = append(timedOut, tx)
 		}
 	}
@@ -121,19 +245,26 @@ func (t *TransactionTracker) handleTimeout(tx *PendingTransaction) {
 	if tx.RetryCount < t.maxRetries {
 		tx.RetryCount++
 		tx.SentAt = time.Now() // Reset timeout
+		nextTimeout := t.timeoutFor(tx.RetryCount)
+		onRetransmit := t.onRetransmit
 		t.mu.Unlock()
 
 		log.WithFields(log.Fields{
-			"seq_num": tx.SeqNum,
-			"attempt": tx.RetryCount,
-			"max":     t.maxRetries,
+			"seq_num":      tx.SeqNum,
+			"attempt":      tx.RetryCount,
+			"max":          t.maxRetries,
+			"next_timeout": nextTimeout,
 		}).Warn("Transaction timeout, retransmitting")
 
 		if err := t.sender.Send(tx.RequestData); err != nil {
 			log.WithError(err).WithField("seq_num", tx.SeqNum).Error("Retransmission failed")
 		}
+		if onRetransmit != nil {
+			onRetransmit(tx.MsgType)
+		}
 	} else {
 		delete(t.pending, tx.SeqNum)
+		t.release()
 		t.mu.Unlock()
 
 		log.WithFields(log.Fields{
@@ -155,6 +286,17 @@ func (t *TransactionTracker) PendingCount() int {
 	return len(t.pending)
 }
 
+// Cancel drops a pending transaction without delivering a result, for a
+// caller that stopped waiting for the response.
+func (t *TransactionTracker) Cancel(seqNum uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, exists := t.pending[seqNum]; exists {
+		delete(t.pending, seqNum)
+		t.release()
+	}
+}
+
 // CancelAll cancels all pending transactions.
 func (t *TransactionTracker) CancelAll() {
 	t.mu.Lock()
@@ -166,5 +308,6 @@ func (t *TransactionTracker) CancelAll() {
 			Error:  fmt.Errorf("cancelled"),
 		}
 		delete(t.pending, seqNum)
+		t.release()
 	}
 }
@@ -0,0 +1,231 @@
+package network
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/message"
+)
+
+// fakeTransport records sent payloads instead of writing to a socket.
+type fakeTransport struct {
+	mu   sync.Mutex
+	sent [][]byte
+}
+
+func (f *fakeTransport) Send(data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, data)
+	return nil
+}
+
+func (f *fakeTransport) SentCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.sent)
+}
+
+func (f *fakeTransport) Conn() net.Conn               { return nil }
+func (f *fakeTransport) Close() error                 { return nil }
+func (f *fakeTransport) LocalAddr() net.Addr          { return &net.UDPAddr{} }
+func (f *fakeTransport) SetRecorder(r PacketRecorder) {}
+
+// backdate moves a pending transaction's send time into the past.
+func backdate(t *TransactionTracker, seqNum uint32, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending[seqNum].SentAt = time.Now().Add(-d)
+}
+
+func TestTransactionTracker_TimeoutFor_Fixed(t *testing.T) {
+	tracker := NewTransactionTracker(&fakeTransport{}, 100, 3)
+	tracker.SetBackoff("fixed", 2)
+
+	for retry := 0; retry <= 3; retry++ {
+		assert.Equal(t, 100*time.Millisecond, tracker.timeoutFor(retry))
+	}
+}
+
+func TestTransactionTracker_TimeoutFor_Exponential(t *testing.T) {
+	tracker := NewTransactionTracker(&fakeTransport{}, 100, 3)
+	tracker.SetBackoff("exponential", 2)
+
+	assert.Equal(t, 100*time.Millisecond, tracker.timeoutFor(0))
+	assert.Equal(t, 200*time.Millisecond, tracker.timeoutFor(1))
+	assert.Equal(t, 400*time.Millisecond, tracker.timeoutFor(2))
+	assert.Equal(t, 800*time.Millisecond, tracker.timeoutFor(3))
+}
+
+func TestTransactionTracker_ExponentialRetransmitSchedule(t *testing.T) {
+	sender := &fakeTransport{}
+	tracker := NewTransactionTracker(sender, 100, 3)
+	tracker.SetBackoff("exponential", 2)
+
+	tracker.Track(context.Background(), 1, message.MsgTypeHeartbeatRequest, []byte{0x01})
+
+	// First retransmit fires once the base timeout elapses
+	backdate(tracker, 1, 90*time.Millisecond)
+	tracker.checkTimeouts()
+	assert.Equal(t, 0, sender.SentCount())
+
+	backdate(tracker, 1, 110*time.Millisecond)
+	tracker.checkTimeouts()
+	assert.Equal(t, 1, sender.SentCount())
+
+	// Second retransmit waits for 2x the timeout
+	backdate(tracker, 1, 150*time.Millisecond)
+	tracker.checkTimeouts()
+	assert.Equal(t, 1, sender.SentCount())
+
+	backdate(tracker, 1, 210*time.Millisecond)
+	tracker.checkTimeouts()
+	assert.Equal(t, 2, sender.SentCount())
+
+	// Third retransmit waits for 4x the timeout
+	backdate(tracker, 1, 390*time.Millisecond)
+	tracker.checkTimeouts()
+	assert.Equal(t, 2, sender.SentCount())
+
+	backdate(tracker, 1, 410*time.Millisecond)
+	tracker.checkTimeouts()
+	assert.Equal(t, 3, sender.SentCount())
+	assert.Equal(t, 1, tracker.PendingCount())
+}
+
+func TestTransactionTracker_FailsAfterMaxRetries(t *testing.T) {
+	sender := &fakeTransport{}
+	tracker := NewTransactionTracker(sender, 100, 1)
+
+	resultCh, _ := tracker.Track(context.Background(), 1, message.MsgTypeHeartbeatRequest, []byte{0x01})
+
+	backdate(tracker, 1, 110*time.Millisecond)
+	tracker.checkTimeouts()
+	backdate(tracker, 1, 110*time.Millisecond)
+	tracker.checkTimeouts()
+
+	select {
+	case result := <-resultCh:
+		require.Error(t, result.Error)
+		assert.Contains(t, result.Error.Error(), "timeout")
+	default:
+		t.Fatal("expected the transaction to fail after max retries")
+	}
+	assert.Equal(t, 1, sender.SentCount())
+	assert.Equal(t, 0, tracker.PendingCount())
+}
+
+func TestTransactionTracker_RetransmitHandlerReceivesMsgType(t *testing.T) {
+	tracker := NewTransactionTracker(&fakeTransport{}, 100, 3)
+
+	var got []uint8
+	tracker.SetRetransmitHandler(func(msgType uint8) {
+		got = append(got, msgType)
+	})
+
+	tracker.Track(context.Background(), 1, message.MsgTypeSessionModificationRequest, []byte{0x01})
+	backdate(tracker, 1, 110*time.Millisecond)
+	tracker.checkTimeouts()
+
+	assert.Equal(t, []uint8{message.MsgTypeSessionModificationRequest}, got)
+}
+
+func TestTransactionTracker_Resolve_RequiresMatchingResponseType(t *testing.T) {
+	tracker := NewTransactionTracker(&fakeTransport{}, 1000, 0)
+	resultCh, _ := tracker.Track(context.Background(), 5, message.MsgTypeSessionEstablishmentRequest, []byte{0x01})
+
+	// Same sequence number, wrong response type: must not resolve
+	stray := message.NewHeartbeatResponse(5, nil)
+	tracker.Resolve(5, stray, nil)
+	assert.Equal(t, 1, tracker.PendingCount())
+	select {
+	case <-resultCh:
+		t.Fatal("mismatched response type resolved the transaction")
+	default:
+	}
+
+	resp := message.NewSessionEstablishmentResponse(0, 0, 1, 5, 0)
+	tracker.Resolve(5, resp, []byte{0x02})
+	assert.Equal(t, 0, tracker.PendingCount())
+	select {
+	case result := <-resultCh:
+		assert.NoError(t, result.Error)
+		assert.Equal(t, []byte{0x02}, result.Response)
+	default:
+		t.Fatal("matching response did not resolve the transaction")
+	}
+}
+
+func TestTransactionTracker_MaxInFlightBlocksUntilResolved(t *testing.T) {
+	tracker := NewTransactionTracker(&fakeTransport{}, 1000, 0)
+	tracker.SetMaxInFlight(2)
+
+	tracker.Track(context.Background(), 1, message.MsgTypeHeartbeatRequest, []byte{0x01})
+	tracker.Track(context.Background(), 2, message.MsgTypeHeartbeatRequest, []byte{0x02})
+
+	tracked := make(chan struct{})
+	go func() {
+		tracker.Track(context.Background(), 3, message.MsgTypeHeartbeatRequest, []byte{0x03})
+		close(tracked)
+	}()
+
+	select {
+	case <-tracked:
+		t.Fatal("Track did not block with a full window")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, 2, tracker.PendingCount())
+
+	tracker.Resolve(1, message.NewHeartbeatResponse(1, nil), nil)
+	select {
+	case <-tracked:
+	case <-time.After(time.Second):
+		t.Fatal("Track still blocked after a transaction was resolved")
+	}
+	assert.Equal(t, 2, tracker.PendingCount())
+}
+
+func TestTransactionTracker_MaxInFlightHonoursContext(t *testing.T) {
+	tracker := NewTransactionTracker(&fakeTransport{}, 1000, 0)
+	tracker.SetMaxInFlight(1)
+	tracker.Track(context.Background(), 1, message.MsgTypeHeartbeatRequest, []byte{0x01})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := tracker.Track(ctx, 2, message.MsgTypeHeartbeatRequest, []byte{0x02})
+		errCh <- err
+	}()
+	cancel()
+
+	select {
+	case err := <-errCh:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("Track still blocked on a full window after its context was cancelled")
+	}
+	assert.False(t, tracker.IsPending(2))
+	assert.Equal(t, 1, tracker.PendingCount())
+}
+
+func TestTransactionTracker_UnexpectedResponseHandler(t *testing.T) {
+	tracker := NewTransactionTracker(&fakeTransport{}, 1000, 0)
+	var unexpected []uint8
+	tracker.SetUnexpectedResponseHandler(func(msgType uint8) { unexpected = append(unexpected, msgType) })
+	tracker.Track(context.Background(), 5, message.MsgTypeSessionEstablishmentRequest, []byte{0x01})
+
+	// Unknown sequence number, then a known one with the wrong type
+	tracker.Resolve(6, message.NewSessionEstablishmentResponse(0, 0, 1, 6, 0), nil)
+	tracker.Resolve(5, message.NewHeartbeatResponse(5, nil), nil)
+	assert.Equal(t, []uint8{message.MsgTypeSessionEstablishmentResponse, message.MsgTypeHeartbeatResponse}, unexpected)
+	assert.True(t, tracker.IsPending(5))
+
+	tracker.Resolve(5, message.NewSessionEstablishmentResponse(0, 0, 1, 5, 0), nil)
+	assert.Len(t, unexpected, 2)
+	assert.False(t, tracker.IsPending(5))
+}
@@ -0,0 +1,50 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Transport carries PFCP messages between the SMF and the UPF.
+type Transport interface {
+	// Send transmits a single encoded PFCP message to the UPF.
+	Send(data []byte) error
+	// Conn returns the underlying connection (for the receiver to read from).
+	Conn() net.Conn
+	// Close closes the underlying connection.
+	Close() error
+	// LocalAddr returns the local address the transport is bound to.
+	LocalAddr() net.Addr
+	// SetRecorder installs a recorder that receives a copy of every sent packet.
+	SetRecorder(r PacketRecorder)
+}
+
+// PacketRecorder receives a copy of every PFCP payload sent to or received from the UPF.
+type PacketRecorder interface {
+	WritePacket(src, dst *net.UDPAddr, payload []byte, ts time.Time) error
+}
+
+// NewTransport creates a transport of the given kind ("udp" or "tcp").
+func NewTransport(kind string, smfAddr string, smfPort int, upfAddr string, upfPort int) (Transport, error) {
+	switch kind {
+	case "", "udp":
+		return NewUDPClient(smfAddr, smfPort, upfAddr, upfPort)
+	case "tcp":
+		return NewTCPClient(smfAddr, smfPort, upfAddr, upfPort)
+	default:
+		return nil, fmt.Errorf("unsupported transport: %s", kind)
+	}
+}
+
+// toUDPAddr converts a transport address to the UDP form used by recorders.
+func toUDPAddr(addr net.Addr) *net.UDPAddr {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a
+	case *net.TCPAddr:
+		return &net.UDPAddr{IP: a.IP, Port: a.Port, Zone: a.Zone}
+	default:
+		return &net.UDPAddr{}
+	}
+}
@@ -0,0 +1,130 @@
+package pcap
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/ip4defrag"
+	"github.com/google/gopacket/layers"
+)
+
+// ipv6MaxFragments bounds the fragments held for one IPv6 datagram.
+const ipv6MaxFragments = 64
+
+// defragmenter reassembles IP-fragmented datagrams (e.g. large Session
+// Establishment Requests) before PFCP is extracted. Only the outermost IP
+// layer is reassembled.
+type defragmenter struct {
+	v4          *ip4defrag.IPv4Defragmenter
+	v6          map[ipv6FragKey][]ipv6Fragment
+	reassembled int
+}
+
+type ipv6FragKey struct {
+	src, dst string
+	id       uint32
+}
+
+type ipv6Fragment struct {
+	offset int
+	more   bool
+	data   []byte
+}
+
+func newDefragmenter() *defragmenter {
+	return &defragmenter{
+		v4: ip4defrag.NewIPv4Defragmenter(),
+		v6: make(map[ipv6FragKey][]ipv6Fragment),
+	}
+}
+
+// process returns the packet to extract PFCP from: packet itself if it is not
+// a fragment, a packet decoded from the reassembled datagram if packet
+// completes one, or nil while fragments are still missing.
+func (d *defragmenter) process(packet gopacket.Packet) (gopacket.Packet, error) {
+	switch ip := packet.NetworkLayer().(type) {
+	case *layers.IPv4:
+		if ip.Flags&layers.IPv4MoreFragments == 0 && ip.FragOffset == 0 {
+			return packet, nil
+		}
+		whole, err := d.v4.DefragIPv4WithTimestamp(ip, packet.Metadata().Timestamp)
+		if err != nil || whole == nil {
+			return nil, err
+		}
+		return d.rebuild(packet, whole, gopacket.Payload(whole.Payload))
+	case *layers.IPv6:
+		frag, ok := packet.Layer(layers.LayerTypeIPv6Fragment).(*layers.IPv6Fragment)
+		if !ok {
+			return packet, nil
+		}
+		payload, err := d.insertIPv6(ip, frag)
+		if err != nil || payload == nil {
+			return nil, err
+		}
+		whole := &layers.IPv6{
+			Version:      6,
+			TrafficClass: ip.TrafficClass,
+			FlowLabel:    ip.FlowLabel,
+			NextHeader:   frag.NextHeader,
+			HopLimit:     ip.HopLimit,
+			SrcIP:        ip.SrcIP,
+			DstIP:        ip.DstIP,
+		}
+		return d.rebuild(packet, whole, gopacket.Payload(payload))
+	default:
+		return packet, nil
+	}
+}
+
+// rebuild serializes a reassembled IP datagram and decodes it as a new packet
+// carrying the original packet's metadata.
+func (d *defragmenter) rebuild(orig gopacket.Packet, ip gopacket.SerializableLayer, payload gopacket.Payload) (gopacket.Packet, error) {
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, ip, payload); err != nil {
+		return nil, fmt.Errorf("failed to rebuild reassembled datagram: %w", err)
+	}
+
+	layerType := layers.LayerTypeIPv4
+	if _, ok := ip.(*layers.IPv6); ok {
+		layerType = layers.LayerTypeIPv6
+	}
+	packet := gopacket.NewPacket(buf.Bytes(), layerType, gopacket.Default)
+	*packet.Metadata() = *orig.Metadata()
+	d.reassembled++
+	return packet, nil
+}
+
+// insertIPv6 stores an IPv6 fragment and returns the reassembled payload once
+// all fragments of the datagram have arrived.
+func (d *defragmenter) insertIPv6(ip *layers.IPv6, frag *layers.IPv6Fragment) ([]byte, error) {
+	key := ipv6FragKey{src: string(ip.SrcIP.To16()), dst: string(ip.DstIP.To16()), id: frag.Identification}
+
+	data := make([]byte, len(frag.Payload))
+	copy(data, frag.Payload)
+	frags := append(d.v6[key], ipv6Fragment{offset: int(frag.FragmentOffset) * 8, more: frag.MoreFragments, data: data})
+	if len(frags) > ipv6MaxFragments {
+		delete(d.v6, key)
+		return nil, fmt.Errorf("IPv6 datagram %d from %s exceeds %d fragments, dropping", frag.Identification, net.IP(ip.SrcIP), ipv6MaxFragments)
+	}
+	d.v6[key] = frags
+
+	sort.Slice(frags, func(i, j int) bool { return frags[i].offset < frags[j].offset })
+	if frags[len(frags)-1].more {
+		return nil, nil // last fragment not seen yet
+	}
+
+	var payload []byte
+	for _, f := range frags {
+		if f.offset > len(payload) {
+			return nil, nil // hole: more fragments pending
+		}
+		if end := f.offset + len(f.data); end > len(payload) {
+			payload = append(payload, f.data[len(payload)-f.offset:]...)
+		}
+	}
+
+	delete(d.v6, key)
+	return payload, nil
+}
@@ -0,0 +1,135 @@
+package pcap
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+)
+
+// largeEstablishment returns an encoded Session Establishment Request larger than a 1500-byte MTU.
+func largeEstablishment(t *testing.T) []byte {
+	t.Helper()
+	ies := []*ie.IE{
+		ie.NewNodeID("10.0.0.1", "", ""),
+		ie.NewFSEID(1, net.ParseIP("10.0.0.1"), nil),
+	}
+	for i := uint16(1); i <= 40; i++ {
+		ies = append(ies, ie.NewCreatePDR(
+			ie.NewPDRID(i),
+			ie.NewPrecedence(uint32(i)),
+			ie.NewPDI(
+				ie.NewSourceInterface(ie.SrcInterfaceAccess),
+				ie.NewFTEID(0x01, uint32(i), net.ParseIP("10.0.0.2"), nil, 0),
+				ie.NewNetworkInstance("internet"),
+				ie.NewUEIPAddress(0x02, "10.60.0.1", "", 0, 0),
+			),
+			ie.NewFARID(uint32(i)),
+		))
+	}
+	msg := message.NewSessionEstablishmentRequest(0, 0, 0, 1, 0, ies...)
+	b := make([]byte, msg.MarshalLen())
+	require.NoError(t, msg.MarshalTo(b))
+	require.Greater(t, len(b), 1500)
+	return b
+}
+
+// udpSegment returns the UDP header and payload of a PFCP packet from SMF to UPF.
+func udpSegment(t *testing.T, payload []byte) []byte {
+	t.Helper()
+	full := serialize(t, ipv4UDPLayers(payload, 8805)...)
+	return full[20:] // strip the option-less IPv4 header
+}
+
+// ipv4Fragments splits a UDP segment into two IPv4 fragments at split (a multiple of 8).
+func ipv4Fragments(t *testing.T, segment []byte, split int) [][]byte {
+	t.Helper()
+	frag := func(part []byte, offset int, more bool) []byte {
+		ip := &layers.IPv4{
+			Version:    4,
+			TTL:        64,
+			Id:         7,
+			Protocol:   layers.IPProtocolUDP,
+			SrcIP:      testSMFIP,
+			DstIP:      testUPFIP,
+			FragOffset: uint16(offset / 8),
+		}
+		if more {
+			ip.Flags = layers.IPv4MoreFragments
+		}
+		return serialize(t, ip, gopacket.Payload(part))
+	}
+	return [][]byte{
+		frag(segment[:split], 0, true),
+		frag(segment[split:], split, false),
+	}
+}
+
+// ipv6Fragments splits a UDP segment into two IPv6 fragments at split (a multiple of 8).
+func ipv6Fragments(t *testing.T, segment []byte, split int) [][]byte {
+	t.Helper()
+	frag := func(part []byte, offset int, more bool) []byte {
+		hdr := make([]byte, 8)
+		hdr[0] = byte(layers.IPProtocolUDP)
+		fo := uint16(offset/8) << 3
+		if more {
+			fo |= 1
+		}
+		binary.BigEndian.PutUint16(hdr[2:4], fo)
+		binary.BigEndian.PutUint32(hdr[4:8], 99)
+
+		ip := &layers.IPv6{
+			Version:    6,
+			NextHeader: layers.IPProtocolIPv6Fragment,
+			HopLimit:   64,
+			SrcIP:      net.ParseIP("2001:db8::1"),
+			DstIP:      net.ParseIP("2001:db8::2"),
+		}
+		return serialize(t, ip, gopacket.Payload(append(hdr, part...)))
+	}
+	return [][]byte{
+		frag(segment[:split], 0, true),
+		frag(segment[split:], split, false),
+	}
+}
+
+func TestParser_ReassemblesIPv4Fragments(t *testing.T) {
+	pfcpMsg := largeEstablishment(t)
+	frames := ipv4Fragments(t, udpSegment(t, pfcpMsg), 1480)
+	path := writePcapFile(t, 101, frames...)
+
+	result, err := NewParser().ParseWithMappings(context.Background(), path)
+	require.NoError(t, err)
+	require.Len(t, result.Messages, 1)
+	assert.Equal(t, pfcpMsg, result.Messages[0].Data)
+	assert.True(t, result.Messages[0].SrcIP.Equal(testSMFIP))
+}
+
+func TestParser_ReassemblesIPv6FragmentsOutOfOrder(t *testing.T) {
+	pfcpMsg := largeEstablishment(t)
+	// The UDP checksum is not verified, so the IPv4-based segment can be reused
+	frames := ipv6Fragments(t, udpSegment(t, pfcpMsg), 1232)
+	path := writePcapFile(t, 101, frames[1], frames[0])
+
+	result, err := NewParser().ParseWithMappings(context.Background(), path)
+	require.NoError(t, err)
+	require.Len(t, result.Messages, 1)
+	assert.Equal(t, pfcpMsg, result.Messages[0].Data)
+	assert.True(t, result.Messages[0].SrcIP.Equal(net.ParseIP("2001:db8::1")))
+}
+
+func TestParser_IncompleteFragmentsAreDropped(t *testing.T) {
+	frames := ipv4Fragments(t, udpSegment(t, largeEstablishment(t)), 1480)
+	path := writePcapFile(t, 101, frames[0])
+
+	result, err := NewParser().ParseWithMappings(context.Background(), path)
+	require.NoError(t, err)
+	assert.Empty(t, result.Messages)
+}
@@ -0,0 +1,80 @@
+package pcap
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/wmnsk/go-pfcp/message"
+
+	"pfcp-generator/pkg/types"
+)
+
+// InferEndpoints determines the SMF and UPF IPs of a capture by majority vote
+// over its request packets: the SMF is the source and the UPF the destination.
+// Only Session Establishment, Modification and Deletion Requests are counted,
+// since they always flow SMF→UPF; if there are none, all requests are used.
+// An error listing the candidates is returned when no IP has a strict majority.
+func (p *Parser) InferEndpoints(messages []types.RawPFCPMessage) (smfIP, upfIP net.IP, err error) {
+	var votes []types.RawPFCPMessage
+	for _, raw := range messages {
+		if len(raw.Data) < 2 {
+			continue
+		}
+		switch raw.Data[1] {
+		case message.MsgTypeSessionEstablishmentRequest,
+			message.MsgTypeSessionModificationRequest,
+			message.MsgTypeSessionDeletionRequest:
+			votes = append(votes, raw)
+		}
+	}
+	if len(votes) == 0 {
+		votes = messages
+	}
+	if len(votes) == 0 {
+		return nil, nil, fmt.Errorf("no PFCP requests to infer the SMF and UPF addresses from")
+	}
+
+	srcCounts := make(map[string]int)
+	dstCounts := make(map[string]int)
+	for _, raw := range votes {
+		srcCounts[raw.SrcIP.String()]++
+		dstCounts[raw.DstIP.String()]++
+	}
+
+	smf, err := majority(srcCounts, len(votes))
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot infer the SMF address from the pcap: %w", err)
+	}
+	upf, err := majority(dstCounts, len(votes))
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot infer the UPF address from the pcap: %w", err)
+	}
+	return net.ParseIP(smf), net.ParseIP(upf), nil
+}
+
+// majority returns the key of counts holding more than half of total, or an
+// error listing every candidate by descending count.
+func majority(counts map[string]int, total int) (string, error) {
+	candidates := make([]string, 0, len(counts))
+	for ip := range counts {
+		candidates = append(candidates, ip)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if counts[candidates[i]] != counts[candidates[j]] {
+			return counts[candidates[i]] > counts[candidates[j]]
+		}
+		return candidates[i] < candidates[j]
+	})
+
+	if counts[candidates[0]]*2 > total {
+		return candidates[0], nil
+	}
+
+	listed := make([]string, len(candidates))
+	for i, ip := range candidates {
+		listed[i] = fmt.Sprintf("%s (%d requests)", ip, counts[ip])
+	}
+	return "", fmt.Errorf("ambiguous candidates %s", strings.Join(listed, ", "))
+}
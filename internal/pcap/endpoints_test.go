@@ -0,0 +1,53 @@
+package pcap
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/message"
+
+	"pfcp-generator/pkg/types"
+)
+
+func rawRequest(msgType uint8, src, dst string) types.RawPFCPMessage {
+	return types.RawPFCPMessage{
+		Data:  []byte{0x21, msgType},
+		SrcIP: net.ParseIP(src),
+		DstIP: net.ParseIP(dst),
+	}
+}
+
+func TestInferEndpoints_MajorityOfSessionRequests(t *testing.T) {
+	messages := []types.RawPFCPMessage{
+		// UPF-originated requests are not counted
+		rawRequest(message.MsgTypeHeartbeatRequest, "10.0.0.2", "10.0.0.1"),
+		rawRequest(message.MsgTypeSessionReportRequest, "10.0.0.2", "10.0.0.1"),
+		rawRequest(message.MsgTypeSessionReportRequest, "10.0.0.2", "10.0.0.1"),
+		rawRequest(message.MsgTypeSessionEstablishmentRequest, "10.0.0.1", "10.0.0.2"),
+		rawRequest(message.MsgTypeSessionDeletionRequest, "10.0.0.1", "10.0.0.2"),
+	}
+
+	smf, upf, err := NewParser().InferEndpoints(messages)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", smf.String())
+	assert.Equal(t, "10.0.0.2", upf.String())
+}
+
+func TestInferEndpoints_Ambiguous(t *testing.T) {
+	messages := []types.RawPFCPMessage{
+		rawRequest(message.MsgTypeSessionEstablishmentRequest, "10.0.0.1", "10.0.0.2"),
+		rawRequest(message.MsgTypeSessionEstablishmentRequest, "10.0.0.3", "10.0.0.2"),
+	}
+
+	_, _, err := NewParser().InferEndpoints(messages)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "SMF address")
+	assert.Contains(t, err.Error(), "10.0.0.1 (1 requests), 10.0.0.3 (1 requests)")
+}
+
+func TestInferEndpoints_NoRequests(t *testing.T) {
+	_, _, err := NewParser().InferEndpoints(nil)
+	assert.Error(t, err)
+}
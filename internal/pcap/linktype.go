@@ -0,0 +1,69 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Link types gopacket v1.1.19 has no decoder for. pcap.Handle.LinkType truncates
+// the DLT to 8 bits, so LINKTYPE_LINUX_SLL2 (276) is reported as 20.
+const (
+	linkTypeLinuxSLL2 layers.LinkType = 276 & 0xff
+	linkTypeDLTRaw    layers.LinkType = 12 // DLT_RAW on Linux and most BSDs
+	linkTypeDLTRawBSD layers.LinkType = 14 // DLT_RAW on OpenBSD
+)
+
+// ethernetTypeQinQLegacy is the pre-802.1ad QinQ outer tag type.
+const ethernetTypeQinQLegacy layers.EthernetType = 0x9100
+
+// sll2HeaderLen is the length of the Linux cooked v2 header (tcpdump -i any on newer kernels).
+const sll2HeaderLen = 20
+
+func init() {
+	// gopacket decodes 0x8100 and 0x88a8 tags but not the legacy 0x9100 QinQ
+	// outer tag still used by some switches.
+	layers.EthernetTypeMetadata[ethernetTypeQinQLegacy] = layers.EnumMetadata{
+		DecodeWith: layers.LayerTypeDot1Q,
+		Name:       "Dot1Q",
+		LayerType:  layers.LayerTypeDot1Q,
+	}
+}
+
+// linkDecoder returns the decoder and a display name for a pcap link type.
+func linkDecoder(linkType layers.LinkType) (gopacket.Decoder, string) {
+	switch linkType {
+	case linkTypeLinuxSLL2:
+		return gopacket.DecodeFunc(decodeLinuxSLL2), "Linux SLL2"
+	case layers.LinkTypeRaw, linkTypeDLTRaw, linkTypeDLTRawBSD, layers.LinkTypeIPv4, layers.LinkTypeIPv6:
+		return gopacket.DecodeFunc(decodeRawIP), "Raw IP"
+	default:
+		return linkType, linkType.String()
+	}
+}
+
+// decodeLinuxSLL2 skips the SLL2 header and decodes the payload by its protocol type.
+func decodeLinuxSLL2(data []byte, p gopacket.PacketBuilder) error {
+	if len(data) < sll2HeaderLen {
+		return fmt.Errorf("Linux SLL2 header too short: %d bytes", len(data))
+	}
+	protocol := layers.EthernetType(binary.BigEndian.Uint16(data[0:2]))
+	return protocol.Decode(data[sll2HeaderLen:], p)
+}
+
+// decodeRawIP decodes a packet without link layer as IPv4 or IPv6 by its version nibble.
+func decodeRawIP(data []byte, p gopacket.PacketBuilder) error {
+	if len(data) == 0 {
+		return fmt.Errorf("empty raw IP packet")
+	}
+	switch data[0] >> 4 {
+	case 4:
+		return layers.LayerTypeIPv4.Decode(data, p)
+	case 6:
+		return layers.LayerTypeIPv6.Decode(data, p)
+	default:
+		return fmt.Errorf("invalid IP version %d in raw IP packet", data[0]>>4)
+	}
+}
@@ -0,0 +1,102 @@
+package pcap
+
+import (
+	"fmt"
+
+	"github.com/wmnsk/go-pfcp/message"
+
+	pfcputil "pfcp-generator/internal/pfcp"
+	"pfcp-generator/pkg/types"
+)
+
+// Multiply turns every session in the result into n sessions. Each Session
+// Establishment, Modification and Deletion Request is replaced by n copies in
+// a row, so every copy follows the original's message order; other requests
+// are kept once. The first copy keeps the pcap's SEIDs, and every further copy
+// gets unused original CP and UP SEIDs (with matching SEID mappings), so the
+// replay treats it as a session of its own and allocates fresh SEIDs, UE IPs
+// and TEIDs for it. Copies are decoded from the original bytes and re-encoded,
+// so no IE is shared between them.
+func (r *ParseResult) Multiply(n int) error {
+	if n <= 1 {
+		return nil
+	}
+
+	// Synthetic SEIDs are numbered after the largest SEID in the pcap
+	var next uint64
+	for _, mapping := range r.SEIDMappings {
+		next = max(next, mapping.OriginalCPSEID, mapping.OriginalRemoteSEID)
+	}
+	for _, raw := range r.Messages {
+		msg, err := pfcputil.Decode(raw.Data)
+		if err != nil || !pfcputil.IsSessionMessage(msg) {
+			continue
+		}
+		next = max(next, pfcputil.ExtractHeaderSEID(msg))
+		if req, ok := msg.(*message.SessionEstablishmentRequest); ok {
+			if cpSEID, err := pfcputil.ExtractCPSEID(req); err == nil {
+				next = max(next, cpSEID)
+			}
+		}
+	}
+
+	// Copy k of original SEID s is clones[s][k-1], used for CP and UP SEIDs alike
+	clones := make(map[uint64][]uint64)
+	cloneSEID := func(seid uint64, k int) uint64 {
+		if k == 0 || seid == 0 {
+			return seid
+		}
+		if _, ok := clones[seid]; !ok {
+			clones[seid] = make([]uint64, n-1)
+			for i := range clones[seid] {
+				next++
+				clones[seid][i] = next
+			}
+		}
+		return clones[seid][k-1]
+	}
+
+	var messages []types.RawPFCPMessage
+	for _, raw := range r.Messages {
+		messages = append(messages, raw)
+		msg, err := pfcputil.Decode(raw.Data)
+		if err != nil || !isSessionRequest(msg) {
+			continue
+		}
+		for k := 1; k < n; k++ {
+			data, err := pfcputil.CloneSessionRequest(raw.Data, func(seid uint64) uint64 { return cloneSEID(seid, k) })
+			if err != nil {
+				return fmt.Errorf("failed to copy %s: %w", pfcputil.MessageTypeName(msg.MessageType()), err)
+			}
+			clone := raw
+			clone.Data = data
+			messages = append(messages, clone)
+		}
+	}
+
+	mappings := append([]types.SEIDMapping(nil), r.SEIDMappings...)
+	for k := 1; k < n; k++ {
+		for _, mapping := range r.SEIDMappings {
+			mappings = append(mappings, types.SEIDMapping{
+				OriginalCPSEID:     cloneSEID(mapping.OriginalCPSEID, k),
+				OriginalRemoteSEID: cloneSEID(mapping.OriginalRemoteSEID, k),
+			})
+		}
+	}
+
+	r.Messages = messages
+	r.SEIDMappings = mappings
+	return nil
+}
+
+// isSessionRequest returns true for the requests that make up a session.
+func isSessionRequest(msg message.Message) bool {
+	switch msg.MessageType() {
+	case message.MsgTypeSessionEstablishmentRequest,
+		message.MsgTypeSessionModificationRequest,
+		message.MsgTypeSessionDeletionRequest:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,71 @@
+package pcap
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+
+	pfcputil "pfcp-generator/internal/pfcp"
+	"pfcp-generator/pkg/types"
+)
+
+func TestParseResult_Multiply(t *testing.T) {
+	encode := func(msg message.Message) types.RawPFCPMessage {
+		data, err := pfcputil.Encode(msg)
+		require.NoError(t, err)
+		return types.RawPFCPMessage{Data: data}
+	}
+	result := &ParseResult{
+		Messages: []types.RawPFCPMessage{
+			encode(message.NewAssociationSetupRequest(1, ie.NewNodeID("10.0.0.1", "", ""))),
+			encode(message.NewSessionEstablishmentRequest(0, 0, 0, 2, 0,
+				ie.NewNodeID("10.0.0.1", "", ""),
+				ie.NewFSEID(0x10, net.ParseIP("10.0.0.1"), nil),
+			)),
+			encode(message.NewSessionDeletionRequest(0, 0, 0x20, 3, 0)),
+		},
+		SEIDMappings: []types.SEIDMapping{{OriginalCPSEID: 0x10, OriginalRemoteSEID: 0x20}},
+	}
+
+	require.NoError(t, result.Multiply(3))
+
+	var msgTypes []uint8
+	var cpSEIDs, headerSEIDs []uint64
+	for _, raw := range result.Messages {
+		msg, err := pfcputil.Decode(raw.Data)
+		require.NoError(t, err)
+		msgTypes = append(msgTypes, msg.MessageType())
+		switch msg := msg.(type) {
+		case *message.SessionEstablishmentRequest:
+			cpSEID, err := pfcputil.ExtractCPSEID(msg)
+			require.NoError(t, err)
+			cpSEIDs = append(cpSEIDs, cpSEID)
+		case *message.SessionDeletionRequest:
+			headerSEIDs = append(headerSEIDs, msg.SEID())
+		}
+	}
+
+	// Association Setup is sent once; session requests three times in a row
+	assert.Equal(t, []uint8{
+		message.MsgTypeAssociationSetupRequest,
+		message.MsgTypeSessionEstablishmentRequest,
+		message.MsgTypeSessionEstablishmentRequest,
+		message.MsgTypeSessionEstablishmentRequest,
+		message.MsgTypeSessionDeletionRequest,
+		message.MsgTypeSessionDeletionRequest,
+		message.MsgTypeSessionDeletionRequest,
+	}, msgTypes)
+
+	// The first copy keeps the pcap's SEIDs, the others get unused ones
+	assert.Equal(t, []uint64{0x10, 0x21, 0x22}, cpSEIDs)
+	assert.Equal(t, []uint64{0x20, 0x23, 0x24}, headerSEIDs)
+	assert.Equal(t, []types.SEIDMapping{
+		{OriginalCPSEID: 0x10, OriginalRemoteSEID: 0x20},
+		{OriginalCPSEID: 0x21, OriginalRemoteSEID: 0x23},
+		{OriginalCPSEID: 0x22, OriginalRemoteSEID: 0x24},
+	}, result.SEIDMappings)
+}
@@ -1,8 +1,10 @@
 package pcap
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/google/gopacket"
@@ -15,66 +17,323 @@ import (
 	"pfcp-generator/pkg/types"
 )
 
+// defaultPFCPPort is the IANA-assigned PFCP UDP port.
+const defaultPFCPPort = 8805
+
+// gtpuPort is the GTP-U UDP port, used when decapsulating tunneled PFCP.
+const gtpuPort = 2152
+
+// streamBufferSize is the number of decoded requests Stream reads ahead of its consumer.
+const streamBufferSize = 1024
+
 // Parser reads PCAP files and extracts PFCP request messages.
-type Parser struct{}
+type Parser struct {
+	ports     []uint16
+	decapGTPU bool
+
+	// progressInterval is how often parsing progress is logged (0 = never)
+	progressInterval time.Duration
 
-// NewParser creates a new PCAP parser.
+	onSEIDMapping func(types.SEIDMapping)
+}
+
+// NewParser creates a new PCAP parser matching PFCP on the default port 8805.
 func NewParser() *Parser {
-	return &Parser{}
+	return &Parser{ports: []uint16{defaultPFCPPort}}
+}
+
+// SetPorts sets the UDP ports that carry PFCP in the pcap. An empty list keeps the current ports.
+func (p *Parser) SetPorts(ports []uint16) {
+	if len(ports) > 0 {
+		p.ports = ports
+	}
+}
+
+// SetDecapGTPU enables looking for PFCP inside GTP-U tunnels (UDP port 2152).
+func (p *Parser) SetDecapGTPU(enabled bool) {
+	p.decapGTPU = enabled
+}
+
+// SetProgressInterval makes parsing log the number of packets read so far
+// every interval. 0 disables progress logging.
+func (p *Parser) SetProgressInterval(interval time.Duration) {
+	p.progressInterval = interval
+}
+
+// isPFCP reports whether either UDP port is a configured PFCP port.
+func (p *Parser) isPFCP(udp *layers.UDP) bool {
+	for _, port := range p.ports {
+		if uint16(udp.SrcPort) == port || uint16(udp.DstPort) == port {
+			return true
+		}
+	}
+	return false
+}
+
+// bpfFilter returns a BPF expression matching UDP on the configured PFCP ports
+// and IP fragments.
+// On Ethernet, frames with one or two VLAN tags are matched as well.
+func (p *Parser) bpfFilter(linkType layers.LinkType) string {
+	terms := make([]string, 0, len(p.ports)+1)
+	for _, port := range p.ports {
+		terms = append(terms, fmt.Sprintf("udp port %d", port))
+	}
+	if p.decapGTPU {
+		terms = append(terms, fmt.Sprintf("udp port %d", gtpuPort))
+	}
+	// Non-first IP fragments carry no UDP header; keep all fragments for reassembly
+	terms = append(terms, "(ip[6:2] & 0x3fff != 0)", "(ip6 and ip6[6] == 44)")
+	filter := strings.Join(terms, " or ")
+
+	if linkType != layers.LinkTypeEthernet {
+		return filter
+	}
+	// Each vlan shifts the offsets of the rest of the expression, including
+	// later alternatives, so the tagged alternatives are nested rather than
+	// listed side by side
+	return fmt.Sprintf("%[1]s or (vlan and (%[1]s or (vlan and (%[1]s))))", filter)
+}
+
+// open opens a pcap file and installs the PFCP port BPF filter. If the filter
+// cannot be applied, the file is read unfiltered and isPFCP does the matching.
+// pcapng files are read with the pure-Go reader, which takes no filter.
+func (p *Parser) open(filename string) (packetReader, error) {
+	if ng, err := isPcapng(filename); err == nil && ng {
+		return openPcapng(filename)
+	}
+
+	handle, err := pcap.OpenOffline(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pcap file %s: %w", filename, err)
+	}
+
+	filter := p.bpfFilter(handle.LinkType())
+	if err := handle.SetBPFFilter(filter); err != nil {
+		log.WithError(err).WithField("filter", filter).Warn("Failed to apply BPF filter, reading pcap unfiltered")
+	} else {
+		log.WithField("filter", filter).Debug("Applied BPF filter")
+	}
+	return handle, nil
 }
 
 // ParseResult contains the parsed PFCP request messages and SEID mappings from the pcap.
 type ParseResult struct {
 	Messages     []types.RawPFCPMessage
 	SEIDMappings []types.SEIDMapping // original CP SEID → original remote (UP) SEID
+	Counts       ScanCounts
 }
 
-// Parse reads a pcap file and returns all PFCP request messages in order,
+// ScanCounts summarises the packets seen while reading a pcap.
+type ScanCounts struct {
+	Packets   int    // All packets read
+	PFCP      int    // Packets on a PFCP port
+	Requests  int    // PFCP request messages
+	Responses int    // PFCP response messages
+	Malformed int    // Packets on a PFCP port that did not decode as PFCP
+	FirstResp string // "src -> dst" of the first response, for diagnostics
+}
+
+// add accumulates the counts of another pcap file.
+func (c *ScanCounts) add(o ScanCounts) {
+	c.Packets += o.Packets
+	c.PFCP += o.PFCP
+	c.Requests += o.Requests
+	c.Responses += o.Responses
+	c.Malformed += o.Malformed
+	if c.FirstResp == "" {
+		c.FirstResp = o.FirstResp
+	}
+}
+
+// NoRequestsError explains why a pcap yielded no PFCP requests. A capture
+// with responses but no requests usually holds only the UPF→SMF direction.
+func (c ScanCounts) NoRequestsError() error {
+	switch {
+	case c.Responses > 0:
+		return fmt.Errorf("no PFCP request messages found in pcap file: all %d PFCP messages are responses "+
+			"(first one %s), so only the UPF→SMF direction appears to have been captured; "+
+			"the capture may be one-directional or filtered by source address, capture both directions of the N4 interface",
+			c.Responses, c.FirstResp)
+	case c.PFCP == 0 && c.Packets > 0:
+		return fmt.Errorf("no PFCP messages found in pcap file (%d packets read): check that input.pfcp_port matches the capture", c.Packets)
+	default:
+		return fmt.Errorf("no PFCP request messages found in pcap file")
+	}
+}
+
+// Parse reads pcap files and returns all PFCP request messages in order,
 // along with SEID mappings extracted from Session Establishment Response messages.
-func (p *Parser) Parse(filename string) ([]types.RawPFCPMessage, error) {
-	result, err := p.ParseWithMappings(filename)
+func (p *Parser) Parse(ctx context.Context, filenames ...string) ([]types.RawPFCPMessage, error) {
+	result, err := p.ParseWithMappings(ctx, filenames...)
 	if err != nil {
 		return nil, err
 	}
 	return result.Messages, nil
 }
 
-// ParseWithMappings reads a pcap file and returns request messages plus SEID mappings.
-func (p *Parser) ParseWithMappings(filename string) (*ParseResult, error) {
-	handle, err := pcap.OpenOffline(filename)
+// ParseWithMappings reads pcap files and returns request messages plus SEID
+// mappings. Several files are read one after the other, as if they were a
+// single capture split into parts: messages keep the order of the files, and
+// mappings from a file apply to sessions continued in later files. If ctx is
+// cancelled, parsing stops and ctx's error is returned.
+func (p *Parser) ParseWithMappings(ctx context.Context, filenames ...string) (*ParseResult, error) {
+	handles, err := p.openAll(filenames)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open pcap file %s: %w", filename, err)
+		return nil, err
 	}
-	defer handle.Close()
 
-	linkType := handle.LinkType()
-	log.WithField("link_type", linkType.String()).Debug("PCAP link type detected")
+	result := &ParseResult{}
+	for i, handle := range handles {
+		counts := p.scan(ctx, handle,
+			func(raw types.RawPFCPMessage) bool {
+				result.Messages = append(result.Messages, raw)
+				return true
+			},
+			func(mapping types.SEIDMapping) {
+				result.SEIDMappings = append(result.SEIDMappings, mapping)
+			},
+		)
+		handle.Close()
+		result.Counts.add(counts)
+		if ctx.Err() != nil {
+			closeAll(handles[i+1:])
+			break
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("pcap parsing interrupted after %d packets: %w", result.Counts.Packets, err)
+	}
+	return result, nil
+}
 
-	packetSource := gopacket.NewPacketSource(handle, linkType)
+// openAll opens every file, closing the ones already open if one fails.
+func (p *Parser) openAll(filenames []string) ([]packetReader, error) {
+	if len(filenames) == 0 {
+		return nil, fmt.Errorf("no pcap file given")
+	}
+	handles := make([]packetReader, 0, len(filenames))
+	for _, filename := range filenames {
+		handle, err := p.open(filename)
+		if err != nil {
+			closeAll(handles)
+			return nil, err
+		}
+		handles = append(handles, handle)
+	}
+	return handles, nil
+}
+
+// closeAll closes every handle.
+func closeAll(handles []packetReader) {
+	for _, handle := range handles {
+		handle.Close()
+	}
+}
+
+// SetSEIDMappingHandler sets the function Stream passes SEID mappings to as
+// Session Establishment Responses are decoded.
+func (p *Parser) SetSEIDMappingHandler(fn func(types.SEIDMapping)) {
+	p.onSEIDMapping = fn
+}
+
+// Stream reads pcap files in the background and emits PFCP request messages
+// in pcap order, file after file, on the returned channel, which is closed at
+// the end of the last file or when ctx is cancelled. Unlike ParseWithMappings,
+// memory use does not grow with the size of the pcap.
+//
+// SEID mappings are passed to the handler set with SetSEIDMappingHandler as
+// soon as their Session Establishment Response is decoded. This relies on the response following its
+// request in the pcap: the mapping is known before any later Modification or
+// Deletion Request of the session is emitted, but usually after the
+// Establishment Request itself has been.
+func (p *Parser) Stream(ctx context.Context, filenames ...string) (<-chan types.RawPFCPMessage, error) {
+	handles, err := p.openAll(filenames)
+	if err != nil {
+		return nil, err
+	}
+
+	onMapping := p.onSEIDMapping
+	if onMapping == nil {
+		onMapping = func(types.SEIDMapping) {}
+	}
+
+	out := make(chan types.RawPFCPMessage, streamBufferSize)
+	go func() {
+		defer close(out)
+		defer closeAll(handles)
+		for _, handle := range handles {
+			p.scan(ctx, handle,
+				func(raw types.RawPFCPMessage) bool {
+					select {
+					case out <- raw:
+						return true
+					case <-ctx.Done():
+						return false
+					}
+				},
+				onMapping,
+			)
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// scan decodes every packet of handle, calling onMapping for each SEID mapping
+// and emit for each request message in pcap order, and returns the packet
+// counts. Scanning stops early when emit returns false or ctx is cancelled.
+func (p *Parser) scan(ctx context.Context, handle packetReader, emit func(types.RawPFCPMessage) bool, onMapping func(types.SEIDMapping)) ScanCounts {
+	packetSource := newPacketSource(handle)
 	packetSource.DecodeOptions.Lazy = true
 	packetSource.DecodeOptions.NoCopy = true
 
-	result := &ParseResult{}
+	var counts ScanCounts
 	totalPackets := 0
 	pfcpPackets := 0
 	requestPackets := 0
+	tunneledPackets := 0
 
+	lastProgress := time.Now()
+	defrag := newDefragmenter()
 	for packet := range packetSource.Packets() {
+		if ctx.Err() != nil {
+			log.WithField("packet", totalPackets).Info("PCAP parsing stopped")
+			counts.Packets, counts.PFCP, counts.Requests = totalPackets, pfcpPackets, requestPackets
+			return counts
+		}
 		totalPackets++
 
-		// Extract UDP layer (works for both Ethernet and Linux cooked captures)
-		udpLayer := packet.Layer(layers.LayerTypeUDP)
-		if udpLayer == nil {
+		if p.progressInterval > 0 && time.Since(lastProgress) >= p.progressInterval {
+			lastProgress = time.Now()
+			log.WithFields(log.Fields{
+				"packets":  totalPackets,
+				"requests": requestPackets,
+			}).Info("Parsing pcap")
+		}
+
+		// Reassemble IP fragments; the link layer is only kept on unfragmented packets
+		vlans := vlanIDs(packet)
+		var err error
+		packet, err = defrag.process(packet)
+		if err != nil {
+			log.WithError(err).WithField("packet", totalPackets).Warn("Failed to reassemble IP fragments, skipping")
 			continue
 		}
+		if packet == nil {
+			continue // Waiting for the remaining fragments
+		}
 
-		udp, ok := udpLayer.(*layers.UDP)
-		if !ok {
+		// Extract UDP layer (works for Ethernet, Linux cooked v1/v2, and raw IP captures)
+		dgram := p.findUDP(packet)
+		if dgram == nil {
 			continue
 		}
+		udp := dgram.udp
 
-		// Filter PFCP port (8805)
-		if udp.DstPort != 8805 && udp.SrcPort != 8805 {
+		// Filter PFCP ports
+		if !p.isPFCP(udp) {
 			continue
 		}
 
@@ -84,11 +343,15 @@ func (p *Parser) ParseWithMappings(filename string) (*ParseResult, error) {
 		}
 
 		pfcpPackets++
+		if dgram.tunneled {
+			tunneledPackets++
+		}
 
 		// Parse PFCP message to check if it's a request
 		msg, err := pfcputil.Decode(payload)
 		if err != nil {
 			log.WithError(err).WithField("packet", totalPackets).Warn("Failed to decode PFCP message, skipping")
+			counts.Malformed++
 			continue
 		}
 
@@ -102,10 +365,10 @@ func (p *Parser) ParseWithMappings(filename string) (*ParseResult, error) {
 						OriginalCPSEID:     cpSEID,
 						OriginalRemoteSEID: fseid.SEID,
 					}
-					result.SEIDMappings = append(result.SEIDMappings, mapping)
+					onMapping(mapping)
 					log.WithFields(log.Fields{
-						"packet":     totalPackets,
-						"cp_seid":    cpSEID,
+						"packet":      totalPackets,
+						"cp_seid":     cpSEID,
 						"remote_seid": fseid.SEID,
 					}).Debug("Extracted SEID mapping from Establishment Response")
 				}
@@ -114,6 +377,10 @@ func (p *Parser) ParseWithMappings(filename string) (*ParseResult, error) {
 
 		// Only keep request messages (skip responses)
 		if !pfcputil.IsRequest(msg) {
+			if counts.Responses == 0 {
+				counts.FirstResp = fmt.Sprintf("%s:%d -> %s:%d", dgram.srcIP, udp.SrcPort, dgram.dstIP, udp.DstPort)
+			}
+			counts.Responses++
 			log.WithFields(log.Fields{
 				"packet":   totalPackets,
 				"msg_type": pfcputil.MessageTypeName(msg.MessageType()),
@@ -123,17 +390,7 @@ func (p *Parser) ParseWithMappings(filename string) (*ParseResult, error) {
 
 		requestPackets++
 
-		// Extract IP addresses
-		var srcIP, dstIP net.IP
-		if ipv4Layer := packet.Layer(layers.LayerTypeIPv4); ipv4Layer != nil {
-			ipv4, _ := ipv4Layer.(*layers.IPv4)
-			srcIP = ipv4.SrcIP
-			dstIP = ipv4.DstIP
-		} else if ipv6Layer := packet.Layer(layers.LayerTypeIPv6); ipv6Layer != nil {
-			ipv6, _ := ipv6Layer.(*layers.IPv6)
-			srcIP = ipv6.SrcIP
-			dstIP = ipv6.DstIP
-		}
+		srcIP, dstIP := dgram.srcIP, dgram.dstIP
 
 		// Copy payload since we're using NoCopy
 		dataCopy := make([]byte, len(payload))
@@ -146,50 +403,148 @@ func (p *Parser) ParseWithMappings(filename string) (*ParseResult, error) {
 			DstIP:     dstIP,
 			SrcPort:   uint16(udp.SrcPort),
 			DstPort:   uint16(udp.DstPort),
+			VLANIDs:   vlans,
 		}
 
-		result.Messages = append(result.Messages, rawMsg)
-
 		log.WithFields(log.Fields{
 			"packet":   totalPackets,
 			"msg_type": pfcputil.MessageTypeName(msg.MessageType()),
 			"src":      fmt.Sprintf("%s:%d", srcIP, udp.SrcPort),
 			"dst":      fmt.Sprintf("%s:%d", dstIP, udp.DstPort),
+			"vlan":     rawMsg.VLANIDs,
 		}).Debug("Extracted PFCP request")
+
+		if !emit(rawMsg) {
+			log.WithField("packet", totalPackets).Info("PCAP parsing stopped")
+			counts.Packets, counts.PFCP, counts.Requests = totalPackets, pfcpPackets, requestPackets
+			return counts
+		}
+	}
+	counts.Packets, counts.PFCP, counts.Requests = totalPackets, pfcpPackets, requestPackets
+
+	summary := log.Fields{
+		"total_packets":    totalPackets,
+		"pfcp_packets":     pfcpPackets,
+		"request_packets":  requestPackets,
+		"response_packets": counts.Responses,
+	}
+	if p.decapGTPU {
+		summary["tunneled_packets"] = tunneledPackets
 	}
+	if defrag.reassembled > 0 {
+		summary["reassembled_packets"] = defrag.reassembled
+	}
+	log.WithFields(summary).Info("PCAP parsing complete")
 
-	log.WithFields(log.Fields{
-		"total_packets":   totalPackets,
-		"pfcp_packets":    pfcpPackets,
-		"request_packets": requestPackets,
-	}).Info("PCAP parsing complete")
+	// Streaming callers never see the counts; warn about one-directional captures here
+	if requestPackets == 0 && counts.Responses > 0 {
+		log.Warn(counts.NoRequestsError())
+	}
+	return counts
+}
 
-	return result, nil
+// udpDatagram is a UDP layer that may carry PFCP, with the addresses of the IP layer carrying it.
+type udpDatagram struct {
+	udp      *layers.UDP
+	srcIP    net.IP
+	dstIP    net.IP
+	tunneled bool // found inside a GTP-U tunnel
 }
 
-// CountMessages returns a summary of message types found in a pcap file.
-func (p *Parser) CountMessages(filename string) (map[string]int, error) {
-	handle, err := pcap.OpenOffline(filename)
+// findUDP returns the outermost UDP datagram of a packet. With GTP-U
+// decapsulation enabled, the datagram inside the (innermost) GTP-U tunnel is
+// returned instead; otherwise decoding stops at the tunnel.
+func (p *Parser) findUDP(packet gopacket.Packet) *udpDatagram {
+	var found *udpDatagram
+	var srcIP, dstIP net.IP
+	tunneled := false
+
+	for _, layer := range packet.Layers() {
+		switch l := layer.(type) {
+		case *layers.IPv4:
+			srcIP, dstIP = l.SrcIP, l.DstIP
+		case *layers.IPv6:
+			srcIP, dstIP = l.SrcIP, l.DstIP
+		case *layers.UDP:
+			if found == nil {
+				found = &udpDatagram{udp: l, srcIP: srcIP, dstIP: dstIP, tunneled: tunneled}
+			}
+		case *layers.GTPv1U:
+			if !p.decapGTPU {
+				return found
+			}
+			found, srcIP, dstIP, tunneled = nil, nil, nil, true
+		}
+	}
+	return found
+}
+
+// vlanIDs returns the 802.1Q VLAN IDs of a packet, outermost first, or nil if untagged.
+func vlanIDs(packet gopacket.Packet) []uint16 {
+	var ids []uint16
+	for _, layer := range packet.Layers() {
+		if tag, ok := layer.(*layers.Dot1Q); ok {
+			ids = append(ids, tag.VLANIdentifier)
+		}
+	}
+	return ids
+}
+
+// newPacketSource creates a packet source decoding the handle's link type,
+// including link types gopacket does not decode on its own (see linkDecoder).
+func newPacketSource(handle packetReader) *gopacket.PacketSource {
+	linkType := handle.LinkType()
+	decoder, linkName := linkDecoder(linkType)
+
+	entry := log.WithField("link_type", linkName)
+	if linkType == layers.LinkTypeEthernet {
+		entry.Debug("PCAP link type detected")
+	} else {
+		entry.Info("PCAP link type detected")
+	}
+
+	return gopacket.NewPacketSource(handle, decoder)
+}
+
+// CountMessages returns a summary of message types found in pcap files. If
+// ctx is cancelled, counting stops and ctx's error is returned.
+func (p *Parser) CountMessages(ctx context.Context, filenames ...string) (map[string]int, error) {
+	handles, err := p.openAll(filenames)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open pcap file %s: %w", filename, err)
+		return nil, err
 	}
-	defer handle.Close()
+	defer closeAll(handles)
 
-	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
 	counts := make(map[string]int)
+	for _, handle := range handles {
+		if err := p.countMessages(ctx, handle, counts); err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
 
+// countMessages adds the message types found in handle to counts.
+func (p *Parser) countMessages(ctx context.Context, handle packetReader, counts map[string]int) error {
+	packetSource := newPacketSource(handle)
+
+	defrag := newDefragmenter()
 	for packet := range packetSource.Packets() {
-		udpLayer := packet.Layer(layers.LayerTypeUDP)
-		if udpLayer == nil {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("pcap counting interrupted: %w", err)
+		}
+		packet, err := defrag.process(packet)
+		if err != nil || packet == nil {
 			continue
 		}
 
-		udp, ok := udpLayer.(*layers.UDP)
-		if !ok {
+		dgram := p.findUDP(packet)
+		if dgram == nil {
 			continue
 		}
+		udp := dgram.udp
 
-		if udp.DstPort != 8805 && udp.SrcPort != 8805 {
+		if !p.isPFCP(udp) {
 			continue
 		}
 
@@ -205,7 +560,7 @@ func (p *Parser) CountMessages(filename string) (map[string]int, error) {
 		counts[pfcputil.MessageTypeName(msg.MessageType())]++
 	}
 
-	return counts, nil
+	return nil
 }
 
 // ValidateHasEstablishment checks that the pcap contains at least one Session Establishment Request.
@@ -0,0 +1,475 @@
+package pcap
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+
+	"pfcp-generator/pkg/types"
+)
+
+var (
+	testSMFIP = net.IPv4(10, 0, 0, 1)
+	testUPFIP = net.IPv4(10, 0, 0, 2)
+)
+
+// heartbeatRequest returns an encoded PFCP Heartbeat Request.
+func heartbeatRequest(t *testing.T, seq uint32) []byte {
+	t.Helper()
+	msg := message.NewHeartbeatRequest(seq, ie.NewRecoveryTimeStamp(time.Unix(1700000000, 0)), nil)
+	b := make([]byte, msg.MarshalLen())
+	require.NoError(t, msg.MarshalTo(b))
+	return b
+}
+
+// serialize encodes the given layers with lengths and checksums fixed up.
+func serialize(t *testing.T, ls ...gopacket.SerializableLayer) []byte {
+	t.Helper()
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(t, gopacket.SerializeLayers(buf, opts, ls...))
+	return buf.Bytes()
+}
+
+// ipv4UDPLayers returns IPv4/UDP/payload layers for a PFCP packet from SMF to UPF on port.
+func ipv4UDPLayers(payload []byte, port layers.UDPPort) []gopacket.SerializableLayer {
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    testSMFIP,
+		DstIP:    testUPFIP,
+	}
+	udp := &layers.UDP{SrcPort: port, DstPort: port}
+	_ = udp.SetNetworkLayerForChecksum(ip)
+	return []gopacket.SerializableLayer{ip, udp, gopacket.Payload(payload)}
+}
+
+// ethernetFrame wraps IPv4/UDP/PFCP in an Ethernet header.
+func ethernetFrame(t *testing.T, payload []byte) []byte {
+	t.Helper()
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	return serialize(t, append([]gopacket.SerializableLayer{eth}, ipv4UDPLayers(payload, 8805)...)...)
+}
+
+// taggedFrame wraps IPv4/UDP/PFCP in an Ethernet header with the given VLAN tags, outermost first.
+func taggedFrame(t *testing.T, payload []byte, outerType layers.EthernetType, vlans ...uint16) []byte {
+	t.Helper()
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 2},
+		EthernetType: outerType,
+	}
+	ls := []gopacket.SerializableLayer{eth}
+	for i, id := range vlans {
+		next := layers.EthernetTypeDot1Q
+		if i == len(vlans)-1 {
+			next = layers.EthernetTypeIPv4
+		}
+		ls = append(ls, &layers.Dot1Q{VLANIdentifier: id, Type: next})
+	}
+	return serialize(t, append(ls, ipv4UDPLayers(payload, 8805)...)...)
+}
+
+// writePcapFile writes frames to a classic pcap file with the given LINKTYPE_ value.
+// The header is written by hand since pcapgo only accepts 8-bit link types.
+func writePcapFile(t *testing.T, linkType uint32, frames ...[]byte) string {
+	t.Helper()
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:4], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(hdr[4:6], 2)
+	binary.LittleEndian.PutUint16(hdr[6:8], 4)
+	binary.LittleEndian.PutUint32(hdr[16:20], 65535)
+	binary.LittleEndian.PutUint32(hdr[20:24], linkType)
+
+	data := hdr
+	for i, frame := range frames {
+		rec := make([]byte, 16)
+		binary.LittleEndian.PutUint32(rec[0:4], uint32(1700000000+i))
+		binary.LittleEndian.PutUint32(rec[8:12], uint32(len(frame)))
+		binary.LittleEndian.PutUint32(rec[12:16], uint32(len(frame)))
+		data = append(data, rec...)
+		data = append(data, frame...)
+	}
+
+	path := filepath.Join(t.TempDir(), "test.pcap")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	return path
+}
+
+// writePcapngFile writes frames to a minimal pcapng file: a Section Header
+// Block, one Interface Description Block and an Enhanced Packet Block per frame.
+func writePcapngFile(t *testing.T, linkType uint16, frames ...[]byte) string {
+	t.Helper()
+	le := binary.LittleEndian
+	block := func(blockType uint32, body []byte) []byte {
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+		total := uint32(12 + len(body))
+		b := le.AppendUint32(nil, blockType)
+		b = le.AppendUint32(b, total)
+		b = append(b, body...)
+		return le.AppendUint32(b, total)
+	}
+
+	shb := le.AppendUint32(nil, 0x1a2b3c4d) // byte-order magic
+	shb = le.AppendUint16(shb, 1)           // major version
+	shb = le.AppendUint16(shb, 0)           // minor version
+	shb = le.AppendUint64(shb, ^uint64(0))  // section length not specified
+	data := block(0x0a0d0d0a, shb)
+
+	idb := le.AppendUint16(nil, linkType)
+	idb = le.AppendUint16(idb, 0)
+	idb = le.AppendUint32(idb, 65535) // snap length
+	data = append(data, block(1, idb)...)
+
+	for i, frame := range frames {
+		ts := uint64(1700000000+i) * 1000000 // microseconds
+		epb := le.AppendUint32(nil, 0)       // interface ID
+		epb = le.AppendUint32(epb, uint32(ts>>32))
+		epb = le.AppendUint32(epb, uint32(ts))
+		epb = le.AppendUint32(epb, uint32(len(frame)))
+		epb = le.AppendUint32(epb, uint32(len(frame)))
+		epb = append(epb, frame...)
+		data = append(data, block(6, epb)...)
+	}
+
+	path := filepath.Join(t.TempDir(), "test.pcapng")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	return path
+}
+
+// assertSingleHeartbeat parses path and checks it yields one Heartbeat Request from SMF to UPF.
+func assertSingleHeartbeat(t *testing.T, path string) {
+	t.Helper()
+	result, err := NewParser().ParseWithMappings(context.Background(), path)
+	require.NoError(t, err)
+	require.Len(t, result.Messages, 1)
+
+	raw := result.Messages[0]
+	assert.True(t, raw.SrcIP.Equal(testSMFIP), "src ip %s", raw.SrcIP)
+	assert.True(t, raw.DstIP.Equal(testUPFIP), "dst ip %s", raw.DstIP)
+	assert.Equal(t, uint16(8805), raw.DstPort)
+
+	msg, err := message.Parse(raw.Data)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(message.MsgTypeHeartbeatRequest), msg.MessageType())
+}
+
+func TestParser_Ethernet(t *testing.T) {
+	path := writePcapFile(t, 1, ethernetFrame(t, heartbeatRequest(t, 1)))
+	assertSingleHeartbeat(t, path)
+}
+
+func TestParser_Pcapng(t *testing.T) {
+	path := writePcapngFile(t, 1, ethernetFrame(t, heartbeatRequest(t, 1)))
+	assertSingleHeartbeat(t, path)
+}
+
+func TestParser_LinuxSLL2(t *testing.T) {
+	sll2 := make([]byte, sll2HeaderLen)
+	binary.BigEndian.PutUint16(sll2[0:2], uint16(layers.EthernetTypeIPv4))
+	binary.BigEndian.PutUint32(sll2[4:8], 1)  // interface index
+	binary.BigEndian.PutUint16(sll2[8:10], 1) // ARPHRD_ETHER
+	sll2[10] = 4                              // PACKET_OUTGOING
+	sll2[11] = 6
+	copy(sll2[12:], []byte{0x02, 0, 0, 0, 0, 1})
+
+	frame := append(sll2, serialize(t, ipv4UDPLayers(heartbeatRequest(t, 1), 8805)...)...)
+	path := writePcapFile(t, 276, frame)
+	assertSingleHeartbeat(t, path)
+}
+
+func TestParser_RawIP(t *testing.T) {
+	frame := serialize(t, ipv4UDPLayers(heartbeatRequest(t, 1), 8805)...)
+	path := writePcapFile(t, 101, frame)
+	assertSingleHeartbeat(t, path)
+}
+
+func TestParser_CountMessages_RawIP(t *testing.T) {
+	frame := serialize(t, ipv4UDPLayers(heartbeatRequest(t, 1), 8805)...)
+	path := writePcapFile(t, 101, frame, frame)
+
+	counts, err := NewParser().CountMessages(context.Background(), path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, counts["HeartbeatRequest"])
+}
+
+func TestParser_CancelledContextStopsParsing(t *testing.T) {
+	frame := serialize(t, ipv4UDPLayers(heartbeatRequest(t, 1), 8805)...)
+	path := writePcapFile(t, 101, frame, frame)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := NewParser().ParseWithMappings(ctx, path)
+	require.ErrorIs(t, err, context.Canceled)
+
+	_, err = NewParser().CountMessages(ctx, path)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestParser_ResponsesOnly(t *testing.T) {
+	resp := message.NewHeartbeatResponse(1, ie.NewRecoveryTimeStamp(time.Unix(1700000000, 0)))
+	b := make([]byte, resp.MarshalLen())
+	require.NoError(t, resp.MarshalTo(b))
+	path := writePcapFile(t, 101, serialize(t, ipv4UDPLayers(b, 8805)...))
+
+	result, err := NewParser().ParseWithMappings(context.Background(), path)
+	require.NoError(t, err)
+	assert.Empty(t, result.Messages)
+	assert.Equal(t, 1, result.Counts.Responses)
+
+	err = result.Counts.NoRequestsError()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "only the UPF→SMF direction")
+	assert.Contains(t, err.Error(), "10.0.0.1:8805 -> 10.0.0.2:8805")
+}
+
+func TestParser_CustomPort(t *testing.T) {
+	frame := serialize(t, ipv4UDPLayers(heartbeatRequest(t, 1), 9805)...)
+	path := writePcapFile(t, 101, frame)
+
+	// Not matched on the default port
+	result, err := NewParser().ParseWithMappings(context.Background(), path)
+	require.NoError(t, err)
+	assert.Empty(t, result.Messages)
+
+	parser := NewParser()
+	parser.SetPorts([]uint16{8805, 9805})
+	result, err = parser.ParseWithMappings(context.Background(), path)
+	require.NoError(t, err)
+	assert.Len(t, result.Messages, 1)
+}
+
+func TestParser_BPFFilter(t *testing.T) {
+	const frags = " or (ip[6:2] & 0x3fff != 0) or (ip6 and ip6[6] == 44)"
+
+	parser := NewParser()
+	assert.Equal(t, "udp port 8805"+frags, parser.bpfFilter(layers.LinkTypeRaw))
+
+	parser.SetPorts([]uint16{8805, 9805})
+	assert.Equal(t, "udp port 8805 or udp port 9805"+frags, parser.bpfFilter(layers.LinkTypeRaw))
+
+	// Ethernet also matches VLAN and QinQ tagged frames
+	base := "udp port 8805 or udp port 9805" + frags
+	assert.Equal(t,
+		base+" or (vlan and ("+base+" or (vlan and ("+base+"))))",
+		parser.bpfFilter(layers.LinkTypeEthernet))
+}
+
+func TestParser_BPFFilterMatchesTaggedFrames(t *testing.T) {
+	// The parser falls back to reading unfiltered if the filter does not
+	// compile, so run the compiled filter on the frames directly
+	bpf, err := pcap.NewBPF(layers.LinkTypeEthernet, 65535, NewParser().bpfFilter(layers.LinkTypeEthernet))
+	require.NoError(t, err)
+
+	payload := heartbeatRequest(t, 1)
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	otherPort := serialize(t, append([]gopacket.SerializableLayer{eth}, ipv4UDPLayers(payload, 9999)...)...)
+
+	for _, tc := range []struct {
+		name  string
+		frame []byte
+		match bool
+	}{
+		{"untagged", ethernetFrame(t, payload), true},
+		{"VLAN", taggedFrame(t, payload, layers.EthernetTypeDot1Q, 100), true},
+		{"double 802.1Q", taggedFrame(t, payload, layers.EthernetTypeDot1Q, 100, 200), true},
+		{"QinQ", taggedFrame(t, payload, layers.EthernetTypeQinQ, 100, 200), true},
+		{"other port", otherPort, false},
+	} {
+		ci := gopacket.CaptureInfo{CaptureLength: len(tc.frame), Length: len(tc.frame)}
+		assert.Equal(t, tc.match, bpf.Matches(ci, tc.frame), tc.name)
+	}
+}
+
+func TestParser_VLANTagged(t *testing.T) {
+	path := writePcapFile(t, 1, taggedFrame(t, heartbeatRequest(t, 1), layers.EthernetTypeDot1Q, 100))
+	assertSingleHeartbeat(t, path)
+
+	result, err := NewParser().ParseWithMappings(context.Background(), path)
+	require.NoError(t, err)
+	assert.Equal(t, []uint16{100}, result.Messages[0].VLANIDs)
+}
+
+func TestParser_QinQ(t *testing.T) {
+	for _, outer := range []layers.EthernetType{layers.EthernetTypeQinQ, ethernetTypeQinQLegacy} {
+		path := writePcapFile(t, 1, taggedFrame(t, heartbeatRequest(t, 1), outer, 100, 200))
+
+		result, err := NewParser().ParseWithMappings(context.Background(), path)
+		require.NoError(t, err)
+		require.Len(t, result.Messages, 1, "outer tag type %#04x", uint16(outer))
+		assert.Equal(t, []uint16{100, 200}, result.Messages[0].VLANIDs)
+	}
+}
+
+func TestParser_UntaggedHasNoVLANs(t *testing.T) {
+	path := writePcapFile(t, 1, ethernetFrame(t, heartbeatRequest(t, 1)))
+
+	result, err := NewParser().ParseWithMappings(context.Background(), path)
+	require.NoError(t, err)
+	require.Len(t, result.Messages, 1)
+	assert.Nil(t, result.Messages[0].VLANIDs)
+}
+
+func TestParser_GTPUDecap(t *testing.T) {
+	outerIP := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.IPv4(192, 168, 0, 1),
+		DstIP:    net.IPv4(192, 168, 0, 2),
+	}
+	outerUDP := &layers.UDP{SrcPort: 2152, DstPort: 2152}
+	_ = outerUDP.SetNetworkLayerForChecksum(outerIP)
+	gtp := &layers.GTPv1U{Version: 1, ProtocolType: 1, MessageType: 255, TEID: 0x1234}
+
+	ls := []gopacket.SerializableLayer{outerIP, outerUDP, gtp}
+	frame := serialize(t, append(ls, ipv4UDPLayers(heartbeatRequest(t, 1), 8805)...)...)
+	path := writePcapFile(t, 101, frame)
+
+	// Without decapsulation the tunneled message is ignored
+	result, err := NewParser().ParseWithMappings(context.Background(), path)
+	require.NoError(t, err)
+	assert.Empty(t, result.Messages)
+
+	parser := NewParser()
+	parser.SetDecapGTPU(true)
+	result, err = parser.ParseWithMappings(context.Background(), path)
+	require.NoError(t, err)
+	require.Len(t, result.Messages, 1)
+	assert.True(t, result.Messages[0].SrcIP.Equal(testSMFIP))
+	assert.True(t, result.Messages[0].DstIP.Equal(testUPFIP))
+}
+
+func TestParser_Stream(t *testing.T) {
+	encode := func(msg message.Message) []byte {
+		b := make([]byte, msg.MarshalLen())
+		require.NoError(t, msg.MarshalTo(b))
+		return b
+	}
+	est := encode(message.NewSessionEstablishmentRequest(0, 0, 0, 1, 0,
+		ie.NewNodeID("10.0.0.1", "", ""),
+		ie.NewFSEID(0x10, net.ParseIP("10.0.0.1"), nil),
+	))
+	resp := encode(message.NewSessionEstablishmentResponse(0, 0, 0x10, 1, 0,
+		ie.NewCause(ie.CauseRequestAccepted),
+		ie.NewFSEID(0x20, net.ParseIP("10.0.0.2"), nil),
+	))
+	frame := func(payload []byte) []byte {
+		return serialize(t, ipv4UDPLayers(payload, 8805)...)
+	}
+	path := writePcapFile(t, 101, frame(est), frame(resp), frame(heartbeatRequest(t, 2)))
+
+	var mappings []types.SEIDMapping
+	parser := NewParser()
+	parser.SetSEIDMappingHandler(func(m types.SEIDMapping) { mappings = append(mappings, m) })
+
+	stream, err := parser.Stream(context.Background(), path)
+	require.NoError(t, err)
+
+	var streamed []types.RawPFCPMessage
+	for raw := range stream {
+		streamed = append(streamed, raw)
+	}
+
+	// Same requests, in the same order, as the slice-based API
+	result, err := NewParser().ParseWithMappings(context.Background(), path)
+	require.NoError(t, err)
+	assert.Equal(t, result.Messages, streamed)
+	require.Len(t, streamed, 2)
+	assert.Equal(t, heartbeatRequest(t, 2), streamed[1].Data)
+
+	assert.Equal(t, result.SEIDMappings, mappings)
+	assert.Equal(t, []types.SEIDMapping{{OriginalCPSEID: 0x10, OriginalRemoteSEID: 0x20}}, mappings)
+}
+
+func TestParser_StreamStopsOnCancel(t *testing.T) {
+	frames := make([][]byte, streamBufferSize+10)
+	for i := range frames {
+		frames[i] = serialize(t, ipv4UDPLayers(heartbeatRequest(t, uint32(i+1)), 8805)...)
+	}
+	path := writePcapFile(t, 101, frames...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := NewParser().Stream(ctx, path)
+	require.NoError(t, err)
+
+	<-stream
+	cancel()
+
+	// The channel is closed without the consumer draining every message
+	count := 0
+	for range stream {
+		count++
+	}
+	assert.Less(t, count, len(frames)-1)
+}
+
+func TestParser_MultipleFiles(t *testing.T) {
+	encode := func(msg message.Message) []byte {
+		b := make([]byte, msg.MarshalLen())
+		require.NoError(t, msg.MarshalTo(b))
+		return b
+	}
+	frame := func(payload []byte) []byte {
+		return serialize(t, ipv4UDPLayers(payload, 8805)...)
+	}
+
+	// A session split across two captures: established in the first, deleted
+	// in the second
+	est := encode(message.NewSessionEstablishmentRequest(0, 0, 0, 1, 0,
+		ie.NewNodeID("10.0.0.1", "", ""),
+		ie.NewFSEID(0x10, net.ParseIP("10.0.0.1"), nil),
+	))
+	resp := encode(message.NewSessionEstablishmentResponse(0, 0, 0x10, 1, 0,
+		ie.NewCause(ie.CauseRequestAccepted),
+		ie.NewFSEID(0x20, net.ParseIP("10.0.0.2"), nil),
+	))
+	del := encode(message.NewSessionDeletionRequest(0, 0, 0x20, 2, 0))
+	first := writePcapFile(t, 101, frame(est), frame(resp))
+	second := writePcapFile(t, 101, frame(del))
+
+	result, err := NewParser().ParseWithMappings(context.Background(), first, second)
+	require.NoError(t, err)
+	require.Len(t, result.Messages, 2)
+	assert.Equal(t, est, result.Messages[0].Data)
+	assert.Equal(t, del, result.Messages[1].Data)
+	assert.Equal(t, []types.SEIDMapping{{OriginalCPSEID: 0x10, OriginalRemoteSEID: 0x20}}, result.SEIDMappings)
+	assert.Equal(t, 3, result.Counts.Packets)
+	assert.Equal(t, 2, result.Counts.Requests)
+
+	stream, err := NewParser().Stream(context.Background(), first, second)
+	require.NoError(t, err)
+	var streamed []types.RawPFCPMessage
+	for raw := range stream {
+		streamed = append(streamed, raw)
+	}
+	assert.Equal(t, result.Messages, streamed)
+
+	counts, err := NewParser().CountMessages(context.Background(), first, second)
+	require.NoError(t, err)
+	assert.Equal(t, 1, counts["SessionDeletionRequest"])
+}
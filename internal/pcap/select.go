@@ -0,0 +1,63 @@
+package pcap
+
+import (
+	"github.com/wmnsk/go-pfcp/message"
+
+	pfcputil "pfcp-generator/internal/pfcp"
+	"pfcp-generator/pkg/types"
+)
+
+// SelectSessions keeps only some of the result's sessions, with their whole
+// lifecycle. A session is identified by the CP SEID in its Session
+// Establishment Request; its Modification and Deletion Requests are matched
+// through the SEID mappings, by the UP SEID in their header, or directly by
+// the header SEID when it has no mapping, since some captures carry the CP
+// SEID there (the same rule as the session manager's lookup). If cpSEIDs is not
+// empty only those sessions are kept, and if maxSessions > 0 at most the first
+// maxSessions of them in pcap order. Requests that do not belong to a session,
+// such as Association Setup, Heartbeat and PFD Management, are always kept;
+// session requests that cannot be matched to a kept session are dropped. It
+// returns the number of sessions kept.
+func (r *ParseResult) SelectSessions(maxSessions int, cpSEIDs []uint64) int {
+	wanted := make(map[uint64]bool, len(cpSEIDs))
+	for _, seid := range cpSEIDs {
+		wanted[seid] = true
+	}
+	cpSEIDByRemote := make(map[uint64]uint64, len(r.SEIDMappings))
+	for _, mapping := range r.SEIDMappings {
+		cpSEIDByRemote[mapping.OriginalRemoteSEID] = mapping.OriginalCPSEID
+	}
+
+	selected := make(map[uint64]bool)
+	var messages []types.RawPFCPMessage
+	for _, raw := range r.Messages {
+		msg, err := pfcputil.Decode(raw.Data)
+		if err != nil || !isSessionRequest(msg) {
+			messages = append(messages, raw)
+			continue
+		}
+
+		var cpSEID uint64
+		if req, ok := msg.(*message.SessionEstablishmentRequest); ok {
+			if cpSEID, err = pfcputil.ExtractCPSEID(req); err != nil {
+				continue
+			}
+			full := maxSessions > 0 && len(selected) >= maxSessions
+			if !selected[cpSEID] && !full && (len(wanted) == 0 || wanted[cpSEID]) {
+				selected[cpSEID] = true
+			}
+		} else {
+			headerSEID := pfcputil.ExtractHeaderSEID(msg)
+			var mapped bool
+			if cpSEID, mapped = cpSEIDByRemote[headerSEID]; !mapped {
+				cpSEID = headerSEID
+			}
+		}
+		if cpSEID != 0 && selected[cpSEID] {
+			messages = append(messages, raw)
+		}
+	}
+
+	r.Messages = messages
+	return len(selected)
+}
@@ -0,0 +1,94 @@
+package pcap
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+
+	pfcputil "pfcp-generator/internal/pfcp"
+	"pfcp-generator/pkg/types"
+)
+
+func TestParseResult_SelectSessions(t *testing.T) {
+	encode := func(msg message.Message) types.RawPFCPMessage {
+		data, err := pfcputil.Encode(msg)
+		require.NoError(t, err)
+		return types.RawPFCPMessage{Data: data}
+	}
+	establish := func(cpSEID uint64, seq uint32) types.RawPFCPMessage {
+		return encode(message.NewSessionEstablishmentRequest(0, 0, 0, seq, 0,
+			ie.NewNodeID("10.0.0.1", "", ""),
+			ie.NewFSEID(cpSEID, net.ParseIP("10.0.0.1"), nil),
+		))
+	}
+	// Three interleaved sessions, CP SEIDs 0x10, 0x11 and 0x12 (UP SEIDs 0x2x)
+	newResult := func() *ParseResult {
+		return &ParseResult{
+			Messages: []types.RawPFCPMessage{
+				encode(message.NewAssociationSetupRequest(1, ie.NewNodeID("10.0.0.1", "", ""))),
+				establish(0x10, 2),
+				establish(0x11, 3),
+				encode(message.NewSessionModificationRequest(0, 0, 0x20, 4, 0)),
+				establish(0x12, 5),
+				encode(message.NewHeartbeatRequest(6, ie.NewRecoveryTimeStamp(time.Unix(0, 0)), nil)),
+				encode(message.NewSessionDeletionRequest(0, 0, 0x21, 7, 0)),
+				encode(message.NewSessionDeletionRequest(0, 0, 0x20, 8, 0)),
+				encode(message.NewSessionDeletionRequest(0, 0, 0x22, 9, 0)),
+			},
+			SEIDMappings: []types.SEIDMapping{
+				{OriginalCPSEID: 0x10, OriginalRemoteSEID: 0x20},
+				{OriginalCPSEID: 0x11, OriginalRemoteSEID: 0x21},
+				{OriginalCPSEID: 0x12, OriginalRemoteSEID: 0x22},
+			},
+		}
+	}
+	seqs := func(r *ParseResult) []uint32 {
+		var s []uint32
+		for _, raw := range r.Messages {
+			msg, err := pfcputil.Decode(raw.Data)
+			require.NoError(t, err)
+			s = append(s, msg.Sequence())
+		}
+		return s
+	}
+
+	// The first session, with its modification and deletion, plus the
+	// non-session requests
+	result := newResult()
+	assert.Equal(t, 1, result.SelectSessions(1, nil))
+	assert.Equal(t, []uint32{1, 2, 4, 6, 8}, seqs(result))
+
+	// Picked by CP SEID
+	result = newResult()
+	assert.Equal(t, 2, result.SelectSessions(0, []uint64{0x11, 0x12}))
+	assert.Equal(t, []uint32{1, 3, 5, 6, 7, 9}, seqs(result))
+
+	// Both: the first of the picked sessions
+	result = newResult()
+	assert.Equal(t, 1, result.SelectSessions(1, []uint64{0x12, 0x11}))
+	assert.Equal(t, []uint32{1, 3, 6, 7}, seqs(result))
+
+	// A capture whose Modification and Deletion Requests carry the CP SEID
+	// in their header
+	result = &ParseResult{
+		Messages: []types.RawPFCPMessage{
+			establish(0x10, 1),
+			establish(0x11, 2),
+			encode(message.NewSessionModificationRequest(0, 0, 0x11, 3, 0)),
+			encode(message.NewSessionModificationRequest(0, 0, 0x10, 4, 0)),
+			encode(message.NewSessionDeletionRequest(0, 0, 0x10, 5, 0)),
+			encode(message.NewSessionDeletionRequest(0, 0, 0x11, 6, 0)),
+		},
+		SEIDMappings: []types.SEIDMapping{
+			{OriginalCPSEID: 0x10, OriginalRemoteSEID: 0x20},
+			{OriginalCPSEID: 0x11, OriginalRemoteSEID: 0x21},
+		},
+	}
+	assert.Equal(t, 1, result.SelectSessions(1, nil))
+	assert.Equal(t, []uint32{1, 4, 5}, seqs(result))
+}
@@ -0,0 +1,72 @@
+package pcap
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/pcapgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// pcapngMagic is the block type of a pcapng Section Header Block, the first
+// four bytes of every pcapng file in either byte order.
+var pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+
+// packetReader reads packets from a classic pcap or a pcapng file.
+type packetReader interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+	Close()
+}
+
+// ngFile reads a pcapng file with the pure-Go reader.
+type ngFile struct {
+	*pcapgo.NgReader
+	file *os.File
+}
+
+func (f *ngFile) Close() {
+	f.file.Close()
+}
+
+// isPcapng reports whether filename starts with the pcapng magic.
+func isPcapng(filename string) (bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(pcapngMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false, nil // Too short for either format; let libpcap report it
+	}
+	return bytes.Equal(magic, pcapngMagic), nil
+}
+
+// openPcapng opens a pcapng file. Unlike libpcap handles no BPF filter is
+// applied, so isPFCP does the port matching.
+func openPcapng(filename string) (*ngFile, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pcap file %s: %w", filename, err)
+	}
+	r, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read pcapng file %s: %w", filename, err)
+	}
+	log.WithField("file", filename).Debug("Reading pcapng file")
+	return &ngFile{NgReader: r, file: f}, nil
+}
+
+// Ensure both readers satisfy packetReader.
+var (
+	_ packetReader = (*pcap.Handle)(nil)
+	_ packetReader = (*ngFile)(nil)
+)
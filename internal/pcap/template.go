@@ -0,0 +1,93 @@
+package pcap
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/wmnsk/go-pfcp/message"
+
+	"pfcp-generator/pkg/types"
+)
+
+// AssociationSetupRequest returns the first Association Setup Request in a
+// pcap, such as a template capture of the association alone. The pcap is only
+// read up to that message.
+func (p *Parser) AssociationSetupRequest(ctx context.Context, filename string) (types.RawPFCPMessage, error) {
+	handle, err := p.open(filename)
+	if err != nil {
+		return types.RawPFCPMessage{}, err
+	}
+	defer handle.Close()
+
+	var found *types.RawPFCPMessage
+	p.scan(ctx, handle,
+		func(raw types.RawPFCPMessage) bool {
+			if isAssociationSetup(raw) {
+				found = &raw
+				return false
+			}
+			return true
+		},
+		func(types.SEIDMapping) {},
+	)
+	if err := ctx.Err(); err != nil {
+		return types.RawPFCPMessage{}, err
+	}
+	if found == nil {
+		return types.RawPFCPMessage{}, fmt.Errorf("no Association Setup Request found in %s", filename)
+	}
+	return *found, nil
+}
+
+// PrependAssociation inserts assoc before the first message unless the result
+// already has an Association Setup Request. It reports whether it did.
+func (r *ParseResult) PrependAssociation(assoc types.RawPFCPMessage) bool {
+	for _, raw := range r.Messages {
+		if isAssociationSetup(raw) {
+			return false
+		}
+	}
+	r.Messages = append([]types.RawPFCPMessage{assoc}, r.Messages...)
+	return true
+}
+
+// PrependAssociationToStream returns a stream that emits assoc and then the
+// messages of stream, unless the first message of stream is an Association
+// Setup Request itself: a streamed pcap cannot be searched ahead, so an
+// association later in it is not detected. The returned channel is closed
+// when stream is, or when ctx is cancelled.
+func PrependAssociationToStream(ctx context.Context, stream <-chan types.RawPFCPMessage, assoc types.RawPFCPMessage) <-chan types.RawPFCPMessage {
+	out := make(chan types.RawPFCPMessage, streamBufferSize)
+	go func() {
+		defer close(out)
+		first := true
+		for raw := range stream {
+			if first && !isAssociationSetup(raw) {
+				if !sendOrDone(ctx, out, assoc) {
+					return
+				}
+			}
+			first = false
+			if !sendOrDone(ctx, out, raw) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// sendOrDone writes raw to out, giving up if ctx is cancelled.
+func sendOrDone(ctx context.Context, out chan<- types.RawPFCPMessage, raw types.RawPFCPMessage) bool {
+	select {
+	case out <- raw:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// isAssociationSetup reports whether raw is an Association Setup Request,
+// going by the message type octet.
+func isAssociationSetup(raw types.RawPFCPMessage) bool {
+	return len(raw.Data) > 1 && raw.Data[1] == message.MsgTypeAssociationSetupRequest
+}
@@ -0,0 +1,75 @@
+package pcap
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+
+	"pfcp-generator/pkg/types"
+)
+
+// associationSetupRequest returns an encoded PFCP Association Setup Request.
+func associationSetupRequest(t *testing.T, seq uint32) []byte {
+	t.Helper()
+	msg := message.NewAssociationSetupRequest(seq,
+		ie.NewNodeID("10.0.0.1", "", ""),
+		ie.NewRecoveryTimeStamp(time.Unix(1700000000, 0)),
+	)
+	b := make([]byte, msg.MarshalLen())
+	require.NoError(t, msg.MarshalTo(b))
+	return b
+}
+
+func TestParser_AssociationSetupRequest(t *testing.T) {
+	path := writePcapFile(t, 101,
+		serialize(t, ipv4UDPLayers(heartbeatRequest(t, 1), 8805)...),
+		serialize(t, ipv4UDPLayers(associationSetupRequest(t, 2), 8805)...),
+	)
+	raw, err := NewParser().AssociationSetupRequest(context.Background(), path)
+	require.NoError(t, err)
+	assert.Equal(t, associationSetupRequest(t, 2), raw.Data)
+
+	path = writePcapFile(t, 101, serialize(t, ipv4UDPLayers(heartbeatRequest(t, 1), 8805)...))
+	_, err = NewParser().AssociationSetupRequest(context.Background(), path)
+	assert.ErrorContains(t, err, "no Association Setup Request found")
+}
+
+func TestParseResult_PrependAssociation(t *testing.T) {
+	assoc := types.RawPFCPMessage{Data: associationSetupRequest(t, 1)}
+	heartbeat := types.RawPFCPMessage{Data: heartbeatRequest(t, 2)}
+
+	result := &ParseResult{Messages: []types.RawPFCPMessage{heartbeat}}
+	assert.True(t, result.PrependAssociation(assoc))
+	assert.Equal(t, []types.RawPFCPMessage{assoc, heartbeat}, result.Messages)
+
+	// A pcap with its own association is left alone
+	assert.False(t, result.PrependAssociation(types.RawPFCPMessage{Data: associationSetupRequest(t, 9)}))
+	assert.Len(t, result.Messages, 2)
+}
+
+func TestPrependAssociationToStream(t *testing.T) {
+	assoc := types.RawPFCPMessage{Data: associationSetupRequest(t, 1)}
+	heartbeat := types.RawPFCPMessage{Data: heartbeatRequest(t, 2)}
+	collect := func(messages ...types.RawPFCPMessage) []types.RawPFCPMessage {
+		in := make(chan types.RawPFCPMessage, len(messages))
+		for _, raw := range messages {
+			in <- raw
+		}
+		close(in)
+		var out []types.RawPFCPMessage
+		for raw := range PrependAssociationToStream(context.Background(), in, assoc) {
+			out = append(out, raw)
+		}
+		return out
+	}
+
+	assert.Equal(t, []types.RawPFCPMessage{assoc, heartbeat, heartbeat}, collect(heartbeat, heartbeat))
+
+	own := types.RawPFCPMessage{Data: associationSetupRequest(t, 5)}
+	assert.Equal(t, []types.RawPFCPMessage{own, heartbeat}, collect(own, heartbeat))
+}
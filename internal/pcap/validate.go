@@ -0,0 +1,183 @@
+package pcap
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+
+	pfcputil "pfcp-generator/internal/pfcp"
+)
+
+// MessageCheck is the validation result of one request.
+type MessageCheck struct {
+	Index    int    // Position among the requests, from 1
+	Type     string // Message type name, or the raw type for undecodable requests
+	SEID     uint64 // CP SEID of an establishment, header SEID of other session requests
+	Problems []string
+}
+
+// ValidationReport is the result of ParseResult.Validate.
+type ValidationReport struct {
+	Checks    []MessageCheck
+	Failed    int // Requests with at least one problem
+	Malformed int // PFCP packets that did not decode, and so are not in Checks
+	Sessions  int // Session Establishment Requests
+}
+
+// OK reports whether the pcap has no problems.
+func (r *ValidationReport) OK() bool {
+	return r.Failed == 0 && r.Malformed == 0
+}
+
+// Validate checks that the requests are well-formed and replayable: that each
+// decodes cleanly, carries the IEs the replay relies on (a Session
+// Establishment Request needs a Node ID, a CP F-SEID, and at least one Create
+// PDR and Create FAR, each with its ID), and that every Session Modification
+// and Deletion Request is addressed to a session established earlier in the
+// pcap and not yet deleted. Requests are matched to sessions through the SEID
+// mappings, as during the replay.
+func (r *ParseResult) Validate() *ValidationReport {
+	cpSEIDByRemote := make(map[uint64]uint64, len(r.SEIDMappings))
+	for _, mapping := range r.SEIDMappings {
+		cpSEIDByRemote[mapping.OriginalRemoteSEID] = mapping.OriginalCPSEID
+	}
+
+	report := &ValidationReport{Malformed: r.Counts.Malformed}
+	state := make(map[uint64]string) // "established" or "deleted", by CP SEID
+	for i, raw := range r.Messages {
+		check := MessageCheck{Index: i + 1}
+		msg, err := pfcputil.Decode(raw.Data)
+		if err != nil {
+			if len(raw.Data) > 1 {
+				check.Type = pfcputil.MessageTypeName(raw.Data[1])
+			}
+			check.Problems = append(check.Problems, fmt.Sprintf("does not decode: %v", err))
+		} else {
+			check.Type = pfcputil.MessageTypeName(msg.MessageType())
+			check.SEID, check.Problems = validateMessage(msg, cpSEIDByRemote, state)
+			if msg.MessageType() == message.MsgTypeSessionEstablishmentRequest {
+				report.Sessions++
+			}
+		}
+		if len(check.Problems) > 0 {
+			report.Failed++
+		}
+		report.Checks = append(report.Checks, check)
+	}
+	return report
+}
+
+// validateMessage checks a decoded request and tracks the state of its
+// session. It returns the SEID to report and the problems found.
+func validateMessage(msg message.Message, cpSEIDByRemote map[uint64]uint64, state map[uint64]string) (uint64, []string) {
+	var problems []string
+	switch msg := msg.(type) {
+	case *message.AssociationSetupRequest:
+		problems = missing(problems, "Node ID", msg.NodeID)
+		problems = missing(problems, "Recovery Time Stamp", msg.RecoveryTimeStamp)
+		return 0, problems
+
+	case *message.HeartbeatRequest:
+		return 0, missing(problems, "Recovery Time Stamp", msg.RecoveryTimeStamp)
+
+	case *message.SessionEstablishmentRequest:
+		problems = missing(problems, "Node ID", msg.NodeID)
+		cpSEID, err := pfcputil.ExtractCPSEID(msg)
+		if err != nil {
+			problems = append(problems, "no CP F-SEID")
+		}
+		if len(msg.CreatePDR) == 0 {
+			problems = append(problems, "no Create PDR")
+		}
+		if len(msg.CreateFAR) == 0 {
+			problems = append(problems, "no Create FAR")
+		}
+		problems = missingChildren(problems, "Create PDR", msg.CreatePDR, ie.PDRID)
+		problems = missingChildren(problems, "Create FAR", msg.CreateFAR, ie.FARID)
+		if err == nil {
+			if state[cpSEID] == "established" {
+				problems = append(problems, fmt.Sprintf("CP SEID 0x%x is already established", cpSEID))
+			}
+			state[cpSEID] = "established"
+		}
+		return cpSEID, problems
+
+	case *message.SessionModificationRequest, *message.SessionDeletionRequest:
+		seid := pfcputil.ExtractHeaderSEID(msg)
+		cpSEID, mapped := cpSEIDByRemote[seid]
+		switch {
+		case seid == 0:
+			problems = append(problems, "no SEID in header")
+		case !mapped:
+			problems = append(problems, "SEID matches no Session Establishment Response in the pcap")
+		case state[cpSEID] == "":
+			problems = append(problems, fmt.Sprintf("session (CP SEID 0x%x) is not established earlier in the pcap", cpSEID))
+		case state[cpSEID] == "deleted":
+			problems = append(problems, fmt.Sprintf("session (CP SEID 0x%x) is already deleted", cpSEID))
+		case msg.MessageType() == message.MsgTypeSessionDeletionRequest:
+			state[cpSEID] = "deleted"
+		}
+		return seid, problems
+	}
+	return 0, nil
+}
+
+// missing appends a problem if the IE is absent.
+func missing(problems []string, name string, i *ie.IE) []string {
+	if i == nil {
+		return append(problems, "no "+name)
+	}
+	return problems
+}
+
+// missingChildren appends a problem for each grouped IE without a child of
+// type idType.
+func missingChildren(problems []string, name string, grouped []*ie.IE, idType uint16) []string {
+	for n, g := range grouped {
+		found := false
+		for _, child := range g.ChildIEs {
+			if child.Type == idType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s #%d has no %s", name, n+1, pfcputil.IETypeName(idType)))
+		}
+	}
+	return problems
+}
+
+// Print writes the report as a table of requests, one line per problem,
+// followed by a pass/fail summary. With verbose, requests without problems are
+// listed too.
+func (r *ValidationReport) Print(w io.Writer, verbose bool) {
+	fmt.Fprintf(w, "%-6s %-32s %-18s %s\n", "#", "TYPE", "SEID", "RESULT")
+	for _, check := range r.Checks {
+		if len(check.Problems) == 0 && !verbose {
+			continue
+		}
+		seid := "-"
+		if check.SEID != 0 {
+			seid = fmt.Sprintf("0x%x", check.SEID)
+		}
+		result := "ok"
+		if len(check.Problems) > 0 {
+			result = strings.Join(check.Problems, "; ")
+		}
+		fmt.Fprintf(w, "%-6d %-32s %-18s %s\n", check.Index, check.Type, seid, result)
+	}
+
+	fmt.Fprintf(w, "\n%d requests, %d sessions: %d with problems\n", len(r.Checks), r.Sessions, r.Failed)
+	if r.Malformed > 0 {
+		fmt.Fprintf(w, "%d PFCP packets do not decode and would be skipped\n", r.Malformed)
+	}
+	if r.OK() {
+		fmt.Fprintln(w, "PASS")
+	} else {
+		fmt.Fprintln(w, "FAIL")
+	}
+}
@@ -0,0 +1,86 @@
+package pcap
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+
+	pfcputil "pfcp-generator/internal/pfcp"
+	"pfcp-generator/pkg/types"
+)
+
+func TestParseResult_Validate(t *testing.T) {
+	encode := func(msg message.Message) types.RawPFCPMessage {
+		data, err := pfcputil.Encode(msg)
+		require.NoError(t, err)
+		return types.RawPFCPMessage{Data: data}
+	}
+	nodeID := ie.NewNodeID("10.0.0.1", "", "")
+	establish := func(cpSEID uint64, seq uint32, ies ...*ie.IE) types.RawPFCPMessage {
+		ies = append([]*ie.IE{nodeID, ie.NewFSEID(cpSEID, net.ParseIP("10.0.0.1"), nil)}, ies...)
+		return encode(message.NewSessionEstablishmentRequest(0, 0, 0, seq, 0, ies...))
+	}
+	pdr := ie.NewCreatePDR(ie.NewPDRID(1), ie.NewPrecedence(100), ie.NewFARID(1))
+	far := ie.NewCreateFAR(ie.NewFARID(1), ie.NewApplyAction(0x02))
+
+	result := &ParseResult{
+		Messages: []types.RawPFCPMessage{
+			encode(message.NewAssociationSetupRequest(1, nodeID, ie.NewRecoveryTimeStamp(time.Unix(0, 0)))),
+			establish(0x10, 2, pdr, far),
+			encode(message.NewSessionModificationRequest(0, 0, 0x20, 3, 0)),
+			establish(0x11, 4, ie.NewCreatePDR(ie.NewPrecedence(100)), far),
+			encode(message.NewSessionDeletionRequest(0, 0, 0x20, 5, 0)),
+			encode(message.NewSessionDeletionRequest(0, 0, 0x20, 6, 0)),
+			encode(message.NewSessionModificationRequest(0, 0, 0x99, 7, 0)),
+			{Data: []byte{0x21, 0x34, 0x00, 0x20}},
+		},
+		SEIDMappings: []types.SEIDMapping{
+			{OriginalCPSEID: 0x10, OriginalRemoteSEID: 0x20},
+			{OriginalCPSEID: 0x11, OriginalRemoteSEID: 0x21},
+		},
+		Counts: ScanCounts{Malformed: 1},
+	}
+
+	report := result.Validate()
+	require.Len(t, report.Checks, 8)
+	assert.Equal(t, 2, report.Sessions)
+	assert.Equal(t, 1, report.Malformed)
+	assert.False(t, report.OK())
+
+	problems := make([][]string, len(report.Checks))
+	for i, check := range report.Checks {
+		problems[i] = check.Problems
+	}
+	assert.Empty(t, problems[0])
+	assert.Empty(t, problems[1])
+	assert.Empty(t, problems[2])
+	assert.Equal(t, []string{"Create PDR #1 has no PDRID"}, problems[3])
+	assert.Empty(t, problems[4])
+	assert.Equal(t, []string{"session (CP SEID 0x10) is already deleted"}, problems[5])
+	assert.Equal(t, []string{"SEID matches no Session Establishment Response in the pcap"}, problems[6])
+	require.Len(t, problems[7], 1)
+	assert.Contains(t, problems[7][0], "does not decode")
+	assert.Equal(t, "SessionModificationRequest", report.Checks[7].Type)
+	assert.Equal(t, 4, report.Failed)
+
+	var out bytes.Buffer
+	report.Print(&out, false)
+	assert.Contains(t, out.String(), "8 requests, 2 sessions: 4 with problems")
+	assert.Contains(t, out.String(), "FAIL")
+	assert.NotContains(t, out.String(), "AssociationSetupRequest")
+}
+
+func TestParseResult_ValidateEstablishmentIEs(t *testing.T) {
+	data, err := pfcputil.Encode(message.NewSessionEstablishmentRequest(0, 0, 0, 1, 0))
+	require.NoError(t, err)
+
+	report := (&ParseResult{Messages: []types.RawPFCPMessage{{Data: data}}}).Validate()
+	require.Len(t, report.Checks, 1)
+	assert.Equal(t, []string{"no Node ID", "no CP F-SEID", "no Create PDR", "no Create FAR"}, report.Checks[0].Problems)
+}
@@ -0,0 +1,132 @@
+package pcap
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// Synthetic MAC addresses used for the Ethernet header of written frames.
+var (
+	smfMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	upfMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+)
+
+// Writer records PFCP payloads into a pcap file, wrapping each one in a
+// synthetic Ethernet/IP/UDP frame. It is safe for concurrent use.
+type Writer struct {
+	file   *os.File
+	writer *pcapgo.Writer
+	smfIP  net.IP
+	closed bool
+	mu     sync.Mutex
+}
+
+// NewWriter creates a pcap file for writing. smfIP is used to pick the
+// direction-specific MAC addresses for each frame.
+func NewWriter(filename string, smfIP net.IP) (*Writer, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pcap file %s: %w", filename, err)
+	}
+
+	w := pcapgo.NewWriter(f)
+	if err := w.WriteFileHeader(65535, layers.LinkTypeEthernet); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write pcap header: %w", err)
+	}
+
+	return &Writer{
+		file:   f,
+		writer: w,
+		smfIP:  smfIP,
+	}, nil
+}
+
+// WritePacket writes a single PFCP payload sent from src to dst at the given time.
+// A wildcard source or destination IP (from a bind-any socket) is replaced by the SMF IP.
+func (w *Writer) WritePacket(src, dst *net.UDPAddr, payload []byte, ts time.Time) error {
+	src, dst = w.resolveWildcard(src), w.resolveWildcard(dst)
+
+	srcMAC, dstMAC := smfMAC, upfMAC
+	if w.smfIP != nil && !src.IP.Equal(w.smfIP) {
+		srcMAC, dstMAC = upfMAC, smfMAC
+	}
+
+	eth := &layers.Ethernet{
+		SrcMAC: srcMAC,
+		DstMAC: dstMAC,
+	}
+	udp := &layers.UDP{
+		SrcPort: layers.UDPPort(src.Port),
+		DstPort: layers.UDPPort(dst.Port),
+	}
+
+	var ip gopacket.SerializableLayer
+	if src.IP.To4() != nil && dst.IP.To4() != nil {
+		eth.EthernetType = layers.EthernetTypeIPv4
+		ipv4 := &layers.IPv4{
+			Version:  4,
+			TTL:      64,
+			Protocol: layers.IPProtocolUDP,
+			SrcIP:    src.IP.To4(),
+			DstIP:    dst.IP.To4(),
+		}
+		udp.SetNetworkLayerForChecksum(ipv4)
+		ip = ipv4
+	} else {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		ipv6 := &layers.IPv6{
+			Version:    6,
+			HopLimit:   64,
+			NextHeader: layers.IPProtocolUDP,
+			SrcIP:      src.IP.To16(),
+			DstIP:      dst.IP.To16(),
+		}
+		udp.SetNetworkLayerForChecksum(ipv6)
+		ip = ipv6
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, udp, gopacket.Payload(payload)); err != nil {
+		return fmt.Errorf("failed to serialize packet: %w", err)
+	}
+
+	ci := gopacket.CaptureInfo{
+		Timestamp:     ts,
+		CaptureLength: len(buf.Bytes()),
+		Length:        len(buf.Bytes()),
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil // Late packets from the receiver during shutdown
+	}
+	if err := w.writer.WritePacket(ci, buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write packet: %w", err)
+	}
+	return nil
+}
+
+func (w *Writer) resolveWildcard(addr *net.UDPAddr) *net.UDPAddr {
+	if w.smfIP == nil || (addr.IP != nil && !addr.IP.IsUnspecified()) {
+		return addr
+	}
+	return &net.UDPAddr{IP: w.smfIP, Port: addr.Port}
+}
+
+// Close flushes and closes the underlying pcap file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	return w.file.Close()
+}
@@ -0,0 +1,140 @@
+package pcap
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readWritten returns the packets of a pcap file produced by Writer.
+func readWritten(t *testing.T, path string) []gopacket.Packet {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	r, err := pcapgo.NewReader(f)
+	require.NoError(t, err)
+	assert.Equal(t, layers.LinkTypeEthernet, r.LinkType())
+
+	var packets []gopacket.Packet
+	for {
+		data, ci, err := r.ReadPacketData()
+		if err != nil {
+			break
+		}
+		p := gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default)
+		p.Metadata().CaptureInfo = ci
+		packets = append(packets, p)
+	}
+	return packets
+}
+
+func TestWriter_SentAndReceived(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.pcap")
+	smfIP := net.ParseIP("10.0.0.1")
+	w, err := NewWriter(path, smfIP)
+	require.NoError(t, err)
+
+	sentAt := time.Unix(1700000000, 123000000).UTC()
+	recvAt := sentAt.Add(5 * time.Millisecond)
+
+	// The SMF socket is bound to any address, so the sent packet's source
+	// and the received packet's destination are wildcards
+	local := &net.UDPAddr{IP: net.IPv4zero, Port: 8805}
+	upf := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 8806}
+	require.NoError(t, w.WritePacket(local, upf, []byte{0x20, 0x01}, sentAt))
+	require.NoError(t, w.WritePacket(upf, local, []byte{0x20, 0x02}, recvAt))
+	require.NoError(t, w.Close())
+
+	// Late packets after Close are dropped
+	require.NoError(t, w.WritePacket(upf, local, []byte{0x20, 0x02}, recvAt))
+
+	packets := readWritten(t, path)
+	require.Len(t, packets, 2)
+
+	tests := []struct {
+		srcIP, dstIP     string
+		srcPort, dstPort layers.UDPPort
+		srcMAC, dstMAC   net.HardwareAddr
+		ts               time.Time
+		payload          []byte
+	}{
+		{"10.0.0.1", "10.0.0.2", 8805, 8806, smfMAC, upfMAC, sentAt, []byte{0x20, 0x01}},
+		{"10.0.0.2", "10.0.0.1", 8806, 8805, upfMAC, smfMAC, recvAt, []byte{0x20, 0x02}},
+	}
+	for i, tt := range tests {
+		p := packets[i]
+
+		eth, ok := p.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+		require.True(t, ok, "packet %d has no Ethernet layer", i)
+		assert.Equal(t, tt.srcMAC, eth.SrcMAC, "packet %d", i)
+		assert.Equal(t, tt.dstMAC, eth.DstMAC, "packet %d", i)
+
+		ip, ok := p.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+		require.True(t, ok, "packet %d has no IPv4 layer", i)
+		assert.Equal(t, tt.srcIP, ip.SrcIP.String(), "packet %d", i)
+		assert.Equal(t, tt.dstIP, ip.DstIP.String(), "packet %d", i)
+
+		udp, ok := p.Layer(layers.LayerTypeUDP).(*layers.UDP)
+		require.True(t, ok, "packet %d has no UDP layer", i)
+		assert.Equal(t, tt.srcPort, udp.SrcPort, "packet %d", i)
+		assert.Equal(t, tt.dstPort, udp.DstPort, "packet %d", i)
+		assert.Equal(t, tt.payload, []byte(udp.Payload), "packet %d", i)
+
+		assert.True(t, tt.ts.Equal(p.Metadata().Timestamp), "packet %d: got %v, want %v", i, p.Metadata().Timestamp, tt.ts)
+	}
+}
+
+func TestWriter_IPv6(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.pcap")
+	smfIP := net.ParseIP("2001:db8::1")
+	w, err := NewWriter(path, smfIP)
+	require.NoError(t, err)
+
+	local := &net.UDPAddr{IP: net.IPv6unspecified, Port: 8805}
+	upf := &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 8805}
+	require.NoError(t, w.WritePacket(upf, local, []byte{0x20, 0x02}, time.Now()))
+	require.NoError(t, w.Close())
+
+	packets := readWritten(t, path)
+	require.Len(t, packets, 1)
+
+	eth, ok := packets[0].Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	require.True(t, ok)
+	assert.Equal(t, upfMAC, eth.SrcMAC)
+	assert.Equal(t, smfMAC, eth.DstMAC)
+
+	ip, ok := packets[0].Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+	require.True(t, ok)
+	assert.Equal(t, "2001:db8::2", ip.SrcIP.String())
+	assert.Equal(t, "2001:db8::1", ip.DstIP.String())
+}
+
+func TestWriter_ResolveWildcard(t *testing.T) {
+	w := &Writer{smfIP: net.ParseIP("10.0.0.1")}
+
+	resolved := w.resolveWildcard(&net.UDPAddr{IP: net.IPv4zero, Port: 8805})
+	assert.Equal(t, "10.0.0.1", resolved.IP.String())
+	assert.Equal(t, 8805, resolved.Port)
+
+	resolved = w.resolveWildcard(&net.UDPAddr{Port: 8805})
+	assert.Equal(t, "10.0.0.1", resolved.IP.String())
+
+	// Concrete addresses are kept
+	upf := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 8805}
+	assert.Same(t, upf, w.resolveWildcard(upf))
+
+	// Without an SMF IP there is nothing to resolve to
+	none := &Writer{}
+	wildcard := &net.UDPAddr{IP: net.IPv4zero, Port: 8805}
+	assert.Same(t, wildcard, none.resolveWildcard(wildcard))
+}
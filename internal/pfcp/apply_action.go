@@ -0,0 +1,33 @@
+package pfcp
+
+import (
+	"fmt"
+
+	"github.com/wmnsk/go-pfcp/ie"
+)
+
+// applyActionNames lists the Apply Action flags (TS 29.244 8.2.26) by octet,
+// starting at octet 5, and by bit, starting at bit 1.
+var applyActionNames = [][8]string{
+	{"DROP", "FORW", "BUFF", "NOCP", "DUPL", "IPMA", "IPMD", "DFRT"},
+	{"EDRT", "BDPN", "DDPN", "FSSM", "MBSU"},
+}
+
+// ParseApplyAction parses an Apply Action setting: either a numeric bitmask
+// (e.g. "0x01", octet 5 in the low byte) or a comma-separated list of flag
+// names (e.g. "BUFF,NOCP"). The flags must form a legal combination: exactly
+// one of DROP, FORW, BUFF, IPMA and IPMD, with NOCP and BDPN only alongside
+// BUFF. It returns the IE value, without octet 6 when no flag in it is set.
+func ParseApplyAction(s string) ([]byte, error) {
+	flags, err := parseFlags(s, applyActionNames, "apply action flag")
+	if err != nil {
+		return nil, err
+	}
+	if flags[1] == 0 {
+		flags = flags[:1]
+	}
+	if err := ie.NewApplyAction(flags...).ValidateApplyAction(); err != nil {
+		return nil, fmt.Errorf("illegal apply action %q: set exactly one of DROP, FORW, BUFF, IPMA and IPMD, and other flags only with the action they qualify", s)
+	}
+	return flags, nil
+}
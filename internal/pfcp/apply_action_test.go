@@ -0,0 +1,30 @@
+package pfcp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseApplyAction(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []byte
+	}{
+		{"DROP", []byte{0x01}},
+		{"0x02", []byte{0x02}},
+		{"buff, nocp", []byte{0x0c}},
+		{"BUFF,BDPN", []byte{0x04, 0x02}},
+	}
+	for _, tt := range tests {
+		got, err := ParseApplyAction(tt.in)
+		require.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+
+	for _, in := range []string{"DROP,FORW", "NOCP", "FORW,NOCP", "0", "FOO"} {
+		_, err := ParseApplyAction(in)
+		assert.Error(t, err, in)
+	}
+}
@@ -0,0 +1,154 @@
+package pfcp
+
+import (
+	"fmt"
+
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+)
+
+// CauseName returns a human-readable name for a PFCP Cause value.
+func CauseName(cause uint8) string {
+	switch cause {
+	case ie.CauseRequestAccepted:
+		return "RequestAccepted"
+	case ie.CauseRequestRejected:
+		return "RequestRejected"
+	case ie.CauseSessionContextNotFound:
+		return "SessionContextNotFound"
+	case ie.CauseMandatoryIEMissing:
+		return "MandatoryIEMissing"
+	case ie.CauseConditionalIEMissing:
+		return "ConditionalIEMissing"
+	case ie.CauseInvalidLength:
+		return "InvalidLength"
+	case ie.CauseMandatoryIEIncorrect:
+		return "MandatoryIEIncorrect"
+	case ie.CauseInvalidForwardingPolicy:
+		return "InvalidForwardingPolicy"
+	case ie.CauseInvalidFTEIDAllocationOption:
+		return "InvalidFTEIDAllocationOption"
+	case ie.CauseNoEstablishedPFCPAssociation:
+		return "NoEstablishedPFCPAssociation"
+	case ie.CauseRuleCreationModificationFailure:
+		return "RuleCreationModificationFailure"
+	case ie.CausePFCPEntityInCongestion:
+		return "PFCPEntityInCongestion"
+	case ie.CauseNoResourcesAvailable:
+		return "NoResourcesAvailable"
+	case ie.CauseServiceNotSupported:
+		return "ServiceNotSupported"
+	case ie.CauseSystemFailure:
+		return "SystemFailure"
+	case ie.CauseRedirectionRequested:
+		return "RedirectionRequested"
+	default:
+		return fmt.Sprintf("Unknown(%d)", cause)
+	}
+}
+
+// causeDescriptions holds the TS 29.244 wording of each Cause value.
+var causeDescriptions = map[uint8]string{
+	ie.CauseRequestAccepted:                 "Request accepted",
+	2:                                       "More Usage Report to send",
+	3:                                       "Request partially accepted",
+	ie.CauseRequestRejected:                 "Request rejected (reason not specified)",
+	ie.CauseSessionContextNotFound:          "Session context not found",
+	ie.CauseMandatoryIEMissing:              "Mandatory IE missing",
+	ie.CauseConditionalIEMissing:            "Conditional IE missing",
+	ie.CauseInvalidLength:                   "Invalid length",
+	ie.CauseMandatoryIEIncorrect:            "Mandatory IE incorrect",
+	ie.CauseInvalidForwardingPolicy:         "Invalid Forwarding Policy",
+	ie.CauseInvalidFTEIDAllocationOption:    "Invalid F-TEID allocation option",
+	ie.CauseNoEstablishedPFCPAssociation:    "No established PFCP Association",
+	ie.CauseRuleCreationModificationFailure: "Rule creation/modification failure",
+	ie.CausePFCPEntityInCongestion:          "PFCP entity in congestion",
+	ie.CauseNoResourcesAvailable:            "No resources available",
+	ie.CauseServiceNotSupported:             "Service not supported",
+	ie.CauseSystemFailure:                   "System failure",
+	ie.CauseRedirectionRequested:            "Redirection requested",
+	79:                                      "All dynamic addresses are occupied",
+	80:                                      "Unknown Pre-defined Rule",
+	81:                                      "Unknown Application ID",
+}
+
+// CauseDescription returns the TS 29.244 description of a PFCP Cause value,
+// e.g. "No established PFCP Association" for 72.
+func CauseDescription(cause uint8) string {
+	if desc, ok := causeDescriptions[cause]; ok {
+		return desc
+	}
+	return fmt.Sprintf("Unknown cause %d", cause)
+}
+
+// ruleTypeNames names the rule types of a Failed Rule ID IE.
+var ruleTypeNames = map[uint8]string{
+	ie.RuleIDTypePDR: "PDR",
+	ie.RuleIDTypeFAR: "FAR",
+	ie.RuleIDTypeQER: "QER",
+	ie.RuleIDTypeURR: "URR",
+	ie.RuleIDTypeBAR: "BAR",
+}
+
+// Rejection describes a response that did not accept a request: its Cause and,
+// if the UPF included them, the Offending IE and Failed Rule ID pointing at the
+// part of the request it did not accept.
+type Rejection struct {
+	Cause       uint8
+	OffendingIE uint16 // IE type, 0 if not present
+	FailedRule  string // e.g. "PDR 2", empty if not present
+}
+
+// String formats the rejection for error messages, e.g.
+// "cause 73 (RuleCreationModificationFailure), offending IE CreatePDR (1), failed rule PDR 2".
+func (r *Rejection) String() string {
+	s := fmt.Sprintf("cause %d (%s)", r.Cause, CauseName(r.Cause))
+	if r.OffendingIE != 0 {
+		s += fmt.Sprintf(", offending IE %s (%d)", IETypeName(r.OffendingIE), r.OffendingIE)
+	}
+	if r.FailedRule != "" {
+		s += ", failed rule " + r.FailedRule
+	}
+	return s
+}
+
+// ExtractRejection returns the rejection details of a response whose Cause is
+// not Request accepted. ok is false for accepted responses and responses
+// without a Cause IE.
+func ExtractRejection(msg message.Message) (rejection *Rejection, ok bool) {
+	cause, err := ResponseCause(msg)
+	if err != nil || cause == ie.CauseRequestAccepted {
+		return nil, false
+	}
+
+	var offending, failedRule *ie.IE
+	switch msg := msg.(type) {
+	case *message.PFDManagementResponse:
+		offending = msg.OffendingIE
+	case *message.SessionEstablishmentResponse:
+		offending, failedRule = msg.OffendingIE, msg.FailedRuleID
+	case *message.SessionModificationResponse:
+		offending, failedRule = msg.OffendingIE, msg.FailedRuleID
+	case *message.SessionDeletionResponse:
+		offending = msg.OffendingIE
+	}
+
+	rejection = &Rejection{Cause: cause}
+	if offending != nil {
+		if t, err := offending.OffendingIE(); err == nil {
+			rejection.OffendingIE = t
+		}
+	}
+	if failedRule != nil {
+		typ, typErr := failedRule.RuleIDType()
+		id, idErr := failedRule.FailedRuleID()
+		if typErr == nil && idErr == nil {
+			name, known := ruleTypeNames[typ]
+			if !known {
+				name = fmt.Sprintf("Rule(%d)", typ)
+			}
+			rejection.FailedRule = fmt.Sprintf("%s %d", name, id)
+		}
+	}
+	return rejection, true
+}
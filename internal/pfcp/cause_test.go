@@ -0,0 +1,48 @@
+package pfcp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+)
+
+func TestExtractRejection(t *testing.T) {
+	resp := message.NewSessionEstablishmentResponse(0, 0, 1, 1, 0,
+		ie.NewCause(ie.CauseRuleCreationModificationFailure),
+		ie.NewOffendingIE(ie.CreatePDR),
+		ie.NewFailedRuleID(ie.RuleIDTypePDR, 2),
+	)
+
+	rej, ok := ExtractRejection(resp)
+	require.True(t, ok)
+	assert.Equal(t, uint8(73), rej.Cause)
+	assert.Equal(t, ie.CreatePDR, rej.OffendingIE)
+	assert.Equal(t, "PDR 2", rej.FailedRule)
+	assert.Equal(t, "cause 73 (RuleCreationModificationFailure), offending IE CreatePDR (1), failed rule PDR 2", rej.String())
+}
+
+func TestExtractRejection_CauseOnly(t *testing.T) {
+	resp := message.NewSessionDeletionResponse(0, 0, 1, 1, 0, ie.NewCause(ie.CauseSessionContextNotFound))
+
+	rej, ok := ExtractRejection(resp)
+	require.True(t, ok)
+	assert.Equal(t, "cause 65 (SessionContextNotFound)", rej.String())
+}
+
+func TestExtractRejection_Accepted(t *testing.T) {
+	_, ok := ExtractRejection(message.NewSessionModificationResponse(0, 0, 1, 1, 0, ie.NewCause(ie.CauseRequestAccepted)))
+	assert.False(t, ok)
+
+	// Heartbeat Responses carry no Cause
+	_, ok = ExtractRejection(message.NewHeartbeatResponse(1, ie.NewRecoveryTimeStamp(time.Now())))
+	assert.False(t, ok)
+}
+
+func TestCauseDescription(t *testing.T) {
+	assert.Equal(t, "No established PFCP Association", CauseDescription(72))
+	assert.Equal(t, "Unknown cause 200", CauseDescription(200))
+}
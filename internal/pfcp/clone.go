@@ -0,0 +1,45 @@
+package pfcp
+
+import (
+	"fmt"
+
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+)
+
+// CloneSessionRequest decodes a fresh copy of an encoded session request,
+// replaces its header SEID and, for an Establishment Request, the SEID in its
+// CP F-SEID with seidFor of the original value, and encodes the copy.
+func CloneSessionRequest(data []byte, seidFor func(uint64) uint64) ([]byte, error) {
+	msg, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if req, ok := msg.(*message.SessionEstablishmentRequest); ok && req.CPFSEID != nil {
+		fseid, err := req.CPFSEID.FSEID()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CP F-SEID: %w", err)
+		}
+		req.CPFSEID = ie.NewFSEID(seidFor(fseid.SEID), fseid.IPv4Address, fseid.IPv6Address)
+	}
+
+	if header := sessionHeader(msg); header != nil {
+		header.SetSEID(seidFor(header.SEID))
+	}
+	return Encode(msg)
+}
+
+// sessionHeader returns the header of a session request.
+func sessionHeader(msg message.Message) *message.Header {
+	switch msg := msg.(type) {
+	case *message.SessionEstablishmentRequest:
+		return msg.Header
+	case *message.SessionModificationRequest:
+		return msg.Header
+	case *message.SessionDeletionRequest:
+		return msg.Header
+	default:
+		return nil
+	}
+}
@@ -2,6 +2,7 @@ package pfcp
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/wmnsk/go-pfcp/message"
 )
@@ -19,9 +20,11 @@ func Decode(data []byte) (message.Message, error) {
 func IsRequest(msg message.Message) bool {
 	switch msg.MessageType() {
 	case message.MsgTypeHeartbeatRequest,
+		message.MsgTypePFDManagementRequest,
 		message.MsgTypeAssociationSetupRequest,
 		message.MsgTypeAssociationUpdateRequest,
 		message.MsgTypeAssociationReleaseRequest,
+		message.MsgTypeNodeReportRequest,
 		message.MsgTypeSessionEstablishmentRequest,
 		message.MsgTypeSessionModificationRequest,
 		message.MsgTypeSessionDeletionRequest,
@@ -32,6 +35,31 @@ func IsRequest(msg message.Message) bool {
 	}
 }
 
+// IsResponse returns true if the message type is a response to a request.
+func IsResponse(msg message.Message) bool {
+	switch msg.MessageType() {
+	case message.MsgTypeHeartbeatResponse,
+		message.MsgTypePFDManagementResponse,
+		message.MsgTypeAssociationSetupResponse,
+		message.MsgTypeAssociationUpdateResponse,
+		message.MsgTypeAssociationReleaseResponse,
+		message.MsgTypeNodeReportResponse,
+		message.MsgTypeSessionEstablishmentResponse,
+		message.MsgTypeSessionModificationResponse,
+		message.MsgTypeSessionDeletionResponse,
+		message.MsgTypeSessionReportResponse:
+		return true
+	default:
+		return false
+	}
+}
+
+// ExpectedResponseType returns the message type of the response that answers
+// the given request type. PFCP response types are always the request type + 1.
+func ExpectedResponseType(requestType uint8) uint8 {
+	return requestType + 1
+}
+
 // IsSessionMessage returns true if the message is session-related (has SEID in header).
 func IsSessionMessage(msg message.Message) bool {
 	switch msg.MessageType() {
@@ -56,6 +84,10 @@ func MessageTypeName(msgType uint8) string {
 		return "HeartbeatRequest"
 	case message.MsgTypeHeartbeatResponse:
 		return "HeartbeatResponse"
+	case message.MsgTypePFDManagementRequest:
+		return "PFDManagementRequest"
+	case message.MsgTypePFDManagementResponse:
+		return "PFDManagementResponse"
 	case message.MsgTypeAssociationSetupRequest:
 		return "AssociationSetupRequest"
 	case message.MsgTypeAssociationSetupResponse:
@@ -68,6 +100,10 @@ func MessageTypeName(msgType uint8) string {
 		return "AssociationReleaseRequest"
 	case message.MsgTypeAssociationReleaseResponse:
 		return "AssociationReleaseResponse"
+	case message.MsgTypeNodeReportRequest:
+		return "NodeReportRequest"
+	case message.MsgTypeNodeReportResponse:
+		return "NodeReportResponse"
 	case message.MsgTypeSessionEstablishmentRequest:
 		return "SessionEstablishmentRequest"
 	case message.MsgTypeSessionEstablishmentResponse:
@@ -88,3 +124,25 @@ func MessageTypeName(msgType uint8) string {
 		return fmt.Sprintf("Unknown(%d)", msgType)
 	}
 }
+
+// ParseMessageTypes converts message type names, as returned by
+// MessageTypeName and matched case-insensitively, into a set of message types.
+func ParseMessageTypes(names []string) (map[uint8]bool, error) {
+	byName := make(map[string]uint8)
+	for t := 1; t <= 255; t++ {
+		name := MessageTypeName(uint8(t))
+		if !strings.HasPrefix(name, "Unknown(") {
+			byName[strings.ToLower(name)] = uint8(t)
+		}
+	}
+
+	types := make(map[uint8]bool, len(names))
+	for _, name := range names {
+		t, ok := byName[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown message type %q", name)
+		}
+		types[t] = true
+	}
+	return types, nil
+}
@@ -0,0 +1,99 @@
+package pfcp
+
+import (
+	"strings"
+
+	"github.com/wmnsk/go-pfcp/message"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 2
+
+// DiffMessages returns a unified diff of the DumpMessage output of two
+// messages, typically a message from the pcap and its modified version.
+// Removed lines are prefixed with "-", added lines with "+" and context lines
+// with a space; "..." separates non-adjacent hunks. The result is empty if
+// both messages dump identically.
+func DiffMessages(original, modified message.Message) string {
+	a := strings.Split(strings.TrimSuffix(DumpMessage(original), "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(DumpMessage(modified), "\n"), "\n")
+
+	ops := diffLines(a, b)
+
+	// Mark the lines to show: every change plus diffContext lines around it
+	show := make([]bool, len(ops))
+	changed := false
+	for i, op := range ops {
+		if op.kind == ' ' {
+			continue
+		}
+		changed = true
+		for j := max(0, i-diffContext); j <= min(len(ops)-1, i+diffContext); j++ {
+			show[j] = true
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	var sb strings.Builder
+	for i, op := range ops {
+		if !show[i] {
+			continue
+		}
+		if i > 0 && !show[i-1] {
+			sb.WriteString("...\n")
+		}
+		sb.WriteByte(op.kind)
+		sb.WriteString(op.line)
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// diffLines computes a line diff of a and b from their longest common subsequence.
+func diffLines(a, b []string) []diffOp {
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
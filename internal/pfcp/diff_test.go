@@ -0,0 +1,35 @@
+package pfcp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/message"
+)
+
+func TestDiffMessages(t *testing.T) {
+	original := testEstablishmentRequest()
+	data, err := Encode(original)
+	require.NoError(t, err)
+	decoded, err := Decode(data)
+	require.NoError(t, err)
+	modified := decoded.(*message.SessionEstablishmentRequest)
+
+	assert.Empty(t, DiffMessages(original, modified))
+
+	m := NewModifier(net.ParseIP("192.168.1.10"), true)
+	require.NoError(t, m.ModifySessionEstablishment(modified, 7, net.ParseIP("10.60.0.99"), 3))
+
+	diff := DiffMessages(original, modified)
+	assert.Contains(t, diff, "-SessionEstablishmentRequest (50) len=103 seq=42 seid=0x0\n")
+	assert.Contains(t, diff, "+SessionEstablishmentRequest (50) len=103 seq=3 seid=0x0\n")
+	assert.Contains(t, diff, "-  NodeID (60) len=5: 10.0.0.1\n")
+	assert.Contains(t, diff, "+  NodeID (60) len=5: 192.168.1.10\n")
+	assert.Contains(t, diff, "-  FSEID (57) len=13: seid=0x1 ipv4=10.0.0.1\n")
+	assert.Contains(t, diff, "+  FSEID (57) len=13: seid=0x7 ipv4=192.168.1.10\n")
+	assert.Contains(t, diff, "+      UEIPAddress (93) len=5: flags=0x02 ipv4=10.60.0.99\n")
+	// Unchanged IEs far from any change are left out
+	assert.NotContains(t, diff, "ApplyAction")
+}
@@ -0,0 +1,312 @@
+package pfcp
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+)
+
+// maxDumpBytes limits the raw bytes printed for IEs without a decoded value.
+const maxDumpBytes = 32
+
+// ieTypeNames maps IE types to the names used by go-pfcp.
+var ieTypeNames = map[uint16]string{
+	ie.CreatePDR:                            "CreatePDR",
+	ie.PDI:                                  "PDI",
+	ie.CreateFAR:                            "CreateFAR",
+	ie.ForwardingParameters:                 "ForwardingParameters",
+	ie.DuplicatingParameters:                "DuplicatingParameters",
+	ie.CreateURR:                            "CreateURR",
+	ie.CreateQER:                            "CreateQER",
+	ie.CreatedPDR:                           "CreatedPDR",
+	ie.UpdatePDR:                            "UpdatePDR",
+	ie.UpdateFAR:                            "UpdateFAR",
+	ie.UpdateForwardingParameters:           "UpdateForwardingParameters",
+	ie.UpdateBARWithinSessionReportResponse: "UpdateBARWithinSessionReportResponse",
+	ie.UpdateURR:                            "UpdateURR",
+	ie.UpdateQER:                            "UpdateQER",
+	ie.RemovePDR:                            "RemovePDR",
+	ie.RemoveFAR:                            "RemoveFAR",
+	ie.RemoveURR:                            "RemoveURR",
+	ie.RemoveQER:                            "RemoveQER",
+	ie.Cause:                                "Cause",
+	ie.SourceInterface:                      "SourceInterface",
+	ie.FTEID:                                "FTEID",
+	ie.NetworkInstance:                      "NetworkInstance",
+	ie.SDFFilter:                            "SDFFilter",
+	ie.ApplicationID:                        "ApplicationID",
+	ie.GateStatus:                           "GateStatus",
+	ie.MBR:                                  "MBR",
+	ie.GBR:                                  "GBR",
+	ie.QERCorrelationID:                     "QERCorrelationID",
+	ie.Precedence:                           "Precedence",
+	ie.TransportLevelMarking:                "TransportLevelMarking",
+	ie.VolumeThreshold:                      "VolumeThreshold",
+	ie.TimeThreshold:                        "TimeThreshold",
+	ie.MonitoringTime:                       "MonitoringTime",
+	ie.SubsequentVolumeThreshold:            "SubsequentVolumeThreshold",
+	ie.SubsequentTimeThreshold:              "SubsequentTimeThreshold",
+	ie.InactivityDetectionTime:              "InactivityDetectionTime",
+	ie.ReportingTriggers:                    "ReportingTriggers",
+	ie.RedirectInformation:                  "RedirectInformation",
+	ie.ReportType:                           "ReportType",
+	ie.OffendingIE:                          "OffendingIE",
+	ie.ForwardingPolicy:                     "ForwardingPolicy",
+	ie.DestinationInterface:                 "DestinationInterface",
+	ie.UPFunctionFeatures:                   "UPFunctionFeatures",
+	ie.ApplyAction:                          "ApplyAction",
+	ie.DownlinkDataServiceInformation:       "DownlinkDataServiceInformation",
+	ie.DownlinkDataNotificationDelay:        "DownlinkDataNotificationDelay",
+	ie.DLBufferingDuration:                  "DLBufferingDuration",
+	ie.DLBufferingSuggestedPacketCount:      "DLBufferingSuggestedPacketCount",
+	ie.PFCPSMReqFlags:                       "PFCPSMReqFlags",
+	ie.PFCPSRRspFlags:                       "PFCPSRRspFlags",
+	ie.LoadControlInformation:               "LoadControlInformation",
+	ie.SequenceNumber:                       "SequenceNumber",
+	ie.Metric:                               "Metric",
+	ie.OverloadControlInformation:           "OverloadControlInformation",
+	ie.Timer:                                "Timer",
+	ie.PDRID:                                "PDRID",
+	ie.FSEID:                                "FSEID",
+	ie.ApplicationIDsPFDs:                   "ApplicationIDsPFDs",
+	ie.PFDContext:                           "PFDContext",
+	ie.NodeID:                               "NodeID",
+	ie.PFDContents:                          "PFDContents",
+	ie.MeasurementMethod:                    "MeasurementMethod",
+	ie.UsageReportTrigger:                   "UsageReportTrigger",
+	ie.MeasurementPeriod:                    "MeasurementPeriod",
+	ie.FQCSID:                               "FQCSID",
+	ie.VolumeMeasurement:                    "VolumeMeasurement",
+	ie.DurationMeasurement:                  "DurationMeasurement",
+	ie.ApplicationDetectionInformation:      "ApplicationDetectionInformation",
+	ie.TimeOfFirstPacket:                    "TimeOfFirstPacket",
+	ie.TimeOfLastPacket:                     "TimeOfLastPacket",
+	ie.QuotaHoldingTime:                     "QuotaHoldingTime",
+	ie.DroppedDLTrafficThreshold:            "DroppedDLTrafficThreshold",
+	ie.VolumeQuota:                          "VolumeQuota",
+	ie.TimeQuota:                            "TimeQuota",
+	ie.StartTime:                            "StartTime",
+	ie.EndTime:                              "EndTime",
+	ie.QueryURR:                             "QueryURR",
+	ie.UsageReportWithinSessionModificationResponse: "UsageReportWithinSessionModificationResponse",
+	ie.UsageReportWithinSessionDeletionResponse:     "UsageReportWithinSessionDeletionResponse",
+	ie.UsageReportWithinSessionReportRequest:        "UsageReportWithinSessionReportRequest",
+	ie.URRID:                                        "URRID",
+	ie.LinkedURRID:                                  "LinkedURRID",
+	ie.DownlinkDataReport:                           "DownlinkDataReport",
+	ie.OuterHeaderCreation:                          "OuterHeaderCreation",
+	ie.CreateBAR:                                    "CreateBAR",
+	ie.UpdateBARWithinSessionModificationRequest:    "UpdateBARWithinSessionModificationRequest",
+	ie.RemoveBAR:                                    "RemoveBAR",
+	ie.BARID:                                        "BARID",
+	ie.CPFunctionFeatures:                           "CPFunctionFeatures",
+	ie.UsageInformation:                             "UsageInformation",
+	ie.ApplicationInstanceID:                        "ApplicationInstanceID",
+	ie.FlowInformation:                              "FlowInformation",
+	ie.UEIPAddress:                                  "UEIPAddress",
+	ie.PacketRate:                                   "PacketRate",
+	ie.OuterHeaderRemoval:                           "OuterHeaderRemoval",
+	ie.RecoveryTimeStamp:                            "RecoveryTimeStamp",
+	ie.DLFlowLevelMarking:                           "DLFlowLevelMarking",
+	ie.HeaderEnrichment:                             "HeaderEnrichment",
+	ie.ErrorIndicationReport:                        "ErrorIndicationReport",
+	ie.MeasurementInformation:                       "MeasurementInformation",
+	ie.NodeReportType:                               "NodeReportType",
+	ie.UserPlanePathFailureReport:                   "UserPlanePathFailureReport",
+	ie.RemoteGTPUPeer:                               "RemoteGTPUPeer",
+	ie.URSEQN:                                       "URSEQN",
+	ie.UpdateDuplicatingParameters:                  "UpdateDuplicatingParameters",
+	ie.ActivatePredefinedRules:                      "ActivatePredefinedRules",
+	ie.DeactivatePredefinedRules:                    "DeactivatePredefinedRules",
+	ie.FARID:                                        "FARID",
+	ie.QERID:                                        "QERID",
+	ie.OCIFlags:                                     "OCIFlags",
+	ie.PFCPAssociationReleaseRequest:                "PFCPAssociationReleaseRequest",
+	ie.GracefulReleasePeriod:                        "GracefulReleasePeriod",
+	ie.PDNType:                                      "PDNType",
+	ie.FailedRuleID:                                 "FailedRuleID",
+	ie.TimeQuotaMechanism:                           "TimeQuotaMechanism",
+	ie.UserPlaneIPResourceInformation:               "UserPlaneIPResourceInformation",
+	ie.UserPlaneInactivityTimer:                     "UserPlaneInactivityTimer",
+	ie.AggregatedURRs:                               "AggregatedURRs",
+	ie.Multiplier:                                   "Multiplier",
+	ie.AggregatedURRID:                              "AggregatedURRID",
+	ie.SubsequentVolumeQuota:                        "SubsequentVolumeQuota",
+	ie.SubsequentTimeQuota:                          "SubsequentTimeQuota",
+	ie.RQI:                                          "RQI",
+	ie.QFI:                                          "QFI",
+	ie.QueryURRReference:                            "QueryURRReference",
+	ie.AdditionalUsageReportsInformation:            "AdditionalUsageReportsInformation",
+	ie.CreateTrafficEndpoint:                        "CreateTrafficEndpoint",
+	ie.CreatedTrafficEndpoint:                       "CreatedTrafficEndpoint",
+	ie.UpdateTrafficEndpoint:                        "UpdateTrafficEndpoint",
+	ie.RemoveTrafficEndpoint:                        "RemoveTrafficEndpoint",
+	ie.TrafficEndpointID:                            "TrafficEndpointID",
+	ie.APNDNN:                                       "APNDNN",
+	ie.UEIPAddressPoolIdentity:                      "UEIPAddressPoolIdentity",
+	ie.SMFSetID:                                     "SMFSetID",
+	ie.PFCPSEReqFlags:                               "PFCPSEReqFlags",
+}
+
+// IETypeName returns a human-readable name for a PFCP IE type.
+func IETypeName(ieType uint16) string {
+	if name, ok := ieTypeNames[ieType]; ok {
+		return name
+	}
+	return fmt.Sprintf("Unknown(%d)", ieType)
+}
+
+// DumpMessage renders a message's header and IE tree as indented text, one IE
+// per line with its type name, length and, for common IE types, decoded value.
+// Lengths are computed from the IE tree, so they reflect modifications that
+// have not been encoded yet.
+func DumpMessage(msg message.Message) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s (%d) len=%d seq=%d", MessageTypeName(msg.MessageType()), msg.MessageType(), msg.MarshalLen(), msg.Sequence())
+	if IsSessionMessage(msg) {
+		fmt.Fprintf(&sb, " seid=%#x", msg.SEID())
+	}
+	sb.WriteString("\n")
+	dumpIEs(&sb, messageIEs(msg), 1)
+	return sb.String()
+}
+
+// dumpIEs writes one line per IE, indented by depth, recursing into grouped IEs.
+func dumpIEs(sb *strings.Builder, ies []*ie.IE, depth int) {
+	for _, i := range ies {
+		if i == nil {
+			continue
+		}
+		fmt.Fprintf(sb, "%s%s (%d) len=%d", strings.Repeat("  ", depth), IETypeName(i.Type), i.Type, i.MarshalLen()-4)
+		if i.IsVendorSpecific() {
+			fmt.Fprintf(sb, " enterprise=%d", i.EnterpriseID)
+		}
+		if len(i.ChildIEs) > 0 {
+			sb.WriteString("\n")
+			dumpIEs(sb, i.ChildIEs, depth+1)
+			continue
+		}
+		if value := ieValue(i); value != "" {
+			sb.WriteString(": " + value)
+		}
+		sb.WriteString("\n")
+	}
+}
+
+// ieValue decodes the value of common IE types, falling back to hex.
+func ieValue(i *ie.IE) string {
+	var value string
+	var err error
+	switch i.Type {
+	case ie.Cause:
+		var cause uint8
+		if cause, err = i.Cause(); err == nil {
+			value = fmt.Sprintf("%d (%s)", cause, CauseName(cause))
+		}
+	case ie.NodeID:
+		value, err = i.NodeID()
+	case ie.FSEID:
+		var f *ie.FSEIDFields
+		if f, err = i.FSEID(); err == nil {
+			value = fmt.Sprintf("seid=%#x%s", f.SEID, ipFields(f.IPv4Address, f.IPv6Address))
+		}
+	case ie.FTEID:
+		var f *ie.FTEIDFields
+		if f, err = i.FTEID(); err == nil {
+			if f.HasCh() {
+				value = fmt.Sprintf("choose id=%d", f.ChooseID)
+			} else {
+				value = fmt.Sprintf("teid=%#x%s", f.TEID, ipFields(f.IPv4Address, f.IPv6Address))
+			}
+		}
+	case ie.UEIPAddress:
+		var f *ie.UEIPAddressFields
+		if f, err = i.UEIPAddress(); err == nil {
+			value = fmt.Sprintf("flags=%#02x%s", f.Flags, ipFields(f.IPv4Address, f.IPv6Address))
+		}
+	case ie.OuterHeaderCreation:
+		var f *ie.OuterHeaderCreationFields
+		if f, err = i.OuterHeaderCreation(); err == nil {
+			value = fmt.Sprintf("desc=%#04x", f.OuterHeaderCreationDescription)
+			if f.HasTEID() {
+				value += fmt.Sprintf(" teid=%#x", f.TEID)
+			}
+			value += ipFields(f.IPv4Address, f.IPv6Address)
+		}
+	case ie.NetworkInstance:
+		name, isFQDN := decodeNetworkInstance(i.Payload)
+		value = fmt.Sprintf("%q", name)
+		if isFQDN {
+			value += " (fqdn)"
+		}
+	case ie.PDRID:
+		var id uint16
+		if id, err = i.PDRID(); err == nil {
+			value = fmt.Sprint(id)
+		}
+	case ie.FARID:
+		var id uint32
+		if id, err = i.FARID(); err == nil {
+			value = fmt.Sprint(id)
+		}
+	case ie.QERID:
+		var id uint32
+		if id, err = i.QERID(); err == nil {
+			value = fmt.Sprint(id)
+		}
+	case ie.URRID:
+		var id uint32
+		if id, err = i.URRID(); err == nil {
+			value = fmt.Sprint(id)
+		}
+	case ie.Precedence:
+		var precedence uint32
+		if precedence, err = i.Precedence(); err == nil {
+			value = fmt.Sprint(precedence)
+		}
+	case ie.SourceInterface:
+		var iface uint8
+		if iface, err = i.SourceInterface(); err == nil {
+			value = fmt.Sprint(iface)
+		}
+	case ie.DestinationInterface:
+		var iface uint8
+		if iface, err = i.DestinationInterface(); err == nil {
+			value = fmt.Sprint(iface)
+		}
+	case ie.RecoveryTimeStamp:
+		var ts time.Time
+		if ts, err = i.RecoveryTimeStamp(); err == nil {
+			value = ts.UTC().Format(time.RFC3339)
+		}
+	}
+	if err != nil || value == "" {
+		return hexValue(i.Payload)
+	}
+	return value
+}
+
+// ipFields formats the IPv4 and IPv6 addresses present in an IE.
+func ipFields(v4, v6 net.IP) string {
+	var s string
+	if v4 != nil {
+		s += " ipv4=" + v4.String()
+	}
+	if v6 != nil {
+		s += " ipv6=" + v6.String()
+	}
+	return s
+}
+
+// hexValue formats an IE payload as hex, truncated to maxDumpBytes.
+func hexValue(b []byte) string {
+	if len(b) > maxDumpBytes {
+		return hex.EncodeToString(b[:maxDumpBytes]) + "..."
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,49 @@
+package pfcp
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+)
+
+func TestDumpMessage_EstablishmentRequest(t *testing.T) {
+	req := testEstablishmentRequest()
+	req.CreatePDR[0].ChildIEs[2].ChildIEs = append(req.CreatePDR[0].ChildIEs[2].ChildIEs,
+		ie.NewFTEID(0x01, 0x1234, net.ParseIP("10.0.0.2"), nil, 0),
+		ie.NewNetworkInstanceFQDN("internet.mnc001.mcc001.gprs"),
+	)
+
+	out := DumpMessage(req)
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+
+	assert.True(t, strings.HasPrefix(lines[0], "SessionEstablishmentRequest (50) len="))
+	assert.Contains(t, lines[0], "seq=42 seid=0x0")
+	assert.Contains(t, out, "\n    PDI (2) len=59\n")
+	assert.Contains(t, out, "\n  NodeID (60) len=5: 10.0.0.1\n")
+	assert.Contains(t, out, "\n  FSEID (57) len=13: seid=0x1 ipv4=10.0.0.1\n")
+	assert.Contains(t, out, "\n  CreatePDR (1) len=85\n")
+	assert.Contains(t, out, "\n    PDRID (56) len=2: 1\n")
+	assert.Contains(t, out, "\n      UEIPAddress (93) len=5: flags=0x02 ipv4=10.60.0.1\n")
+	assert.Contains(t, out, "\n      FTEID (21) len=9: teid=0x1234 ipv4=10.0.0.2\n")
+	assert.Contains(t, out, `NetworkInstance (22) len=28: "internet.mnc001.mcc001.gprs" (fqdn)`)
+	assert.Contains(t, out, "\n    ApplyAction (44) len=1: 02\n")
+}
+
+func TestDumpMessage_ResponseCause(t *testing.T) {
+	resp := message.NewHeartbeatResponse(7, ie.NewCause(ie.CauseRequestRejected))
+
+	out := DumpMessage(resp)
+	assert.True(t, strings.HasPrefix(out, "HeartbeatResponse (2) len="))
+	assert.NotContains(t, out, "seid=")
+	assert.Contains(t, out, "Cause (19) len=1: 64 (RequestRejected)")
+}
+
+func TestIETypeName(t *testing.T) {
+	assert.Equal(t, "CreatePDR", IETypeName(ie.CreatePDR))
+	assert.Equal(t, "UEIPAddress", IETypeName(ie.UEIPAddress))
+	assert.Equal(t, "Unknown(65000)", IETypeName(65000))
+}
@@ -0,0 +1,89 @@
+package pfcp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// cpFeatureNames lists the CP Function Features flags (TS 29.244 8.2.58) by
+// octet, starting at octet 5, and by bit, starting at bit 1.
+var cpFeatureNames = [][8]string{
+	{"LOAD", "OVRL", "EPFAR", "SSET", "BUNDL", "MPAS", "ARDR", "UIAUR"},
+	{"PSUCC", "RPGUR"},
+}
+
+// upFeatureNames lists the UP Function Features flags (TS 29.244 8.2.25) by
+// octet, starting at octet 5, and by bit, starting at bit 1.
+var upFeatureNames = [][8]string{
+	{"BUCP", "DDND", "DLBD", "TRST", "FTUP", "PFDM", "HEEU", "TREU"},
+	{"EMPU", "PDIU", "UDBC", "QUOAC", "TRACE", "FRRT", "PFDE", "EPFAR"},
+	{"DPDRA", "ADPDP", "UEIP", "SSET", "MNOP", "MTE", "BUNDL", "GCOM"},
+	{"MPAS", "RTTL", "VTIME", "NORP", "IPTV", "IP6PL", "TSCU", "MPTCP"},
+	{"ATSSS-LL", "QFQM", "GPQM", "MT-EDT", "CIOT", "ETHAR", "DDDS", "RDS"},
+}
+
+// CPFunctionFeatureNames returns the names of the flags set in a CP Function
+// Features value. Unnamed flags are reported as "octet N bit M".
+func CPFunctionFeatureNames(features []byte) []string {
+	return featureNames(features, cpFeatureNames)
+}
+
+// UPFunctionFeatureNames returns the names of the flags set in a UP Function
+// Features value. Unnamed flags are reported as "octet N bit M".
+func UPFunctionFeatureNames(features []byte) []string {
+	return featureNames(features, upFeatureNames)
+}
+
+func featureNames(features []byte, table [][8]string) []string {
+	var names []string
+	for octet, b := range features {
+		for bit := 0; bit < 8; bit++ {
+			if b&(1<<bit) == 0 {
+				continue
+			}
+			if octet < len(table) && table[octet][bit] != "" {
+				names = append(names, table[octet][bit])
+			} else {
+				names = append(names, fmt.Sprintf("octet %d bit %d", octet+5, bit+1))
+			}
+		}
+	}
+	return names
+}
+
+// ParseCPFunctionFeatures parses a CP Function Features setting: either a
+// numeric bitmask (e.g. "0x03", octet 5 in the low byte) or a comma-separated
+// list of flag names (e.g. "LOAD,OVRL"). It returns the IE value, two octets
+// long.
+func ParseCPFunctionFeatures(s string) ([]byte, error) {
+	return parseFlags(s, cpFeatureNames, "CP function feature")
+}
+
+// parseFlags parses a two-octet flags value given as a number or as a
+// comma-separated list of the flag names in table. kind names the flags in
+// errors.
+func parseFlags(s string, table [][8]string, kind string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if v, err := strconv.ParseUint(s, 0, 16); err == nil {
+		return []byte{byte(v), byte(v >> 8)}, nil
+	}
+
+	flags := make([]byte, len(table))
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		found := false
+		for octet, names := range table {
+			for bit, n := range names {
+				if n != "" && n == name {
+					flags[octet] |= 1 << bit
+					found = true
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown %s %q", kind, name)
+		}
+	}
+	return flags, nil
+}
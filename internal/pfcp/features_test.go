@@ -0,0 +1,35 @@
+package pfcp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCPFunctionFeatures(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []byte
+	}{
+		{"0x03", []byte{0x03, 0x00}},
+		{"256", []byte{0x00, 0x01}},
+		{"LOAD, ovrl", []byte{0x03, 0x00}},
+		{"UIAUR,PSUCC", []byte{0x80, 0x01}},
+	}
+	for _, tt := range tests {
+		got, err := ParseCPFunctionFeatures(tt.in)
+		require.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+
+	_, err := ParseCPFunctionFeatures("LOAD,FOO")
+	assert.Error(t, err)
+}
+
+func TestFunctionFeatureNames(t *testing.T) {
+	assert.Equal(t, []string{"LOAD", "OVRL"}, CPFunctionFeatureNames([]byte{0x03}))
+	assert.Equal(t, []string{"FTUP", "EMPU", "UEIP", "octet 11 bit 1"},
+		UPFunctionFeatureNames([]byte{0x10, 0x01, 0x04, 0x00, 0x00, 0x00, 0x01}))
+	assert.Empty(t, UPFunctionFeatureNames(nil))
+}
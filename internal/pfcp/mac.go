@@ -0,0 +1,144 @@
+package pfcp
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/wmnsk/go-pfcp/ie"
+)
+
+// MACMapper returns the UE MAC address to use in place of an original UE MAC
+// address from the pcap.
+type MACMapper func(original net.HardwareAddr) (net.HardwareAddr, error)
+
+// ParseMACPool parses a UE MAC pool given as a base MAC address and a prefix
+// length in bits, e.g. "02:00:00:00:00:00/24". The base must be a unicast
+// address, and the prefix leaves at least 8 bits of addresses.
+func ParseMACPool(spec string) (net.HardwareAddr, int, error) {
+	addr, bits, ok := strings.Cut(spec, "/")
+	if !ok {
+		return nil, 0, fmt.Errorf("MAC pool %q needs a prefix length, e.g. 02:00:00:00:00:00/24", spec)
+	}
+	base, err := net.ParseMAC(addr)
+	if err != nil || len(base) != 6 {
+		return nil, 0, fmt.Errorf("invalid MAC address %q in MAC pool", addr)
+	}
+	prefix, err := strconv.Atoi(bits)
+	if err != nil || prefix < 1 || prefix > 40 {
+		return nil, 0, fmt.Errorf("MAC pool prefix length must be between 1 and 40, got %q", bits)
+	}
+	if base[0]&0x01 != 0 {
+		return nil, 0, fmt.Errorf("MAC pool base %s is a multicast address", base)
+	}
+	return base, prefix, nil
+}
+
+// ModifyUEMACs rewrites the UE MAC addresses in the MAC Address IEs within
+// Ethernet Packet Filters of the given PDR lists (Create/Update PDR → PDI),
+// for Ethernet PDU sessions. The UE is the source of uplink traffic, so PDIs
+// with Source Interface Access have their source MAC rewritten and all others
+// their destination MAC. MAC ranges (with an upper address) are left
+// untouched. It returns the number of MAC addresses rewritten.
+func (m *Modifier) ModifyUEMACs(mapMAC MACMapper, pdrs ...[]*ie.IE) (int, error) {
+	var firstErr error
+	count := 0
+	for _, ies := range pdrs {
+		_, err := WalkIEs(ies, func(i *ie.IE) (*ie.IE, bool) {
+			if i.Type != ie.PDI || firstErr != nil {
+				return nil, false
+			}
+			replacement, n, err := rewritePDIMACs(i, mapMAC)
+			if err != nil {
+				firstErr = err
+				return nil, false
+			}
+			count += n
+			return replacement, replacement != nil
+		})
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return count, fmt.Errorf("failed to modify UE MAC in PDI: %w", firstErr)
+	}
+	return count, nil
+}
+
+// rewritePDIMACs returns pdi with its UE MAC addresses mapped, or nil if it has
+// none, and the number of addresses rewritten.
+func rewritePDIMACs(pdi *ie.IE, mapMAC MACMapper) (*ie.IE, int, error) {
+	var source *ie.IE
+	for _, child := range pdi.ChildIEs {
+		if child.Type == ie.SourceInterface {
+			source = child
+		}
+	}
+	if source == nil {
+		return nil, 0, nil
+	}
+	iface, err := source.SourceInterface()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to decode Source Interface: %w", err)
+	}
+	uplink := iface == ie.SrcInterfaceAccess
+
+	var firstErr error
+	children, n, err := walkIEs(pdi.ChildIEs, func(i *ie.IE) (*ie.IE, bool) {
+		if i.Type != ie.MACAddress || firstErr != nil {
+			return nil, false
+		}
+		replacement, err := rewriteMACAddress(i, uplink, mapMAC)
+		if err != nil {
+			firstErr = err
+			return nil, false
+		}
+		return replacement, replacement != nil
+	}, true)
+	if firstErr != nil {
+		return nil, 0, firstErr
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if n == 0 {
+		return nil, 0, nil
+	}
+	replacement, err := groupedIE(pdi.Type, pdi.EnterpriseID, children)
+	if err != nil {
+		return nil, 0, err
+	}
+	return replacement, n, nil
+}
+
+// rewriteMACAddress maps the UE's address in a MAC Address IE: the source
+// address for uplink, the destination address otherwise. It patches a copy of
+// the payload, returning nil if there is no such single address.
+func rewriteMACAddress(original *ie.IE, uplink bool, mapMAC MACMapper) (*ie.IE, error) {
+	fields, err := original.MACAddress()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode MAC Address: %w", err)
+	}
+
+	// Addresses follow the flags octet in the order SOUR, DEST, USOU, UDES
+	offset := 1
+	switch {
+	case uplink && fields.HasSOUR() && !fields.HasUSOU():
+	case !uplink && fields.HasDEST() && !fields.HasUDES():
+		if fields.HasSOUR() {
+			offset += 6
+		}
+	default:
+		return nil, nil
+	}
+
+	mac, err := mapMAC(net.HardwareAddr(original.Payload[offset : offset+6]))
+	if err != nil {
+		return nil, err
+	}
+	payload := append([]byte(nil), original.Payload...)
+	copy(payload[offset:offset+6], mac)
+	return ie.New(ie.MACAddress, payload), nil
+}
@@ -0,0 +1,73 @@
+package pfcp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+)
+
+func TestParseMACPool(t *testing.T) {
+	base, prefix, err := ParseMACPool("02:00:00:00:00:00/24")
+	require.NoError(t, err)
+	assert.Equal(t, net.HardwareAddr{0x02, 0, 0, 0, 0, 0}, base)
+	assert.Equal(t, 24, prefix)
+
+	for _, spec := range []string{"02:00:00:00:00:00", "02:00:00:00:00/24", "02:00:00:00:00:00/44", "01:00:5e:00:00:00/24"} {
+		_, _, err := ParseMACPool(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestModifier_ModifyUEMACs(t *testing.T) {
+	ue := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	dn := net.HardwareAddr{0x00, 0xaa, 0xbb, 0xcc, 0xdd, 0xee}
+	ethernetPDR := func(id uint16, source uint8, mac *ie.IE) *ie.IE {
+		return ie.NewCreatePDR(
+			ie.NewPDRID(id),
+			ie.NewPDI(
+				ie.NewSourceInterface(source),
+				ie.NewEthernetPacketFilter(ie.NewEthernetFilterID(uint32(id)), mac, ie.NewEthertype(0x0800)),
+			),
+		)
+	}
+	pdrs := []*ie.IE{
+		ethernetPDR(1, ie.SrcInterfaceAccess, ie.NewMACAddress(ue, dn, nil, nil)),
+		ethernetPDR(2, ie.SrcInterfaceCore, ie.NewMACAddress(dn, ue, nil, nil)),
+		// A range of source MACs is not a UE identity
+		ethernetPDR(3, ie.SrcInterfaceAccess, ie.NewMACAddress(ue, nil, dn, nil)),
+		// An IP PDU session PDR
+		ie.NewCreatePDR(ie.NewPDRID(4), ie.NewPDI(ie.NewSourceInterface(ie.SrcInterfaceAccess), ie.NewUEIPAddress(0x02, "10.0.0.1", "", 0, 0))),
+	}
+	untouched := []*ie.IE{pdrs[2], pdrs[3]}
+
+	allocated := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+	var mapped []string
+	n, err := NewModifier(nil, false).ModifyUEMACs(func(original net.HardwareAddr) (net.HardwareAddr, error) {
+		mapped = append(mapped, original.String())
+		return allocated, nil
+	}, pdrs)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{ue.String(), ue.String()}, mapped)
+
+	// Uplink: the source MAC is the UE's, downlink: the destination MAC
+	mac, err := pdrs[0].ChildIEs[1].MACAddress()
+	require.NoError(t, err)
+	assert.Equal(t, allocated, mac.SourceMACAddress)
+	assert.Equal(t, dn, mac.DestinationMACAddress)
+	mac, err = pdrs[1].ChildIEs[1].MACAddress()
+	require.NoError(t, err)
+	assert.Equal(t, dn, mac.SourceMACAddress)
+	assert.Equal(t, allocated, mac.DestinationMACAddress)
+
+	// The Ethernet Packet Filter keeps its other children
+	filter, err := pdrs[0].ChildIEs[1].EthernetPacketFilter()
+	require.NoError(t, err)
+	assert.Len(t, filter, 3)
+
+	assert.Same(t, untouched[0], pdrs[2])
+	assert.Same(t, untouched[1], pdrs[3])
+}
@@ -1,38 +1,156 @@
 package pfcp
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"strings"
 
 	"github.com/wmnsk/go-pfcp/ie"
 	"github.com/wmnsk/go-pfcp/message"
 )
 
+// ErrNoUEIPAddress is returned when the PDRs to rewrite carry no UE IP
+// Address IE. That is not a failure: the UPF may allocate the UE IP, or the
+// PDRs may match on something else.
+var ErrNoUEIPAddress = errors.New("no UE IP Address in PDRs")
+
 // Modifier applies session-specific modifications to PFCP messages.
 type Modifier struct {
 	smfIP     net.IP
+	nodeID    string
 	stripIPv6 bool
+
+	// Network Instance rewriting
+	networkInstanceOverride string
+	networkInstanceMap      map[string]string
+
+	// CP Function Features to advertise in Association Setup (nil = unchanged)
+	cpFeatures []byte
+
+	// GTP-U peer override for Outer Header Creation
+	gtpPeerIP       net.IP
+	gtpPeerTEIDBase uint32
+
+	// Apply Action to force in FARs (nil = unchanged)
+	applyAction []byte
+
+	// Values to force in the Create PDRs of Session Establishments
+	pdrOverride PDROverride
+
+	// Bit rates to force in Create/Update QERs
+	qerOverride QEROverride
+
+	// Message priority for session requests (-1 = keep the pcap's header)
+	messagePriority int
 }
 
 // NewModifier creates a new PFCP message modifier.
 func NewModifier(smfIP net.IP, stripIPv6 bool) *Modifier {
 	return &Modifier{
-		smfIP:     smfIP,
-		stripIPv6: stripIPv6,
+		smfIP:           smfIP,
+		stripIPv6:       stripIPv6,
+		messagePriority: -1,
+	}
+}
+
+// SetNodeID sets the Node ID to send instead of the SMF IP. An IP address
+// produces an IPv4 or IPv6 Node ID, anything else an FQDN Node ID.
+func (m *Modifier) SetNodeID(nodeID string) {
+	m.nodeID = nodeID
+}
+
+// SetNetworkInstanceRewrite configures Network Instance substitution. A non-empty
+// override replaces every Network Instance; otherwise values found in mapping
+// (matched case-insensitively) are replaced and all others are left unchanged.
+func (m *Modifier) SetNetworkInstanceRewrite(override string, mapping map[string]string) {
+	m.networkInstanceOverride = override
+	m.networkInstanceMap = make(map[string]string, len(mapping))
+	for from, to := range mapping {
+		m.networkInstanceMap[strings.ToLower(from)] = to
+	}
+}
+
+// SetGTPPeerOverride makes ModifyGTPPeer point GTP-U Outer Header Creation
+// at ip (if non-nil) and, with a non-zero teidBase, number its TEIDs from
+// teidBase. With a TEID base, ModifyTEIDs leaves Outer Header Creation to
+// ModifyGTPPeer.
+func (m *Modifier) SetGTPPeerOverride(ip net.IP, teidBase uint32) {
+	m.gtpPeerIP = ip
+	m.gtpPeerTEIDBase = teidBase
+}
+
+// SetCPFunctionFeatures sets the CP Function Features value to advertise in
+// Association Setup Requests, added if the request has none. nil keeps the
+// captured value.
+func (m *Modifier) SetCPFunctionFeatures(features []byte) {
+	m.cpFeatures = features
+}
+
+// SetApplyActionOverride sets the Apply Action value that ModifyApplyActions
+// writes into every FAR. nil keeps the captured values.
+func (m *Modifier) SetApplyActionOverride(applyAction []byte) {
+	m.applyAction = applyAction
+}
+
+// PDROverride lists Create PDR values to force in every Session Establishment
+// Request. A nil field keeps the captured values.
+type PDROverride struct {
+	Precedence         *uint32
+	SourceInterface    *uint8
+	OuterHeaderRemoval *uint8
+}
+
+// SetPDROverride sets the Create PDR values that ModifySessionEstablishment
+// writes into every Session Establishment Request.
+func (m *Modifier) SetPDROverride(override PDROverride) {
+	m.pdrOverride = override
+}
+
+// QEROverride lists QER bit rates, in kbps, to force in the Create and Update
+// QERs of session requests. A nil field keeps the captured value.
+type QEROverride struct {
+	MBRUplink   *uint64
+	MBRDownlink *uint64
+	GBRUplink   *uint64
+	GBRDownlink *uint64
+}
+
+// SetQEROverride sets the bit rates that ModifyQERs writes into every QER.
+func (m *Modifier) SetQEROverride(override QEROverride) {
+	m.qerOverride = override
+}
+
+// SetMessagePriority makes the session request modifiers set the MP flag and
+// the given message priority (0-15) in the header. A negative priority keeps
+// the captured header flags and priority.
+func (m *Modifier) SetMessagePriority(priority int) {
+	m.messagePriority = priority
+}
+
+// setMessagePriority applies the configured message priority to a session
+// request header. Header.SetMP is not used as it sets the FO flag (0x04)
+// rather than MP (0x02).
+func (m *Modifier) setMessagePriority(h *message.Header) {
+	if m.messagePriority < 0 {
+		return
 	}
+	h.Flags |= 0x02
+	h.MessagePriority = uint8(m.messagePriority&0x0f) << 4
 }
 
-// ModifyAssociationSetup updates the sequence number and optionally the Node ID.
+// ModifyAssociationSetup updates the sequence number and optionally the Node ID
+// and CP Function Features.
 func (m *Modifier) ModifyAssociationSetup(msg *message.AssociationSetupRequest, seqNum uint32) error {
 	msg.Header.SetSequenceNumber(seqNum)
 
-	// Update Node ID to use our SMF IP if configured
-	if m.smfIP != nil && msg.NodeID != nil {
-		if m.smfIP.To4() != nil {
-			msg.NodeID = ie.NewNodeID(m.smfIP.String(), "", "")
-		} else {
-			msg.NodeID = ie.NewNodeID("", m.smfIP.String(), "")
-		}
+	if m.cpFeatures != nil {
+		msg.CPFunctionFeatures = ie.NewCPFunctionFeatures(m.cpFeatures...)
+	}
+
+	// Update Node ID to use our Node ID if configured
+	if nodeID := m.newNodeID(); nodeID != nil && msg.NodeID != nil {
+		msg.NodeID = nodeID
 	}
 
 	return nil
@@ -48,6 +166,7 @@ func (m *Modifier) ModifySessionEstablishment(
 	// Set header SEID to 0 for initial establishment
 	msg.Header.SetSEID(0)
 	msg.Header.SetSequenceNumber(seqNum)
+	m.setMessagePriority(msg.Header)
 
 	// Replace CP F-SEID with our local SEID and SMF IP
 	if msg.CPFSEID != nil {
@@ -70,22 +189,41 @@ func (m *Modifier) ModifySessionEstablishment(
 	}
 
 	// Replace UE IP Address in Create PDR → PDI
-	if err := m.modifyUEIPInCreatePDRs(msg.CreatePDR, ueIP); err != nil {
+	if err := m.modifyUEIPInCreatePDRs(msg.CreatePDR, ueIP); err != nil && !errors.Is(err, ErrNoUEIPAddress) {
 		return fmt.Errorf("failed to modify UE IP in Create PDRs: %w", err)
 	}
 
+	if _, err := m.ModifyPDRs(msg.CreatePDR); err != nil {
+		return err
+	}
+
 	// Also update Node ID
-	if m.smfIP != nil && msg.NodeID != nil {
-		if m.smfIP.To4() != nil {
-			msg.NodeID = ie.NewNodeID(m.smfIP.String(), "", "")
-		} else {
-			msg.NodeID = ie.NewNodeID("", m.smfIP.String(), "")
-		}
+	if nodeID := m.newNodeID(); nodeID != nil && msg.NodeID != nil {
+		msg.NodeID = nodeID
 	}
 
 	return nil
 }
 
+// newNodeID returns the Node ID IE for the configured Node ID, falling back to
+// the SMF IP, or nil if neither is set.
+func (m *Modifier) newNodeID() *ie.IE {
+	ip := m.smfIP
+	if m.nodeID != "" {
+		ip = net.ParseIP(m.nodeID)
+		if ip == nil {
+			return ie.NewNodeID("", "", m.nodeID)
+		}
+	}
+	if ip == nil {
+		return nil
+	}
+	if ip.To4() != nil {
+		return ie.NewNodeID(ip.String(), "", "")
+	}
+	return ie.NewNodeID("", ip.String(), "")
+}
+
 // ModifySessionModification updates the header SEID and sequence number.
 func (m *Modifier) ModifySessionModification(
 	msg *message.SessionModificationRequest,
@@ -95,17 +233,18 @@ func (m *Modifier) ModifySessionModification(
 ) error {
 	msg.Header.SetSEID(remoteSEID)
 	msg.Header.SetSequenceNumber(seqNum)
+	m.setMessagePriority(msg.Header)
 
 	// If there are new Create PDRs in the modification, update UE IP
 	if len(msg.CreatePDR) > 0 && ueIP != nil {
-		if err := m.modifyUEIPInCreatePDRs(msg.CreatePDR, ueIP); err != nil {
+		if err := m.modifyUEIPInCreatePDRs(msg.CreatePDR, ueIP); err != nil && !errors.Is(err, ErrNoUEIPAddress) {
 			return fmt.Errorf("failed to modify UE IP in Create PDRs: %w", err)
 		}
 	}
 
 	// Also modify UE IP in Update PDRs if present
 	if len(msg.UpdatePDR) > 0 && ueIP != nil {
-		if err := m.modifyUEIPInCreatePDRs(msg.UpdatePDR, ueIP); err != nil {
+		if err := m.modifyUEIPInCreatePDRs(msg.UpdatePDR, ueIP); err != nil && !errors.Is(err, ErrNoUEIPAddress) {
 			return fmt.Errorf("failed to modify UE IP in Update PDRs: %w", err)
 		}
 	}
@@ -121,6 +260,7 @@ func (m *Modifier) ModifySessionDeletion(
 ) error {
 	msg.Header.SetSEID(remoteSEID)
 	msg.Header.SetSequenceNumber(seqNum)
+	m.setMessagePriority(msg.Header)
 	return nil
 }
 
@@ -130,122 +270,449 @@ func (m *Modifier) ModifyHeartbeat(msg *message.HeartbeatRequest, seqNum uint32)
 	return nil
 }
 
+// ModifyPFDManagement updates the sequence number on a PFD Management Request.
+// PFDs are keyed by Application ID, not by session, so nothing else changes.
+func (m *Modifier) ModifyPFDManagement(msg *message.PFDManagementRequest, seqNum uint32) error {
+	msg.Header.SetSequenceNumber(seqNum)
+	return nil
+}
+
+// NewNodeReportResponse builds the response to a Node Report Request from the
+// UPF, carrying our Node ID and the given Cause.
+func (m *Modifier) NewNodeReportResponse(seqNum uint32, cause uint8) *message.NodeReportResponse {
+	return message.NewNodeReportResponse(seqNum, m.newNodeID(), ie.NewCause(cause), nil)
+}
+
 // modifyUEIPInCreatePDRs finds and replaces UE IP Address IEs within Create/Update PDR IEs.
+// It returns ErrNoUEIPAddress if the PDRs have none.
 func (m *Modifier) modifyUEIPInCreatePDRs(pdrs []*ie.IE, newUEIP net.IP) error {
-	for i, pdr := range pdrs {
-		if pdr == nil {
-			continue
+	var firstErr error
+	found := false
+	_, err := ReplaceIEs(pdrs, ie.UEIPAddress, func(original *ie.IE) *ie.IE {
+		found = true
+		if firstErr != nil {
+			return nil
 		}
-		modified, err := m.modifyUEIPInPDR(pdr, newUEIP)
+		replacement, err := m.createModifiedUEIPIE(original, newUEIP)
 		if err != nil {
-			continue // PDR may not have UE IP, that's OK
-		}
-		if modified != nil {
-			pdrs[i] = modified
+			firstErr = err
+			return nil
 		}
+		return replacement
+	})
+	switch {
+	case firstErr != nil:
+		return firstErr
+	case err != nil:
+		return err
+	case !found:
+		return ErrNoUEIPAddress
 	}
 	return nil
 }
 
-// modifyUEIPInPDR modifies the UE IP Address IE within a single PDR IE.
-func (m *Modifier) modifyUEIPInPDR(pdr *ie.IE, newUEIP net.IP) (*ie.IE, error) {
-	// Get all child IEs from the Create/Update PDR
-	childIEs := pdr.ChildIEs
-	if len(childIEs) == 0 {
+// createModifiedUEIPIE creates a new UE IP Address IE with the allocated IP.
+// It returns nil, leaving the original in place, for an IPv6-only UE IP
+// Address when IPv6 is not stripped.
+func (m *Modifier) createModifiedUEIPIE(original *ie.IE, newUEIP net.IP) (*ie.IE, error) {
+	// Common case: only the IPv4 address changes, which follows the flags
+	// octet, so patch it in a copy of the payload instead of re-encoding
+	if ip4 := newUEIP.To4(); !m.stripIPv6 && ip4 != nil && len(original.Payload) >= 5 {
+		if flags := original.Payload[0]; flags&0x02 != 0 && flags&0x10 == 0 { // V4 set, CHV4 clear
+			payload := append([]byte(nil), original.Payload...)
+			copy(payload[1:5], ip4)
+			return ie.New(ie.UEIPAddress, payload), nil
+		}
+	}
+
+	ueIPFields, err := original.UEIPAddress()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode UE IP Address: %w", err)
+	}
+
+	flags := ueIPFields.Flags
+
+	var replacement *ie.IE
+	switch {
+	case m.stripIPv6:
+		// Strip IPv6: clear V6 flag (bit 0 = 0x01), ensure V4 flag set (bit 1 = 0x02)
+		flags = flags &^ 0x01 // clear V6
+		flags = flags | 0x02  // set V4
+		// Also clear IPv6D flag (bit 3 = 0x08) and IP6PL (bit 6 = 0x40)
+		flags = flags &^ 0x08
+		flags = flags &^ 0x40
+		replacement = ie.NewUEIPAddress(flags, newUEIP.String(), "", 0, 0)
+	case flags&0x02 != 0: // V4 flag set
+		// Preserve original flags, just replace IPv4
+		v6str := ""
+		var v6d, v6pl uint8
+		if flags&0x01 != 0 && ueIPFields.IPv6Address != nil { // V6 flag set
+			v6str = ueIPFields.IPv6Address.String()
+			v6d = ueIPFields.IPv6PrefixDelegationBits
+			v6pl = ueIPFields.IPv6PrefixLength
+		}
+		replacement = ie.NewUEIPAddress(flags, newUEIP.String(), v6str, v6d, v6pl)
+	default:
 		return nil, nil
 	}
 
-	modified := false
-	newChildren := make([]*ie.IE, 0, len(childIEs))
+	if replacement == nil {
+		return nil, fmt.Errorf("failed to encode UE IP Address %s with flags 0x%02x", newUEIP, flags)
+	}
+	return replacement, nil
+}
 
-	for _, child := range childIEs {
-		if child.Type == ie.PDI {
-			// Found PDI - modify UE IP Address within it
-			modifiedPDI, pdiModified := m.modifyUEIPInPDI(child, newUEIP)
-			if pdiModified {
-				newChildren = append(newChildren, modifiedPDI)
-				modified = true
-			} else {
-				newChildren = append(newChildren, child)
+// ModifyNetworkInstances substitutes Network Instance IEs anywhere within the
+// given IE lists (e.g. Create PDR → PDI, Create FAR → Forwarding Parameters)
+// and returns the number of IEs rewritten.
+func (m *Modifier) ModifyNetworkInstances(ieLists ...[]*ie.IE) (int, error) {
+	if m.networkInstanceOverride == "" && len(m.networkInstanceMap) == 0 {
+		return 0, nil
+	}
+
+	count := 0
+	for _, ies := range ieLists {
+		n, err := ReplaceIEs(ies, ie.NetworkInstance, m.rewriteNetworkInstance)
+		count += n
+		if err != nil {
+			return count, fmt.Errorf("failed to modify Network Instance: %w", err)
+		}
+	}
+	return count, nil
+}
+
+// rewriteNetworkInstance returns the substituted Network Instance IE, or nil if
+// the value is not matched. DNS label encoded values are re-encoded the same way.
+func (m *Modifier) rewriteNetworkInstance(original *ie.IE) *ie.IE {
+	name, isFQDN := decodeNetworkInstance(original.Payload)
+
+	replacement := m.networkInstanceOverride
+	if replacement == "" {
+		var ok bool
+		replacement, ok = m.networkInstanceMap[strings.ToLower(name)]
+		if !ok {
+			return nil
+		}
+	}
+	if replacement == name {
+		return nil
+	}
+
+	if isFQDN {
+		return ie.NewNetworkInstanceFQDN(replacement)
+	}
+	return ie.NewNetworkInstance(replacement)
+}
+
+// decodeNetworkInstance returns the Network Instance as a string. Values encoded
+// as DNS labels (APN/DNN format, TS 23.003) are returned in dotted form with
+// isFQDN set.
+func decodeNetworkInstance(b []byte) (name string, isFQDN bool) {
+	var labels []string
+	for i := 0; i < len(b); {
+		n := int(b[i])
+		if n == 0 || i+1+n > len(b) {
+			return string(b), false
+		}
+		labels = append(labels, string(b[i+1:i+1+n]))
+		i += 1 + n
+	}
+	if len(labels) == 0 {
+		return string(b), false
+	}
+	return strings.Join(labels, "."), true
+}
+
+// TEIDMapper returns the TEID to use in place of an original TEID from the pcap.
+type TEIDMapper func(originalTEID uint32) (uint32, error)
+
+// ModifyTEIDs replaces GTP-U TEIDs in F-TEID IEs within Create PDR → PDI and in
+// Outer Header Creation IEs within Create/Update FAR forwarding parameters.
+// F-TEIDs with the CHOOSE flag set are left untouched so the UPF still allocates them.
+func (m *Modifier) ModifyTEIDs(pdrs, fars []*ie.IE, mapTEID TEIDMapper) error {
+	var firstErr error
+	rewrite := func(fn func(*ie.IE, TEIDMapper) (*ie.IE, error)) func(*ie.IE) *ie.IE {
+		return func(original *ie.IE) *ie.IE {
+			if firstErr != nil {
+				return nil
 			}
-		} else {
-			newChildren = append(newChildren, child)
+			replacement, err := fn(original, mapTEID)
+			if err != nil {
+				firstErr = err
+				return nil
+			}
+			return replacement
 		}
 	}
 
-	if !modified {
-		return nil, nil
+	if _, err := ReplaceIEs(pdrs, ie.FTEID, rewrite(rewriteFTEID)); firstErr == nil {
+		firstErr = err
+	}
+	if firstErr != nil {
+		return fmt.Errorf("failed to modify F-TEID in PDR: %w", firstErr)
 	}
 
-	// Rebuild the Create/Update PDR IE with modified children
-	newPDR := ie.NewCreatePDR(newChildren...)
-	newPDR.Type = pdr.Type // Preserve original type (CreatePDR vs UpdatePDR)
-	return newPDR, nil
+	if m.gtpPeerTEIDBase != 0 {
+		return nil // Peer TEIDs are set by ModifyGTPPeer
+	}
+	if _, err := ReplaceIEs(fars, ie.OuterHeaderCreation, rewrite(rewriteOuterHeaderCreation)); firstErr == nil {
+		firstErr = err
+	}
+	if firstErr != nil {
+		return fmt.Errorf("failed to modify Outer Header Creation in FAR: %w", firstErr)
+	}
+
+	return nil
 }
 
-// modifyUEIPInPDI modifies the UE IP Address IE within a PDI IE.
-func (m *Modifier) modifyUEIPInPDI(pdi *ie.IE, newUEIP net.IP) (*ie.IE, bool) {
-	childIEs := pdi.ChildIEs
-	if len(childIEs) == 0 {
-		return pdi, false
+// ModifyGTPPeer rewrites GTP-U Outer Header Creation IEs within the given FAR
+// lists (Create/Update FAR → Forwarding Parameters) to the configured peer.
+// The peer IP replaces the address, switching the description between
+// GTP-U/UDP/IPv4 and GTP-U/UDP/IPv6 to match; with a TEID base, each TEID
+// becomes the base plus the offset of the TEID mapTEID returns for it (mapped
+// TEIDs start at 1). Non-GTP-U descriptions are left untouched. It returns the
+// number of IEs rewritten.
+func (m *Modifier) ModifyGTPPeer(mapTEID TEIDMapper, fars ...[]*ie.IE) (int, error) {
+	if m.gtpPeerIP == nil && m.gtpPeerTEIDBase == 0 {
+		return 0, nil
 	}
 
-	modified := false
-	newChildren := make([]*ie.IE, 0, len(childIEs))
+	var firstErr error
+	count := 0
+	for _, ies := range fars {
+		n, err := ReplaceIEs(ies, ie.OuterHeaderCreation, func(original *ie.IE) *ie.IE {
+			if firstErr != nil {
+				return nil
+			}
+			replacement, err := m.rewriteGTPPeer(original, mapTEID)
+			if err != nil {
+				firstErr = err
+				return nil
+			}
+			return replacement
+		})
+		count += n
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return count, fmt.Errorf("failed to modify Outer Header Creation in FAR: %w", firstErr)
+	}
+	return count, nil
+}
 
-	for _, child := range childIEs {
-		if child.Type == ie.UEIPAddress {
-			newIE := m.createModifiedUEIPIE(child, newUEIP)
-			if newIE != nil {
-				newChildren = append(newChildren, newIE)
-				modified = true
-			} else {
-				newChildren = append(newChildren, child)
+// ModifyApplyActions replaces the Apply Action IEs within the given FAR lists
+// (Create/Update FAR) with the configured override. It returns the number of
+// IEs replaced.
+func (m *Modifier) ModifyApplyActions(fars ...[]*ie.IE) (int, error) {
+	if m.applyAction == nil {
+		return 0, nil
+	}
+
+	count := 0
+	for _, ies := range fars {
+		n, err := ReplaceIEs(ies, ie.ApplyAction, func(*ie.IE) *ie.IE {
+			return ie.NewApplyAction(m.applyAction...)
+		})
+		count += n
+		if err != nil {
+			return count, fmt.Errorf("failed to modify Apply Action: %w", err)
+		}
+	}
+	return count, nil
+}
+
+// ModifyPDRs writes the configured PDR override into the Precedence, Source
+// Interface and Outer Header Removal IEs within the given PDR lists. PDRs
+// without one of these IEs are left without it. It returns the number of IEs
+// replaced.
+func (m *Modifier) ModifyPDRs(pdrs ...[]*ie.IE) (int, error) {
+	o := m.pdrOverride
+	if o.Precedence == nil && o.SourceInterface == nil && o.OuterHeaderRemoval == nil {
+		return 0, nil
+	}
+
+	count := 0
+	for _, ies := range pdrs {
+		n, err := WalkIEs(ies, func(i *ie.IE) (*ie.IE, bool) {
+			switch {
+			case i.Type == ie.Precedence && o.Precedence != nil:
+				return ie.NewPrecedence(*o.Precedence), true
+			case i.Type == ie.SourceInterface && o.SourceInterface != nil:
+				return ie.NewSourceInterface(*o.SourceInterface), true
+			case i.Type == ie.OuterHeaderRemoval && o.OuterHeaderRemoval != nil:
+				// Keep the GTP-U Extension Header Deletion octet, if any
+				var ext uint8
+				if len(i.Payload) > 1 {
+					ext = i.Payload[1]
+				}
+				return ie.NewOuterHeaderRemoval(*o.OuterHeaderRemoval, ext), true
 			}
-		} else {
-			newChildren = append(newChildren, child)
+			return nil, false
+		})
+		count += n
+		if err != nil {
+			return count, fmt.Errorf("failed to apply PDR override: %w", err)
 		}
 	}
+	return count, nil
+}
 
-	if !modified {
-		return pdi, false
+// ModifyQERs writes the configured QER override into the MBR and GBR IEs
+// within the given QER lists (Create/Update QER). A rate not overridden keeps
+// its captured value, and QERs without an MBR or GBR are left without one. It
+// returns the number of IEs replaced.
+func (m *Modifier) ModifyQERs(qers ...[]*ie.IE) (int, error) {
+	o := m.qerOverride
+	overrideMBR := o.MBRUplink != nil || o.MBRDownlink != nil
+	overrideGBR := o.GBRUplink != nil || o.GBRDownlink != nil
+	if !overrideMBR && !overrideGBR {
+		return 0, nil
 	}
 
-	return ie.NewPDI(newChildren...), true
+	var firstErr error
+	count := 0
+	for _, ies := range qers {
+		n, err := WalkIEs(ies, func(i *ie.IE) (*ie.IE, bool) {
+			if firstErr != nil {
+				return nil, false
+			}
+			switch {
+			case i.Type == ie.MBR && overrideMBR:
+				ul, dl, err := bitRates(i)
+				if err != nil {
+					firstErr = fmt.Errorf("failed to parse MBR: %w", err)
+					return nil, false
+				}
+				return ie.NewMBR(rate(o.MBRUplink, ul), rate(o.MBRDownlink, dl)), true
+			case i.Type == ie.GBR && overrideGBR:
+				ul, dl, err := bitRates(i)
+				if err != nil {
+					firstErr = fmt.Errorf("failed to parse GBR: %w", err)
+					return nil, false
+				}
+				return ie.NewGBR(rate(o.GBRUplink, ul), rate(o.GBRDownlink, dl)), true
+			}
+			return nil, false
+		})
+		count += n
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return count, fmt.Errorf("failed to apply QER override: %w", firstErr)
+	}
+	return count, nil
 }
 
-// createModifiedUEIPIE creates a new UE IP Address IE with the allocated IP.
-func (m *Modifier) createModifiedUEIPIE(original *ie.IE, newUEIP net.IP) *ie.IE {
-	ueIPFields, err := original.UEIPAddress()
-	if err != nil {
-		return nil
+// bitRates decodes the uplink and downlink rates of an MBR or GBR IE.
+func bitRates(i *ie.IE) (ul, dl uint64, err error) {
+	if i.Type == ie.GBR {
+		if ul, err = i.GBRUL(); err == nil {
+			dl, err = i.GBRDL()
+		}
+		return ul, dl, err
+	}
+	if ul, err = i.MBRUL(); err == nil {
+		dl, err = i.MBRDL()
 	}
+	return ul, dl, err
+}
 
-	flags := ueIPFields.Flags
+// rate returns the overridden rate, or captured if there is no override.
+func rate(override *uint64, captured uint64) uint64 {
+	if override != nil {
+		return *override
+	}
+	return captured
+}
 
-	if m.stripIPv6 {
-		// Strip IPv6: clear V6 flag (bit 0 = 0x01), ensure V4 flag set (bit 1 = 0x02)
-		flags = flags &^ 0x01 // clear V6
-		flags = flags | 0x02  // set V4
-		// Also clear IPv6D flag (bit 3 = 0x08) and IP6PL (bit 6 = 0x40)
-		flags = flags &^ 0x08
-		flags = flags &^ 0x40
-		return ie.NewUEIPAddress(flags, newUEIP.String(), "", 0, 0)
+// rewriteGTPPeer returns an Outer Header Creation IE pointing at the GTP-U
+// peer, or nil if the description has no GTP-U header.
+func (m *Modifier) rewriteGTPPeer(original *ie.IE, mapTEID TEIDMapper) (*ie.IE, error) {
+	fields, err := original.OuterHeaderCreation()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Outer Header Creation: %w", err)
+	}
+	if !fields.HasTEID() {
+		return nil, nil
 	}
 
-	// Preserve original flags, just replace IPv4
-	if flags&0x02 != 0 { // V4 flag set
-		v6str := ""
-		var v6d, v6pl uint8
-		if flags&0x01 != 0 && ueIPFields.IPv6Address != nil { // V6 flag set
-			v6str = ueIPFields.IPv6Address.String()
-			v6d = ueIPFields.IPv6PrefixDelegationBits
-			v6pl = ueIPFields.IPv6PrefixLength
+	if m.gtpPeerTEIDBase != 0 {
+		teid, err := mapTEID(fields.TEID)
+		if err != nil {
+			return nil, err
 		}
-		return ie.NewUEIPAddress(flags, newUEIP.String(), v6str, v6d, v6pl)
+		fields.TEID = m.gtpPeerTEIDBase + teid - 1
 	}
 
-	return nil
+	if m.gtpPeerIP != nil {
+		const gtpuIPv4, gtpuIPv6 = 0x0100, 0x0200
+		desc := fields.OuterHeaderCreationDescription &^ (gtpuIPv4 | gtpuIPv6)
+		if v4 := m.gtpPeerIP.To4(); v4 != nil {
+			fields.OuterHeaderCreationDescription = desc | gtpuIPv4
+			fields.IPv4Address, fields.IPv6Address = v4, nil
+		} else {
+			fields.OuterHeaderCreationDescription = desc | gtpuIPv6
+			fields.IPv4Address, fields.IPv6Address = nil, m.gtpPeerIP
+		}
+	}
+
+	b, err := fields.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode Outer Header Creation: %w", err)
+	}
+	return ie.New(ie.OuterHeaderCreation, b), nil
+}
+
+// rewriteFTEID returns an F-TEID IE carrying the mapped TEID, or nil if the
+// original asks the UPF to choose the TEID.
+func rewriteFTEID(original *ie.IE, mapTEID TEIDMapper) (*ie.IE, error) {
+	fields, err := original.FTEID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse F-TEID: %w", err)
+	}
+	if fields.HasCh() {
+		return nil, nil
+	}
+
+	teid, err := mapTEID(fields.TEID)
+	if err != nil {
+		return nil, err
+	}
+	fields.TEID = teid
+
+	b, err := fields.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode F-TEID: %w", err)
+	}
+	return ie.New(ie.FTEID, b), nil
+}
+
+// rewriteOuterHeaderCreation returns an Outer Header Creation IE carrying the
+// mapped TEID, or nil if the description has no GTP-U TEID.
+func rewriteOuterHeaderCreation(original *ie.IE, mapTEID TEIDMapper) (*ie.IE, error) {
+	fields, err := original.OuterHeaderCreation()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Outer Header Creation: %w", err)
+	}
+	if !fields.HasTEID() {
+		return nil, nil
+	}
+
+	teid, err := mapTEID(fields.TEID)
+	if err != nil {
+		return nil, err
+	}
+	fields.TEID = teid
+
+	b, err := fields.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode Outer Header Creation: %w", err)
+	}
+	return ie.New(ie.OuterHeaderCreation, b), nil
 }
 
 // ExtractCPSEID extracts the CP SEID from a Session Establishment Request's F-SEID IE.
@@ -272,6 +739,19 @@ func ExtractRemoteSEID(msg *message.SessionEstablishmentResponse) (uint64, error
 	return fseid.SEID, nil
 }
 
+// ExtractNetworkInstance returns the first Network Instance found within the
+// given Create PDRs, or "" if there is none.
+func ExtractNetworkInstance(createPDRs []*ie.IE) string {
+	var name string
+	WalkIEs(createPDRs, func(i *ie.IE) (*ie.IE, bool) {
+		if name == "" && i.Type == ie.NetworkInstance {
+			name, _ = decodeNetworkInstance(i.Payload)
+		}
+		return nil, false
+	})
+	return name
+}
+
 // ExtractHeaderSEID returns the SEID from the PFCP message header.
 func ExtractHeaderSEID(msg message.Message) uint64 {
 	return msg.SEID()
@@ -290,3 +770,25 @@ func ExtractCause(ies []*ie.IE) (uint8, error) {
 	}
 	return 0, fmt.Errorf("no Cause IE found")
 }
+
+// ResponseCause extracts the Cause IE value from a response message. It fails
+// for messages without a Cause IE, such as Heartbeat Responses.
+func ResponseCause(msg message.Message) (uint8, error) {
+	var cause *ie.IE
+	switch msg := msg.(type) {
+	case *message.AssociationSetupResponse:
+		cause = msg.Cause
+	case *message.PFDManagementResponse:
+		cause = msg.Cause
+	case *message.SessionEstablishmentResponse:
+		cause = msg.Cause
+	case *message.SessionModificationResponse:
+		cause = msg.Cause
+	case *message.SessionDeletionResponse:
+		cause = msg.Cause
+	}
+	if cause == nil {
+		return 0, fmt.Errorf("no Cause IE found")
+	}
+	return cause.Cause()
+}
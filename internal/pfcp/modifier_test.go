@@ -0,0 +1,728 @@
+package pfcp
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+)
+
+// offsetMapper maps every TEID to original+offset.
+func offsetMapper(offset uint32) TEIDMapper {
+	return func(originalTEID uint32) (uint32, error) {
+		return originalTEID + offset, nil
+	}
+}
+
+func TestModifier_ModifyTEIDs_RewritesPDRAndFAR(t *testing.T) {
+	m := NewModifier(net.ParseIP("10.0.0.1"), true)
+
+	pdrs := []*ie.IE{
+		ie.NewCreatePDR(
+			ie.NewPDRID(1),
+			ie.NewPDI(
+				ie.NewSourceInterface(ie.SrcInterfaceAccess),
+				ie.NewFTEID(0x01, 0x1111, net.ParseIP("192.168.1.1"), nil, 0),
+			),
+		),
+	}
+	fars := []*ie.IE{
+		ie.NewCreateFAR(
+			ie.NewFARID(1),
+			ie.NewApplyAction(0x02),
+			ie.NewForwardingParameters(
+				ie.NewDestinationInterface(ie.DstInterfaceAccess),
+				ie.NewOuterHeaderCreation(0x0100, 0x2222, "192.168.1.2", "", 0, 0, 0),
+			),
+		),
+		ie.NewUpdateFAR(
+			ie.NewFARID(2),
+			ie.NewUpdateForwardingParameters(
+				ie.NewOuterHeaderCreation(0x0100, 0x3333, "192.168.1.2", "", 0, 0, 0),
+			),
+		),
+	}
+
+	require.NoError(t, m.ModifyTEIDs(pdrs, fars, offsetMapper(0x100000)))
+
+	fteid, err := pdrs[0].ChildIEs[1].FTEID()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0x101111), fteid.TEID)
+	assert.Equal(t, "192.168.1.1", fteid.IPv4Address.String())
+
+	ohc, err := fars[0].ChildIEs[2].OuterHeaderCreation()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0x102222), ohc.TEID)
+
+	ohc, err = fars[1].ChildIEs[1].OuterHeaderCreation()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0x103333), ohc.TEID)
+
+	// Other children must survive the rebuild
+	farID, err := fars[0].FARID()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), farID)
+}
+
+func TestModifier_ModifyTEIDs_PreservesChooseFlag(t *testing.T) {
+	m := NewModifier(net.ParseIP("10.0.0.1"), true)
+
+	// CH=1, V4=1: the UPF allocates the TEID
+	original := ie.NewCreatePDR(
+		ie.NewPDRID(1),
+		ie.NewPDI(
+			ie.NewSourceInterface(ie.SrcInterfaceAccess),
+			ie.NewFTEID(0x05, 0, nil, nil, 0),
+		),
+	)
+	pdrs := []*ie.IE{original}
+
+	called := false
+	err := m.ModifyTEIDs(pdrs, nil, func(uint32) (uint32, error) {
+		called = true
+		return 1, nil
+	})
+	require.NoError(t, err)
+
+	assert.False(t, called, "CHOOSE F-TEID must not be mapped")
+	assert.Same(t, original, pdrs[0])
+}
+
+func TestModifier_ModifyGTPPeer(t *testing.T) {
+	m := NewModifier(net.ParseIP("10.0.0.1"), true)
+	m.SetGTPPeerOverride(net.ParseIP("2001:db8::5"), 0x5000)
+
+	fars := []*ie.IE{
+		ie.NewCreateFAR(
+			ie.NewFARID(1),
+			ie.NewForwardingParameters(
+				ie.NewDestinationInterface(ie.DstInterfaceAccess),
+				ie.NewOuterHeaderCreation(0x0100, 0x2222, "192.168.1.2", "", 0, 0, 0),
+			),
+		),
+		ie.NewCreateFAR(
+			ie.NewFARID(2),
+			ie.NewForwardingParameters(
+				ie.NewDestinationInterface(ie.DstInterfaceCore),
+				ie.NewOuterHeaderCreation(0x0400, 0, "192.168.1.3", "", 2152, 0, 0), // UDP/IPv4, no GTP-U
+			),
+		),
+	}
+
+	// TEIDs are left to ModifyGTPPeer when a TEID base is set
+	require.NoError(t, m.ModifyTEIDs(nil, fars, offsetMapper(0x100000)))
+	n, err := m.ModifyGTPPeer(offsetMapper(1), fars)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	ohc, err := fars[0].ChildIEs[1].OuterHeaderCreation()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(0x0200), ohc.OuterHeaderCreationDescription)
+	assert.Equal(t, uint32(0x5000+0x2222), ohc.TEID)
+	assert.Equal(t, "2001:db8::5", ohc.IPv6Address.String())
+
+	ohc, err = fars[1].ChildIEs[1].OuterHeaderCreation()
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.1.3", ohc.IPv4Address.String())
+}
+
+func TestModifier_ModifyApplyActions(t *testing.T) {
+	m := NewModifier(net.ParseIP("10.0.0.1"), true)
+	fars := []*ie.IE{
+		ie.NewCreateFAR(ie.NewFARID(1), ie.NewApplyAction(0x02)),
+		ie.NewUpdateFAR(ie.NewFARID(2), ie.NewApplyAction(0x02)),
+	}
+
+	// No override leaves the FARs alone
+	n, err := m.ModifyApplyActions(fars)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	m.SetApplyActionOverride([]byte{0x01})
+	n, err = m.ModifyApplyActions(fars)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	for _, far := range fars {
+		assert.True(t, far.HasDROP())
+		assert.False(t, far.HasFORW())
+	}
+}
+
+func TestModifier_ModifyPDRs(t *testing.T) {
+	m := NewModifier(net.ParseIP("10.0.0.1"), true)
+	pdrs := []*ie.IE{
+		ie.NewCreatePDR(
+			ie.NewPDRID(1),
+			ie.NewPrecedence(200),
+			ie.NewPDI(ie.NewSourceInterface(ie.SrcInterfaceAccess)),
+			ie.NewOuterHeaderRemoval(0, 1),
+		),
+		ie.NewCreatePDR(
+			ie.NewPDRID(2),
+			ie.NewPrecedence(100),
+			ie.NewPDI(ie.NewSourceInterface(ie.SrcInterfaceCore)),
+		),
+	}
+
+	// No override leaves the PDRs alone
+	n, err := m.ModifyPDRs(pdrs)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	precedence, source, removal := uint32(32), uint8(ie.SrcInterfaceSGiLANN6LAN), uint8(6)
+	m.SetPDROverride(PDROverride{Precedence: &precedence, SourceInterface: &source, OuterHeaderRemoval: &removal})
+	n, err = m.ModifyPDRs(pdrs)
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+
+	for _, pdr := range pdrs {
+		p, err := pdr.Precedence()
+		require.NoError(t, err)
+		assert.Equal(t, precedence, p)
+		s, err := pdr.ChildIEs[2].SourceInterface()
+		require.NoError(t, err)
+		assert.Equal(t, source, s)
+	}
+	ohr, err := pdrs[0].OuterHeaderRemoval()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{6, 1}, ohr)
+
+	// PDRs without Outer Header Removal do not gain one
+	_, err = pdrs[1].OuterHeaderRemoval()
+	assert.Error(t, err)
+}
+
+func TestModifier_ModifyQERs(t *testing.T) {
+	m := NewModifier(net.ParseIP("10.0.0.1"), true)
+	qers := []*ie.IE{
+		ie.NewCreateQER(
+			ie.NewQERID(1),
+			ie.NewGateStatus(ie.GateStatusOpen, ie.GateStatusOpen),
+			ie.NewMBR(1000, 2000),
+			ie.NewGBR(300, 400),
+		),
+	}
+	updates := []*ie.IE{
+		ie.NewUpdateQER(ie.NewQERID(2), ie.NewMBR(5000, 6000)),
+		ie.NewUpdateQER(ie.NewQERID(3), ie.NewGateStatus(ie.GateStatusClosed, ie.GateStatusClosed)),
+	}
+
+	// No override leaves the QERs alone
+	n, err := m.ModifyQERs(qers, updates)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	mbrUL, gbrDL := uint64(100000), uint64(50000)
+	m.SetQEROverride(QEROverride{MBRUplink: &mbrUL, GBRDownlink: &gbrDL})
+	n, err = m.ModifyQERs(qers, updates)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	// Rates without an override keep their captured values
+	ul, dl, err := bitRates(qers[0].ChildIEs[2])
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{100000, 2000}, []uint64{ul, dl})
+	ul, dl, err = bitRates(qers[0].ChildIEs[3])
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{300, 50000}, []uint64{ul, dl})
+	ul, dl, err = bitRates(updates[0].ChildIEs[1])
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{100000, 6000}, []uint64{ul, dl})
+
+	// QERs without an MBR do not gain one
+	_, err = updates[1].MBR()
+	assert.Error(t, err)
+}
+
+func TestModifier_ModifyGTPPeer_IPOnly(t *testing.T) {
+	m := NewModifier(net.ParseIP("10.0.0.1"), true)
+	m.SetGTPPeerOverride(net.ParseIP("172.16.0.9"), 0)
+
+	fars := []*ie.IE{
+		ie.NewUpdateFAR(
+			ie.NewFARID(2),
+			ie.NewUpdateForwardingParameters(
+				ie.NewOuterHeaderCreation(0x0100, 0x3333, "192.168.1.2", "", 0, 0, 0),
+			),
+		),
+	}
+
+	n, err := m.ModifyGTPPeer(nil, fars)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	ohc, err := fars[0].ChildIEs[1].OuterHeaderCreation()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0x3333), ohc.TEID)
+	assert.Equal(t, "172.16.0.9", ohc.IPv4Address.String())
+}
+
+func TestModifier_ModifyNetworkInstances_Map(t *testing.T) {
+	m := NewModifier(net.ParseIP("10.0.0.1"), true)
+	m.SetNetworkInstanceRewrite("", map[string]string{"Internet": "internet.lab"})
+
+	pdrs := []*ie.IE{
+		ie.NewCreatePDR(
+			ie.NewPDRID(1),
+			ie.NewPDI(
+				ie.NewSourceInterface(ie.SrcInterfaceCore),
+				ie.NewNetworkInstanceFQDN("internet"),
+			),
+		),
+	}
+	fars := []*ie.IE{
+		ie.NewCreateFAR(
+			ie.NewFARID(1),
+			ie.NewForwardingParameters(
+				ie.NewDestinationInterface(ie.DstInterfaceAccess),
+				ie.NewNetworkInstance("access"),
+			),
+		),
+	}
+
+	n, err := m.ModifyNetworkInstances(pdrs, fars)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// DNS label encoding is preserved
+	name, isFQDN := decodeNetworkInstance(pdrs[0].ChildIEs[1].ChildIEs[1].Payload)
+	assert.True(t, isFQDN)
+	assert.Equal(t, "internet.lab", name)
+
+	// Unmatched values are left alone
+	value, err := fars[0].ChildIEs[1].NetworkInstance()
+	require.NoError(t, err)
+	assert.Equal(t, "access", value)
+}
+
+func TestModifier_ModifyNetworkInstances_Override(t *testing.T) {
+	m := NewModifier(net.ParseIP("10.0.0.1"), true)
+	m.SetNetworkInstanceRewrite("dnn1", nil)
+
+	fars := []*ie.IE{
+		ie.NewUpdateFAR(
+			ie.NewFARID(1),
+			ie.NewUpdateForwardingParameters(ie.NewNetworkInstance("access")),
+		),
+	}
+
+	n, err := m.ModifyNetworkInstances(fars)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	ni, err := fars[0].ChildIEs[1].NetworkInstance()
+	require.NoError(t, err)
+	assert.Equal(t, "dnn1", ni)
+}
+
+func TestModifier_ModifyAssociationSetup_NodeID(t *testing.T) {
+	tests := []struct {
+		name     string
+		nodeID   string
+		wantType uint8
+		want     string
+	}{
+		{"smf ip", "", 0, "10.0.0.1"},
+		{"ipv6", "2001:db8::1", 1, "2001:db8::1"},
+		{"fqdn", "smf.example.com", 2, "smf.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewModifier(net.ParseIP("10.0.0.1"), true)
+			m.SetNodeID(tt.nodeID)
+
+			req := message.NewAssociationSetupRequest(1, ie.NewNodeID("192.168.0.1", "", ""))
+			require.NoError(t, m.ModifyAssociationSetup(req, 7))
+
+			assert.Equal(t, tt.wantType, req.NodeID.Payload[0]&0x0f)
+			nodeID, err := req.NodeID.NodeID()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, nodeID)
+		})
+	}
+}
+
+func TestModifier_ModifyAssociationSetup_CPFunctionFeatures(t *testing.T) {
+	m := NewModifier(net.ParseIP("10.0.0.1"), true)
+	req := message.NewAssociationSetupRequest(1, ie.NewNodeID("192.168.0.1", "", ""),
+		ie.NewCPFunctionFeatures(0x01),
+	)
+
+	// Unset keeps the captured value
+	require.NoError(t, m.ModifyAssociationSetup(req, 7))
+	features, err := req.CPFunctionFeatures.CPFunctionFeatures()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x01}, features)
+
+	m.SetCPFunctionFeatures([]byte{0x02, 0x01})
+	require.NoError(t, m.ModifyAssociationSetup(req, 8))
+	features, err = req.CPFunctionFeatures.CPFunctionFeatures()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x02, 0x01}, features)
+}
+
+func TestModifier_ModifySessionEstablishment_FQDNNodeID(t *testing.T) {
+	m := NewModifier(net.ParseIP("10.0.0.1"), true)
+	m.SetNodeID("smf.example.com")
+
+	req := message.NewSessionEstablishmentRequest(0, 0, 1, 1, 0,
+		ie.NewNodeID("192.168.0.1", "", ""),
+		ie.NewFSEID(0x10, net.ParseIP("192.168.0.1"), nil),
+	)
+	require.NoError(t, m.ModifySessionEstablishment(req, 0x20, net.ParseIP("10.45.0.1"), 7))
+
+	nodeID, err := req.NodeID.NodeID()
+	require.NoError(t, err)
+	assert.Equal(t, "smf.example.com", nodeID)
+
+	// The F-SEID still carries the SMF IP
+	fseid, err := req.CPFSEID.FSEID()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0x20), fseid.SEID)
+	assert.True(t, fseid.IPv4Address.Equal(net.ParseIP("10.0.0.1")))
+}
+
+func TestModifier_SetMessagePriority(t *testing.T) {
+	m := NewModifier(net.ParseIP("10.0.0.1"), true)
+
+	// Unset: the captured header is kept
+	req := message.NewSessionDeletionRequest(0, 0, 0x10, 1, 0)
+	require.NoError(t, m.ModifySessionDeletion(req, 0x20, 2))
+	assert.False(t, req.Header.HasMP())
+
+	m.SetMessagePriority(5)
+	req = message.NewSessionDeletionRequest(0, 0, 0x10, 1, 0)
+	require.NoError(t, m.ModifySessionDeletion(req, 0x20, 2))
+
+	// The MP flag and priority survive encoding, and FO stays clear
+	data, err := Encode(req)
+	require.NoError(t, err)
+	decoded, err := Decode(data)
+	require.NoError(t, err)
+	header := decoded.(*message.SessionDeletionRequest).Header
+	assert.True(t, header.HasMP())
+	assert.False(t, header.HasFO())
+	assert.Equal(t, uint8(5), header.MP())
+	assert.Equal(t, uint64(0x20), header.SEID)
+}
+
+// benchEstablishment returns an encoded Session Establishment Request shaped
+// like a typical 5GC session: uplink and downlink PDRs for several QoS flows,
+// each with a UE IP in its PDI, plus their FARs and QERs.
+func benchEstablishment(b *testing.B) []byte {
+	var ies []*ie.IE
+	ies = append(ies,
+		ie.NewNodeID("192.168.0.1", "", ""),
+		ie.NewFSEID(0x10, net.ParseIP("192.168.0.1"), nil),
+	)
+	for flow := uint16(0); flow < 4; flow++ {
+		ul, dl := 2*flow+1, 2*flow+2
+		ies = append(ies,
+			ie.NewCreatePDR(
+				ie.NewPDRID(ul),
+				ie.NewPrecedence(255),
+				ie.NewPDI(
+					ie.NewSourceInterface(ie.SrcInterfaceAccess),
+					ie.NewFTEID(0x01, 0x1000+uint32(ul), net.ParseIP("192.168.1.1"), nil, 0),
+					ie.NewNetworkInstance("internet"),
+					ie.NewUEIPAddress(0x02, "10.60.0.1", "", 0, 0),
+					ie.NewSDFFilter("permit out ip from any to assigned", "", "", "", 0),
+					ie.NewQFI(uint8(flow+1)),
+				),
+				ie.NewOuterHeaderRemoval(0, 0),
+				ie.NewFARID(uint32(ul)),
+				ie.NewQERID(uint32(flow+1)),
+			),
+			ie.NewCreatePDR(
+				ie.NewPDRID(dl),
+				ie.NewPrecedence(255),
+				ie.NewPDI(
+					ie.NewSourceInterface(ie.SrcInterfaceCore),
+					ie.NewNetworkInstance("internet"),
+					ie.NewUEIPAddress(0x06, "10.60.0.1", "", 0, 0),
+					ie.NewSDFFilter("permit out ip from any to assigned", "", "", "", 0),
+				),
+				ie.NewFARID(uint32(dl)),
+				ie.NewQERID(uint32(flow+1)),
+			),
+			ie.NewCreateFAR(
+				ie.NewFARID(uint32(ul)),
+				ie.NewApplyAction(0x02),
+				ie.NewForwardingParameters(
+					ie.NewDestinationInterface(ie.DstInterfaceCore),
+					ie.NewNetworkInstance("internet"),
+				),
+			),
+			ie.NewCreateFAR(
+				ie.NewFARID(uint32(dl)),
+				ie.NewApplyAction(0x02),
+				ie.NewForwardingParameters(
+					ie.NewDestinationInterface(ie.DstInterfaceAccess),
+					ie.NewOuterHeaderCreation(0x0100, 0x2000+uint32(dl), "192.168.1.2", "", 0, 0, 0),
+				),
+			),
+			ie.NewCreateQER(
+				ie.NewQERID(uint32(flow+1)),
+				ie.NewGateStatus(ie.GateStatusOpen, ie.GateStatusOpen),
+				ie.NewMBR(1000000, 1000000),
+				ie.NewQFI(uint8(flow+1)),
+			),
+		)
+	}
+
+	req := message.NewSessionEstablishmentRequest(0, 0, 0, 1, 0, ies...)
+	data := make([]byte, req.MarshalLen())
+	require.NoError(b, req.MarshalTo(data))
+	return data
+}
+
+func BenchmarkModifier_ModifySessionEstablishment(b *testing.B) {
+	m := NewModifier(net.ParseIP("10.0.0.1"), false)
+	data := benchEstablishment(b)
+	ueIP := net.ParseIP("10.45.0.1")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		msg, err := Decode(data)
+		require.NoError(b, err)
+		req := msg.(*message.SessionEstablishmentRequest)
+		b.StartTimer()
+
+		if err := m.ModifySessionEstablishment(req, uint64(i), ueIP, uint32(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestModifier_CreateModifiedUEIPIE_PatchMatchesEncoding(t *testing.T) {
+	m := NewModifier(net.ParseIP("10.0.0.1"), false)
+	newIP := net.ParseIP("10.45.0.7")
+
+	for _, flags := range []uint8{0x02, 0x06} {
+		original := ie.NewUEIPAddress(flags, "10.60.0.1", "", 0, 0)
+		got, err := m.createModifiedUEIPIE(original, newIP)
+		require.NoError(t, err)
+		require.NotNil(t, got)
+		assert.Equal(t, ie.NewUEIPAddress(flags, "10.45.0.7", "", 0, 0).Payload, got.Payload)
+		// The original IE is not modified
+		assert.Equal(t, ie.NewUEIPAddress(flags, "10.60.0.1", "", 0, 0).Payload, original.Payload)
+	}
+}
+
+// establishmentWithPDI returns a Session Establishment Request with one Create
+// PDR whose PDI holds pdi.
+func establishmentWithPDI(fseid *ie.IE, pdi ...*ie.IE) *message.SessionEstablishmentRequest {
+	return message.NewSessionEstablishmentRequest(0, 0, 0, 1, 0,
+		ie.NewNodeID("10.0.0.1", "", ""),
+		fseid,
+		ie.NewCreatePDR(ie.NewPDRID(1), ie.NewPrecedence(100), ie.NewPDI(pdi...)),
+	)
+}
+
+func TestModifier_ModifySessionEstablishment_ReplacesUEIP(t *testing.T) {
+	m := NewModifier(net.ParseIP("192.168.1.10"), false)
+	msg := establishmentWithPDI(ie.NewFSEID(1, net.ParseIP("10.0.0.1"), nil),
+		ie.NewSourceInterface(ie.SrcInterfaceAccess),
+		ie.NewUEIPAddress(0x02, "10.99.0.7", "", 0, 0),
+	)
+
+	require.NoError(t, m.ModifySessionEstablishment(msg, 42, net.ParseIP("10.60.0.1"), 7))
+
+	ueIP, err := msg.CreatePDR[0].UEIPAddress()
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0x02), ueIP.Flags)
+	assert.True(t, ueIP.IPv4Address.Equal(net.ParseIP("10.60.0.1")))
+	// The rest of the PDI is kept
+	src, err := msg.CreatePDR[0].SourceInterface()
+	require.NoError(t, err)
+	assert.Equal(t, ie.SrcInterfaceAccess, src)
+}
+
+func TestModifier_CreateModifiedUEIPIE_StripIPv6(t *testing.T) {
+	m := NewModifier(net.ParseIP("10.0.0.1"), true)
+	// V6, V4, SD, IPv6D and IP6PL
+	original := ie.NewUEIPAddress(0x4f, "10.99.0.7", "2001:db8::1", 8, 64)
+
+	got, err := m.createModifiedUEIPIE(original, net.ParseIP("10.60.0.1"))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	ueIP, err := got.UEIPAddress()
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0x06), ueIP.Flags, "V6, IPv6D and IP6PL cleared, V4 and SD kept")
+	assert.True(t, ueIP.IPv4Address.Equal(net.ParseIP("10.60.0.1")))
+	assert.Nil(t, ueIP.IPv6Address)
+
+	// An IPv6-only UE IP Address becomes IPv4
+	got, err = m.createModifiedUEIPIE(ie.NewUEIPAddress(0x01, "", "2001:db8::1", 0, 0), net.ParseIP("10.60.0.2"))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	ueIP, err = got.UEIPAddress()
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0x02), ueIP.Flags)
+	assert.True(t, ueIP.IPv4Address.Equal(net.ParseIP("10.60.0.2")))
+}
+
+func TestModifier_CreateModifiedUEIPIE_KeepsIPv6(t *testing.T) {
+	m := NewModifier(net.ParseIP("10.0.0.1"), false)
+
+	// Dual stack: the IPv4 address is replaced, the IPv6 address and SD kept
+	got, err := m.createModifiedUEIPIE(ie.NewUEIPAddress(0x07, "10.99.0.7", "2001:db8::1", 0, 0), net.ParseIP("10.60.0.1"))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	ueIP, err := got.UEIPAddress()
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0x07), ueIP.Flags)
+	assert.True(t, ueIP.IPv4Address.Equal(net.ParseIP("10.60.0.1")))
+	assert.True(t, ueIP.IPv6Address.Equal(net.ParseIP("2001:db8::1")))
+
+	// IPv6 only: left alone
+	got, err = m.createModifiedUEIPIE(ie.NewUEIPAddress(0x01, "", "2001:db8::1", 0, 0), net.ParseIP("10.60.0.1"))
+	require.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func TestModifier_ModifySessionEstablishment_PDIWithoutUEIP(t *testing.T) {
+	m := NewModifier(net.ParseIP("192.168.1.10"), true)
+	msg := establishmentWithPDI(ie.NewFSEID(1, net.ParseIP("10.0.0.1"), nil),
+		ie.NewSourceInterface(ie.SrcInterfaceCore),
+		ie.NewNetworkInstance("internet"),
+	)
+	want := append([]byte(nil), msg.CreatePDR[0].Payload...)
+
+	assert.ErrorIs(t, m.modifyUEIPInCreatePDRs(msg.CreatePDR, net.ParseIP("10.60.0.1")), ErrNoUEIPAddress)
+	require.NoError(t, m.ModifySessionEstablishment(msg, 42, net.ParseIP("10.60.0.1"), 7))
+	assert.Equal(t, want, msg.CreatePDR[0].Payload)
+	_, err := msg.CreatePDR[0].UEIPAddress()
+	assert.Error(t, err)
+}
+
+func TestModifier_ModifySessionEstablishment_MalformedUEIP(t *testing.T) {
+	m := NewModifier(net.ParseIP("192.168.1.10"), false)
+	// V4 flag set but the address is cut short
+	msg := establishmentWithPDI(ie.NewFSEID(1, net.ParseIP("10.0.0.1"), nil),
+		ie.New(ie.UEIPAddress, []byte{0x02, 0x0a}),
+	)
+
+	err := m.ModifySessionEstablishment(msg, 42, net.ParseIP("10.60.0.1"), 7)
+	assert.ErrorContains(t, err, "failed to decode UE IP Address")
+}
+
+func TestModifier_ModifySessionEstablishment_FSEIDIPVersion(t *testing.T) {
+	dualStack := func() *ie.IE {
+		return ie.NewFSEID(1, net.ParseIP("10.0.0.1"), net.ParseIP("2001:db8::1"))
+	}
+
+	// Without an SMF IP, both addresses of the original F-SEID are kept
+	msg := establishmentWithPDI(dualStack())
+	require.NoError(t, NewModifier(nil, false).ModifySessionEstablishment(msg, 42, net.ParseIP("10.60.0.1"), 7))
+	fseid, err := msg.CPFSEID.FSEID()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(42), fseid.SEID)
+	assert.True(t, fseid.IPv4Address.Equal(net.ParseIP("10.0.0.1")))
+	assert.True(t, fseid.IPv6Address.Equal(net.ParseIP("2001:db8::1")))
+
+	// An IPv6 SMF IP replaces them with an IPv6-only F-SEID
+	msg = establishmentWithPDI(dualStack())
+	require.NoError(t, NewModifier(net.ParseIP("2001:db8::10"), false).ModifySessionEstablishment(msg, 43, net.ParseIP("10.60.0.1"), 7))
+	fseid, err = msg.CPFSEID.FSEID()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(43), fseid.SEID)
+	assert.Nil(t, fseid.IPv4Address)
+	assert.True(t, fseid.IPv6Address.Equal(net.ParseIP("2001:db8::10")))
+
+	// An IPv4 SMF IP gives an IPv4-only F-SEID
+	msg = establishmentWithPDI(dualStack())
+	require.NoError(t, NewModifier(net.ParseIP("192.168.1.10"), false).ModifySessionEstablishment(msg, 44, net.ParseIP("10.60.0.1"), 7))
+	fseid, err = msg.CPFSEID.FSEID()
+	require.NoError(t, err)
+	assert.True(t, fseid.IPv4Address.Equal(net.ParseIP("192.168.1.10")))
+	assert.Nil(t, fseid.IPv6Address)
+}
+
+func TestModifier_ModifyNetworkInstances_ReportsRebuildFailure(t *testing.T) {
+	m := NewModifier(net.ParseIP("10.0.0.1"), true)
+	// Too long for the PDI and Create PDR around it
+	m.SetNetworkInstanceRewrite(strings.Repeat("a", 0xfff0), nil)
+
+	original := ie.NewCreatePDR(ie.NewPDRID(1), ie.NewPDI(ie.NewSourceInterface(ie.SrcInterfaceCore), ie.NewNetworkInstance("internet")))
+	pdrs := []*ie.IE{original}
+
+	n, err := m.ModifyNetworkInstances(pdrs)
+	assert.ErrorContains(t, err, "failed to modify Network Instance")
+	assert.Zero(t, n)
+	assert.Same(t, original, pdrs[0])
+}
+
+// ieTree lists the types of ies and their descendants in order, with the
+// nesting depth.
+func ieTree(ies []*ie.IE) []string {
+	var tree []string
+	var walk func(ies []*ie.IE, depth int)
+	walk = func(ies []*ie.IE, depth int) {
+		for _, i := range ies {
+			tree = append(tree, strings.Repeat("  ", depth)+IETypeName(i.Type))
+			walk(i.ChildIEs, depth+1)
+		}
+	}
+	walk(ies, 0)
+	return tree
+}
+
+func TestModifier_ModifySessionModification_UpdatePDRRoundTrip(t *testing.T) {
+	m := NewModifier(net.ParseIP("192.168.1.10"), false)
+	original := message.NewSessionModificationRequest(0, 0, 0x20, 1, 0,
+		ie.NewUpdatePDR(
+			ie.NewPDRID(1),
+			ie.NewPrecedence(100),
+			ie.NewPDI(
+				ie.NewSourceInterface(ie.SrcInterfaceAccess),
+				ie.NewNetworkInstance("internet"),
+				ie.NewUEIPAddress(0x02, "10.99.0.7", "", 0, 0),
+				ie.NewEthernetPacketFilter(
+					ie.NewEthernetFilterID(1),
+					ie.NewMACAddress(net.HardwareAddr{0, 1, 2, 3, 4, 5}, nil, nil, nil),
+					ie.NewEthertype(0x0800),
+				),
+				ie.NewSDFFilter("permit out ip from any to assigned", "", "", "", 0),
+			),
+			ie.NewVendorSpecificIE(0x8001, 10415, []byte{0xde, 0xad}),
+			ie.NewFARID(1),
+		),
+	)
+	b, err := original.Marshal()
+	require.NoError(t, err)
+	msg, err := message.ParseSessionModificationRequest(b)
+	require.NoError(t, err)
+	want := ieTree(msg.UpdatePDR)
+
+	require.NoError(t, m.ModifySessionModification(msg, 0x30, net.ParseIP("10.60.0.1"), 2))
+	b, err = msg.Marshal()
+	require.NoError(t, err)
+	got, err := message.ParseSessionModificationRequest(b)
+	require.NoError(t, err)
+
+	// Every child survives in place, and the Update PDR stays an Update PDR
+	require.Len(t, got.UpdatePDR, 1)
+	assert.Equal(t, uint16(ie.UpdatePDR), got.UpdatePDR[0].Type)
+	assert.Equal(t, want, ieTree(got.UpdatePDR))
+	assert.Equal(t, len(original.UpdatePDR[0].Payload), len(got.UpdatePDR[0].Payload))
+
+	pdi := got.UpdatePDR[0].ChildIEs[2]
+	ueIP, err := pdi.UEIPAddress()
+	require.NoError(t, err)
+	assert.True(t, ueIP.IPv4Address.Equal(net.ParseIP("10.60.0.1")))
+	filter := pdi.ChildIEs[3]
+	require.Len(t, filter.ChildIEs, 3)
+	mac, err := filter.ChildIEs[1].MACAddress()
+	require.NoError(t, err)
+	assert.Equal(t, net.HardwareAddr{0, 1, 2, 3, 4, 5}, mac.SourceMACAddress)
+	vendor := got.UpdatePDR[0].ChildIEs[3]
+	assert.Equal(t, uint16(10415), vendor.EnterpriseID)
+	assert.Equal(t, []byte{0xde, 0xad}, vendor.Payload)
+}
@@ -0,0 +1,46 @@
+package pfcp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/wmnsk/go-pfcp/ie"
+)
+
+// interfaceNames maps Source and Destination Interface names (TS 29.244
+// 8.2.2) to their values.
+var interfaceNames = map[string]uint8{
+	"access":      ie.SrcInterfaceAccess,
+	"core":        ie.SrcInterfaceCore,
+	"sgi-lan":     ie.SrcInterfaceSGiLANN6LAN,
+	"n6-lan":      ie.SrcInterfaceSGiLANN6LAN,
+	"cp-function": ie.SrcInterfaceCPFunction,
+}
+
+// outerHeaderRemovalNames maps Outer Header Removal names (TS 29.244 8.2.64)
+// to their descriptions.
+var outerHeaderRemovalNames = map[string]uint8{
+	"gtpu-udp-ipv4": 0,
+	"gtpu-udp-ipv6": 1,
+	"gtpu-udp-ip":   6,
+}
+
+// ParseInterface parses a Source or Destination Interface name: access, core,
+// sgi-lan (or n6-lan) or cp-function, case-insensitively.
+func ParseInterface(name string) (uint8, error) {
+	intf, ok := interfaceNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown interface %q (want access, core, sgi-lan or cp-function)", name)
+	}
+	return intf, nil
+}
+
+// ParseOuterHeaderRemoval parses an Outer Header Removal name: gtpu-udp-ipv4,
+// gtpu-udp-ipv6 or gtpu-udp-ip, case-insensitively.
+func ParseOuterHeaderRemoval(name string) (uint8, error) {
+	desc, ok := outerHeaderRemovalNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown outer header removal %q (want gtpu-udp-ipv4, gtpu-udp-ipv6 or gtpu-udp-ip)", name)
+	}
+	return desc, nil
+}
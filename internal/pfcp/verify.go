@@ -0,0 +1,150 @@
+package pfcp
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+)
+
+// IEPaths counts the IEs of a message by their type path (e.g. "1/2/93" for
+// a UE IP Address within a PDI within a Create PDR).
+type IEPaths map[string]int
+
+// MessageIEPaths returns the IE paths of msg, including children of grouped
+// IEs. Taken before msg is modified, it is what VerifyEncode checks the
+// encoded message against.
+func MessageIEPaths(msg message.Message) IEPaths {
+	return ieCounts(messageIEs(msg))
+}
+
+// VerifyEncode decodes data and checks that it matches msg: message type,
+// sequence number, SEID (for session messages), and the IE tree. It is used
+// to catch modifier regressions where a rebuilt grouped IE no longer carries
+// all of its children.
+//
+// The IE tree is checked against expected, the IE paths of the message before
+// it was modified, so a child dropped by a rebuild is caught even though the
+// modified message no longer has it either. Top-level IEs the modified
+// message adds or no longer has at all (e.g. CP Function Features set by the
+// modifier) follow the modified message. If expected is nil, every IE
+// present in msg must be present in the decoded message and no others.
+func VerifyEncode(msg message.Message, expected IEPaths, data []byte) error {
+	decoded, err := Decode(data)
+	if err != nil {
+		return err
+	}
+
+	if decoded.MessageType() != msg.MessageType() {
+		return fmt.Errorf("message type mismatch: expected %s, decoded %s",
+			MessageTypeName(msg.MessageType()), MessageTypeName(decoded.MessageType()))
+	}
+	if decoded.Sequence() != msg.Sequence() {
+		return fmt.Errorf("sequence number mismatch: expected %d, decoded %d", msg.Sequence(), decoded.Sequence())
+	}
+	if IsSessionMessage(msg) && decoded.SEID() != msg.SEID() {
+		return fmt.Errorf("SEID mismatch: expected %d, decoded %d", msg.SEID(), decoded.SEID())
+	}
+
+	modified := MessageIEPaths(msg)
+	if expected == nil {
+		expected = modified
+	} else {
+		expected = mergeTopLevel(expected, modified)
+	}
+	actual := MessageIEPaths(decoded)
+
+	var diffs []string
+	for path, n := range expected {
+		if actual[path] < n {
+			diffs = append(diffs, fmt.Sprintf("missing %s (expected %d, decoded %d)", path, n, actual[path]))
+		}
+	}
+	for path, n := range actual {
+		if expected[path] < n {
+			diffs = append(diffs, fmt.Sprintf("unexpected %s (expected %d, decoded %d)", path, expected[path], n))
+		}
+	}
+	if len(diffs) > 0 {
+		sort.Strings(diffs)
+		return fmt.Errorf("IE mismatch after encode: %s", strings.Join(diffs, "; "))
+	}
+
+	return nil
+}
+
+// mergeTopLevel returns the paths of original, without the top-level IE types
+// modified has none of, and with the paths of the top-level IE types only
+// modified has.
+func mergeTopLevel(original, modified IEPaths) IEPaths {
+	merged := make(IEPaths, len(original))
+	for path, n := range original {
+		if _, ok := modified[topLevel(path)]; ok {
+			merged[path] = n
+		}
+	}
+	for path, n := range modified {
+		if _, ok := original[topLevel(path)]; !ok {
+			merged[path] = n
+		}
+	}
+	return merged
+}
+
+// topLevel returns the top-level IE type of path.
+func topLevel(path string) string {
+	top, _, _ := strings.Cut(path, "/")
+	return top
+}
+
+// messageIEs returns the top-level IEs of a go-pfcp message by collecting
+// every *ie.IE and []*ie.IE field of its struct.
+func messageIEs(msg message.Message) []*ie.IE {
+	v := reflect.ValueOf(msg)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	ieType := reflect.TypeOf(&ie.IE{})
+	var ies []*ie.IE
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		switch {
+		case f.Type() == ieType:
+			if x, ok := f.Interface().(*ie.IE); ok && x != nil {
+				ies = append(ies, x)
+			}
+		case f.Kind() == reflect.Slice && f.Type().Elem() == ieType:
+			for j := 0; j < f.Len(); j++ {
+				if x, ok := f.Index(j).Interface().(*ie.IE); ok && x != nil {
+					ies = append(ies, x)
+				}
+			}
+		}
+	}
+	return ies
+}
+
+// ieCounts counts IEs by their type path, descending into grouped IEs.
+func ieCounts(ies []*ie.IE) IEPaths {
+	counts := make(IEPaths)
+	var walk func(ies []*ie.IE, prefix string)
+	walk = func(ies []*ie.IE, prefix string) {
+		for _, x := range ies {
+			if x == nil {
+				continue
+			}
+			path := fmt.Sprintf("%s%d", prefix, x.Type)
+			counts[path]++
+			walk(x.ChildIEs, path+"/")
+		}
+	}
+	walk(ies, "")
+	return counts
+}
@@ -0,0 +1,109 @@
+package pfcp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+)
+
+func testEstablishmentRequest() *message.SessionEstablishmentRequest {
+	return message.NewSessionEstablishmentRequest(0, 0, 0, 42, 0,
+		ie.NewNodeID("10.0.0.1", "", ""),
+		ie.NewFSEID(1, net.ParseIP("10.0.0.1"), nil),
+		ie.NewCreatePDR(
+			ie.NewPDRID(1),
+			ie.NewPrecedence(100),
+			ie.NewPDI(
+				ie.NewSourceInterface(ie.SrcInterfaceCore),
+				ie.NewUEIPAddress(0x02, "10.60.0.1", "", 0, 0),
+			),
+			ie.NewFARID(1),
+		),
+		ie.NewCreateFAR(ie.NewFARID(1), ie.NewApplyAction(0x02)),
+	)
+}
+
+func TestVerifyEncode_RoundTripOK(t *testing.T) {
+	req := testEstablishmentRequest()
+	data, err := Encode(req)
+	require.NoError(t, err)
+
+	assert.NoError(t, VerifyEncode(req, nil, data))
+}
+
+func TestVerifyEncode_DetectsPDRChildCountChange(t *testing.T) {
+	req := testEstablishmentRequest()
+	data, err := Encode(req)
+	require.NoError(t, err)
+
+	// The in-memory PDR now has a child the encoded bytes don't carry
+	pdr := req.CreatePDR[0]
+	pdr.ChildIEs = append(pdr.ChildIEs, ie.NewQERID(1))
+
+	err = VerifyEncode(req, nil, data)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "missing")
+}
+
+func TestVerifyEncode_DetectsDroppedNestedChild(t *testing.T) {
+	req := testEstablishmentRequest()
+	data, err := Encode(req)
+	require.NoError(t, err)
+
+	// Drop the UE IP Address from the in-memory PDI: the encoded bytes have one more IE
+	pdi := req.CreatePDR[0].ChildIEs[2]
+	pdi.ChildIEs = pdi.ChildIEs[:1]
+
+	err = VerifyEncode(req, nil, data)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unexpected")
+}
+
+func TestVerifyEncode_DetectsHeaderMismatch(t *testing.T) {
+	req := testEstablishmentRequest()
+	data, err := Encode(req)
+	require.NoError(t, err)
+
+	req.Header.SetSequenceNumber(43)
+	err = VerifyEncode(req, nil, data)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "sequence number")
+}
+
+func TestVerifyEncode_DetectsChildDroppedByRebuild(t *testing.T) {
+	req := testEstablishmentRequest()
+	expected := MessageIEPaths(req)
+
+	// A modifier rebuilds the Create PDR and forgets its PDI: the modified
+	// message and the encoded bytes agree, but the captured message had one
+	req.CreatePDR[0] = ie.NewCreatePDR(ie.NewPDRID(1), ie.NewPrecedence(100), ie.NewFARID(1))
+	data, err := Encode(req)
+	require.NoError(t, err)
+
+	require.NoError(t, VerifyEncode(req, nil, data))
+	err = VerifyEncode(req, expected, data)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "missing 1/2 ")
+	assert.Contains(t, err.Error(), "missing 1/2/93 ")
+}
+
+func TestVerifyEncode_AcceptsTopLevelIEChanges(t *testing.T) {
+	req := message.NewAssociationSetupRequest(7,
+		ie.NewNodeID("10.0.0.1", "", ""),
+		ie.NewRecoveryTimeStamp(time.Now()),
+	)
+	expected := MessageIEPaths(req)
+
+	// The modifier replaces the Node ID and adds CP Function Features
+	req.NodeID = ie.NewNodeID("10.0.0.2", "", "")
+	req.CPFunctionFeatures = ie.NewCPFunctionFeatures(0x01)
+	data, err := Encode(req)
+	require.NoError(t, err)
+
+	assert.NoError(t, VerifyEncode(req, expected, data))
+}
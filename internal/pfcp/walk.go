@@ -0,0 +1,122 @@
+package pfcp
+
+import (
+	"fmt"
+
+	"github.com/wmnsk/go-pfcp/ie"
+)
+
+// maxIELength is the largest value of the 16-bit IE Length field.
+const maxIELength = 0xffff
+
+// IEVisitor inspects an IE during WalkIEs. It returns the replacement IE and
+// true to substitute it, or false to keep the IE and descend into its children.
+type IEVisitor func(i *ie.IE) (*ie.IE, bool)
+
+// WalkIEs visits every IE in ies and, recursively, every child of grouped IEs.
+// Replaced IEs are written back into ies, and each grouped IE above a
+// replacement is rebuilt from its parent's type (and Enterprise ID), so the
+// Length and Payload of every ancestor are re-marshaled. Replacements are not
+// walked. It returns the number of IEs replaced, and an error if a grouped IE
+// could not be rebuilt around its replaced children: that IE is then left as
+// it was, and the walk goes on with the rest.
+func WalkIEs(ies []*ie.IE, visit IEVisitor) (int, error) {
+	_, count, err := walkIEs(ies, visit, false)
+	return count, err
+}
+
+// walkIEs implements WalkIEs. With copyOnWrite, ies is left untouched and the
+// replacements go into a copy made at the first one, so grouped IEs without a
+// replacement below them cost no allocation; otherwise ies is modified in
+// place. It returns the resulting slice, the number of IEs replaced and the
+// first rebuild error.
+func walkIEs(ies []*ie.IE, visit IEVisitor, copyOnWrite bool) ([]*ie.IE, int, error) {
+	out := ies
+	copied := !copyOnWrite
+	count := 0
+	var firstErr error
+	for i, cur := range ies {
+		if cur == nil {
+			continue
+		}
+		replacement, ok := visit(cur)
+		n := 1
+		if !ok {
+			if len(cur.ChildIEs) == 0 {
+				continue
+			}
+			var children []*ie.IE
+			var err error
+			children, n, err = walkIEs(cur.ChildIEs, visit, true)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			if n == 0 {
+				continue
+			}
+			replacement, err = groupedIE(cur.Type, cur.EnterpriseID, children)
+			if err != nil {
+				// Keep the original, with none of the replacements below it
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+		}
+		if !copied {
+			out = append([]*ie.IE(nil), ies...)
+			copied = true
+		}
+		out[i] = replacement
+		count += n
+	}
+	return out, count, firstErr
+}
+
+// groupedIE builds a grouped IE like ie.NewVendorSpecificGroupedIE, but
+// marshals the children into a single payload buffer. It fails if a child
+// fails to marshal or the children do not fit in the IE's Length field.
+func groupedIE(itype, eid uint16, children []*ie.IE) (*ie.IE, error) {
+	kept := children[:0]
+	size := 0
+	for _, child := range children {
+		if child != nil {
+			kept = append(kept, child)
+			size += child.MarshalLen()
+		}
+	}
+
+	limit := maxIELength
+	if itype&0x8000 != 0 {
+		limit -= 2 // Enterprise ID
+	}
+	if size > limit {
+		return nil, fmt.Errorf("failed to rebuild %s: %d bytes of child IEs exceed the IE length limit", IETypeName(itype), size)
+	}
+
+	payload := make([]byte, size)
+	offset := 0
+	for _, child := range kept {
+		if err := child.MarshalTo(payload[offset:]); err != nil {
+			return nil, fmt.Errorf("failed to rebuild %s: %s: %w", IETypeName(itype), IETypeName(child.Type), err)
+		}
+		offset += child.MarshalLen()
+	}
+
+	grouped := ie.NewVendorSpecificIE(itype, eid, payload)
+	grouped.ChildIEs = kept
+	return grouped, nil
+}
+
+// ReplaceIEs replaces every IE of ieType found by WalkIEs. fn returns the
+// replacement, or nil to keep the IE. It returns the number of IEs replaced,
+// and an error as WalkIEs does.
+func ReplaceIEs(ies []*ie.IE, ieType uint16, fn func(*ie.IE) *ie.IE) (int, error) {
+	return WalkIEs(ies, func(i *ie.IE) (*ie.IE, bool) {
+		if i.Type != ieType {
+			return nil, false
+		}
+		replacement := fn(i)
+		return replacement, replacement != nil
+	})
+}
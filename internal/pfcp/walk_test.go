@@ -0,0 +1,148 @@
+package pfcp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+)
+
+func TestWalkIEs_ReplacesNestedIEAndRebuildsParents(t *testing.T) {
+	pdrs := []*ie.IE{
+		ie.NewUpdatePDR(
+			ie.NewPDRID(7),
+			ie.NewPrecedence(100),
+			ie.NewPDI(
+				ie.NewSourceInterface(ie.SrcInterfaceCore),
+				ie.NewUEIPAddress(0x02, "10.0.0.1", "", 0, 0),
+				ie.NewSDFFilter("permit out ip from any to assigned", "", "", "", 0),
+			),
+		),
+	}
+	originalLen := pdrs[0].MarshalLen()
+
+	n, err := ReplaceIEs(pdrs, ie.UEIPAddress, func(*ie.IE) *ie.IE {
+		return ie.NewUEIPAddress(0x02, "10.60.0.5", "", 0, 0)
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	// Parent keeps its type, and the rebuilt bytes parse back to the same tree shape
+	pdr := pdrs[0]
+	assert.Equal(t, uint16(ie.UpdatePDR), pdr.Type)
+	assert.Equal(t, originalLen, pdr.MarshalLen())
+
+	b, err := pdr.Marshal()
+	require.NoError(t, err)
+	parsed, err := ie.Parse(b)
+	require.NoError(t, err)
+
+	children, err := parsed.UpdatePDR()
+	require.NoError(t, err)
+	require.Len(t, children, 3)
+	assert.Equal(t, uint16(ie.PDRID), children[0].Type)
+	assert.Equal(t, uint16(ie.Precedence), children[1].Type)
+	assert.Equal(t, uint16(ie.PDI), children[2].Type)
+
+	pdi, err := children[2].PDI()
+	require.NoError(t, err)
+	require.Len(t, pdi, 3)
+	assert.Equal(t, uint16(ie.SDFFilter), pdi[2].Type)
+
+	ueIP, err := children[2].UEIPAddress()
+	require.NoError(t, err)
+	assert.True(t, ueIP.IPv4Address.Equal(net.ParseIP("10.60.0.5")))
+}
+
+func TestWalkIEs_NoMatchLeavesIEsUntouched(t *testing.T) {
+	original := ie.NewCreatePDR(
+		ie.NewPDRID(1),
+		ie.NewPDI(ie.NewSourceInterface(ie.SrcInterfaceAccess)),
+	)
+	pdrs := []*ie.IE{original, nil}
+
+	n, err := WalkIEs(pdrs, func(*ie.IE) (*ie.IE, bool) { return nil, false })
+	require.NoError(t, err)
+
+	assert.Zero(t, n)
+	assert.Same(t, original, pdrs[0])
+}
+
+func TestWalkIEs_DoesNotDescendIntoReplacement(t *testing.T) {
+	pdrs := []*ie.IE{
+		ie.NewCreatePDR(
+			ie.NewPDRID(1),
+			ie.NewPDI(ie.NewSourceInterface(ie.SrcInterfaceAccess)),
+		),
+	}
+
+	visited := 0
+	n, err := WalkIEs(pdrs, func(i *ie.IE) (*ie.IE, bool) {
+		visited++
+		if i.Type == ie.PDI {
+			return ie.NewPDI(ie.NewSourceInterface(ie.SrcInterfaceCore)), true
+		}
+		return nil, false
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	// CreatePDR, PDRID, PDI; the replacement PDI's children are not visited
+	assert.Equal(t, 3, visited)
+}
+
+func TestWalkIEs_LeavesOriginalGroupedIEsIntact(t *testing.T) {
+	pdi := ie.NewPDI(
+		ie.NewSourceInterface(ie.SrcInterfaceAccess),
+		ie.NewUEIPAddress(0x02, "10.0.0.1", "", 0, 0),
+	)
+	untouched := ie.NewPDI(ie.NewSourceInterface(ie.SrcInterfaceCore))
+	pdr := ie.NewCreatePDR(ie.NewPDRID(1), pdi, untouched)
+	pdrs := []*ie.IE{pdr}
+
+	n, err := ReplaceIEs(pdrs, ie.UEIPAddress, func(*ie.IE) *ie.IE {
+		return ie.NewUEIPAddress(0x02, "10.60.0.5", "", 0, 0)
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	// The original grouped IEs keep their children; only the top-level slice changes
+	assert.False(t, pdr == pdrs[0], "the rebuilt Create PDR replaces the original")
+	ueIP, err := pdi.UEIPAddress()
+	require.NoError(t, err)
+	assert.True(t, ueIP.IPv4Address.Equal(net.ParseIP("10.0.0.1")))
+
+	// A grouped IE without a replacement below it is reused as is
+	assert.Same(t, untouched, pdrs[0].ChildIEs[2])
+}
+
+func TestWalkIEs_ReportsRebuildFailure(t *testing.T) {
+	pdi := ie.NewPDI(ie.NewSourceInterface(ie.SrcInterfaceAccess), ie.NewNetworkInstance("internet"))
+	pdrs := []*ie.IE{
+		ie.NewCreatePDR(ie.NewPDRID(1), pdi),
+		ie.NewCreatePDR(ie.NewPDRID(2), ie.NewPDI(ie.NewUEIPAddress(0x02, "10.0.0.1", "", 0, 0))),
+	}
+	original := pdrs[0]
+
+	// A replacement too long for the PDI's Length field, next to one that fits
+	n, err := WalkIEs(pdrs, func(i *ie.IE) (*ie.IE, bool) {
+		switch i.Type {
+		case ie.NetworkInstance:
+			return ie.New(ie.NetworkInstance, make([]byte, 0xfff0)), true
+		case ie.UEIPAddress:
+			return ie.NewUEIPAddress(0x02, "10.60.0.5", "", 0, 0), true
+		}
+		return nil, false
+	})
+
+	assert.ErrorContains(t, err, "failed to rebuild CreatePDR")
+	assert.Equal(t, 1, n)
+	// The PDR that could not be rebuilt is kept as it was, the other rewritten
+	assert.Same(t, original, pdrs[0])
+	assert.Equal(t, pdi.Payload, pdrs[0].ChildIEs[1].Payload)
+	ueIP, err := pdrs[1].UEIPAddress()
+	require.NoError(t, err)
+	assert.True(t, ueIP.IPv4Address.Equal(net.ParseIP("10.60.0.5")))
+}
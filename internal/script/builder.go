@@ -0,0 +1,251 @@
+package script
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+
+	"pfcp-generator/internal/pfcp"
+	"pfcp-generator/pkg/types"
+)
+
+// defaultNodeID is the scripted SMF address when node_id is not set.
+const defaultNodeID = "127.0.0.1"
+
+// defaultUEIP is the UE IP placeholder when a step sets no ue_ip.
+const defaultUEIP = "0.0.0.0"
+
+// Build encodes the script's requests in order, as if they had been read from
+// a pcap, and returns them with the SEID mappings a pcap's Session
+// Establishment Responses would have provided. The nth session established
+// gets n as both its CP SEID and the UP SEID its later requests are addressed
+// to; a name can be established again once deleted, as a new session.
+func (s *Script) Build() ([]types.RawPFCPMessage, []types.SEIDMapping, error) {
+	nodeID := s.NodeID
+	if nodeID == "" {
+		nodeID = defaultNodeID
+	}
+	nodeIP := net.ParseIP(nodeID)
+	if nodeIP == nil {
+		return nil, nil, fmt.Errorf("node_id must be an IP address, got %q", nodeID)
+	}
+
+	var messages []types.RawPFCPMessage
+	var mappings []types.SEIDMapping
+	sessions := make(map[string]uint64) // Active sessions by name
+	for i, step := range s.Steps {
+		seq := uint32(i + 1)
+
+		var msg message.Message
+		var err error
+		switch step.Type {
+		case "associate":
+			msg = message.NewAssociationSetupRequest(seq, newNodeID(nodeIP), ie.NewRecoveryTimeStamp(time.Now()))
+		case "heartbeat":
+			msg = message.NewHeartbeatRequest(seq, ie.NewRecoveryTimeStamp(time.Now()), nil)
+		case "establish":
+			if step.Session == "" {
+				err = fmt.Errorf("session name is required")
+				break
+			}
+			if _, active := sessions[step.Session]; active {
+				err = fmt.Errorf("session %q is already established", step.Session)
+				break
+			}
+			seid := uint64(len(mappings) + 1)
+			if msg, err = step.establishment(seq, seid, nodeIP); err == nil {
+				sessions[step.Session] = seid
+				mappings = append(mappings, types.SEIDMapping{OriginalCPSEID: seid, OriginalRemoteSEID: seid})
+			}
+		case "modify", "delete":
+			seid, active := sessions[step.Session]
+			if !active {
+				err = fmt.Errorf("session %q is not established", step.Session)
+				break
+			}
+			if step.Type == "modify" {
+				msg, err = step.modification(seq, seid)
+			} else {
+				msg = message.NewSessionDeletionRequest(0, 0, seid, seq, 0)
+				delete(sessions, step.Session)
+			}
+		default:
+			err = fmt.Errorf("unknown type %q (want associate, heartbeat, establish, modify or delete)", step.Type)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("step %d (%s): %w", i+1, step.Type, err)
+		}
+
+		data, err := pfcp.Encode(msg)
+		if err != nil {
+			return nil, nil, fmt.Errorf("step %d (%s): %w", i+1, step.Type, err)
+		}
+		messages = append(messages, types.RawPFCPMessage{Data: data})
+	}
+	return messages, mappings, nil
+}
+
+// establishment builds a Session Establishment Request with CP SEID seid.
+func (s Step) establishment(seq uint32, seid uint64, nodeIP net.IP) (message.Message, error) {
+	if len(s.PDRs) == 0 || len(s.FARs) == 0 {
+		return nil, fmt.Errorf("at least one PDR and one FAR are required")
+	}
+	ueIP := s.UEIP
+	if ueIP == "" {
+		ueIP = defaultUEIP
+	}
+	if net.ParseIP(ueIP).To4() == nil {
+		return nil, fmt.Errorf("ue_ip must be an IPv4 address, got %q", ueIP)
+	}
+
+	ies := []*ie.IE{newNodeID(nodeIP), newFSEID(seid, nodeIP)}
+	pdrs, err := createPDRs(s.PDRs, ueIP)
+	if err != nil {
+		return nil, err
+	}
+	fars, err := farIEs(s.FARs, ie.NewCreateFAR, ie.NewForwardingParameters)
+	if err != nil {
+		return nil, err
+	}
+	ies = append(append(ies, pdrs...), fars...)
+	return message.NewSessionEstablishmentRequest(0, 0, 0, seq, 0, ies...), nil
+}
+
+// modification builds a Session Modification Request addressed to UP SEID seid.
+func (s Step) modification(seq uint32, seid uint64) (message.Message, error) {
+	var ies []*ie.IE
+	for _, id := range s.RemovePDRs {
+		ies = append(ies, ie.NewRemovePDR(ie.NewPDRID(id)))
+	}
+	for _, id := range s.RemoveFARs {
+		ies = append(ies, ie.NewRemoveFAR(ie.NewFARID(id)))
+	}
+
+	ueIP := s.UEIP
+	if ueIP == "" {
+		ueIP = defaultUEIP
+	}
+	pdrs, err := createPDRs(s.PDRs, ueIP)
+	if err != nil {
+		return nil, err
+	}
+	fars, err := farIEs(s.FARs, ie.NewCreateFAR, ie.NewForwardingParameters)
+	if err != nil {
+		return nil, err
+	}
+	updates, err := farIEs(s.UpdateFARs, ie.NewUpdateFAR, ie.NewUpdateForwardingParameters)
+	if err != nil {
+		return nil, err
+	}
+	ies = append(append(append(ies, pdrs...), fars...), updates...)
+	if len(ies) == 0 {
+		return nil, fmt.Errorf("nothing to modify")
+	}
+	return message.NewSessionModificationRequest(0, 0, seid, seq, 0, ies...), nil
+}
+
+// createPDRs builds Create PDR IEs.
+func createPDRs(pdrs []PDR, ueIP string) ([]*ie.IE, error) {
+	var ies []*ie.IE
+	for _, pdr := range pdrs {
+		source, err := pfcp.ParseInterface(pdr.SourceInterface)
+		if err != nil {
+			return nil, fmt.Errorf("PDR %d: source_interface: %w", pdr.ID, err)
+		}
+		if pdr.FARID == 0 {
+			return nil, fmt.Errorf("PDR %d: far_id is required", pdr.ID)
+		}
+
+		pdi := []*ie.IE{ie.NewSourceInterface(source)}
+		switch {
+		case pdr.ChooseTEID:
+			pdi = append(pdi, ie.NewFTEID(0x05, 0, nil, nil, 0)) // CH, V4
+		case pdr.TEID != 0:
+			addr := net.ParseIP(pdr.TEIDAddress).To4()
+			if addr == nil {
+				return nil, fmt.Errorf("PDR %d: teid_address must be an IPv4 address, got %q", pdr.ID, pdr.TEIDAddress)
+			}
+			pdi = append(pdi, ie.NewFTEID(0x01, pdr.TEID, addr, nil, 0))
+		}
+		if pdr.NetworkInstance != "" {
+			pdi = append(pdi, ie.NewNetworkInstance(pdr.NetworkInstance))
+		}
+		if pdr.UEIP {
+			flags := uint8(0x02) // V4
+			if source != ie.SrcInterfaceAccess {
+				flags |= 0x04 // S/D: destination address
+			}
+			pdi = append(pdi, ie.NewUEIPAddress(flags, ueIP, "", 0, 0))
+		}
+
+		fields := []*ie.IE{
+			ie.NewPDRID(pdr.ID),
+			ie.NewPrecedence(pdr.Precedence),
+			ie.NewPDI(pdi...),
+		}
+		if pdr.OuterHeaderRemoval != "" {
+			desc, err := pfcp.ParseOuterHeaderRemoval(pdr.OuterHeaderRemoval)
+			if err != nil {
+				return nil, fmt.Errorf("PDR %d: outer_header_removal: %w", pdr.ID, err)
+			}
+			fields = append(fields, ie.NewOuterHeaderRemoval(desc, 0))
+		}
+		fields = append(fields, ie.NewFARID(pdr.FARID))
+		ies = append(ies, ie.NewCreatePDR(fields...))
+	}
+	return ies, nil
+}
+
+// farIEs builds Create or Update FAR IEs with newFAR, and their forwarding
+// parameters with newParams.
+func farIEs(fars []FAR, newFAR, newParams func(...*ie.IE) *ie.IE) ([]*ie.IE, error) {
+	var ies []*ie.IE
+	for _, far := range fars {
+		fields := []*ie.IE{ie.NewFARID(far.ID)}
+		if far.ApplyAction != "" {
+			flags, err := pfcp.ParseApplyAction(far.ApplyAction)
+			if err != nil {
+				return nil, fmt.Errorf("FAR %d: apply_action: %w", far.ID, err)
+			}
+			fields = append(fields, ie.NewApplyAction(flags...))
+		}
+
+		if far.DestinationInterface != "" {
+			dest, err := pfcp.ParseInterface(far.DestinationInterface)
+			if err != nil {
+				return nil, fmt.Errorf("FAR %d: destination_interface: %w", far.ID, err)
+			}
+			params := []*ie.IE{ie.NewDestinationInterface(dest)}
+			if far.NetworkInstance != "" {
+				params = append(params, ie.NewNetworkInstance(far.NetworkInstance))
+			}
+			if ohc := far.OuterHeaderCreation; ohc != nil {
+				addr := net.ParseIP(ohc.Address).To4()
+				if addr == nil {
+					return nil, fmt.Errorf("FAR %d: outer_header_creation.address must be an IPv4 address, got %q", far.ID, ohc.Address)
+				}
+				params = append(params, ie.NewOuterHeaderCreation(0x0100, ohc.TEID, addr.String(), "", 0, 0, 0)) // GTP-U/UDP/IPv4
+			}
+			fields = append(fields, newParams(params...))
+		}
+		ies = append(ies, newFAR(fields...))
+	}
+	return ies, nil
+}
+
+func newNodeID(ip net.IP) *ie.IE {
+	if ip.To4() != nil {
+		return ie.NewNodeID(ip.String(), "", "")
+	}
+	return ie.NewNodeID("", ip.String(), "")
+}
+
+func newFSEID(seid uint64, ip net.IP) *ie.IE {
+	if ip.To4() != nil {
+		return ie.NewFSEID(seid, ip, nil)
+	}
+	return ie.NewFSEID(seid, nil, ip)
+}
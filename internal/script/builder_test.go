@@ -0,0 +1,146 @@
+package script
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+
+	"pfcp-generator/internal/pfcp"
+	"pfcp-generator/pkg/types"
+)
+
+const flow = `
+node_id: 10.0.0.1
+steps:
+  - type: associate
+  - type: establish
+    session: internet
+    pdrs:
+      - id: 1
+        precedence: 200
+        source_interface: access
+        choose_teid: true
+        ue_ip: true
+        outer_header_removal: gtpu-udp-ipv4
+        far_id: 1
+      - id: 2
+        precedence: 200
+        source_interface: core
+        network_instance: internet
+        ue_ip: true
+        far_id: 2
+    fars:
+      - id: 1
+        apply_action: FORW
+        destination_interface: core
+      - id: 2
+        apply_action: BUFF,NOCP
+  - type: modify
+    session: internet
+    update_fars:
+      - id: 2
+        apply_action: FORW
+        destination_interface: access
+        outer_header_creation: {teid: 0x100, address: 192.168.1.2}
+  - type: delete
+    session: internet
+`
+
+// find returns the first IE of type typ below i, depth first.
+func find(i *ie.IE, typ uint16) *ie.IE {
+	for _, child := range i.ChildIEs {
+		if child.Type == typ {
+			return child
+		}
+		if found := find(child, typ); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func TestScript_Build(t *testing.T) {
+	s, err := Parse([]byte(flow))
+	require.NoError(t, err)
+	messages, mappings, err := s.Build()
+	require.NoError(t, err)
+	require.Len(t, messages, 4)
+	assert.Equal(t, []types.SEIDMapping{{OriginalCPSEID: 1, OriginalRemoteSEID: 1}}, mappings)
+
+	decoded := make([]message.Message, len(messages))
+	for i, raw := range messages {
+		decoded[i], err = pfcp.Decode(raw.Data)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, message.MsgTypeAssociationSetupRequest, decoded[0].MessageType())
+
+	est, ok := decoded[1].(*message.SessionEstablishmentRequest)
+	require.True(t, ok)
+	cpSEID, err := pfcp.ExtractCPSEID(est)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), cpSEID)
+	require.Len(t, est.CreatePDR, 2)
+	require.Len(t, est.CreateFAR, 2)
+
+	// Uplink PDR: UPF-allocated F-TEID, UE IP as source address
+	fteid, err := find(est.CreatePDR[0], ie.FTEID).FTEID()
+	require.NoError(t, err)
+	assert.True(t, fteid.HasCh())
+	ueIP, err := find(est.CreatePDR[0], ie.UEIPAddress).UEIPAddress()
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0x02), ueIP.Flags)
+	removal, err := find(est.CreatePDR[0], ie.OuterHeaderRemoval).OuterHeaderRemovalDescription()
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0), removal)
+
+	// Downlink PDR: UE IP as destination address
+	ueIP, err = find(est.CreatePDR[1], ie.UEIPAddress).UEIPAddress()
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0x06), ueIP.Flags)
+
+	action, err := find(est.CreateFAR[1], ie.ApplyAction).ApplyAction()
+	require.NoError(t, err)
+	assert.Equal(t, []uint8{0x0c}, action)
+
+	// Later requests are addressed to the session's UP SEID
+	mod, ok := decoded[2].(*message.SessionModificationRequest)
+	require.True(t, ok)
+	assert.Equal(t, uint64(1), mod.SEID())
+	require.Len(t, mod.UpdateFAR, 1)
+	ohc, err := find(mod.UpdateFAR[0], ie.OuterHeaderCreation).OuterHeaderCreation()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0x100), ohc.TEID)
+
+	assert.Equal(t, message.MsgTypeSessionDeletionRequest, decoded[3].MessageType())
+	assert.Equal(t, uint64(1), pfcp.ExtractHeaderSEID(decoded[3]))
+}
+
+func TestScript_BuildErrors(t *testing.T) {
+	tests := map[string]string{
+		`steps: [{type: modify, session: a, remove_pdrs: [1]}]`: `step 1 (modify): session "a" is not established`,
+		`steps: [{type: establish, session: a}]`:                "at least one PDR and one FAR are required",
+		`steps: [{type: teardown}]`:                             `unknown type "teardown"`,
+		`steps: [{type: establish, session: a, pdrs: [{id: 1, far_id: 1, source_interface: radio}], fars: [{id: 1}]}]`:                     `unknown interface "radio"`,
+		`steps: [{type: establish, session: a, pdrs: [{id: 1, far_id: 1, source_interface: access}], fars: [{id: 1, apply_action: FLY}]}]`: "FAR 1: apply_action",
+	}
+	for script, want := range tests {
+		s, err := Parse([]byte(script))
+		require.NoError(t, err, script)
+		_, _, err = s.Build()
+		assert.ErrorContains(t, err, want, script)
+	}
+
+	// Misspelled fields are rejected when parsing
+	_, err := Parse([]byte(`steps: [{type: establish, sesion: a}]`))
+	assert.ErrorContains(t, err, "field sesion not found")
+}
+
+func TestLoad_SampleScript(t *testing.T) {
+	s, err := Load("../../test/testdata/sample-script.yaml")
+	require.NoError(t, err)
+	_, _, err = s.Build()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,108 @@
+package script
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Script describes a PFCP flow declaratively: the requests an SMF would send
+// to the UPF, in order. It is an alternative to a pcap for users without a
+// capture. YAML and JSON are both accepted.
+type Script struct {
+	// Node ID and F-SEID address of the scripted SMF; the replay replaces them
+	// with the configured SMF address like those of a pcap
+	NodeID string `yaml:"node_id"`
+
+	Steps []Step `yaml:"steps"`
+}
+
+// Step is one request of the flow. Type selects the request; the other fields
+// apply to the types noted on them.
+type Step struct {
+	// associate, heartbeat, establish, modify or delete
+	Type string `yaml:"type"`
+
+	// Name of the session to establish, modify or delete
+	Session string `yaml:"session"`
+
+	// UE IP Address placed in PDRs with ue_ip (establish); the replay allocates
+	// a UE IP from the pool in its place
+	UEIP string `yaml:"ue_ip"`
+
+	// Create PDRs and FARs (establish, modify)
+	PDRs []PDR `yaml:"pdrs"`
+	FARs []FAR `yaml:"fars"`
+
+	// Update FARs, and PDRs and FARs to remove by ID (modify)
+	UpdateFARs []FAR    `yaml:"update_fars"`
+	RemovePDRs []uint16 `yaml:"remove_pdrs"`
+	RemoveFARs []uint32 `yaml:"remove_fars"`
+}
+
+// PDR describes a Create PDR.
+type PDR struct {
+	ID              uint16 `yaml:"id"`
+	Precedence      uint32 `yaml:"precedence"`
+	SourceInterface string `yaml:"source_interface"` // access, core, sgi-lan or cp-function
+	NetworkInstance string `yaml:"network_instance"`
+
+	// Local F-TEID: teid and teid_address, or choose_teid to let the UPF allocate it
+	TEID        uint32 `yaml:"teid"`
+	TEIDAddress string `yaml:"teid_address"`
+	ChooseTEID  bool   `yaml:"choose_teid"`
+
+	// Match the UE IP: as source address on access, as destination otherwise
+	UEIP bool `yaml:"ue_ip"`
+
+	// gtpu-udp-ipv4, gtpu-udp-ipv6 or gtpu-udp-ip; empty for none
+	OuterHeaderRemoval string `yaml:"outer_header_removal"`
+
+	FARID uint32 `yaml:"far_id"`
+}
+
+// FAR describes a Create or Update FAR.
+type FAR struct {
+	ID uint32 `yaml:"id"`
+
+	// Flag names as for session.apply_action_override, e.g. FORW or BUFF,NOCP
+	ApplyAction string `yaml:"apply_action"`
+
+	// Forwarding parameters, omitted when destination_interface is empty
+	DestinationInterface string       `yaml:"destination_interface"` // access, core, sgi-lan or cp-function
+	NetworkInstance      string       `yaml:"network_instance"`
+	OuterHeaderCreation  *OuterHeader `yaml:"outer_header_creation"`
+}
+
+// OuterHeader describes a GTP-U Outer Header Creation.
+type OuterHeader struct {
+	TEID    uint32 `yaml:"teid"`
+	Address string `yaml:"address"`
+}
+
+// Load reads a script file. Unknown fields are rejected so that typos are
+// reported instead of ignored.
+func Load(filename string) (*Script, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read script file: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse decodes a script from YAML or JSON.
+func Parse(data []byte) (*Script, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	var s Script
+	if err := decoder.Decode(&s); err != nil {
+		return nil, fmt.Errorf("failed to parse script: %w", err)
+	}
+	if len(s.Steps) == 0 {
+		return nil, fmt.Errorf("script has no steps")
+	}
+	return &s, nil
+}
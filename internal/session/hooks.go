@@ -0,0 +1,63 @@
+package session
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/wmnsk/go-pfcp/message"
+
+	"pfcp-generator/internal/pfcp"
+	"pfcp-generator/pkg/types"
+)
+
+// Hooks lets library users run their own code on the messages the Manager
+// exchanges with the UPF, e.g. to rewrite IEs the modifier does not handle or
+// to assert on responses. Either function may be nil.
+//
+// Hooks are called from whichever goroutine sends the message or waits for
+// its response: the replay, the heartbeat loop, session cleanup, which
+// deletes sessions in concurrent batches, and the response handler, which
+// answers the Heartbeat, Node Report and Session Report Requests of the UPF.
+// They must therefore be safe for concurrent use, and should return quickly,
+// since they delay the sender.
+type Hooks struct {
+	// BeforeSend is called with every message after the Manager's own
+	// modifications and before it is encoded, so changes made to msg are
+	// sent (or printed, in dry-run mode). Returning an error drops the
+	// message, which is reported as failed to process.
+	BeforeSend func(msg message.Message) error
+
+	// AfterResponse is called with each request that was answered, its
+	// response and the response time. resp is nil if the response could not
+	// be decoded. It is not called for requests that timed out.
+	AfterResponse func(req, resp message.Message, rt time.Duration)
+}
+
+// SetHooks installs hooks, replacing any installed before. It must be called
+// before the replay starts.
+func (m *Manager) SetHooks(hooks Hooks) {
+	m.hooks = hooks
+}
+
+// beforeSend runs the BeforeSend hook, if any, on msg.
+func (m *Manager) beforeSend(msg message.Message) error {
+	if m.hooks.BeforeSend == nil {
+		return nil
+	}
+	if err := m.hooks.BeforeSend(msg); err != nil {
+		return fmt.Errorf("BeforeSend hook: %w", err)
+	}
+	return nil
+}
+
+// afterResponse runs the AfterResponse hook, if any, on an answered request.
+func (m *Manager) afterResponse(req message.Message, result types.TransactionResult) {
+	if m.hooks.AfterResponse == nil || result.Error != nil {
+		return
+	}
+	resp, err := pfcp.Decode(result.Response)
+	if err != nil {
+		resp = nil
+	}
+	m.hooks.AfterResponse(req, resp, result.ResponseTime)
+}
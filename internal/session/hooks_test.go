@@ -0,0 +1,68 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+
+	"pfcp-generator/internal/network"
+	"pfcp-generator/internal/stats"
+)
+
+func TestManager_Hooks(t *testing.T) {
+	cfg := testConfig()
+	cfg.Timing.ResponseTimeoutMs = 1000
+
+	upf := &acceptingUPF{}
+	upf.tracker = network.NewTransactionTracker(upf, cfg.Timing.ResponseTimeoutMs, 0)
+	mgr, err := NewManager(cfg, upf, nil, upf.tracker, stats.NewCollector(), nil, nil)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var responses []uint8
+	mgr.SetHooks(Hooks{
+		// Tag every establishment with a User ID, and drop deletions
+		BeforeSend: func(msg message.Message) error {
+			switch req := msg.(type) {
+			case *message.SessionEstablishmentRequest:
+				req.UserID = ie.NewUserID(0x08, "", "", "", "user@example.com")
+			case *message.SessionDeletionRequest:
+				return errors.New("deletions disabled")
+			}
+			return nil
+		},
+		AfterResponse: func(req, resp message.Message, rt time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			require.NotNil(t, resp)
+			assert.Equal(t, req.Sequence(), resp.Sequence())
+			assert.Greater(t, rt, time.Duration(0))
+			responses = append(responses, resp.MessageType())
+		},
+	})
+
+	messages, mappings := repeatPcap(t)
+	mgr.SetSEIDMappings(mappings)
+	require.NoError(t, mgr.Replay(context.Background(), messages[1:]))
+
+	msgTypes, _ := sentRequests(t, upf)
+	assert.Equal(t, []uint8{message.MsgTypeSessionEstablishmentRequest, message.MsgTypeSessionEstablishmentRequest}, msgTypes)
+	for _, sent := range upf.sent {
+		msg, err := message.Parse(sent)
+		require.NoError(t, err)
+		req := msg.(*message.SessionEstablishmentRequest)
+		require.NotNil(t, req.UserID)
+		userID, err := req.UserID.UserID()
+		require.NoError(t, err)
+		assert.Equal(t, "user@example.com", userID.NAI)
+	}
+	assert.Equal(t, []uint8{message.MsgTypeSessionEstablishmentResponse, message.MsgTypeSessionEstablishmentResponse}, responses)
+	assert.Equal(t, 2, mgr.ActiveSessionCount())
+}
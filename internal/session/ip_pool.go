@@ -1,16 +1,52 @@
 package session
 
 import (
+	"encoding/binary"
 	"fmt"
+	"hash/fnv"
 	"net"
 	"sync"
 )
 
-// UEIPPool manages allocation of UE IP addresses from a CIDR range.
+// IPAllocator allocates the UE IP addresses of new sessions, and takes back
+// those of deleted ones. Allocate must never return an address that is still
+// allocated. Implementations must be safe for concurrent use.
+type IPAllocator interface {
+	Allocate() (net.IP, error)
+	Release(ip net.IP)
+	Available() int
+}
+
+// keyedIPAllocator is implemented by IP allocators that can derive a
+// session's address from its original CP SEID.
+type keyedIPAllocator interface {
+	AllocateFor(key uint64) (net.IP, error)
+}
+
+// ipSeeder is implemented by IP allocators whose allocations can be saved to
+// and restored from the session state file.
+type ipSeeder interface {
+	Allocated() []net.IP
+	Seed(ips []net.IP)
+}
+
+// allocateUEIP allocates an address from pool for the session whose original
+// CP SEID is key.
+func allocateUEIP(pool IPAllocator, key uint64) (net.IP, error) {
+	if keyed, ok := pool.(keyedIPAllocator); ok {
+		return keyed.AllocateFor(key)
+	}
+	return pool.Allocate()
+}
+
+// UEIPPool is the built-in IPAllocator: it allocates UE IP addresses from a
+// CIDR range.
 type UEIPPool struct {
+	strategy  string
 	cidr      *net.IPNet
 	nextIP    net.IP
 	allocated map[string]bool
+	excluded  map[string]bool // never allocated, e.g. gateway addresses
 	mu        sync.Mutex
 }
 
@@ -21,16 +57,167 @@ func NewUEIPPool(cidr string) (*UEIPPool, error) {
 		return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
 	}
 
-	// Start from first usable address (network address + 1)
-	firstIP := make(net.IP, len(ipnet.IP))
-	copy(firstIP, ipnet.IP)
-	incrementIP(firstIP)
-
-	return &UEIPPool{
+	p := &UEIPPool{
+		strategy:  "sequential",
 		cidr:      ipnet,
-		nextIP:    firstIP,
 		allocated: make(map[string]bool),
-	}, nil
+		excluded:  make(map[string]bool),
+	}
+	p.nextIP = p.firstIP()
+	return p, nil
+}
+
+// pointToPoint reports whether the pool is a /31 or /32 (or the IPv6
+// equivalents), which have no network or broadcast address: every address in
+// the CIDR is usable.
+func (p *UEIPPool) pointToPoint() bool {
+	ones, bits := p.cidr.Mask.Size()
+	return bits-ones <= 1
+}
+
+// firstIP returns the first usable address: the network address + 1, or the
+// network address itself for point-to-point pools.
+func (p *UEIPPool) firstIP() net.IP {
+	if p.pointToPoint() {
+		return ipAtOffset(p.cidr.IP, 0)
+	}
+	return ipAtOffset(p.cidr.IP, 1)
+}
+
+// rangeSize returns the number of addresses allocation cycles through, from
+// firstIP to the end of the CIDR. Very large IPv6 pools are capped to 2^32.
+func (p *UEIPPool) rangeSize() uint64 {
+	ones, bits := p.cidr.Mask.Size()
+	hostBits := bits - ones
+	if hostBits > 32 {
+		hostBits = 32 // plenty of addresses to spread sessions over
+	}
+	if p.pointToPoint() {
+		return uint64(1) << hostBits
+	}
+	return uint64(1)<<hostBits - 1 // excluding the network address
+}
+
+// Contains reports whether ip is within the pool's CIDR.
+func (p *UEIPPool) Contains(ip net.IP) bool {
+	return p.cidr.Contains(ip)
+}
+
+// Exclude removes addresses from the pool so they are never allocated. Each
+// entry is an IP address or a CIDR block within the pool.
+func (p *UEIPPool) Exclude(entries []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, entry := range entries {
+		if ip := net.ParseIP(entry); ip != nil {
+			if !p.cidr.Contains(ip) {
+				return fmt.Errorf("excluded address %s is outside the pool %s", entry, p.cidr)
+			}
+			p.excluded[ip.String()] = true
+			continue
+		}
+
+		_, block, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("invalid excluded address or CIDR %q", entry)
+		}
+		blockOnes, _ := block.Mask.Size()
+		poolOnes, _ := p.cidr.Mask.Size()
+		if !p.cidr.Contains(block.IP) || blockOnes < poolOnes {
+			return fmt.Errorf("excluded block %s is outside the pool %s", entry, p.cidr)
+		}
+		ip := make(net.IP, len(block.IP))
+		copy(ip, block.IP)
+		for ; block.Contains(ip); incrementIP(ip) {
+			p.excluded[ip.String()] = true
+		}
+	}
+	return nil
+}
+
+// SetSkipBroadcast excludes the pool's broadcast (last) address from
+// allocation. Point-to-point pools have no broadcast address.
+func (p *UEIPPool) SetSkipBroadcast(skip bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pointToPoint() {
+		return
+	}
+	broadcast := p.broadcast().String()
+	if skip {
+		p.excluded[broadcast] = true
+	} else {
+		delete(p.excluded, broadcast)
+	}
+}
+
+// broadcast returns the last address of the pool's CIDR.
+func (p *UEIPPool) broadcast() net.IP {
+	ip := make(net.IP, len(p.cidr.IP))
+	for i := range ip {
+		ip[i] = p.cidr.IP[i] | ^p.cidr.Mask[i]
+	}
+	return ip
+}
+
+// isFree reports whether ipStr can be allocated. Callers must hold p.mu.
+func (p *UEIPPool) isFree(ipStr string) bool {
+	return !p.allocated[ipStr] && !p.excluded[ipStr]
+}
+
+// SetStrategy selects how AllocateFor picks addresses: "sequential" hands out
+// the next free address, "deterministic" derives the address from the session
+// key so the same key maps to the same address on every run.
+func (p *UEIPPool) SetStrategy(strategy string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.strategy = strategy
+}
+
+// AllocateFor returns an IP address for the session identified by key (the
+// original CP SEID). With the deterministic strategy the address is at an
+// offset into the CIDR given by a hash of key, probing forward on collisions;
+// otherwise it is the same as Allocate.
+func (p *UEIPPool) AllocateFor(key uint64) (net.IP, error) {
+	p.mu.Lock()
+	strategy := p.strategy
+	p.mu.Unlock()
+
+	switch strategy {
+	case "sequential":
+		return p.Allocate()
+	case "deterministic":
+		return p.allocateDeterministic(key)
+	default:
+		return nil, fmt.Errorf("unknown UE IP strategy: %s", strategy)
+	}
+}
+
+// allocateDeterministic allocates the first free address at or after the
+// hashed offset of key, wrapping within the CIDR. The network address is
+// never used, matching Allocate.
+func (p *UEIPPool) allocateDeterministic(key uint64) (net.IP, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	first := p.firstIP()
+	size := p.rangeSize()
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], key)
+	h := fnv.New64a()
+	h.Write(buf[:])
+	offset := h.Sum64() % size
+
+	for i := uint64(0); i < size; i++ {
+		ip := ipAtOffset(first, (offset+i)%size)
+		if ipStr := ip.String(); p.isFree(ipStr) {
+			p.allocated[ipStr] = true
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("UE IP pool exhausted (all %d addresses allocated)", len(p.allocated))
 }
 
 // Allocate returns the next available IP address from the pool.
@@ -40,29 +227,24 @@ func (p *UEIPPool) Allocate() (net.IP, error) {
 
 	// Ensure nextIP is within CIDR before starting
 	if !p.cidr.Contains(p.nextIP) {
-		copy(p.nextIP, p.cidr.IP)
-		incrementIP(p.nextIP)
+		p.nextIP = p.firstIP()
 	}
 
 	startIP := make(net.IP, len(p.nextIP))
 	copy(startIP, p.nextIP)
-	checked := 0
-
-	// Calculate total usable IPs in the CIDR
-	ones, bits := p.cidr.Mask.Size()
-	totalIPs := 1 << (bits - ones)
+	var checked uint64
+	size := p.rangeSize()
 
 	for {
 		ipStr := p.nextIP.String()
-		if !p.allocated[ipStr] {
+		if p.isFree(ipStr) {
 			p.allocated[ipStr] = true
 			result := make(net.IP, len(p.nextIP))
 			copy(result, p.nextIP)
 			incrementIP(p.nextIP)
 			// Wrap if needed for next call
 			if !p.cidr.Contains(p.nextIP) {
-				copy(p.nextIP, p.cidr.IP)
-				incrementIP(p.nextIP)
+				p.nextIP = p.firstIP()
 			}
 			return result, nil
 		}
@@ -72,12 +254,11 @@ func (p *UEIPPool) Allocate() (net.IP, error) {
 
 		// Wrap around if we've gone past the end
 		if !p.cidr.Contains(p.nextIP) {
-			copy(p.nextIP, p.cidr.IP)
-			incrementIP(p.nextIP)
+			p.nextIP = p.firstIP()
 		}
 
 		// If we've checked all IPs in the range, the pool is exhausted
-		if checked >= totalIPs-1 || p.nextIP.Equal(startIP) {
+		if checked >= size || p.nextIP.Equal(startIP) {
 			return nil, fmt.Errorf("UE IP pool exhausted (all %d addresses allocated)", len(p.allocated))
 		}
 	}
@@ -103,13 +284,40 @@ func (p *UEIPPool) Available() int {
 	defer p.mu.Unlock()
 	ones, bits := p.cidr.Mask.Size()
 	total := 1 << (bits - ones)
-	avail := total - len(p.allocated) - 2 // subtract network and broadcast
+
+	// Every address of a /31 or /32 is usable
+	if p.pointToPoint() {
+		return total - len(p.allocated) - len(p.excluded)
+	}
+
+	// The network address is never allocated and always subtracted below; the
+	// broadcast address is only left out when excluded, e.g. by skip_broadcast
+	excluded := len(p.excluded)
+	if p.excluded[p.cidr.IP.String()] {
+		excluded--
+	}
+
+	avail := total - len(p.allocated) - excluded - 1 // subtract network
 	if avail < 0 {
 		return 0
 	}
 	return avail
 }
 
+// ipAtOffset returns base plus offset.
+func ipAtOffset(base net.IP, offset uint64) net.IP {
+	ip := make(net.IP, len(base))
+	copy(ip, base)
+	var carry uint64
+	for i := len(ip) - 1; i >= 0 && (offset > 0 || carry > 0); i-- {
+		sum := uint64(ip[i]) + offset&0xFF + carry
+		ip[i] = byte(sum)
+		carry = sum >> 8
+		offset >>= 8
+	}
+	return ip
+}
+
 func incrementIP(ip net.IP) {
 	for i := len(ip) - 1; i >= 0; i-- {
 		ip[i]++
@@ -118,3 +326,26 @@ func incrementIP(ip net.IP) {
 		}
 	}
 }
+
+// Allocated returns the currently allocated IPs in no particular order.
+func (p *UEIPPool) Allocated() []net.IP {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ips := make([]net.IP, 0, len(p.allocated))
+	for ipStr := range p.allocated {
+		ips = append(ips, net.ParseIP(ipStr))
+	}
+	return ips
+}
+
+// Seed marks ips as allocated, e.g. to restore allocations saved by a previous
+// run. IPs outside the pool's CIDR are ignored.
+func (p *UEIPPool) Seed(ips []net.IP) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, ip := range ips {
+		if ip != nil && p.cidr.Contains(ip) {
+			p.allocated[ip.String()] = true
+		}
+	}
+}
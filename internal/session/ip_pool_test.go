@@ -21,8 +21,9 @@ func TestUEIPPool_InvalidCIDR(t *testing.T) {
 }
 
 func TestUEIPPool_Allocate_Sequential(t *testing.T) {
-	pool, err := NewUEIPPool("10.60.0.0/24")
+	cidrPool, err := NewUEIPPool("10.60.0.0/24")
 	require.NoError(t, err)
+	var pool IPAllocator = cidrPool
 
 	ip1, err := pool.Allocate()
 	require.NoError(t, err)
@@ -73,8 +74,9 @@ func TestUEIPPool_Exhaustion(t *testing.T) {
 }
 
 func TestUEIPPool_Release_AllowsReallocation(t *testing.T) {
-	pool, err := NewUEIPPool("10.60.0.0/30")
+	cidrPool, err := NewUEIPPool("10.60.0.0/30")
 	require.NoError(t, err)
+	var pool IPAllocator = cidrPool
 
 	ip1, err := pool.Allocate()
 	require.NoError(t, err)
@@ -100,15 +102,40 @@ func TestUEIPPool_Release_AllowsReallocation(t *testing.T) {
 }
 
 func TestUEIPPool_Available_Count(t *testing.T) {
-	pool, err := NewUEIPPool("10.60.0.0/24")
+	cidrPool, err := NewUEIPPool("10.60.0.0/24")
 	require.NoError(t, err)
+	var pool IPAllocator = cidrPool
 
-	// /24 = 256 total, minus network and broadcast = 254
-	assert.Equal(t, 254, pool.Available())
+	// /24 = 256 total, minus network = 255
+	assert.Equal(t, 255, pool.Available())
 
 	_, err = pool.Allocate()
 	require.NoError(t, err)
-	assert.Equal(t, 253, pool.Available())
+	assert.Equal(t, 254, pool.Available())
+}
+
+func TestUEIPPool_Available_Broadcast(t *testing.T) {
+	pool, err := NewUEIPPool("10.0.0.0/30")
+	require.NoError(t, err)
+
+	// The broadcast address is handed out unless skip_broadcast is set
+	assert.Equal(t, 3, pool.Available())
+
+	var got []string
+	for {
+		ip, err := pool.Allocate()
+		if err != nil {
+			break
+		}
+		got = append(got, ip.String())
+	}
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, got)
+	assert.Equal(t, 0, pool.Available())
+
+	skipping, err := NewUEIPPool("10.0.0.0/30")
+	require.NoError(t, err)
+	skipping.SetSkipBroadcast(true)
+	assert.Equal(t, 2, skipping.Available())
 }
 
 func TestUEIPPool_ConcurrentAccess(t *testing.T) {
@@ -161,3 +188,127 @@ func TestUEIPPool_Release_UnknownIP(t *testing.T) {
 	pool.Release(net.ParseIP("10.60.0.99"))
 	assert.Equal(t, 0, pool.AllocatedCount())
 }
+
+func TestUEIPPool_Deterministic_StableAcrossPools(t *testing.T) {
+	pool1, err := NewUEIPPool("10.60.0.0/16")
+	require.NoError(t, err)
+	pool1.SetStrategy("deterministic")
+	pool2, err := NewUEIPPool("10.60.0.0/16")
+	require.NoError(t, err)
+	pool2.SetStrategy("deterministic")
+
+	// Allocation order must not matter
+	a1, err := pool1.AllocateFor(1001)
+	require.NoError(t, err)
+	b1, err := pool1.AllocateFor(2002)
+	require.NoError(t, err)
+	b2, err := pool2.AllocateFor(2002)
+	require.NoError(t, err)
+	a2, err := pool2.AllocateFor(1001)
+	require.NoError(t, err)
+
+	assert.Equal(t, a1.String(), a2.String())
+	assert.Equal(t, b1.String(), b2.String())
+	assert.NotEqual(t, a1.String(), b1.String())
+}
+
+func TestUEIPPool_Deterministic_ProbesAndExhausts(t *testing.T) {
+	// /30: .1, .2 and .3 are usable, as with sequential allocation
+	pool, err := NewUEIPPool("10.60.0.0/30")
+	require.NoError(t, err)
+	pool.SetStrategy("deterministic")
+
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		ip, err := pool.AllocateFor(42)
+		require.NoError(t, err)
+		assert.NotEqual(t, "10.60.0.0", ip.String())
+		assert.False(t, seen[ip.String()], "duplicate %s", ip)
+		seen[ip.String()] = true
+	}
+
+	_, err = pool.AllocateFor(42)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "exhausted")
+}
+
+func TestUEIPPool_Exclude_NeverAllocated(t *testing.T) {
+	pool, err := NewUEIPPool("10.60.0.0/28")
+	require.NoError(t, err)
+	require.NoError(t, pool.Exclude([]string{"10.60.0.1", "10.60.0.8/30"}))
+	pool.SetSkipBroadcast(true)
+
+	// 16 addresses - network - broadcast - 5 excluded
+	assert.Equal(t, 9, pool.Available())
+
+	var got []string
+	for {
+		ip, err := pool.Allocate()
+		if err != nil {
+			break
+		}
+		got = append(got, ip.String())
+	}
+	assert.Equal(t, []string{
+		"10.60.0.2", "10.60.0.3", "10.60.0.4", "10.60.0.5", "10.60.0.6",
+		"10.60.0.7", "10.60.0.12", "10.60.0.13", "10.60.0.14",
+	}, got)
+	assert.Equal(t, 0, pool.Available())
+}
+
+func TestUEIPPool_Exclude_Invalid(t *testing.T) {
+	pool, err := NewUEIPPool("10.60.0.0/24")
+	require.NoError(t, err)
+
+	assert.Error(t, pool.Exclude([]string{"10.61.0.1"}))
+	assert.Error(t, pool.Exclude([]string{"10.60.0.0/16"}))
+	assert.Error(t, pool.Exclude([]string{"gateway"}))
+}
+
+func TestUEIPPool_HostRoute(t *testing.T) {
+	pool, err := NewUEIPPool("10.60.0.5/32")
+	require.NoError(t, err)
+	assert.Equal(t, 1, pool.Available())
+
+	ip, err := pool.Allocate()
+	require.NoError(t, err)
+	assert.Equal(t, "10.60.0.5", ip.String())
+	assert.Equal(t, 0, pool.Available())
+
+	_, err = pool.Allocate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "exhausted")
+
+	pool.Release(ip)
+	ip, err = pool.Allocate()
+	require.NoError(t, err)
+	assert.Equal(t, "10.60.0.5", ip.String())
+}
+
+func TestUEIPPool_PointToPoint(t *testing.T) {
+	pool, err := NewUEIPPool("10.60.0.4/31")
+	require.NoError(t, err)
+	pool.SetSkipBroadcast(true) // no broadcast address in a /31
+	assert.Equal(t, 2, pool.Available())
+
+	ip1, err := pool.Allocate()
+	require.NoError(t, err)
+	ip2, err := pool.Allocate()
+	require.NoError(t, err)
+	assert.Equal(t, "10.60.0.4", ip1.String())
+	assert.Equal(t, "10.60.0.5", ip2.String())
+	assert.Equal(t, 0, pool.Available())
+
+	_, err = pool.Allocate()
+	assert.Error(t, err)
+
+	// Deterministic allocation uses the same two addresses
+	det, err := NewUEIPPool("10.60.0.4/31")
+	require.NoError(t, err)
+	det.SetStrategy("deterministic")
+	for i := 0; i < 2; i++ {
+		ip, err := det.AllocateFor(7)
+		require.NoError(t, err)
+		assert.True(t, ip.Equal(ip1) || ip.Equal(ip2), ip.String())
+	}
+}
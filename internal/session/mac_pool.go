@@ -0,0 +1,96 @@
+package session
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+
+	"pfcp-generator/internal/pfcp"
+)
+
+// UEMACPool manages allocation of UE MAC addresses for Ethernet PDU sessions
+// from a prefix such as 02:00:00:00:00:00/24.
+type UEMACPool struct {
+	base      uint64
+	size      uint64 // addresses in the prefix
+	next      uint64 // offset of the next address to try
+	allocated map[uint64]bool
+	mu        sync.Mutex
+}
+
+// NewUEMACPool creates a UE MAC pool from a prefix (see pfcp.ParseMACPool).
+// Addresses are handed out in order from the base address + 1.
+func NewUEMACPool(spec string) (*UEMACPool, error) {
+	base, prefix, err := pfcp.ParseMACPool(spec)
+	if err != nil {
+		return nil, err
+	}
+	size := uint64(1) << (48 - prefix)
+	return &UEMACPool{
+		base:      macToUint64(base) &^ (size - 1),
+		size:      size,
+		next:      1,
+		allocated: make(map[uint64]bool),
+	}, nil
+}
+
+// Allocate returns the next free MAC address from the pool.
+func (p *UEMACPool) Allocate() (net.HardwareAddr, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// The base address itself is never handed out
+	for checked := uint64(1); checked < p.size; checked++ {
+		offset := p.next
+		p.next++
+		if p.next >= p.size {
+			p.next = 1
+		}
+		if addr := p.base + offset; !p.allocated[addr] {
+			p.allocated[addr] = true
+			return uint64ToMAC(addr), nil
+		}
+	}
+	return nil, fmt.Errorf("UE MAC pool exhausted (all %d addresses allocated)", len(p.allocated))
+}
+
+// Release frees a previously allocated MAC address back to the pool.
+func (p *UEMACPool) Release(mac net.HardwareAddr) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.allocated, macToUint64(mac))
+}
+
+// Seed marks MAC addresses as allocated, e.g. restored from a state file, so
+// they are not handed out again. Addresses outside the pool are ignored.
+func (p *UEMACPool) Seed(macs []net.HardwareAddr) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, mac := range macs {
+		if addr := macToUint64(mac); addr&^(p.size-1) == p.base {
+			p.allocated[addr] = true
+		}
+	}
+}
+
+// AllocatedCount returns the number of currently allocated MAC addresses.
+func (p *UEMACPool) AllocatedCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.allocated)
+}
+
+// macToUint64 returns a 48-bit MAC address as an integer.
+func macToUint64(mac net.HardwareAddr) uint64 {
+	var b [8]byte
+	copy(b[2:], mac)
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// uint64ToMAC returns the 48-bit MAC address held in v.
+func uint64ToMAC(v uint64) net.HardwareAddr {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return net.HardwareAddr(b[2:])
+}
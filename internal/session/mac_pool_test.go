@@ -0,0 +1,47 @@
+package session
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUEMACPool_AllocateAndRelease(t *testing.T) {
+	pool, err := NewUEMACPool("02:00:00:00:00:00/40")
+	require.NoError(t, err)
+
+	// 256 addresses in a /40, the base itself never allocated
+	var macs []string
+	for i := 0; i < 255; i++ {
+		mac, err := pool.Allocate()
+		require.NoError(t, err)
+		macs = append(macs, mac.String())
+	}
+	assert.Equal(t, "02:00:00:00:00:01", macs[0])
+	assert.Equal(t, "02:00:00:00:00:ff", macs[254])
+	_, err = pool.Allocate()
+	assert.ErrorContains(t, err, "UE MAC pool exhausted")
+
+	pool.Release(net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02})
+	mac, err := pool.Allocate()
+	require.NoError(t, err)
+	assert.Equal(t, "02:00:00:00:00:02", mac.String())
+	assert.Equal(t, 255, pool.AllocatedCount())
+}
+
+func TestUEMACPool_Seed(t *testing.T) {
+	pool, err := NewUEMACPool("02:00:00:00:00:00/24")
+	require.NoError(t, err)
+
+	pool.Seed([]net.HardwareAddr{
+		{0x02, 0, 0, 0, 0, 0x01},
+		{0x04, 0, 0, 0, 0, 0x02}, // outside the pool
+	})
+	assert.Equal(t, 1, pool.AllocatedCount())
+
+	mac, err := pool.Allocate()
+	require.NoError(t, err)
+	assert.Equal(t, "02:00:00:00:00:02", mac.String())
+}
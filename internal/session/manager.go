@@ -2,9 +2,15 @@ package session
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -18,18 +24,57 @@ import (
 	"pfcp-generator/pkg/types"
 )
 
+// ErrAssociationFailed is returned by the replay when Association Setup fails
+// after all retries and association.on_setup_failure is "abort".
+var ErrAssociationFailed = errors.New("association setup failed")
+
+// errNoResponse marks cleanup deletions that got no response in time.
+var errNoResponse = errors.New("no response")
+
 // Manager orchestrates the PFCP session replay workflow.
 type Manager struct {
 	cfg        *config.Config
-	client     *network.UDPClient
+	client     network.Transport
 	receiver   *network.Receiver
 	tracker    *network.TransactionTracker
 	modifier   *pfcp.Modifier
-	seidAlloc  *SEIDAllocator
-	ipPool     *UEIPPool
+	seidAlloc  SEIDStrategy
+	teidAlloc  *TEIDAllocator
+	ipPool     IPAllocator
+	dnnPools   map[string]*UEIPPool // by lower-case Network Instance
+	macPool    *UEMACPool           // nil unless session.ue_mac_pool is set
 	stats      *stats.Collector
 	seqCounter *SequenceCounter
 
+	// Decode and compare every encoded message before sending
+	verifyEncode bool
+
+	// Per-transaction events (nil = disabled)
+	events *stats.EventWriter
+
+	// Library users' code run on sent messages and received responses
+	hooks Hooks
+
+	// Run the modification pipeline and print messages instead of sending them
+	dryRun       bool
+	dryRunOutput DryRunOutput
+	out          io.Writer
+
+	// How often replay progress is logged (0 = never)
+	progressInterval time.Duration
+
+	// Set once the stats.warmup_sec warm-up has been started
+	warmupStarted atomic.Bool
+
+	// Level of the per-message logs, such as "Sent Session Establishment
+	// Request": Info, or Debug without logging.per_message
+	messageLogLevel log.Level
+
+	// Request types to replay (nil = all) and to skip, from input.include_types
+	// and input.exclude_types
+	includeTypes map[uint8]bool
+	excludeTypes map[uint8]bool
+
 	// Session mappings
 	byOriginalCPSEID     map[uint64]*types.SessionInfo
 	byOriginalRemoteSEID map[uint64]*types.SessionInfo
@@ -38,42 +83,204 @@ type Manager struct {
 
 	// Original SEID mappings from pcap (CP SEID → remote SEID)
 	originalSEIDMappings map[uint64]uint64
+
+	// Our Recovery Time Stamp, sent in periodic heartbeats (guarded by mu)
+	recoveryTime time.Time
+
+	// UPF restart detection: the last Recovery Time Stamp seen from the UPF and,
+	// with reconnect_on_restart, the sessions to re-establish (guarded by mu)
+	upfRecoveryTime time.Time
+	lostSessions    []uint64
+	restartPending  atomic.Bool
+
+	// Unmodified requests kept for re-establishment after a UPF restart
+	// (replay goroutine only). An establishment request is dropped once its
+	// session fails, is deleted or is forgotten.
+	associationRequest    []byte
+	establishmentRequests map[uint64][]byte
 }
 
+// DryRunOutput selects what dry-run mode prints for each message.
+type DryRunOutput int
+
+const (
+	// DryRunSummary prints one line per message.
+	DryRunSummary DryRunOutput = iota
+	// DryRunVerbose adds the IE tree of the modified message.
+	DryRunVerbose
+	// DryRunDiff adds a diff of the original and modified IE trees.
+	DryRunDiff
+)
+
 // SequenceCounter manages PFCP sequence numbers.
 type SequenceCounter struct {
 	current uint32
 	mu      sync.Mutex
+
+	// inUse reports sequence numbers still held by a pending transaction
+	inUse func(seqNum uint32) bool
+}
+
+// maxSequence is the largest 24-bit PFCP sequence number.
+const maxSequence = 0xFFFFFF
+
+// SetInUse registers a function reporting whether a sequence number is still
+// awaiting its response; Next skips such numbers after wrapping around.
+func (s *SequenceCounter) SetInUse(fn func(seqNum uint32) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inUse = fn
 }
 
-// Next returns the next sequence number (24-bit, wraps at 0xFFFFFF).
+// Next returns the next sequence number (24-bit, wraps at 0xFFFFFF), skipping
+// numbers still in use by a pending transaction.
 func (s *SequenceCounter) Next() uint32 {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.current++
-	if s.current > 0xFFFFFF {
-		s.current = 1
+	for skipped := 0; ; skipped++ {
+		s.current++
+		if s.current > maxSequence {
+			s.current = 1
+		}
+		if s.inUse == nil || !s.inUse(s.current) || skipped == maxSequence {
+			return s.current
+		}
+		log.WithField("seq_num", s.current).Debug("Sequence number still pending, skipping")
 	}
-	return s.current
 }
 
-// NewManager creates a new session manager.
+// NewManager creates a new session manager. client, receiver and tracker are
+// not used in dry-run mode and may be nil.
+//
+// seidAlloc allocates the local SEIDs of new sessions; if nil, the built-in
+// strategy selected by session.seid_strategy is used. The state file only
+// keeps the SEIDs of a strategy that implements Allocated() []uint64 and
+// Seed([]uint64), like the built-in ones.
+//
+// ueIPAlloc allocates the UE IP addresses of new sessions; if nil, the
+// built-in pool of session.ue_ip_pool is used. The pools of
+// session.ue_ip_pools still serve their DNNs. The allocator's
+// AllocateFor(key uint64) (net.IP, error) is used instead of Allocate if it
+// has one, with the session's original CP SEID as key, and the state file
+// only keeps its addresses if it implements Allocated() []net.IP and
+// Seed([]net.IP), like UEIPPool.
 func NewManager(
 	cfg *config.Config,
-	client *network.UDPClient,
+	client network.Transport,
 	receiver *network.Receiver,
 	tracker *network.TransactionTracker,
 	statsCollector *stats.Collector,
+	seidAlloc SEIDStrategy,
+	ueIPAlloc IPAllocator,
 ) (*Manager, error) {
 	smfIP := net.ParseIP(cfg.SMF.Address)
-	seidAlloc := NewSEIDAllocator(cfg.Session.SEIDStrategy, cfg.Session.SEIDStart)
+	var err error
+	if seidAlloc == nil {
+		seidAlloc, err = NewSEIDStrategy(cfg.Session.SEIDStrategy, cfg.Session.SEIDStart, cfg.Session.SEIDRangeEnd)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	ipPool, err := NewUEIPPool(cfg.Session.UEIPPool)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create UE IP pool: %w", err)
+	if ueIPAlloc == nil {
+		pool, err := newUEIPPool(cfg, cfg.Session.UEIPPool)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create UE IP pool: %w", err)
+		}
+		ueIPAlloc = pool
+	}
+	dnnPools := make(map[string]*UEIPPool)
+	for dnn, cidr := range cfg.Session.UEIPPools {
+		pool, err := newUEIPPool(cfg, cidr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create UE IP pool for %s: %w", dnn, err)
+		}
+		dnnPools[strings.ToLower(dnn)] = pool
+	}
+
+	var macPool *UEMACPool
+	if cfg.Session.UEMACPool != "" {
+		if macPool, err = NewUEMACPool(cfg.Session.UEMACPool); err != nil {
+			return nil, fmt.Errorf("failed to create UE MAC pool: %w", err)
+		}
 	}
 
 	modifier := pfcp.NewModifier(smfIP, cfg.Session.StripIPv6)
+	modifier.SetNodeID(cfg.SMF.NodeID)
+	modifier.SetNetworkInstanceRewrite(cfg.Session.NetworkInstanceOverride, cfg.Session.NetworkInstanceMap)
+	if cfg.Association.CPFunctionFeatures != "" {
+		features, err := pfcp.ParseCPFunctionFeatures(cfg.Association.CPFunctionFeatures)
+		if err != nil {
+			return nil, fmt.Errorf("invalid association.cp_function_features: %w", err)
+		}
+		modifier.SetCPFunctionFeatures(features)
+	}
+	modifier.SetGTPPeerOverride(net.ParseIP(cfg.Session.GTPPeerOverride.IP), cfg.Session.GTPPeerOverride.TEIDBase)
+	if cfg.Session.ApplyActionOverride != "" {
+		applyAction, err := pfcp.ParseApplyAction(cfg.Session.ApplyActionOverride)
+		if err != nil {
+			return nil, fmt.Errorf("invalid session.apply_action_override: %w", err)
+		}
+		modifier.SetApplyActionOverride(applyAction)
+	}
+	if o := cfg.Session.PDROverride; o.IsSet() {
+		override := pfcp.PDROverride{Precedence: o.Precedence}
+		if o.SourceInterface != "" {
+			source, err := pfcp.ParseInterface(o.SourceInterface)
+			if err != nil {
+				return nil, fmt.Errorf("invalid session.pdr_override.source_interface: %w", err)
+			}
+			override.SourceInterface = &source
+		}
+		if o.OuterHeaderRemoval != "" {
+			removal, err := pfcp.ParseOuterHeaderRemoval(o.OuterHeaderRemoval)
+			if err != nil {
+				return nil, fmt.Errorf("invalid session.pdr_override.outer_header_removal: %w", err)
+			}
+			override.OuterHeaderRemoval = &removal
+		}
+		modifier.SetPDROverride(override)
+	}
+	if o := cfg.Session.QEROverride; o.IsSet() {
+		modifier.SetQEROverride(pfcp.QEROverride{
+			MBRUplink:   o.MBRUplink,
+			MBRDownlink: o.MBRDownlink,
+			GBRUplink:   o.GBRUplink,
+			GBRDownlink: o.GBRDownlink,
+		})
+	}
+	if cfg.Session.SetMessagePriority {
+		modifier.SetMessagePriority(cfg.Session.MessagePriority)
+	}
+
+	var includeTypes map[uint8]bool
+	if len(cfg.Input.IncludeTypes) > 0 {
+		if includeTypes, err = pfcp.ParseMessageTypes(cfg.Input.IncludeTypes); err != nil {
+			return nil, fmt.Errorf("invalid input.include_types: %w", err)
+		}
+	}
+	excludeTypes, err := pfcp.ParseMessageTypes(cfg.Input.ExcludeTypes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid input.exclude_types: %w", err)
+	}
+
+	// Count retransmissions under the request's message type, and never reuse
+	// the sequence number of a pending transaction
+	seqCounter := &SequenceCounter{}
+	if tracker != nil {
+		tracker.SetRetransmitHandler(func(msgType uint8) {
+			statsCollector.RecordRetransmit(pfcp.MessageTypeName(msgType))
+		})
+		tracker.SetUnexpectedResponseHandler(func(msgType uint8) {
+			statsCollector.RecordUnexpectedResponse(pfcp.MessageTypeName(msgType))
+		})
+		seqCounter.SetInUse(tracker.IsPending)
+	}
+
+	messageLogLevel := log.InfoLevel
+	if !cfg.Logging.PerMessage {
+		messageLogLevel = log.DebugLevel
+	}
 
 	return &Manager{
 		cfg:                   cfg,
@@ -82,66 +289,302 @@ func NewManager(
 		tracker:               tracker,
 		modifier:              modifier,
 		seidAlloc:             seidAlloc,
-		ipPool:                ipPool,
+		teidAlloc:             NewTEIDAllocator(1),
+		ipPool:                ueIPAlloc,
+		dnnPools:              dnnPools,
+		macPool:               macPool,
 		stats:                 statsCollector,
-		seqCounter:            &SequenceCounter{},
-		byOriginalCPSEID:     make(map[uint64]*types.SessionInfo),
-		byOriginalRemoteSEID: make(map[uint64]*types.SessionInfo),
-		byLocalSEID:          make(map[uint64]*types.SessionInfo),
-		originalSEIDMappings: make(map[uint64]uint64),
+		seqCounter:            seqCounter,
+		out:                   os.Stdout,
+		messageLogLevel:       messageLogLevel,
+		includeTypes:          includeTypes,
+		excludeTypes:          excludeTypes,
+		byOriginalCPSEID:      make(map[uint64]*types.SessionInfo),
+		byOriginalRemoteSEID:  make(map[uint64]*types.SessionInfo),
+		byLocalSEID:           make(map[uint64]*types.SessionInfo),
+		originalSEIDMappings:  make(map[uint64]uint64),
+		recoveryTime:          time.Now(),
+		establishmentRequests: make(map[uint64][]byte),
 	}, nil
 }
 
+// SetVerifyEncode enables decoding every encoded message and comparing it
+// against the modified message before it is sent. The IEs of messages from
+// the pcap are compared against the captured message, so IEs a BeforeSend
+// hook adds to or removes from a grouped IE fail the check.
+func (m *Manager) SetVerifyEncode(enabled bool) {
+	m.verifyEncode = enabled
+}
+
+// SetProgressInterval makes Replay and ReplayStream log how many messages
+// have been sent every interval. 0 disables progress logging.
+func (m *Manager) SetProgressInterval(interval time.Duration) {
+	m.progressInterval = interval
+}
+
+// SetEventWriter enables writing an event for every request sent to the UPF.
+func (m *Manager) SetEventWriter(w *stats.EventWriter) {
+	m.events = w
+}
+
+// SetDryRun enables dry-run mode: every message goes through the full
+// modification pipeline, and a one-line summary of the result is printed
+// instead of sending it, with more detail depending on output. Since no UPF
+// allocates remote SEIDs, the original remote SEID from the pcap stands in
+// for it.
+func (m *Manager) SetDryRun(enabled bool, output DryRunOutput) {
+	m.dryRun = enabled
+	m.dryRunOutput = output
+}
+
+// printDryRun prints the one-line summary of a message that would have been sent.
+func (m *Manager) printDryRun(msg message.Message, data []byte, details string) {
+	line := fmt.Sprintf("%-28s seq=%-6d len=%-5d", pfcp.MessageTypeName(msg.MessageType()), msg.Sequence(), len(data))
+	fmt.Fprintln(m.out, strings.TrimSpace(line+" "+details))
+	if m.dryRunOutput == DryRunVerbose {
+		fmt.Fprintln(m.out, pfcp.DumpMessage(msg))
+	}
+}
+
+// expectedIEs returns the IE paths of msg for encode to verify the modified
+// message against, or nil if verification is disabled. It must be called
+// before msg is modified.
+func (m *Manager) expectedIEs(msg message.Message) pfcp.IEPaths {
+	if !m.verifyEncode {
+		return nil
+	}
+	return pfcp.MessageIEPaths(msg)
+}
+
+// encode runs the BeforeSend hook on msg, serializes it and, if verification
+// is enabled, checks that the bytes decode back to an equivalent message with
+// the IEs in expected, taken with expectedIEs before msg was modified. A nil
+// expected, for messages built here rather than taken from the pcap, checks
+// the bytes against msg itself.
+func (m *Manager) encode(msg message.Message, expected pfcp.IEPaths) ([]byte, error) {
+	if err := m.beforeSend(msg); err != nil {
+		return nil, err
+	}
+	data, err := pfcp.Encode(msg)
+	if err != nil {
+		return nil, err
+	}
+	if m.verifyEncode {
+		if err := pfcp.VerifyEncode(msg, expected, data); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"msg_type": pfcp.MessageTypeName(msg.MessageType()),
+				"seq_num":  msg.Sequence(),
+			}).Error("Encoded message failed verification")
+			return nil, fmt.Errorf("encode verification failed: %w", err)
+		}
+	}
+	return data, nil
+}
+
 // SetSEIDMappings registers the original CP SEID → remote SEID mappings
 // extracted from Session Establishment Response messages in the pcap.
 func (m *Manager) SetSEIDMappings(mappings []types.SEIDMapping) {
 	for _, mapping := range mappings {
-		m.originalSEIDMappings[mapping.OriginalCPSEID] = mapping.OriginalRemoteSEID
-		log.WithFields(log.Fields{
-			"cp_seid":     mapping.OriginalCPSEID,
-			"remote_seid": mapping.OriginalRemoteSEID,
-		}).Debug("Registered original SEID mapping from pcap")
+		m.AddSEIDMapping(mapping)
+	}
+}
+
+// AddSEIDMapping registers one original CP SEID → remote SEID mapping. It is
+// safe to call during replay: when streaming, the mapping is usually found
+// after the session's Establishment Request has been sent, so an existing
+// session is linked to the remote SEID directly.
+func (m *Manager) AddSEIDMapping(mapping types.SEIDMapping) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.originalSEIDMappings[mapping.OriginalCPSEID] = mapping.OriginalRemoteSEID
+	if session, ok := m.byOriginalCPSEID[mapping.OriginalCPSEID]; ok {
+		session.OriginalRemoteSEID = mapping.OriginalRemoteSEID
+		m.byOriginalRemoteSEID[mapping.OriginalRemoteSEID] = session
 	}
+
+	log.WithFields(log.Fields{
+		"cp_seid":     mapping.OriginalCPSEID,
+		"remote_seid": mapping.OriginalRemoteSEID,
+	}).Debug("Registered original SEID mapping from pcap")
 }
 
 // Replay processes all PFCP messages from the pcap in order.
 func (m *Manager) Replay(ctx context.Context, messages []types.RawPFCPMessage) error {
-	// Start response handler
-	go m.handleResponses(ctx)
-
-	interval := time.Duration(m.cfg.Timing.MessageIntervalMs) * time.Millisecond
+	messages = m.filterTypes(messages)
+	stop := m.startReplay(ctx)
+	defer stop()
+	progress := m.startProgress(ctx, len(messages))
+	defer progress.stop()
 
 	for i, raw := range messages {
+		if err := m.replayMessage(ctx, i, raw); err != nil {
+			return err
+		}
+		progress.advance()
+	}
+
+	return nil
+}
+
+// ReplayStream processes PFCP messages in order as they arrive on messages
+// (see pcap.Parser.Stream) until the channel is closed.
+func (m *Manager) ReplayStream(ctx context.Context, messages <-chan types.RawPFCPMessage) error {
+	stop := m.startReplay(ctx)
+	defer stop()
+	progress := m.startProgress(ctx, 0)
+	defer progress.stop()
+
+	for i := 0; ; {
+		var raw types.RawPFCPMessage
 		select {
 		case <-ctx.Done():
 			log.Info("Replay cancelled")
 			return ctx.Err()
-		default:
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			raw = msg
 		}
-
-		msg, err := pfcp.Decode(raw.Data)
-		if err != nil {
-			log.WithError(err).WithField("index", i).Warn("Failed to decode PFCP message, skipping")
+		if !m.replaysType(raw) {
 			continue
 		}
 
-		if err := m.processMessage(ctx, msg, raw); err != nil {
-			log.WithError(err).WithFields(log.Fields{
-				"index":    i,
-				"msg_type": pfcp.MessageTypeName(msg.MessageType()),
-			}).Error("Failed to process message")
+		if err := m.replayMessage(ctx, i, raw); err != nil {
+			return err
 		}
+		i++
+		progress.advance()
+	}
+}
 
-		// Apply inter-message delay
-		if interval > 0 && i < len(messages)-1 {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case <-time.After(interval):
-			}
+// replaysType reports whether raw's message type passes input.include_types
+// and input.exclude_types.
+func (m *Manager) replaysType(raw types.RawPFCPMessage) bool {
+	if len(raw.Data) < 2 {
+		return true // Reported as a decode failure
+	}
+	msgType := raw.Data[1]
+	if m.includeTypes != nil && !m.includeTypes[msgType] {
+		return false
+	}
+	return !m.excludeTypes[msgType]
+}
+
+// filterTypes returns the messages whose type is replayed, reusing messages
+// when no type is filtered out.
+func (m *Manager) filterTypes(messages []types.RawPFCPMessage) []types.RawPFCPMessage {
+	if m.includeTypes == nil && len(m.excludeTypes) == 0 {
+		return messages
+	}
+	var kept []types.RawPFCPMessage
+	for _, raw := range messages {
+		if m.replaysType(raw) {
+			kept = append(kept, raw)
+		}
+	}
+	if skipped := len(messages) - len(kept); skipped > 0 {
+		log.WithField("skipped", skipped).Info("Skipping requests filtered by message type")
+	}
+	return kept
+}
+
+// startReplay starts the response handler and, if heartbeat_interval_sec is
+// set, the heartbeat loop. The returned function stops the heartbeat loop; the
+// response handler keeps running until ctx is cancelled so that cleanup can
+// still receive responses.
+func (m *Manager) startReplay(ctx context.Context) context.CancelFunc {
+	if m.dryRun {
+		return func() {}
+	}
+
+	if m.receiver != nil {
+		go m.handleResponses(ctx)
+	}
+
+	heartbeatCtx, cancel := context.WithCancel(ctx)
+	if interval := time.Duration(m.cfg.Association.HeartbeatIntervalSec) * time.Second; interval > 0 {
+		go m.heartbeatLoop(heartbeatCtx, interval)
+	}
+	if warmup := time.Duration(m.cfg.Stats.WarmupSec) * time.Second; warmup > 0 && m.warmupStarted.CompareAndSwap(false, true) {
+		m.startWarmup(ctx, warmup)
+	}
+	return cancel
+}
+
+// startWarmup records transactions in the collector's warm-up statistics for
+// the first d of the replay, Association Setup included. A replay that ends
+// before then leaves the warm-up unfinished.
+func (m *Manager) startWarmup(ctx context.Context, d time.Duration) {
+	m.stats.StartWarmup()
+	log.WithField("duration", d).Info("Warm-up started, statistics are reported separately")
+	go func() {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+			m.stats.EndWarmup()
+			log.Info("Warm-up finished")
+		}
+	}()
+}
+
+// replayMessage decodes and processes the i-th message of the replay, waiting
+// the configured message interval before every message but the first (except
+// in dry-run mode).
+// Only cancellation and ErrAssociationFailed are returned as errors; other
+// failures are logged.
+func (m *Manager) replayMessage(ctx context.Context, i int, raw types.RawPFCPMessage) error {
+	// Apply inter-message delay
+	if interval := time.Duration(m.cfg.Timing.MessageIntervalMs) * time.Millisecond; interval > 0 && i > 0 && !m.dryRun {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		log.Info("Replay cancelled")
+		return ctx.Err()
+	default:
+	}
+
+	if m.restartPending.CompareAndSwap(true, false) {
+		m.reconnect(ctx)
+	}
+
+	msg, err := pfcp.Decode(raw.Data)
+	if err != nil {
+		log.WithError(err).WithField("index", i).Warn("Failed to decode PFCP message, skipping")
+		return nil
+	}
+
+	// Keep an unmodified copy to diff against; handlers modify msg in place
+	var original message.Message
+	if m.dryRun && m.dryRunOutput == DryRunDiff {
+		original, _ = pfcp.Decode(raw.Data)
+	}
+
+	if err := m.processMessage(ctx, msg, raw); err != nil {
+		if errors.Is(err, ErrAssociationFailed) {
+			return err
 		}
+		log.WithError(err).WithFields(log.Fields{
+			"index":    i,
+			"msg_type": pfcp.MessageTypeName(msg.MessageType()),
+		}).Error("Failed to process message")
+		return nil
 	}
 
+	if original != nil {
+		if diff := pfcp.DiffMessages(original, msg); diff != "" {
+			fmt.Fprintln(m.out, diff)
+		}
+	}
 	return nil
 }
 
@@ -157,6 +600,8 @@ func (m *Manager) processMessage(ctx context.Context, msg message.Message, raw t
 		return m.handleSessionDeletion(ctx, msg)
 	case message.MsgTypeHeartbeatRequest:
 		return m.handleHeartbeat(ctx, msg)
+	case message.MsgTypePFDManagementRequest:
+		return m.handlePFDManagement(ctx, msg)
 	default:
 		log.WithField("msg_type", pfcp.MessageTypeName(msg.MessageType())).Debug("Skipping unsupported message type")
 		return nil
@@ -174,39 +619,126 @@ func (m *Manager) handleAssociationSetup(ctx context.Context, msg message.Messag
 		return fmt.Errorf("unexpected message type for Association Setup")
 	}
 
+	if m.cfg.Association.ReconnectOnRestart {
+		if data, err := pfcp.Encode(req); err == nil {
+			m.associationRequest = data
+		}
+	}
+
+	expected := m.expectedIEs(req)
+	var err error
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			delay := m.associationRetryDelay(attempt)
+			log.WithFields(log.Fields{
+				"attempt": attempt,
+				"delay":   delay,
+			}).WithError(err).Warn("Association Setup failed, retrying")
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		err = m.associationSetupAttempt(ctx, req, expected)
+		if err == nil || m.dryRun || ctx.Err() != nil || attempt >= m.cfg.Association.MaxSetupRetries {
+			break
+		}
+	}
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+
+	if m.cfg.Association.OnSetupFailure == "abort" {
+		return fmt.Errorf("%w: %v", ErrAssociationFailed, err)
+	}
+	log.WithError(err).Warn("Association Setup failed, continuing without association")
+	return nil
+}
+
+// associationRetryDelay returns the wait before the nth Association Setup
+// retry: setup_retry_interval_ms, doubled for every further retry.
+func (m *Manager) associationRetryDelay(attempt int) time.Duration {
+	delay := time.Duration(m.cfg.Association.SetupRetryIntervalMs) * time.Millisecond
+	for i := 1; i < attempt && delay < time.Minute; i++ {
+		delay *= 2
+	}
+	return delay
+}
+
+// associationSetupAttempt sends req with a fresh sequence number and waits for
+// an accepting response. expected is passed on to encode.
+func (m *Manager) associationSetupAttempt(ctx context.Context, req *message.AssociationSetupRequest, expected pfcp.IEPaths) error {
 	seqNum := m.seqCounter.Next()
 	if err := m.modifier.ModifyAssociationSetup(req, seqNum); err != nil {
 		return fmt.Errorf("failed to modify Association Setup: %w", err)
 	}
 
-	data, err := pfcp.Encode(req)
+	data, err := m.encode(req, expected)
 	if err != nil {
 		return fmt.Errorf("failed to encode Association Setup: %w", err)
 	}
 
+	// Keep periodic heartbeats consistent with the Recovery Time Stamp sent here
+	if req.RecoveryTimeStamp != nil {
+		if ts, err := req.RecoveryTimeStamp.RecoveryTimeStamp(); err == nil {
+			m.mu.Lock()
+			m.recoveryTime = ts
+			m.mu.Unlock()
+		}
+	}
+
+	if m.dryRun {
+		m.printDryRun(req, data, fmt.Sprintf("node_id=%s", m.cfg.SMF.NodeIDValue()))
+		return nil
+	}
+
 	msgTypeName := "AssociationSetupRequest"
+	resultCh, err := m.tracker.Track(ctx, seqNum, message.MsgTypeAssociationSetupRequest, data)
+	if err != nil {
+		return fmt.Errorf("failed to send Association Setup: %w", err)
+	}
 	m.stats.RecordSent(msgTypeName)
-	resultCh := m.tracker.Track(seqNum, data)
 
 	if err := m.client.Send(data); err != nil {
+		m.tracker.Cancel(seqNum)
 		return fmt.Errorf("failed to send Association Setup: %w", err)
 	}
 
 	log.WithField("seq_num", seqNum).Info("Sent Association Setup Request")
 
 	// Wait for response
-	result := m.waitForResult(ctx, resultCh)
+	result := m.waitForResult(ctx, req, resultCh)
 	if result.Error != nil {
 		m.stats.RecordTimeout(msgTypeName)
+		m.recordEvent(req, nil, 0, stats.ResultTimeout, nil)
 		return fmt.Errorf("Association Setup failed: %w", result.Error)
 	}
 
 	m.stats.RecordReceived("AssociationSetupResponse")
+
+	// Check cause; a UPF that does not accept our Node ID rejects the association
+	rej, err := checkCause(result)
+	if err != nil {
+		m.stats.RecordFailure(msgTypeName)
+		m.recordEvent(req, nil, result.ResponseTime, stats.ResultError, nil)
+		return fmt.Errorf("Association Setup not accepted: %w", err)
+	}
+	if rej != nil {
+		m.stats.RecordFailure(msgTypeName)
+		m.stats.RecordCause(msgTypeName, rej.Cause)
+		m.recordEvent(req, nil, result.ResponseTime, stats.ResultRejected, rej)
+		return fmt.Errorf("Association Setup rejected with %s, node_id=%s", rej, m.cfg.SMF.NodeIDValue())
+	}
+
 	m.stats.RecordSuccess(msgTypeName, result.ResponseTime)
+	m.recordEvent(req, nil, result.ResponseTime, stats.ResultSuccess, nil)
 	log.WithFields(log.Fields{
 		"seq_num":       seqNum,
 		"response_time": result.ResponseTime.Round(time.Microsecond),
 	}).Info("Association Setup successful")
+	logUPFunctionFeatures(result.Response)
 
 	return nil
 }
@@ -224,6 +756,12 @@ func (m *Manager) handleSessionEstablishment(ctx context.Context, msg message.Me
 		originalCPSEID = 0
 	}
 
+	if m.cfg.Association.ReconnectOnRestart {
+		if data, err := pfcp.Encode(req); err == nil {
+			m.establishmentRequests[originalCPSEID] = data
+		}
+	}
+
 	// Allocate new identifiers
 	localSEID, err := m.seidAlloc.Allocate()
 	if err != nil {
@@ -231,10 +769,14 @@ func (m *Manager) handleSessionEstablishment(ctx context.Context, msg message.Me
 		return fmt.Errorf("failed to allocate SEID: %w", err)
 	}
 
-	ueIP, err := m.ipPool.Allocate()
+	pool, dnn := m.selectUEIPPool(req)
+	ueIP, err := allocateUEIP(pool, originalCPSEID)
 	if err != nil {
 		m.seidAlloc.Release(localSEID)
 		m.stats.RecordSessionFailed()
+		if dnn != "" {
+			return fmt.Errorf("failed to allocate UE IP from the %s pool: %w", dnn, err)
+		}
 		return fmt.Errorf("failed to allocate UE IP: %w", err)
 	}
 
@@ -243,8 +785,9 @@ func (m *Manager) handleSessionEstablishment(ctx context.Context, msg message.Me
 		OriginalCPSEID: originalCPSEID,
 		LocalSEID:      localSEID,
 		UEIP:           ueIP,
+		TEIDs:          make(map[uint32]uint32),
 		State:          "establishing",
-		CreatedAt:       time.Now(),
+		CreatedAt:      time.Now(),
 	}
 
 	// Store mapping
@@ -258,22 +801,83 @@ func (m *Manager) handleSessionEstablishment(ctx context.Context, msg message.Me
 	}
 	m.mu.Unlock()
 
-	// Modify message
+	// A session whose establishment fails is forgotten, so that failures do
+	// not drain the pools or accumulate in the session maps. Its request is
+	// cancelled first: while it can still be retransmitted and accepted by
+	// the UPF, its identifiers must not be handed to another session.
+	established := false
 	seqNum := m.seqCounter.Next()
+	defer func() {
+		if !established {
+			if m.tracker != nil {
+				m.tracker.Cancel(seqNum)
+			}
+			m.abandonSession(session)
+		}
+	}()
+
+	// Modify message
+	expected := m.expectedIEs(req)
 	if err := m.modifier.ModifySessionEstablishment(req, localSEID, ueIP, seqNum); err != nil {
 		return fmt.Errorf("failed to modify Session Establishment: %w", err)
 	}
+	if m.cfg.Session.RewriteTEID {
+		if err := m.modifier.ModifyTEIDs(req.CreatePDR, req.CreateFAR, m.teidMapper(session)); err != nil {
+			return fmt.Errorf("failed to modify TEIDs in Session Establishment: %w", err)
+		}
+	}
+	if m.macPool != nil {
+		if n, err := m.modifier.ModifyUEMACs(m.macMapper(session), req.CreatePDR); err != nil {
+			return fmt.Errorf("failed to modify UE MACs in Session Establishment: %w", err)
+		} else if n > 0 {
+			log.WithFields(log.Fields{"seq_num": seqNum, "count": n}).Debug("Rewrote UE MAC addresses")
+		}
+	}
+	if n, err := m.modifier.ModifyGTPPeer(m.teidMapper(session), req.CreateFAR); err != nil {
+		return fmt.Errorf("failed to modify GTP-U peer in Session Establishment: %w", err)
+	} else if n > 0 {
+		log.WithFields(log.Fields{"seq_num": seqNum, "count": n}).Debug("Rewrote Outer Header Creation IEs")
+	}
+	if n, err := m.modifier.ModifyNetworkInstances(req.CreatePDR, req.CreateFAR); err != nil {
+		return fmt.Errorf("failed to modify Network Instances in Session Establishment: %w", err)
+	} else if n > 0 {
+		log.WithFields(log.Fields{"seq_num": seqNum, "count": n}).Debug("Rewrote Network Instance IEs")
+	}
+	if n, err := m.modifier.ModifyApplyActions(req.CreateFAR); err != nil {
+		return fmt.Errorf("failed to modify Apply Actions in Session Establishment: %w", err)
+	} else if n > 0 {
+		log.WithFields(log.Fields{"seq_num": seqNum, "count": n}).Debug("Rewrote Apply Action IEs")
+	}
+	if n, err := m.modifier.ModifyQERs(req.CreateQER); err != nil {
+		return fmt.Errorf("failed to modify QERs in Session Establishment: %w", err)
+	} else if n > 0 {
+		log.WithFields(log.Fields{"seq_num": seqNum, "count": n}).Debug("Rewrote QER bit rates")
+	}
 
-	data, err := pfcp.Encode(req)
+	data, err := m.encode(req, expected)
 	if err != nil {
 		return fmt.Errorf("failed to encode Session Establishment: %w", err)
 	}
 
+	if m.dryRun {
+		m.printDryRun(req, data, fmt.Sprintf("local_seid=%d ue_ip=%s orig_seid=%d", localSEID, ueIP, originalCPSEID))
+		m.mu.Lock()
+		session.RemoteSEID = session.OriginalRemoteSEID
+		session.State = "established"
+		m.mu.Unlock()
+		established = true
+		return nil
+	}
+
 	msgTypeName := "SessionEstablishmentRequest"
+	resultCh, err := m.tracker.Track(ctx, seqNum, message.MsgTypeSessionEstablishmentRequest, data)
+	if err != nil {
+		return fmt.Errorf("failed to send Session Establishment: %w", err)
+	}
 	m.stats.RecordSent(msgTypeName)
-	resultCh := m.tracker.Track(seqNum, data)
 
 	if err := m.client.Send(data); err != nil {
+		m.tracker.Cancel(seqNum)
 		return fmt.Errorf("failed to send Session Establishment: %w", err)
 	}
 
@@ -282,14 +886,17 @@ func (m *Manager) handleSessionEstablishment(ctx context.Context, msg message.Me
 		"local_seid": localSEID,
 		"ue_ip":      ueIP,
 		"orig_seid":  originalCPSEID,
-	}).Info("Sent Session Establishment Request")
+	}).Log(m.messageLogLevel, "Sent Session Establishment Request")
 
 	// Wait for response
-	result := m.waitForResult(ctx, resultCh)
+	result := m.waitForResult(ctx, req, resultCh)
 	if result.Error != nil {
 		m.stats.RecordTimeout(msgTypeName)
 		m.stats.RecordSessionFailed()
+		m.mu.Lock()
 		session.State = "failed"
+		m.mu.Unlock()
+		m.recordEvent(req, session, 0, stats.ResultTimeout, nil)
 		return fmt.Errorf("Session Establishment timeout: %w", result.Error)
 	}
 
@@ -300,6 +907,7 @@ func (m *Manager) handleSessionEstablishment(ctx context.Context, msg message.Me
 	if err != nil {
 		m.stats.RecordFailure(msgTypeName)
 		m.stats.RecordSessionFailed()
+		m.recordEvent(req, session, result.ResponseTime, stats.ResultError, nil)
 		return fmt.Errorf("failed to decode Establishment Response: %w", err)
 	}
 
@@ -307,18 +915,20 @@ func (m *Manager) handleSessionEstablishment(ctx context.Context, msg message.Me
 	if !ok {
 		m.stats.RecordFailure(msgTypeName)
 		m.stats.RecordSessionFailed()
+		m.recordEvent(req, session, result.ResponseTime, stats.ResultError, nil)
 		return fmt.Errorf("unexpected response type: %T", respMsg)
 	}
 
 	// Check cause
-	if resp.Cause != nil {
-		cause, err := resp.Cause.Cause()
-		if err == nil && cause != ie.CauseRequestAccepted {
-			m.stats.RecordFailure(msgTypeName)
-			m.stats.RecordSessionFailed()
-			session.State = "failed"
-			return fmt.Errorf("Session Establishment rejected with cause %d", cause)
-		}
+	if rej, ok := pfcp.ExtractRejection(resp); ok {
+		m.stats.RecordFailure(msgTypeName)
+		m.stats.RecordCause(msgTypeName, rej.Cause)
+		m.stats.RecordSessionFailed()
+		m.mu.Lock()
+		session.State = "failed"
+		m.mu.Unlock()
+		m.recordEvent(req, session, result.ResponseTime, stats.ResultRejected, rej)
+		return fmt.Errorf("Session Establishment rejected with %s", rej)
 	}
 
 	// Extract remote SEID
@@ -326,6 +936,7 @@ func (m *Manager) handleSessionEstablishment(ctx context.Context, msg message.Me
 	if err != nil {
 		m.stats.RecordFailure(msgTypeName)
 		m.stats.RecordSessionFailed()
+		m.recordEvent(req, session, result.ResponseTime, stats.ResultError, nil)
 		return fmt.Errorf("failed to extract remote SEID: %w", err)
 	}
 
@@ -334,9 +945,11 @@ func (m *Manager) handleSessionEstablishment(ctx context.Context, msg message.Me
 	session.RemoteSEID = remoteSEID
 	session.State = "established"
 	m.mu.Unlock()
+	established = true
 
 	m.stats.RecordSuccess(msgTypeName, result.ResponseTime)
 	m.stats.RecordSessionEstablished()
+	m.recordEvent(req, session, result.ResponseTime, stats.ResultSuccess, nil)
 
 	log.WithFields(log.Fields{
 		"seq_num":       seqNum,
@@ -344,7 +957,7 @@ func (m *Manager) handleSessionEstablishment(ctx context.Context, msg message.Me
 		"remote_seid":   remoteSEID,
 		"ue_ip":         ueIP,
 		"response_time": result.ResponseTime.Round(time.Microsecond),
-	}).Info("Session established")
+	}).Log(m.messageLogLevel, "Session established")
 
 	return nil
 }
@@ -361,24 +974,71 @@ func (m *Manager) handleSessionModification(ctx context.Context, msg message.Mes
 	// Look up session by original remote SEID
 	session := m.findSessionByOriginalRemoteSEID(originalRemoteSEID)
 	if session == nil {
+		m.stats.RecordOrphanedRequest(pfcp.MessageTypeName(msg.MessageType()), originalRemoteSEID)
 		return fmt.Errorf("no session found for original remote SEID %d", originalRemoteSEID)
 	}
 
+	expected := m.expectedIEs(req)
 	seqNum := m.seqCounter.Next()
 	if err := m.modifier.ModifySessionModification(req, session.RemoteSEID, session.UEIP, seqNum); err != nil {
 		return fmt.Errorf("failed to modify Session Modification: %w", err)
 	}
+	if m.cfg.Session.RewriteTEID {
+		mapTEID := m.teidMapper(session)
+		if err := m.modifier.ModifyTEIDs(req.CreatePDR, req.CreateFAR, mapTEID); err != nil {
+			return fmt.Errorf("failed to modify TEIDs in Session Modification: %w", err)
+		}
+		if err := m.modifier.ModifyTEIDs(nil, req.UpdateFAR, mapTEID); err != nil {
+			return fmt.Errorf("failed to modify TEIDs in Session Modification: %w", err)
+		}
+	}
+	if m.macPool != nil {
+		if n, err := m.modifier.ModifyUEMACs(m.macMapper(session), req.CreatePDR, req.UpdatePDR); err != nil {
+			return fmt.Errorf("failed to modify UE MACs in Session Modification: %w", err)
+		} else if n > 0 {
+			log.WithFields(log.Fields{"seq_num": seqNum, "count": n}).Debug("Rewrote UE MAC addresses")
+		}
+	}
+	if n, err := m.modifier.ModifyGTPPeer(m.teidMapper(session), req.CreateFAR, req.UpdateFAR); err != nil {
+		return fmt.Errorf("failed to modify GTP-U peer in Session Modification: %w", err)
+	} else if n > 0 {
+		log.WithFields(log.Fields{"seq_num": seqNum, "count": n}).Debug("Rewrote Outer Header Creation IEs")
+	}
+	if n, err := m.modifier.ModifyNetworkInstances(req.CreatePDR, req.UpdatePDR, req.CreateFAR, req.UpdateFAR); err != nil {
+		return fmt.Errorf("failed to modify Network Instances in Session Modification: %w", err)
+	} else if n > 0 {
+		log.WithFields(log.Fields{"seq_num": seqNum, "count": n}).Debug("Rewrote Network Instance IEs")
+	}
+	if n, err := m.modifier.ModifyApplyActions(req.CreateFAR, req.UpdateFAR); err != nil {
+		return fmt.Errorf("failed to modify Apply Actions in Session Modification: %w", err)
+	} else if n > 0 {
+		log.WithFields(log.Fields{"seq_num": seqNum, "count": n}).Debug("Rewrote Apply Action IEs")
+	}
+	if n, err := m.modifier.ModifyQERs(req.CreateQER, req.UpdateQER); err != nil {
+		return fmt.Errorf("failed to modify QERs in Session Modification: %w", err)
+	} else if n > 0 {
+		log.WithFields(log.Fields{"seq_num": seqNum, "count": n}).Debug("Rewrote QER bit rates")
+	}
 
-	data, err := pfcp.Encode(req)
+	data, err := m.encode(req, expected)
 	if err != nil {
 		return fmt.Errorf("failed to encode Session Modification: %w", err)
 	}
 
+	if m.dryRun {
+		m.printDryRun(req, data, fmt.Sprintf("seid=%d local_seid=%d ue_ip=%s", session.RemoteSEID, session.LocalSEID, session.UEIP))
+		return nil
+	}
+
 	msgTypeName := "SessionModificationRequest"
+	resultCh, err := m.tracker.Track(ctx, seqNum, message.MsgTypeSessionModificationRequest, data)
+	if err != nil {
+		return fmt.Errorf("failed to send Session Modification: %w", err)
+	}
 	m.stats.RecordSent(msgTypeName)
-	resultCh := m.tracker.Track(seqNum, data)
 
 	if err := m.client.Send(data); err != nil {
+		m.tracker.Cancel(seqNum)
 		return fmt.Errorf("failed to send Session Modification: %w", err)
 	}
 
@@ -386,22 +1046,36 @@ func (m *Manager) handleSessionModification(ctx context.Context, msg message.Mes
 		"seq_num":     seqNum,
 		"remote_seid": session.RemoteSEID,
 		"local_seid":  session.LocalSEID,
-	}).Info("Sent Session Modification Request")
+	}).Log(m.messageLogLevel, "Sent Session Modification Request")
 
-	result := m.waitForResult(ctx, resultCh)
+	result := m.waitForResult(ctx, req, resultCh)
 	if result.Error != nil {
 		m.stats.RecordTimeout(msgTypeName)
+		m.recordEvent(req, session, 0, stats.ResultTimeout, nil)
 		return fmt.Errorf("Session Modification timeout: %w", result.Error)
 	}
 
 	m.stats.RecordReceived("SessionModificationResponse")
+	rej, err := checkCause(result)
+	if err != nil {
+		m.stats.RecordFailure(msgTypeName)
+		m.recordEvent(req, session, result.ResponseTime, stats.ResultError, nil)
+		return fmt.Errorf("invalid Session Modification Response: %w", err)
+	}
+	if rej != nil {
+		m.stats.RecordFailure(msgTypeName)
+		m.stats.RecordCause(msgTypeName, rej.Cause)
+		m.recordEvent(req, session, result.ResponseTime, stats.ResultRejected, rej)
+		return fmt.Errorf("Session Modification rejected with %s", rej)
+	}
 	m.stats.RecordSuccess(msgTypeName, result.ResponseTime)
 	m.stats.RecordSessionModified()
+	m.recordEvent(req, session, result.ResponseTime, stats.ResultSuccess, nil)
 
 	log.WithFields(log.Fields{
 		"seq_num":       seqNum,
 		"response_time": result.ResponseTime.Round(time.Microsecond),
-	}).Info("Session modified")
+	}).Log(m.messageLogLevel, "Session modified")
 
 	return nil
 }
@@ -417,24 +1091,36 @@ func (m *Manager) handleSessionDeletion(ctx context.Context, msg message.Message
 
 	session := m.findSessionByOriginalRemoteSEID(originalRemoteSEID)
 	if session == nil {
+		m.stats.RecordOrphanedRequest(pfcp.MessageTypeName(msg.MessageType()), originalRemoteSEID)
 		return fmt.Errorf("no session found for original remote SEID %d", originalRemoteSEID)
 	}
 
+	expected := m.expectedIEs(req)
 	seqNum := m.seqCounter.Next()
 	if err := m.modifier.ModifySessionDeletion(req, session.RemoteSEID, seqNum); err != nil {
 		return fmt.Errorf("failed to modify Session Deletion: %w", err)
 	}
 
-	data, err := pfcp.Encode(req)
+	data, err := m.encode(req, expected)
 	if err != nil {
 		return fmt.Errorf("failed to encode Session Deletion: %w", err)
 	}
 
+	if m.dryRun {
+		m.printDryRun(req, data, fmt.Sprintf("seid=%d local_seid=%d", session.RemoteSEID, session.LocalSEID))
+		m.releaseSession(session)
+		return nil
+	}
+
 	msgTypeName := "SessionDeletionRequest"
+	resultCh, err := m.tracker.Track(ctx, seqNum, message.MsgTypeSessionDeletionRequest, data)
+	if err != nil {
+		return fmt.Errorf("failed to send Session Deletion: %w", err)
+	}
 	m.stats.RecordSent(msgTypeName)
-	resultCh := m.tracker.Track(seqNum, data)
 
 	if err := m.client.Send(data); err != nil {
+		m.tracker.Cancel(seqNum)
 		return fmt.Errorf("failed to send Session Deletion: %w", err)
 	}
 
@@ -442,75 +1128,476 @@ func (m *Manager) handleSessionDeletion(ctx context.Context, msg message.Message
 		"seq_num":     seqNum,
 		"remote_seid": session.RemoteSEID,
 		"local_seid":  session.LocalSEID,
-	}).Info("Sent Session Deletion Request")
+	}).Log(m.messageLogLevel, "Sent Session Deletion Request")
 
-	result := m.waitForResult(ctx, resultCh)
+	result := m.waitForResult(ctx, req, resultCh)
 	if result.Error != nil {
 		m.stats.RecordTimeout(msgTypeName)
+		m.recordEvent(req, session, 0, stats.ResultTimeout, nil)
 		return fmt.Errorf("Session Deletion timeout: %w", result.Error)
 	}
 
 	m.stats.RecordReceived("SessionDeletionResponse")
+	rej, err := checkCause(result)
+	if err != nil {
+		m.stats.RecordFailure(msgTypeName)
+		m.recordEvent(req, session, result.ResponseTime, stats.ResultError, nil)
+		return fmt.Errorf("invalid Session Deletion Response: %w", err)
+	}
+	if rej != nil {
+		m.stats.RecordFailure(msgTypeName)
+		m.stats.RecordCause(msgTypeName, rej.Cause)
+		m.recordEvent(req, session, result.ResponseTime, stats.ResultRejected, rej)
+		return fmt.Errorf("Session Deletion rejected with %s", rej)
+	}
 	m.stats.RecordSuccess(msgTypeName, result.ResponseTime)
 	m.stats.RecordSessionDeleted()
+	m.recordLifetime(session)
+	m.recordEvent(req, session, result.ResponseTime, stats.ResultSuccess, nil)
 
-	// Release resources
-	m.seidAlloc.Release(session.LocalSEID)
-	if session.UEIP != nil {
-		m.ipPool.Release(session.UEIP)
-	}
-
-	m.mu.Lock()
-	session.State = "deleted"
-	m.mu.Unlock()
+	m.releaseSession(session)
+	delete(m.establishmentRequests, session.OriginalCPSEID)
 
 	log.WithFields(log.Fields{
 		"seq_num":       seqNum,
 		"local_seid":    session.LocalSEID,
 		"response_time": result.ResponseTime.Round(time.Microsecond),
-	}).Info("Session deleted")
+	}).Log(m.messageLogLevel, "Session deleted")
 
 	return nil
 }
 
+// recordLifetime stamps the deletion time on a session whose deletion the UPF
+// accepted and records how long it existed.
+func (m *Manager) recordLifetime(session *types.SessionInfo) {
+	m.mu.Lock()
+	session.DeletedAt = time.Now()
+	lifetime := session.DeletedAt.Sub(session.CreatedAt)
+	m.mu.Unlock()
+	m.stats.RecordSessionLifetime(lifetime)
+}
+
+// releaseSession returns a deleted session's SEID, UE IP, UE MACs and TEIDs
+// to their allocators.
+func (m *Manager) releaseSession(session *types.SessionInfo) {
+	m.releaseIdentifiers(session, "deleted")
+}
+
+// abandonSession releases the identifiers of a session whose establishment
+// failed, marks it failed and removes it from the session maps and the
+// requests kept for re-establishment. The establishment's transaction must
+// no longer be pending.
+func (m *Manager) abandonSession(session *types.SessionInfo) {
+	m.releaseIdentifiers(session, "failed")
+	delete(m.establishmentRequests, session.OriginalCPSEID)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.byOriginalCPSEID[session.OriginalCPSEID] == session {
+		delete(m.byOriginalCPSEID, session.OriginalCPSEID)
+	}
+	if m.byOriginalRemoteSEID[session.OriginalRemoteSEID] == session {
+		delete(m.byOriginalRemoteSEID, session.OriginalRemoteSEID)
+	}
+	if m.byLocalSEID[session.LocalSEID] == session {
+		delete(m.byLocalSEID, session.LocalSEID)
+	}
+}
+
+// releaseIdentifiers returns a session's SEID, UE IP, UE MACs and TEIDs to
+// their allocators and sets its state.
+func (m *Manager) releaseIdentifiers(session *types.SessionInfo, state string) {
+	m.seidAlloc.Release(session.LocalSEID)
+	if session.UEIP != nil {
+		m.poolContaining(session.UEIP).Release(session.UEIP)
+	}
+
+	m.mu.Lock()
+	for _, teid := range session.TEIDs {
+		m.teidAlloc.Release(teid)
+	}
+	if m.macPool != nil {
+		for _, mac := range session.UEMACs {
+			m.macPool.Release(mac)
+		}
+	}
+	session.State = state
+	m.mu.Unlock()
+}
+
+// Reset forgets every session and releases its SEID, UE IP, TEIDs and UE
+// MACs for reuse, keeping the pcap's original SEID mappings, so that the pcap
+// can be replayed again from a clean state. Sessions still active on the UPF
+// should be deleted first: their identifiers are handed out again.
+func (m *Manager) Reset() {
+	m.mu.Lock()
+	sessions := make(map[*types.SessionInfo]bool, len(m.byLocalSEID))
+	for _, byID := range []map[uint64]*types.SessionInfo{m.byOriginalCPSEID, m.byOriginalRemoteSEID, m.byLocalSEID} {
+		for _, session := range byID {
+			sessions[session] = true
+		}
+	}
+	m.byOriginalCPSEID = make(map[uint64]*types.SessionInfo)
+	m.byOriginalRemoteSEID = make(map[uint64]*types.SessionInfo)
+	m.byLocalSEID = make(map[uint64]*types.SessionInfo)
+	m.lostSessions = nil
+	m.mu.Unlock()
+
+	m.restartPending.Store(false)
+	m.establishmentRequests = make(map[uint64][]byte)
+	for session := range sessions {
+		m.releaseSession(session)
+	}
+}
+
+// newUEIPPool creates a UE IP pool for cidr with the configured strategy and
+// those session.ue_ip_exclude entries that fall within it.
+func newUEIPPool(cfg *config.Config, cidr string) (*UEIPPool, error) {
+	pool, err := NewUEIPPool(cidr)
+	if err != nil {
+		return nil, err
+	}
+	pool.SetStrategy(cfg.Session.UEIPStrategy)
+	pool.SetSkipBroadcast(cfg.Session.UEIPSkipBroadcast)
+
+	var exclude []string
+	for _, entry := range cfg.Session.UEIPExclude {
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			ip, _, _ = net.ParseCIDR(entry)
+		}
+		if ip != nil && pool.Contains(ip) {
+			exclude = append(exclude, entry)
+		}
+	}
+	if err := pool.Exclude(exclude); err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+// selectUEIPPool returns the pool for the Network Instance in the request's
+// Create PDRs (as in the pcap, before any rewriting) and the matched Network
+// Instance, or the default pool and "" if there is no matching pool.
+func (m *Manager) selectUEIPPool(req *message.SessionEstablishmentRequest) (IPAllocator, string) {
+	if len(m.dnnPools) == 0 {
+		return m.ipPool, ""
+	}
+	dnn := strings.ToLower(pfcp.ExtractNetworkInstance(req.CreatePDR))
+	if pool, ok := m.dnnPools[dnn]; ok {
+		return pool, dnn
+	}
+	return m.ipPool, ""
+}
+
+// poolContaining returns the pool that ip was allocated from.
+func (m *Manager) poolContaining(ip net.IP) IPAllocator {
+	for _, pool := range m.dnnPools {
+		if pool.Contains(ip) {
+			return pool
+		}
+	}
+	return m.ipPool
+}
+
+// ueIPPools returns the default pool followed by the per-DNN pools.
+func (m *Manager) ueIPPools() []IPAllocator {
+	pools := []IPAllocator{m.ipPool}
+	for _, pool := range m.dnnPools {
+		pools = append(pools, pool)
+	}
+	return pools
+}
+
+// recordEvent writes a transaction event for req if an events file is
+// configured. session may be nil for node-level messages, rejection is nil
+// unless the UPF rejected the request.
+func (m *Manager) recordEvent(req message.Message, session *types.SessionInfo, responseTime time.Duration, result string, rejection *pfcp.Rejection) {
+	if m.events == nil {
+		return
+	}
+
+	ev := stats.Event{
+		Timestamp:      time.Now(),
+		MsgType:        pfcp.MessageTypeName(req.MessageType()),
+		SeqNum:         req.Sequence(),
+		ResponseTimeMs: float64(responseTime) / float64(time.Millisecond),
+		Result:         result,
+	}
+	if rejection != nil {
+		ev.Cause = rejection.Cause
+		if rejection.OffendingIE != 0 {
+			ev.OffendingIE = pfcp.IETypeName(rejection.OffendingIE)
+		}
+		ev.FailedRule = rejection.FailedRule
+	}
+	if session != nil {
+		m.mu.RLock()
+		ev.LocalSEID = session.LocalSEID
+		ev.RemoteSEID = session.RemoteSEID
+		if session.UEIP != nil {
+			ev.UEIP = session.UEIP.String()
+		}
+		m.mu.RUnlock()
+	}
+
+	if err := m.events.Write(ev); err != nil {
+		log.WithError(err).Warn("Failed to write event")
+	}
+}
+
+// teidMapper returns a TEIDMapper that allocates one local TEID per original
+// TEID and reuses it for the rest of the session.
+func (m *Manager) teidMapper(session *types.SessionInfo) pfcp.TEIDMapper {
+	return func(originalTEID uint32) (uint32, error) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if teid, ok := session.TEIDs[originalTEID]; ok {
+			return teid, nil
+		}
+		teid, err := m.teidAlloc.Allocate()
+		if err != nil {
+			return 0, fmt.Errorf("failed to allocate TEID: %w", err)
+		}
+		session.TEIDs[originalTEID] = teid
+		return teid, nil
+	}
+}
+
+// macMapper returns a MACMapper that allocates a UE MAC from the MAC pool the
+// first time an original UE MAC of the session is seen, and reuses it after.
+func (m *Manager) macMapper(session *types.SessionInfo) pfcp.MACMapper {
+	return func(original net.HardwareAddr) (net.HardwareAddr, error) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if mac, ok := session.UEMACs[original.String()]; ok {
+			return mac, nil
+		}
+		mac, err := m.macPool.Allocate()
+		if err != nil {
+			return nil, fmt.Errorf("failed to allocate UE MAC: %w", err)
+		}
+		if session.UEMACs == nil {
+			session.UEMACs = make(map[string]net.HardwareAddr)
+		}
+		session.UEMACs[original.String()] = mac
+		return mac, nil
+	}
+}
+
 func (m *Manager) handleHeartbeat(ctx context.Context, msg message.Message) error {
 	req, ok := msg.(*message.HeartbeatRequest)
 	if !ok {
 		return fmt.Errorf("unexpected message type for Heartbeat")
 	}
 
+	expected := m.expectedIEs(req)
 	seqNum := m.seqCounter.Next()
 	if err := m.modifier.ModifyHeartbeat(req, seqNum); err != nil {
 		return fmt.Errorf("failed to modify Heartbeat: %w", err)
 	}
 
-	data, err := pfcp.Encode(req)
+	data, err := m.encode(req, expected)
 	if err != nil {
 		return fmt.Errorf("failed to encode Heartbeat: %w", err)
 	}
 
+	if m.dryRun {
+		m.printDryRun(req, data, "")
+		return nil
+	}
+
+	return m.sendHeartbeat(ctx, req, data)
+}
+
+// handlePFDManagement replays a PFD Management Request with a new sequence
+// number and checks the response's Cause.
+func (m *Manager) handlePFDManagement(ctx context.Context, msg message.Message) error {
+	req, ok := msg.(*message.PFDManagementRequest)
+	if !ok {
+		return fmt.Errorf("unexpected message type for PFD Management")
+	}
+
+	expected := m.expectedIEs(req)
+	seqNum := m.seqCounter.Next()
+	if err := m.modifier.ModifyPFDManagement(req, seqNum); err != nil {
+		return fmt.Errorf("failed to modify PFD Management: %w", err)
+	}
+
+	data, err := m.encode(req, expected)
+	if err != nil {
+		return fmt.Errorf("failed to encode PFD Management: %w", err)
+	}
+
+	if m.dryRun {
+		m.printDryRun(req, data, fmt.Sprintf("applications=%d", len(req.ApplicationIDsPFDs)))
+		return nil
+	}
+
+	msgTypeName := "PFDManagementRequest"
+	resultCh, err := m.tracker.Track(ctx, seqNum, message.MsgTypePFDManagementRequest, data)
+	if err != nil {
+		return fmt.Errorf("failed to send PFD Management: %w", err)
+	}
+	m.stats.RecordSent(msgTypeName)
+
+	if err := m.client.Send(data); err != nil {
+		m.tracker.Cancel(seqNum)
+		return fmt.Errorf("failed to send PFD Management: %w", err)
+	}
+
+	result := m.waitForResult(ctx, req, resultCh)
+	if result.Error != nil {
+		m.stats.RecordTimeout(msgTypeName)
+		m.recordEvent(req, nil, 0, stats.ResultTimeout, nil)
+		return fmt.Errorf("PFD Management timeout: %w", result.Error)
+	}
+
+	m.stats.RecordReceived("PFDManagementResponse")
+
+	rej, err := checkCause(result)
+	if err != nil {
+		m.stats.RecordFailure(msgTypeName)
+		m.recordEvent(req, nil, result.ResponseTime, stats.ResultError, nil)
+		return fmt.Errorf("invalid PFD Management Response: %w", err)
+	}
+	if rej != nil {
+		m.stats.RecordFailure(msgTypeName)
+		m.stats.RecordCause(msgTypeName, rej.Cause)
+		m.recordEvent(req, nil, result.ResponseTime, stats.ResultRejected, rej)
+		return fmt.Errorf("PFD Management rejected with %s", rej)
+	}
+
+	m.stats.RecordSuccess(msgTypeName, result.ResponseTime)
+	m.recordEvent(req, nil, result.ResponseTime, stats.ResultSuccess, nil)
+	log.WithFields(log.Fields{
+		"seq_num":       seqNum,
+		"applications":  len(req.ApplicationIDsPFDs),
+		"response_time": result.ResponseTime.Round(time.Microsecond),
+	}).Debug("PFD Management successful")
+	return nil
+}
+
+// heartbeatLoop sends a Heartbeat Request with our Recovery Time Stamp every
+// interval until ctx is cancelled.
+func (m *Manager) heartbeatLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		m.mu.RLock()
+		recoveryTime := m.recoveryTime
+		m.mu.RUnlock()
+
+		seqNum := m.seqCounter.Next()
+		req := message.NewHeartbeatRequest(seqNum, ie.NewRecoveryTimeStamp(recoveryTime), nil)
+		data, err := m.encode(req, nil)
+		if err != nil {
+			log.WithError(err).Error("Failed to encode periodic Heartbeat")
+			continue
+		}
+
+		if err := m.sendHeartbeat(ctx, req, data); err != nil {
+			if ctx.Err() == nil {
+				log.WithError(err).WithField("seq_num", seqNum).Warn("Periodic Heartbeat failed")
+			}
+			continue
+		}
+		log.WithField("seq_num", seqNum).Debug("Periodic Heartbeat successful")
+	}
+}
+
+// sendHeartbeat sends a Heartbeat Request, encoded as data, and waits for the response.
+func (m *Manager) sendHeartbeat(ctx context.Context, req *message.HeartbeatRequest, data []byte) error {
 	msgTypeName := "HeartbeatRequest"
+	resultCh, err := m.tracker.Track(ctx, req.Sequence(), message.MsgTypeHeartbeatRequest, data)
+	if err != nil {
+		return fmt.Errorf("failed to send Heartbeat: %w", err)
+	}
 	m.stats.RecordSent(msgTypeName)
-	resultCh := m.tracker.Track(seqNum, data)
 
 	if err := m.client.Send(data); err != nil {
+		m.tracker.Cancel(req.Sequence())
 		return fmt.Errorf("failed to send Heartbeat: %w", err)
 	}
 
-	result := m.waitForResult(ctx, resultCh)
+	result := m.waitForResult(ctx, req, resultCh)
 	if result.Error != nil {
 		m.stats.RecordTimeout(msgTypeName)
+		m.recordEvent(req, nil, 0, stats.ResultTimeout, nil)
 		return fmt.Errorf("Heartbeat timeout: %w", result.Error)
 	}
 
 	m.stats.RecordReceived("HeartbeatResponse")
 	m.stats.RecordSuccess(msgTypeName, result.ResponseTime)
+	m.recordEvent(req, nil, result.ResponseTime, stats.ResultSuccess, nil)
 
 	return nil
 }
 
-// CleanupSessions sends Session Deletion for all active sessions.
-func (m *Manager) CleanupSessions(ctx context.Context) {
+// Probe sends a Heartbeat Request and waits up to timeout for the response,
+// to check that the UPF is reachable before any session is established.
+func (m *Manager) Probe(ctx context.Context, timeout time.Duration) error {
+	addr := net.JoinHostPort(m.cfg.UPF.Address, strconv.Itoa(m.cfg.UPF.Port))
+	probeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if m.receiver != nil {
+		go m.handleResponses(probeCtx)
+	}
+
+	m.mu.RLock()
+	recoveryTime := m.recoveryTime
+	m.mu.RUnlock()
+
+	seqNum := m.seqCounter.Next()
+	req := message.NewHeartbeatRequest(seqNum, ie.NewRecoveryTimeStamp(recoveryTime), nil)
+	data, err := m.encode(req, nil)
+	if err != nil {
+		return fmt.Errorf("failed to encode probe Heartbeat: %w", err)
+	}
+
+	resultCh, err := m.tracker.Track(probeCtx, seqNum, message.MsgTypeHeartbeatRequest, data)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("UPF not reachable at %s: no Heartbeat Response within %v", addr, timeout)
+	}
+	defer m.tracker.Cancel(seqNum)
+	if err := m.client.Send(data); err != nil {
+		return fmt.Errorf("UPF not reachable at %s: %w", addr, err)
+	}
+
+	result := m.waitForResult(probeCtx, req, resultCh)
+	if result.Error != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("UPF not reachable at %s: no Heartbeat Response within %v", addr, timeout)
+	}
+
+	log.WithFields(log.Fields{
+		"upf":           addr,
+		"response_time": result.ResponseTime.Round(time.Microsecond),
+	}).Info("UPF reachable")
+	return nil
+}
+
+// CleanupSessions sends Session Deletion for all active sessions and returns
+// how many were deleted and how many could not be. Up to
+// session.cleanup_concurrency batches of deletions await their responses at
+// once, so a session the UPF does not answer does not hold up the others. A
+// deletion is attempted for every session even if earlier ones fail; sessions
+// not yet attempted when ctx expires are counted as failed.
+func (m *Manager) CleanupSessions(ctx context.Context) (deleted, failed int) {
 	m.mu.RLock()
 	var activeSessions []*types.SessionInfo
 	for _, s := range m.byLocalSEID {
@@ -521,43 +1608,170 @@ func (m *Manager) CleanupSessions(ctx context.Context) {
 	m.mu.RUnlock()
 
 	if len(activeSessions) == 0 {
-		return
+		return 0, 0
 	}
 
 	log.WithField("count", len(activeSessions)).Info("Cleaning up active sessions")
 
-	for _, session := range activeSessions {
-		select {
-		case <-ctx.Done():
-			return
-		default:
+	// The replay's response handler stops with the replay context, which is
+	// already cancelled after a shutdown signal
+	if m.receiver != nil {
+		go m.handleResponses(ctx)
+	}
+
+	// With network.send_batch, deletions are sent in batches without waiting
+	// for responses in between. This is the only batched send path: the replay
+	// needs each response before it can send the next message.
+	batchSize := 1
+	if m.cfg.Network.SendBatch > 1 {
+		batchSize = m.cfg.Network.SendBatch
+	}
+	workers := max(m.cfg.Session.CleanupConcurrency, 1)
+
+	// Each worker tracks its whole batch before sending it, so with a
+	// timing.max_in_flight window the batches of all workers must fit in it
+	// together. Otherwise workers blocked in Track with part of their batch
+	// tracked can fill the window with deletions none of them can send.
+	if window := m.cfg.Timing.MaxInFlight; window > 0 {
+		workers = max(min(workers, window/batchSize), 1)
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		timedOut int
+	)
+	batches := make(chan []*types.SessionInfo)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				errs := m.cleanupBatch(ctx, batch)
+				mu.Lock()
+				for j, err := range errs {
+					switch {
+					case err == nil:
+						deleted++
+						continue
+					case errors.Is(err, errNoResponse):
+						timedOut++
+					}
+					log.WithError(err).WithField("local_seid", batch[j].LocalSEID).Warn("Cleanup deletion failed")
+					failed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	remaining := 0
+	for i := 0; i < len(activeSessions) && remaining == 0; i += batchSize {
+		batch := activeSessions[i:min(i+batchSize, len(activeSessions))]
+		if ctx.Err() == nil {
+			select {
+			case batches <- batch:
+				continue
+			case <-ctx.Done():
+			}
 		}
+		remaining = len(activeSessions) - i
+	}
+	close(batches)
+	wg.Wait()
+
+	if remaining > 0 {
+		failed += remaining
+		log.WithField("remaining", remaining).Warn("Cleanup timed out before all sessions were deleted")
+	}
+
+	entry := log.WithFields(log.Fields{"deleted": deleted, "timed_out": timedOut + remaining, "failed": failed})
+	if failed > 0 {
+		entry.Warn("Session cleanup finished, some sessions may remain on the UPF")
+	} else {
+		entry.Info("Session cleanup finished")
+	}
+	return deleted, failed
+}
 
+// cleanupBatch sends a Session Deletion Request for each session, all of them
+// before waiting for any response and with a single batched send if the
+// transport supports it. It returns one error per session, nil if the UPF
+// accepted the deletion.
+func (m *Manager) cleanupBatch(ctx context.Context, sessions []*types.SessionInfo) []error {
+	errs := make([]error, len(sessions))
+	reqs := make([]*message.SessionDeletionRequest, len(sessions))
+	resultChs := make([]<-chan types.TransactionResult, len(sessions))
+	var batch [][]byte
+	var batched []int // index in sessions of each message in batch
+
+	for i, session := range sessions {
 		seqNum := m.seqCounter.Next()
 		req := message.NewSessionDeletionRequest(0, 0, session.RemoteSEID, seqNum, 0)
-
-		data, err := pfcp.Encode(req)
+		data, err := m.encode(req, nil)
 		if err != nil {
-			log.WithError(err).WithField("local_seid", session.LocalSEID).Error("Failed to encode cleanup deletion")
+			errs[i] = fmt.Errorf("failed to encode cleanup deletion: %w", err)
 			continue
 		}
-
-		resultCh := m.tracker.Track(seqNum, data)
-		if err := m.client.Send(data); err != nil {
-			log.WithError(err).WithField("local_seid", session.LocalSEID).Error("Failed to send cleanup deletion")
+		if resultChs[i], err = m.tracker.Track(ctx, seqNum, message.MsgTypeSessionDeletionRequest, data); err != nil {
+			errs[i] = fmt.Errorf("failed to send cleanup deletion: %w", err)
 			continue
 		}
+		reqs[i] = req
+		batch = append(batch, data)
+		batched = append(batched, i)
+	}
 
-		result := m.waitForResult(ctx, resultCh)
-		if result.Error != nil {
-			log.WithError(result.Error).WithField("local_seid", session.LocalSEID).Warn("Cleanup deletion failed")
-		} else {
-			m.stats.RecordSessionDeleted()
-			m.mu.Lock()
-			session.State = "deleted"
-			m.mu.Unlock()
+	sent := 0
+	var sendErr error
+	if sender, ok := m.client.(network.BatchSender); ok && len(batch) > 1 {
+		sent, sendErr = sender.SendBatch(batch)
+	} else {
+		for _, data := range batch {
+			if sendErr = m.client.Send(data); sendErr != nil {
+				break
+			}
+			sent++
 		}
 	}
+
+	// The deletions that went out are waited for even if a later one failed;
+	// the unsent ones are cancelled so they are not retransmitted
+	for _, i := range batched[sent:] {
+		m.tracker.Cancel(reqs[i].Sequence())
+		errs[i] = fmt.Errorf("failed to send cleanup deletion: %w", sendErr)
+	}
+	for _, i := range batched[:sent] {
+		errs[i] = m.finishCleanup(ctx, reqs[i], sessions[i], resultChs[i])
+	}
+	return errs
+}
+
+// finishCleanup waits for the response to a cleanup deletion and records its outcome.
+func (m *Manager) finishCleanup(ctx context.Context, req *message.SessionDeletionRequest, session *types.SessionInfo, resultCh <-chan types.TransactionResult) error {
+	result := m.waitForResult(ctx, req, resultCh)
+	if result.Error != nil {
+		m.recordEvent(req, session, 0, stats.ResultTimeout, nil)
+		return fmt.Errorf("%w: %w", errNoResponse, result.Error)
+	}
+
+	rej, err := checkCause(result)
+	if err != nil {
+		m.recordEvent(req, session, result.ResponseTime, stats.ResultError, nil)
+		return fmt.Errorf("invalid Session Deletion Response: %w", err)
+	}
+	if rej != nil {
+		m.recordEvent(req, session, result.ResponseTime, stats.ResultRejected, rej)
+		return fmt.Errorf("rejected with %s", rej)
+	}
+
+	m.stats.RecordSessionDeleted()
+	m.recordLifetime(session)
+	m.recordEvent(req, session, result.ResponseTime, stats.ResultSuccess, nil)
+	m.mu.Lock()
+	session.State = "deleted"
+	m.mu.Unlock()
+	return nil
 }
 
 // handleResponses processes incoming PFCP messages from the UPF.
@@ -570,12 +1784,251 @@ func (m *Manager) handleResponses(ctx context.Context) {
 			if !ok {
 				return
 			}
+			if ts, ok := upfRecoveryTimeStamp(received.Message); ok {
+				m.checkUPFRecovery(ts)
+			}
+			// Requests originated by the UPF answer none of ours
+			if !pfcp.IsResponse(received.Message) {
+				m.handleIncomingRequest(received.Message)
+				continue
+			}
 			seqNum := received.Message.Sequence()
 			m.tracker.Resolve(seqNum, received.Message, received.Data)
 		}
 	}
 }
 
+// handleIncomingRequest dispatches a request originated by the UPF. Requests
+// we have no handler for are counted and logged, but not answered.
+func (m *Manager) handleIncomingRequest(msg message.Message) {
+	switch req := msg.(type) {
+	case *message.NodeReportRequest:
+		m.handleNodeReport(req)
+	case *message.HeartbeatRequest:
+		m.handleIncomingHeartbeat(req)
+	case *message.SessionReportRequest:
+		m.handleSessionReport(req)
+	default:
+		msgTypeName := pfcp.MessageTypeName(msg.MessageType())
+		m.stats.RecordReceived(msgTypeName)
+		log.WithFields(log.Fields{
+			"msg_type": msgTypeName,
+			"seq_num":  msg.Sequence(),
+		}).Warn("Ignoring unsupported request from UPF")
+	}
+}
+
+// handleIncomingHeartbeat answers a Heartbeat Request from the UPF with our
+// Recovery Time Stamp.
+func (m *Manager) handleIncomingHeartbeat(req *message.HeartbeatRequest) {
+	m.stats.RecordReceived("HeartbeatRequest")
+
+	m.mu.RLock()
+	recoveryTime := m.recoveryTime
+	m.mu.RUnlock()
+
+	m.reply(message.NewHeartbeatResponse(req.Sequence(), ie.NewRecoveryTimeStamp(recoveryTime)), "HeartbeatResponse")
+}
+
+// handleSessionReport answers a Session Report Request from the UPF with the
+// configured Cause, addressed to the UPF's SEID for the session. A report for
+// an unknown session is answered with "Session context not found".
+func (m *Manager) handleSessionReport(req *message.SessionReportRequest) {
+	m.stats.RecordReceived("SessionReportRequest")
+
+	fields := log.Fields{"seq_num": req.Sequence(), "seid": req.SEID()}
+	if req.ReportType != nil {
+		if reportType, err := req.ReportType.ReportType(); err == nil {
+			fields["report_type"] = fmt.Sprintf("0x%02x", reportType)
+		}
+	}
+
+	if !m.cfg.Report.AutoRespond {
+		log.WithFields(fields).Log(m.messageLogLevel, "Received Session Report Request, not answering")
+		return
+	}
+
+	m.mu.RLock()
+	session := m.byLocalSEID[req.SEID()]
+	var remoteSEID uint64
+	if session != nil {
+		remoteSEID = session.RemoteSEID
+	}
+	m.mu.RUnlock()
+
+	cause := uint8(m.cfg.Report.ResponseCause)
+	if session == nil {
+		cause = ie.CauseSessionContextNotFound
+	}
+	fields["cause"] = pfcp.CauseName(cause)
+	log.WithFields(fields).Log(m.messageLogLevel, "Received Session Report Request")
+
+	m.reply(message.NewSessionReportResponse(0, 0, remoteSEID, req.Sequence(), 0, ie.NewCause(cause)), "SessionReportResponse")
+}
+
+// reply sends a response to a request originated by the UPF.
+func (m *Manager) reply(resp message.Message, msgTypeName string) {
+	data, err := m.encode(resp, nil)
+	if err != nil {
+		log.WithError(err).WithField("msg_type", msgTypeName).Warn("Failed to encode response")
+		return
+	}
+	if err := m.client.Send(data); err != nil {
+		log.WithError(err).WithField("msg_type", msgTypeName).Warn("Failed to send response")
+		return
+	}
+	m.stats.RecordSent(msgTypeName)
+}
+
+// handleNodeReport answers a Node Report Request from the UPF with an accepted
+// Node Report Response, logging the report type and any failed GTP-U peers.
+func (m *Manager) handleNodeReport(req *message.NodeReportRequest) {
+	m.stats.RecordReceived("NodeReportRequest")
+
+	fields := log.Fields{"seq_num": req.Sequence()}
+	if req.NodeReportType != nil {
+		if reportType, err := req.NodeReportType.NodeReportType(); err == nil {
+			fields["report_type"] = fmt.Sprintf("0x%02x", reportType)
+		}
+	}
+	if peers := pathFailurePeers(req.UserPlanePathFailureReport); len(peers) > 0 {
+		fields["failed_peers"] = strings.Join(peers, ",")
+	}
+	log.WithFields(fields).Info("Received Node Report Request")
+
+	m.reply(m.modifier.NewNodeReportResponse(req.Sequence(), ie.CauseRequestAccepted), "NodeReportResponse")
+}
+
+// pathFailurePeers returns the addresses of the Remote GTP-U Peers in a User
+// Plane Path Failure Report.
+func pathFailurePeers(report *ie.IE) []string {
+	if report == nil {
+		return nil
+	}
+	children, err := report.UserPlanePathFailureReport()
+	if err != nil {
+		return nil
+	}
+	var peers []string
+	for _, child := range children {
+		if child.Type != ie.RemoteGTPUPeer {
+			continue
+		}
+		peer, err := child.RemoteGTPUPeer()
+		if err != nil {
+			continue
+		}
+		if peer.IPv4Address != nil {
+			peers = append(peers, peer.IPv4Address.String())
+		}
+		if peer.IPv6Address != nil {
+			peers = append(peers, peer.IPv6Address.String())
+		}
+	}
+	return peers
+}
+
+// upfRecoveryTimeStamp returns the Recovery Time Stamp carried by a message
+// from the UPF, if any.
+func upfRecoveryTimeStamp(msg message.Message) (time.Time, bool) {
+	var rts *ie.IE
+	switch msg := msg.(type) {
+	case *message.AssociationSetupResponse:
+		rts = msg.RecoveryTimeStamp
+	case *message.HeartbeatResponse:
+		rts = msg.RecoveryTimeStamp
+	case *message.HeartbeatRequest:
+		rts = msg.RecoveryTimeStamp
+	}
+	if rts == nil {
+		return time.Time{}, false
+	}
+	ts, err := rts.RecoveryTimeStamp()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return ts, true
+}
+
+// checkUPFRecovery compares a Recovery Time Stamp received from the UPF with
+// the last one seen. A later timestamp means the UPF has restarted and lost
+// all sessions: they are counted as failed and released and, with
+// reconnect_on_restart, the association and sessions are set up again before
+// the next replayed message.
+func (m *Manager) checkUPFRecovery(ts time.Time) {
+	m.mu.Lock()
+	previous := m.upfRecoveryTime
+	if !ts.After(previous) {
+		m.mu.Unlock()
+		return
+	}
+	m.upfRecoveryTime = ts
+	if previous.IsZero() {
+		m.mu.Unlock()
+		return
+	}
+
+	var lost []*types.SessionInfo
+	for _, session := range m.byLocalSEID {
+		if session.State == "established" || session.State == "modifying" {
+			lost = append(lost, session)
+			if m.cfg.Association.ReconnectOnRestart {
+				m.lostSessions = append(m.lostSessions, session.OriginalCPSEID)
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	log.WithFields(log.Fields{
+		"previous_recovery": previous.Format(time.RFC3339),
+		"recovery":          ts.Format(time.RFC3339),
+		"lost_sessions":     len(lost),
+	}).Warn("UPF restart detected")
+	m.stats.RecordUPFRestart()
+
+	for _, session := range lost {
+		m.stats.RecordSessionLost()
+		m.releaseSession(session)
+	}
+
+	if m.cfg.Association.ReconnectOnRestart {
+		m.restartPending.Store(true)
+	}
+}
+
+// reconnect replays the Association Setup Request and the Establishment
+// Requests of the sessions lost in a UPF restart.
+func (m *Manager) reconnect(ctx context.Context) {
+	m.mu.Lock()
+	lost := m.lostSessions
+	m.lostSessions = nil
+	m.mu.Unlock()
+
+	log.WithField("sessions", len(lost)).Info("Reconnecting after UPF restart")
+
+	if m.associationRequest != nil {
+		if msg, err := pfcp.Decode(m.associationRequest); err == nil {
+			if err := m.handleAssociationSetup(ctx, msg); err != nil {
+				log.WithError(err).Error("Failed to re-establish association after UPF restart")
+			}
+		}
+	}
+
+	for _, cpSEID := range lost {
+		data, ok := m.establishmentRequests[cpSEID]
+		if !ok {
+			continue
+		}
+		msg, err := pfcp.Decode(data)
+		if err != nil {
+			continue
+		}
+		if err := m.handleSessionEstablishment(ctx, msg); err != nil {
+			log.WithError(err).WithField("cp_seid", cpSEID).Error("Failed to re-establish session after UPF restart")
+		}
+	}
+}
+
 // findSessionByOriginalRemoteSEID finds a session using the original remote SEID from the pcap.
 func (m *Manager) findSessionByOriginalRemoteSEID(originalRemoteSEID uint64) *types.SessionInfo {
 	m.mu.RLock()
@@ -616,11 +2069,59 @@ func (m *Manager) ActiveSessionCount() int {
 	return count
 }
 
-func (m *Manager) waitForResult(ctx context.Context, resultCh <-chan types.TransactionResult) types.TransactionResult {
+// logUPFunctionFeatures logs the UP Function Features the UPF advertised in
+// its Association Setup Response.
+func logUPFunctionFeatures(data []byte) {
+	msg, err := pfcp.Decode(data)
+	if err != nil {
+		return
+	}
+	resp, ok := msg.(*message.AssociationSetupResponse)
+	if !ok {
+		return
+	}
+	if resp.UPFunctionFeatures == nil {
+		log.Warn("UPF advertised no UP Function Features")
+		return
+	}
+	features, err := resp.UPFunctionFeatures.UPFunctionFeatures()
+	if err != nil {
+		log.WithError(err).Warn("Failed to decode UP Function Features")
+		return
+	}
+	log.WithFields(log.Fields{
+		"features": strings.Join(pfcp.UPFunctionFeatureNames(features), ","),
+		"raw":      fmt.Sprintf("%x", features),
+	}).Info("UPF function features")
+}
+
+// checkCause returns the rejection details of a response whose Cause is not
+// Request accepted, or nil if it is. Responses that do not decode or carry no
+// Cause, such as a Version Not Supported Response, are an error: the Cause is
+// mandatory, so they cannot count as accepted.
+func checkCause(result types.TransactionResult) (*pfcp.Rejection, error) {
+	resp, err := pfcp.Decode(result.Response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if _, err := pfcp.ResponseCause(resp); err != nil {
+		return nil, fmt.Errorf("%s: %w", pfcp.MessageTypeName(resp.MessageType()), err)
+	}
+	rej, _ := pfcp.ExtractRejection(resp)
+	return rej, nil
+}
+
+// waitForResult waits for the result of req's transaction and passes an
+// answered request to the AfterResponse hook. If ctx is done first, the
+// transaction is cancelled, so that it is not retransmitted after the caller
+// gave up on it.
+func (m *Manager) waitForResult(ctx context.Context, req message.Message, resultCh <-chan types.TransactionResult) types.TransactionResult {
 	select {
 	case <-ctx.Done():
+		m.tracker.Cancel(req.Sequence())
 		return types.TransactionResult{Error: ctx.Err()}
 	case result := <-resultCh:
+		m.afterResponse(req, result)
 		return result
 	}
 }
@@ -0,0 +1,987 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+
+	"pfcp-generator/internal/config"
+	"pfcp-generator/internal/network"
+	"pfcp-generator/internal/stats"
+	"pfcp-generator/pkg/types"
+)
+
+// fakeTransport records sent payloads instead of writing to a socket.
+type fakeTransport struct {
+	mu   sync.Mutex
+	sent [][]byte
+}
+
+func (f *fakeTransport) Send(data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, data)
+	return nil
+}
+
+func (f *fakeTransport) Conn() net.Conn                       { return nil }
+func (f *fakeTransport) Close() error                         { return nil }
+func (f *fakeTransport) LocalAddr() net.Addr                  { return &net.UDPAddr{} }
+func (f *fakeTransport) SetRecorder(r network.PacketRecorder) {}
+
+// brokenTransport fails every send.
+type brokenTransport struct {
+	fakeTransport
+}
+
+func (b *brokenTransport) Send(data []byte) error {
+	return errors.New("network is unreachable")
+}
+
+func testConfig() *config.Config {
+	return &config.Config{
+		SMF: config.SMFConfig{Address: "127.0.0.1", Port: 8805},
+		UPF: config.UPFConfig{Address: "127.0.0.1", Port: 8805},
+		Session: config.SessionConfig{
+			SEIDStart:    1,
+			SEIDStrategy: "sequential",
+			UEIPStrategy: "sequential",
+			UEIPPool:     "10.60.0.0/24",
+			StripIPv6:    true,
+		},
+		Timing: config.TimingConfig{
+			ResponseTimeoutMs: 20,
+			MaxRetries:        2,
+		},
+	}
+}
+
+func TestManager_RecordsRetransmitOnTimeout(t *testing.T) {
+	cfg := testConfig()
+	transport := &fakeTransport{}
+	tracker := network.NewTransactionTracker(transport, cfg.Timing.ResponseTimeoutMs, cfg.Timing.MaxRetries)
+	collector := stats.NewCollector()
+
+	_, err := NewManager(cfg, transport, nil, tracker, collector, nil, nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tracker.StartTimeoutMonitor(ctx)
+
+	tracker.Track(ctx, 1, message.MsgTypeSessionEstablishmentRequest, []byte{0x01})
+
+	require.Eventually(t, func() bool {
+		snap := collector.Snapshot()
+		s, ok := snap.MessageStats["SessionEstablishmentRequest"]
+		return ok && s.Retransmit >= 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	snap := collector.Snapshot()
+	assert.Zero(t, snap.MessageStats["SessionEstablishmentRequest"].Timeout)
+}
+
+func TestManager_AddSEIDMappingLinksExistingSession(t *testing.T) {
+	cfg := testConfig()
+	transport := &fakeTransport{}
+	tracker := network.NewTransactionTracker(transport, cfg.Timing.ResponseTimeoutMs, cfg.Timing.MaxRetries)
+
+	mgr, err := NewManager(cfg, transport, nil, tracker, stats.NewCollector(), nil, nil)
+	require.NoError(t, err)
+
+	// Streaming: the Establishment Request was replayed before its response was read
+	session := &types.SessionInfo{OriginalCPSEID: 0x10, LocalSEID: 1}
+	mgr.byOriginalCPSEID[0x10] = session
+	mgr.byLocalSEID[1] = session
+
+	mgr.AddSEIDMapping(types.SEIDMapping{OriginalCPSEID: 0x10, OriginalRemoteSEID: 0x20})
+
+	assert.Equal(t, uint64(0x20), session.OriginalRemoteSEID)
+	assert.Same(t, session, mgr.findSessionByOriginalRemoteSEID(0x20))
+	assert.Nil(t, mgr.findSessionByOriginalRemoteSEID(0x30))
+}
+
+func TestManager_DryRunPrintsModifiedMessages(t *testing.T) {
+	encode := func(msg message.Message) types.RawPFCPMessage {
+		b := make([]byte, msg.MarshalLen())
+		require.NoError(t, msg.MarshalTo(b))
+		return types.RawPFCPMessage{Data: b}
+	}
+	messages := []types.RawPFCPMessage{
+		encode(message.NewSessionEstablishmentRequest(0, 0, 0, 1, 0,
+			ie.NewNodeID("10.0.0.1", "", ""),
+			ie.NewFSEID(0x10, net.ParseIP("10.0.0.1"), nil),
+			ie.NewCreatePDR(
+				ie.NewPDRID(1),
+				ie.NewPDI(
+					ie.NewSourceInterface(ie.SrcInterfaceCore),
+					ie.NewUEIPAddress(0x02, "10.99.0.7", "", 0, 0),
+				),
+			),
+		)),
+		encode(message.NewSessionModificationRequest(0, 0, 0x20, 2, 0)),
+		encode(message.NewSessionDeletionRequest(0, 0, 0x20, 3, 0)),
+	}
+
+	mgr, err := NewManager(testConfig(), nil, nil, nil, stats.NewCollector(), nil, nil)
+	require.NoError(t, err)
+	var out bytes.Buffer
+	mgr.out = &out
+	mgr.SetDryRun(true, DryRunSummary)
+	mgr.SetSEIDMappings([]types.SEIDMapping{{OriginalCPSEID: 0x10, OriginalRemoteSEID: 0x20}})
+
+	require.NoError(t, mgr.Replay(context.Background(), messages))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[0], "SessionEstablishmentRequest")
+	assert.Contains(t, lines[0], "local_seid=1 ue_ip=10.60.0.1 orig_seid=16")
+	assert.Contains(t, lines[1], "SessionModificationRequest")
+	assert.Contains(t, lines[1], "seid=32 local_seid=1 ue_ip=10.60.0.1")
+	assert.Contains(t, lines[2], "SessionDeletionRequest")
+
+	// The deleted session's resources are released
+	assert.Zero(t, mgr.ActiveSessionCount())
+	assert.Zero(t, mgr.ipPool.(*UEIPPool).AllocatedCount())
+}
+
+func TestManager_DryRunPFDManagement(t *testing.T) {
+	req := message.NewPFDManagementRequest(7,
+		ie.NewApplicationIDsPFDs(
+			ie.NewApplicationID("app1"),
+			ie.NewPFDContext(ie.NewPFDContents("permit out ip from any to 10.0.0.1", "", "", "", "", nil, nil, nil)),
+		),
+	)
+	b := make([]byte, req.MarshalLen())
+	require.NoError(t, req.MarshalTo(b))
+
+	mgr, err := NewManager(testConfig(), nil, nil, nil, stats.NewCollector(), nil, nil)
+	require.NoError(t, err)
+	var out bytes.Buffer
+	mgr.out = &out
+	mgr.SetDryRun(true, DryRunSummary)
+
+	require.NoError(t, mgr.Replay(context.Background(), []types.RawPFCPMessage{{Data: b}}))
+
+	assert.Contains(t, out.String(), "PFDManagementRequest")
+	assert.Contains(t, out.String(), "applications=1")
+}
+
+func TestManager_SelectsUEIPPoolByNetworkInstance(t *testing.T) {
+	cfg := testConfig()
+	cfg.Session.UEIPPools = map[string]string{"IMS": "10.70.0.0/24"}
+	mgr, err := NewManager(cfg, nil, nil, nil, stats.NewCollector(), nil, nil)
+	require.NoError(t, err)
+
+	request := func(pdi ...*ie.IE) *message.SessionEstablishmentRequest {
+		return message.NewSessionEstablishmentRequest(0, 0, 0, 1, 0,
+			ie.NewCreatePDR(ie.NewPDRID(1), ie.NewPDI(pdi...)),
+		)
+	}
+
+	tests := []struct {
+		name string
+		req  *message.SessionEstablishmentRequest
+		pool string
+		dnn  string
+	}{
+		{"matching", request(ie.NewNetworkInstance("ims")), "10.70.0.0/24", "ims"},
+		{"dns labels", request(ie.NewNetworkInstanceFQDN("ims")), "10.70.0.0/24", "ims"},
+		{"no match", request(ie.NewNetworkInstance("internet")), "10.60.0.0/24", ""},
+		{"no network instance", request(ie.NewSourceInterface(ie.SrcInterfaceCore)), "10.60.0.0/24", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, dnn := mgr.selectUEIPPool(tt.req)
+			assert.Equal(t, tt.pool, pool.(*UEIPPool).cidr.String())
+			assert.Equal(t, tt.dnn, dnn)
+		})
+	}
+
+	// Addresses go back to the pool they came from
+	ip, err := mgr.dnnPools["ims"].Allocate()
+	require.NoError(t, err)
+	mgr.releaseSession(&types.SessionInfo{UEIP: ip})
+	assert.Zero(t, mgr.dnnPools["ims"].AllocatedCount())
+}
+
+func TestManager_DryRunDiff(t *testing.T) {
+	req := message.NewHeartbeatRequest(9, ie.NewRecoveryTimeStamp(time.Unix(1700000000, 0)), nil)
+	b := make([]byte, req.MarshalLen())
+	require.NoError(t, req.MarshalTo(b))
+
+	mgr, err := NewManager(testConfig(), nil, nil, nil, stats.NewCollector(), nil, nil)
+	require.NoError(t, err)
+	var out bytes.Buffer
+	mgr.out = &out
+	mgr.SetDryRun(true, DryRunDiff)
+
+	require.NoError(t, mgr.Replay(context.Background(), []types.RawPFCPMessage{{Data: b}}))
+
+	assert.Contains(t, out.String(), "-HeartbeatRequest (1) len=16 seq=9\n")
+	assert.Contains(t, out.String(), "+HeartbeatRequest (1) len=16 seq=1\n")
+}
+
+func TestManager_HeartbeatLoopSendsRecoveryTimeStamp(t *testing.T) {
+	cfg := testConfig()
+	transport := &fakeTransport{}
+	tracker := network.NewTransactionTracker(transport, cfg.Timing.ResponseTimeoutMs, 0)
+	collector := stats.NewCollector()
+
+	mgr, err := NewManager(cfg, transport, nil, tracker, collector, nil, nil)
+	require.NoError(t, err)
+	recoveryTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mgr.recoveryTime = recoveryTime
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tracker.StartTimeoutMonitor(ctx)
+	go mgr.heartbeatLoop(ctx, 10*time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		transport.mu.Lock()
+		defer transport.mu.Unlock()
+		return len(transport.sent) >= 2
+	}, 2*time.Second, 10*time.Millisecond)
+	cancel()
+
+	transport.mu.Lock()
+	data := transport.sent[0]
+	transport.mu.Unlock()
+
+	msg, err := message.Parse(data)
+	require.NoError(t, err)
+	req, ok := msg.(*message.HeartbeatRequest)
+	require.True(t, ok)
+	ts, err := req.RecoveryTimeStamp.RecoveryTimeStamp()
+	require.NoError(t, err)
+	assert.True(t, ts.Equal(recoveryTime))
+
+	assert.GreaterOrEqual(t, collector.Snapshot().MessageStats["HeartbeatRequest"].Sent, uint64(1))
+}
+
+func TestManager_Probe(t *testing.T) {
+	cfg := testConfig()
+
+	upf := &acceptingUPF{}
+	upf.tracker = network.NewTransactionTracker(upf, cfg.Timing.ResponseTimeoutMs, 0)
+	mgr, err := NewManager(cfg, upf, nil, upf.tracker, stats.NewCollector(), nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, mgr.Probe(context.Background(), time.Second))
+
+	// A UPF that never answers fails the probe after its timeout, not the
+	// tracker's, and leaves no transaction pending
+	transport := &fakeTransport{}
+	tracker := network.NewTransactionTracker(transport, 60000, 0)
+	mgr, err = NewManager(cfg, transport, nil, tracker, stats.NewCollector(), nil, nil)
+	require.NoError(t, err)
+	err = mgr.Probe(context.Background(), 50*time.Millisecond)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "UPF not reachable at 127.0.0.1:8805")
+	assert.Zero(t, tracker.PendingCount())
+}
+
+func TestManager_DropsEstablishmentRequestOfDeletedSession(t *testing.T) {
+	cfg := testConfig()
+	cfg.Timing.ResponseTimeoutMs = 1000
+	cfg.Association.ReconnectOnRestart = true
+
+	upf := &acceptingUPF{}
+	upf.tracker = network.NewTransactionTracker(upf, cfg.Timing.ResponseTimeoutMs, 0)
+	mgr, err := NewManager(cfg, upf, nil, upf.tracker, stats.NewCollector(), nil, nil)
+	require.NoError(t, err)
+
+	// Only the session left open can be lost in a UPF restart
+	messages, mappings := repeatPcap(t)
+	mgr.SetSEIDMappings(mappings)
+	require.NoError(t, mgr.Replay(context.Background(), messages[1:]))
+	assert.Len(t, mgr.establishmentRequests, 1)
+	assert.Contains(t, mgr.establishmentRequests, uint64(0x20))
+}
+
+func TestManager_DetectsUPFRestart(t *testing.T) {
+	cfg := testConfig()
+	cfg.Association.ReconnectOnRestart = true
+	collector := stats.NewCollector()
+
+	mgr, err := NewManager(cfg, &fakeTransport{}, nil, nil, collector, nil, nil)
+	require.NoError(t, err)
+
+	session := &types.SessionInfo{OriginalCPSEID: 0x10, LocalSEID: 1, State: "established", TEIDs: map[uint32]uint32{}}
+	mgr.byOriginalCPSEID[0x10] = session
+	mgr.byLocalSEID[1] = session
+	collector.RecordSessionEstablished()
+
+	// The first timestamp is the baseline; the same timestamp again is not a restart
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mgr.checkUPFRecovery(start)
+	mgr.checkUPFRecovery(start)
+	assert.Equal(t, "established", session.State)
+	assert.False(t, mgr.restartPending.Load())
+
+	mgr.checkUPFRecovery(start.Add(time.Minute))
+
+	assert.Equal(t, "deleted", session.State)
+	assert.Equal(t, 0, mgr.ActiveSessionCount())
+	assert.True(t, mgr.restartPending.Load())
+	assert.Equal(t, []uint64{0x10}, mgr.lostSessions)
+
+	snap := collector.Snapshot()
+	assert.Equal(t, uint64(1), snap.UPFRestarts)
+	assert.Equal(t, uint64(1), snap.SessionsFailed)
+	assert.Zero(t, snap.ActiveSessions)
+}
+
+func TestManager_AnswersNodeReport(t *testing.T) {
+	transport := &fakeTransport{}
+	collector := stats.NewCollector()
+	mgr, err := NewManager(testConfig(), transport, nil, nil, collector, nil, nil)
+	require.NoError(t, err)
+
+	mgr.handleNodeReport(message.NewNodeReportRequest(42,
+		ie.NewNodeID("10.0.0.2", "", ""),
+		ie.NewNodeReportType(0x01),
+		ie.NewUserPlanePathFailureReport(ie.NewRemoteGTPUPeer(0x02, "192.0.2.1", "", 0, "")),
+	))
+
+	require.Len(t, transport.sent, 1)
+	msg, err := message.Parse(transport.sent[0])
+	require.NoError(t, err)
+	resp, ok := msg.(*message.NodeReportResponse)
+	require.True(t, ok)
+	assert.Equal(t, uint32(42), resp.Sequence())
+	cause, err := resp.Cause.Cause()
+	require.NoError(t, err)
+	assert.Equal(t, ie.CauseRequestAccepted, cause)
+
+	snap := collector.Snapshot()
+	assert.Equal(t, uint64(1), snap.MessageStats["NodeReportRequest"].Received)
+	assert.Equal(t, uint64(1), snap.MessageStats["NodeReportResponse"].Sent)
+}
+
+func TestManager_HandleIncomingRequest(t *testing.T) {
+	transport := &fakeTransport{}
+	collector := stats.NewCollector()
+	mgr, err := NewManager(testConfig(), transport, nil, nil, collector, nil, nil)
+	require.NoError(t, err)
+	recoveryTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mgr.recoveryTime = recoveryTime
+
+	mgr.handleIncomingRequest(message.NewHeartbeatRequest(7, ie.NewRecoveryTimeStamp(recoveryTime), nil))
+	// No handler: counted but not answered
+	mgr.handleIncomingRequest(message.NewAssociationUpdateRequest(8, ie.NewNodeID("10.0.0.2", "", "")))
+
+	require.Len(t, transport.sent, 1)
+	msg, err := message.Parse(transport.sent[0])
+	require.NoError(t, err)
+	resp, ok := msg.(*message.HeartbeatResponse)
+	require.True(t, ok)
+	assert.Equal(t, uint32(7), resp.Sequence())
+	ts, err := resp.RecoveryTimeStamp.RecoveryTimeStamp()
+	require.NoError(t, err)
+	assert.True(t, ts.Equal(recoveryTime))
+
+	snap := collector.Snapshot()
+	assert.Equal(t, uint64(1), snap.MessageStats["HeartbeatResponse"].Sent)
+	assert.Equal(t, uint64(1), snap.MessageStats["AssociationUpdateRequest"].Received)
+}
+
+func TestManager_AnswersSessionReport(t *testing.T) {
+	cfg := testConfig()
+	cfg.Report = config.ReportConfig{AutoRespond: true, ResponseCause: int(ie.CauseRequestRejected)}
+	transport := &fakeTransport{}
+	collector := stats.NewCollector()
+	mgr, err := NewManager(cfg, transport, nil, nil, collector, nil, nil)
+	require.NoError(t, err)
+
+	session := &types.SessionInfo{LocalSEID: 1, RemoteSEID: 0x99, State: "established"}
+	mgr.byLocalSEID[1] = session
+
+	report := func(seid uint64, seq uint32) *message.SessionReportRequest {
+		return message.NewSessionReportRequest(0, 0, seid, seq, 0, ie.NewReportType(0, 0, 1, 0))
+	}
+	mgr.handleIncomingRequest(report(1, 10))
+	mgr.handleIncomingRequest(report(2, 11))
+
+	require.Len(t, transport.sent, 2)
+	tests := []struct {
+		seq   uint32
+		seid  uint64
+		cause uint8
+	}{
+		{10, 0x99, ie.CauseRequestRejected},
+		{11, 0, ie.CauseSessionContextNotFound},
+	}
+	for i, tt := range tests {
+		msg, err := message.Parse(transport.sent[i])
+		require.NoError(t, err)
+		resp, ok := msg.(*message.SessionReportResponse)
+		require.True(t, ok)
+		assert.Equal(t, tt.seq, resp.Sequence())
+		assert.Equal(t, tt.seid, resp.SEID())
+		cause, err := resp.Cause.Cause()
+		require.NoError(t, err)
+		assert.Equal(t, tt.cause, cause)
+	}
+	assert.Equal(t, uint64(2), collector.Snapshot().MessageStats["SessionReportResponse"].Sent)
+
+	// With auto_respond off, reports are counted but not answered
+	mgr.cfg.Report.AutoRespond = false
+	mgr.handleIncomingRequest(report(1, 12))
+	assert.Len(t, transport.sent, 2)
+	assert.Equal(t, uint64(3), collector.Snapshot().MessageStats["SessionReportRequest"].Received)
+}
+
+func TestUPFRecoveryTimeStamp(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	got, ok := upfRecoveryTimeStamp(message.NewHeartbeatResponse(1, ie.NewRecoveryTimeStamp(ts)))
+	require.True(t, ok)
+	assert.True(t, got.Equal(ts))
+
+	_, ok = upfRecoveryTimeStamp(message.NewSessionDeletionResponse(0, 0, 1, 1, 0, ie.NewCause(ie.CauseRequestAccepted)))
+	assert.False(t, ok)
+}
+
+func TestManager_AssociationSetupRetriesThenAborts(t *testing.T) {
+	cfg := testConfig()
+	cfg.Association = config.AssociationConfig{
+		Enabled:              true,
+		MaxSetupRetries:      2,
+		SetupRetryIntervalMs: 1,
+		OnSetupFailure:       "abort",
+	}
+	transport := &fakeTransport{}
+	tracker := network.NewTransactionTracker(transport, cfg.Timing.ResponseTimeoutMs, 0)
+	collector := stats.NewCollector()
+
+	mgr, err := NewManager(cfg, transport, nil, tracker, collector, nil, nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tracker.StartTimeoutMonitor(ctx)
+
+	req := message.NewAssociationSetupRequest(1, ie.NewNodeID("10.0.0.1", "", ""))
+	data, err := req.Marshal()
+	require.NoError(t, err)
+
+	err = mgr.replayMessage(ctx, 0, types.RawPFCPMessage{Data: data})
+	require.ErrorIs(t, err, ErrAssociationFailed)
+
+	// One attempt plus two retries, each with a fresh sequence number
+	transport.mu.Lock()
+	require.Len(t, transport.sent, 3)
+	var seqs []uint32
+	for _, sent := range transport.sent {
+		msg, err := message.Parse(sent)
+		require.NoError(t, err)
+		seqs = append(seqs, msg.Sequence())
+	}
+	transport.mu.Unlock()
+	assert.Equal(t, []uint32{1, 2, 3}, seqs)
+	assert.Equal(t, uint64(3), collector.Snapshot().MessageStats["AssociationSetupRequest"].Timeout)
+
+	// With on_setup_failure: continue the replay goes on
+	cfg.Association.OnSetupFailure = "continue"
+	assert.NoError(t, mgr.replayMessage(ctx, 0, types.RawPFCPMessage{Data: data}))
+}
+
+func TestManager_AssociationSetupRejected(t *testing.T) {
+	cfg := testConfig()
+	cfg.Timing.ResponseTimeoutMs = 1000
+	cfg.Association = config.AssociationConfig{
+		Enabled:              true,
+		MaxSetupRetries:      1,
+		SetupRetryIntervalMs: 1,
+		OnSetupFailure:       "abort",
+	}
+
+	upf := &acceptingUPF{causes: map[uint8]uint8{message.MsgTypeAssociationSetupRequest: ie.CauseRequestRejected}}
+	upf.tracker = network.NewTransactionTracker(upf, cfg.Timing.ResponseTimeoutMs, 0)
+	collector := stats.NewCollector()
+	mgr, err := NewManager(cfg, upf, nil, upf.tracker, collector, nil, nil)
+	require.NoError(t, err)
+
+	// The rejection is retried, then aborts the replay before any session
+	messages, mappings := repeatPcap(t)
+	mgr.SetSEIDMappings(mappings)
+	err = mgr.Replay(context.Background(), messages)
+	require.ErrorIs(t, err, ErrAssociationFailed)
+	assert.Contains(t, err.Error(), "Association Setup rejected")
+
+	msgTypes, _ := sentRequests(t, upf)
+	assert.Equal(t, []uint8{message.MsgTypeAssociationSetupRequest, message.MsgTypeAssociationSetupRequest}, msgTypes)
+	s := collector.Snapshot().MessageStats["AssociationSetupRequest"]
+	assert.Equal(t, uint64(2), s.Failed)
+	assert.Zero(t, s.Success)
+}
+
+func TestManager_ModificationAndDeletionCheckCause(t *testing.T) {
+	cfg := testConfig()
+	cfg.Timing.ResponseTimeoutMs = 1000
+
+	// The modification is rejected and the deletion answered without a Cause
+	upf := &acceptingUPF{causes: map[uint8]uint8{
+		message.MsgTypeSessionModificationRequest: ie.CauseRuleCreationModificationFailure,
+		message.MsgTypeSessionDeletionRequest:     0,
+	}}
+	upf.tracker = network.NewTransactionTracker(upf, cfg.Timing.ResponseTimeoutMs, 0)
+	collector := stats.NewCollector()
+	mgr, err := NewManager(cfg, upf, nil, upf.tracker, collector, nil, nil)
+	require.NoError(t, err)
+
+	messages, mappings := repeatPcap(t)
+	modification := message.NewSessionModificationRequest(0, 0, 0x9010, 5, 0)
+	data, err := modification.Marshal()
+	require.NoError(t, err)
+	mgr.SetSEIDMappings(mappings)
+	require.NoError(t, mgr.Replay(context.Background(), []types.RawPFCPMessage{messages[1], {Data: data}, messages[3]}))
+
+	snap := collector.Snapshot()
+	mod := snap.MessageStats["SessionModificationRequest"]
+	assert.Equal(t, uint64(1), mod.Failed)
+	assert.Zero(t, mod.Success)
+	assert.Equal(t, map[uint8]uint64{ie.CauseRuleCreationModificationFailure: 1}, snap.Causes["SessionModificationRequest"])
+	del := snap.MessageStats["SessionDeletionRequest"]
+	assert.Equal(t, uint64(1), del.Failed)
+	assert.Zero(t, del.Success)
+	assert.Zero(t, snap.SessionsModified)
+	assert.Zero(t, snap.SessionsDeleted)
+	assert.Equal(t, 1, mgr.ActiveSessionCount())
+}
+
+func TestManager_PFDManagementChecksCause(t *testing.T) {
+	cfg := testConfig()
+	cfg.Timing.ResponseTimeoutMs = 1000
+
+	// The response carries no Cause, so the request cannot count as accepted
+	upf := &acceptingUPF{causes: map[uint8]uint8{message.MsgTypePFDManagementRequest: 0}}
+	upf.tracker = network.NewTransactionTracker(upf, cfg.Timing.ResponseTimeoutMs, 0)
+	collector := stats.NewCollector()
+	mgr, err := NewManager(cfg, upf, nil, upf.tracker, collector, nil, nil)
+	require.NoError(t, err)
+
+	req := message.NewPFDManagementRequest(7, ie.NewApplicationIDsPFDs(ie.NewApplicationID("app1")))
+	data, err := req.Marshal()
+	require.NoError(t, err)
+	require.NoError(t, mgr.Replay(context.Background(), []types.RawPFCPMessage{{Data: data}}))
+
+	s := collector.Snapshot().MessageStats["PFDManagementRequest"]
+	assert.Equal(t, uint64(1), s.Failed)
+	assert.Zero(t, s.Success)
+}
+
+func TestManager_ModificationRewritesUpdateFARsAndQERs(t *testing.T) {
+	cfg := testConfig()
+	cfg.Timing.ResponseTimeoutMs = 1000
+	cfg.Session.RewriteTEID = true
+	cfg.Session.GTPPeerOverride = config.GTPPeerConfig{IP: "172.16.0.9"}
+	mbrDL := uint64(200000)
+	cfg.Session.QEROverride.MBRDownlink = &mbrDL
+
+	upf := &acceptingUPF{}
+	upf.tracker = network.NewTransactionTracker(upf, cfg.Timing.ResponseTimeoutMs, 0)
+	mgr, err := NewManager(cfg, upf, nil, upf.tracker, stats.NewCollector(), nil, nil)
+	require.NoError(t, err)
+
+	encode := func(msg message.Message) types.RawPFCPMessage {
+		b := make([]byte, msg.MarshalLen())
+		require.NoError(t, msg.MarshalTo(b))
+		return types.RawPFCPMessage{Data: b}
+	}
+	messages := []types.RawPFCPMessage{
+		encode(message.NewSessionEstablishmentRequest(0, 0, 0, 1, 0,
+			ie.NewNodeID("10.0.0.1", "", ""),
+			ie.NewFSEID(0x10, net.ParseIP("10.0.0.1"), nil),
+			ie.NewCreateFAR(
+				ie.NewFARID(1),
+				ie.NewForwardingParameters(
+					ie.NewOuterHeaderCreation(0x0100, 0x2001, "192.168.1.2", "", 0, 0, 0),
+				),
+			),
+		)),
+		// A handover: the downlink FAR moves to a new gNB and the rates change
+		encode(message.NewSessionModificationRequest(0, 0, 0x9010, 2, 0,
+			ie.NewUpdateFAR(
+				ie.NewFARID(1),
+				ie.NewUpdateForwardingParameters(
+					ie.NewOuterHeaderCreation(0x0100, 0x2001, "192.168.1.3", "", 0, 0, 0),
+				),
+			),
+			ie.NewUpdateQER(ie.NewQERID(1), ie.NewMBR(1000, 2000)),
+		)),
+	}
+	mgr.SetSEIDMappings([]types.SEIDMapping{{OriginalCPSEID: 0x10, OriginalRemoteSEID: 0x9010}})
+	require.NoError(t, mgr.Replay(context.Background(), messages))
+
+	require.Len(t, upf.sent, 2)
+	est, err := message.ParseSessionEstablishmentRequest(upf.sent[0])
+	require.NoError(t, err)
+	mod, err := message.ParseSessionModificationRequest(upf.sent[1])
+	require.NoError(t, err)
+
+	created, err := est.CreateFAR[0].ChildIEs[1].OuterHeaderCreation()
+	require.NoError(t, err)
+	updated, err := mod.UpdateFAR[0].ChildIEs[1].OuterHeaderCreation()
+	require.NoError(t, err)
+	assert.Equal(t, "172.16.0.9", updated.IPv4Address.String())
+	assert.NotEqual(t, uint32(0x2001), updated.TEID)
+	assert.Equal(t, created.TEID, updated.TEID)
+
+	ul, err := mod.UpdateQER[0].MBRUL()
+	require.NoError(t, err)
+	dl, err := mod.UpdateQER[0].MBRDL()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1000), ul)
+	assert.Equal(t, mbrDL, dl)
+}
+
+// listSEIDs is a SEIDStrategy handing out a fixed list of SEIDs.
+type listSEIDs struct {
+	mu       sync.Mutex
+	free     []uint64
+	released []uint64
+}
+
+func (l *listSEIDs) Allocate() (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.free) == 0 {
+		return 0, errors.New("no SEID left")
+	}
+	seid := l.free[0]
+	l.free = l.free[1:]
+	return seid, nil
+}
+
+func (l *listSEIDs) Release(seid uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.released = append(l.released, seid)
+}
+
+func TestManager_CustomSEIDStrategy(t *testing.T) {
+	cfg := testConfig()
+	cfg.Timing.ResponseTimeoutMs = 1000
+
+	upf := &acceptingUPF{}
+	upf.tracker = network.NewTransactionTracker(upf, cfg.Timing.ResponseTimeoutMs, 0)
+	seids := &listSEIDs{free: []uint64{0xA0, 0xA1}}
+	mgr, err := NewManager(cfg, upf, nil, upf.tracker, stats.NewCollector(), seids, nil)
+	require.NoError(t, err)
+
+	messages, mappings := repeatPcap(t)
+	mgr.SetSEIDMappings(mappings)
+	require.NoError(t, mgr.Replay(context.Background(), messages[1:]))
+
+	// The CP F-SEIDs sent are the strategy's, and the deleted session's is
+	// given back to it
+	var cpSEIDs []uint64
+	for _, sent := range upf.sent {
+		msg, err := message.Parse(sent)
+		require.NoError(t, err)
+		if req, ok := msg.(*message.SessionEstablishmentRequest); ok {
+			fseid, err := req.CPFSEID.FSEID()
+			require.NoError(t, err)
+			cpSEIDs = append(cpSEIDs, fseid.SEID)
+		}
+	}
+	assert.Equal(t, []uint64{0xA0, 0xA1}, cpSEIDs)
+	assert.Equal(t, []uint64{0xA0}, seids.released)
+}
+
+// listIPs is an IPAllocator handing out a fixed list of addresses.
+type listIPs struct {
+	mu       sync.Mutex
+	free     []net.IP
+	released []string
+}
+
+func (l *listIPs) Allocate() (net.IP, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.free) == 0 {
+		return nil, errors.New("no UE IP left")
+	}
+	ip := l.free[0]
+	l.free = l.free[1:]
+	return ip, nil
+}
+
+func (l *listIPs) Release(ip net.IP) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.released = append(l.released, ip.String())
+}
+
+func (l *listIPs) Available() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.free)
+}
+
+func TestManager_CustomUEIPAllocator(t *testing.T) {
+	cfg := testConfig()
+	cfg.Timing.ResponseTimeoutMs = 1000
+
+	upf := &acceptingUPF{}
+	upf.tracker = network.NewTransactionTracker(upf, cfg.Timing.ResponseTimeoutMs, 0)
+	ips := &listIPs{free: []net.IP{net.ParseIP("192.0.2.7"), net.ParseIP("192.0.2.9")}}
+	mgr, err := NewManager(cfg, upf, nil, upf.tracker, stats.NewCollector(), nil, ips)
+	require.NoError(t, err)
+
+	messages, mappings := repeatPcap(t)
+	mgr.SetSEIDMappings(mappings)
+	require.NoError(t, mgr.Replay(context.Background(), messages[1:]))
+
+	// The deleted session's address goes back to the allocator, the other
+	// session keeps its own
+	assert.Equal(t, []string{"192.0.2.7"}, ips.released)
+	require.Equal(t, 1, mgr.ActiveSessionCount())
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+	for _, session := range mgr.byLocalSEID {
+		if session.State == "established" {
+			assert.Equal(t, "192.0.2.9", session.UEIP.String())
+		}
+	}
+}
+
+func TestManager_AssociationRetryDelay(t *testing.T) {
+	cfg := testConfig()
+	cfg.Association.SetupRetryIntervalMs = 500
+	mgr, err := NewManager(cfg, nil, nil, nil, stats.NewCollector(), nil, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, 500*time.Millisecond, mgr.associationRetryDelay(1))
+	assert.Equal(t, time.Second, mgr.associationRetryDelay(2))
+	assert.Equal(t, 2*time.Second, mgr.associationRetryDelay(3))
+}
+
+func TestManager_CleanupSessionsAttemptsEverySession(t *testing.T) {
+	cfg := testConfig()
+	transport := &fakeTransport{}
+	tracker := network.NewTransactionTracker(transport, cfg.Timing.ResponseTimeoutMs, 0)
+
+	mgr, err := NewManager(cfg, transport, nil, tracker, stats.NewCollector(), nil, nil)
+	require.NoError(t, err)
+
+	for seid := uint64(1); seid <= 3; seid++ {
+		mgr.byLocalSEID[seid] = &types.SessionInfo{LocalSEID: seid, RemoteSEID: seid + 100, State: "established"}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tracker.StartTimeoutMonitor(ctx)
+
+	// No UPF answers: every deletion is attempted and fails
+	deleted, failed := mgr.CleanupSessions(ctx)
+	assert.Equal(t, 0, deleted)
+	assert.Equal(t, 3, failed)
+	transport.mu.Lock()
+	assert.Len(t, transport.sent, 3)
+	transport.mu.Unlock()
+
+	// Sessions left when the cleanup context expires count as failed
+	expired, expire := context.WithCancel(context.Background())
+	expire()
+	deleted, failed = mgr.CleanupSessions(expired)
+	assert.Equal(t, 0, deleted)
+	assert.Equal(t, 3, failed)
+}
+
+func TestManager_SkipsExcludedMessageTypes(t *testing.T) {
+	encode := func(msg message.Message) types.RawPFCPMessage {
+		b := make([]byte, msg.MarshalLen())
+		require.NoError(t, msg.MarshalTo(b))
+		return types.RawPFCPMessage{Data: b}
+	}
+	cfg := testConfig()
+	cfg.Input.ExcludeTypes = []string{"sessiondeletionrequest"}
+	transport := &fakeTransport{}
+	tracker := network.NewTransactionTracker(transport, cfg.Timing.ResponseTimeoutMs, 0)
+	collector := stats.NewCollector()
+
+	mgr, err := NewManager(cfg, transport, nil, tracker, collector, nil, nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tracker.StartTimeoutMonitor(ctx)
+
+	messages := []types.RawPFCPMessage{
+		encode(message.NewSessionDeletionRequest(0, 0, 0x20, 1, 0)),
+		encode(message.NewHeartbeatRequest(2, ie.NewRecoveryTimeStamp(time.Now()), nil)),
+		encode(message.NewSessionDeletionRequest(0, 0, 0x20, 3, 0)),
+	}
+	require.NoError(t, mgr.Replay(ctx, messages))
+
+	// Only the heartbeat is sent and counted
+	transport.mu.Lock()
+	require.Len(t, transport.sent, 1)
+	assert.Equal(t, message.MsgTypeHeartbeatRequest, transport.sent[0][1])
+	transport.mu.Unlock()
+
+	snap := collector.Snapshot()
+	assert.Contains(t, snap.MessageStats, "HeartbeatRequest")
+	assert.NotContains(t, snap.MessageStats, "SessionDeletionRequest")
+}
+
+func TestManager_CountsOrphanedRequests(t *testing.T) {
+	encode := func(msg message.Message) types.RawPFCPMessage {
+		b := make([]byte, msg.MarshalLen())
+		require.NoError(t, msg.MarshalTo(b))
+		return types.RawPFCPMessage{Data: b}
+	}
+	cfg := testConfig()
+	transport := &fakeTransport{}
+	tracker := network.NewTransactionTracker(transport, cfg.Timing.ResponseTimeoutMs, 0)
+	collector := stats.NewCollector()
+
+	mgr, err := NewManager(cfg, transport, nil, tracker, collector, nil, nil)
+	require.NoError(t, err)
+
+	// No session was established, so nothing is sent
+	messages := []types.RawPFCPMessage{
+		encode(message.NewSessionModificationRequest(0, 0, 0x20, 1, 0)),
+		encode(message.NewSessionDeletionRequest(0, 0, 0x20, 2, 0)),
+		encode(message.NewSessionDeletionRequest(0, 0, 0x21, 3, 0)),
+	}
+	require.NoError(t, mgr.Replay(context.Background(), messages))
+
+	transport.mu.Lock()
+	assert.Empty(t, transport.sent)
+	transport.mu.Unlock()
+
+	snap := collector.Snapshot()
+	assert.Equal(t, map[string]uint64{"SessionModificationRequest": 1, "SessionDeletionRequest": 2}, snap.OrphanedRequests)
+	assert.Equal(t, map[uint64]uint64{0x20: 2, 0x21: 1}, snap.OrphanedSEIDs)
+	assert.Zero(t, collector.Failures())
+}
+
+func TestManager_FailedRequestsFreeTheirWindowSlot(t *testing.T) {
+	heartbeat := func(t *testing.T, seq uint32) (*message.HeartbeatRequest, []byte) {
+		req := message.NewHeartbeatRequest(seq, ie.NewRecoveryTimeStamp(time.Now()), nil)
+		data, err := req.Marshal()
+		require.NoError(t, err)
+		return req, data
+	}
+
+	t.Run("failed send", func(t *testing.T) {
+		transport := &brokenTransport{}
+		tracker := network.NewTransactionTracker(transport, 60000, 0)
+		tracker.SetMaxInFlight(1)
+		mgr, err := NewManager(testConfig(), transport, nil, tracker, stats.NewCollector(), nil, nil)
+		require.NoError(t, err)
+
+		// With the first request still holding the only slot, the second
+		// would wait for it until the context times out
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		for seq := uint32(1); seq <= 2; seq++ {
+			req, data := heartbeat(t, seq)
+			assert.ErrorContains(t, mgr.sendHeartbeat(ctx, req, data), "network is unreachable")
+		}
+		assert.Zero(t, tracker.PendingCount())
+	})
+
+	t.Run("cancelled wait", func(t *testing.T) {
+		transport := &fakeTransport{}
+		tracker := network.NewTransactionTracker(transport, 20, 2)
+		tracker.SetMaxInFlight(1)
+		mgr, err := NewManager(testConfig(), transport, nil, tracker, stats.NewCollector(), nil, nil)
+		require.NoError(t, err)
+
+		netCtx, stop := context.WithCancel(context.Background())
+		defer stop()
+		tracker.StartTimeoutMonitor(netCtx)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		req, data := heartbeat(t, 1)
+		assert.ErrorIs(t, mgr.sendHeartbeat(ctx, req, data), context.DeadlineExceeded)
+		assert.Zero(t, tracker.PendingCount())
+
+		// The abandoned request is not retransmitted
+		time.Sleep(100 * time.Millisecond)
+		transport.mu.Lock()
+		assert.Len(t, transport.sent, 1)
+		transport.mu.Unlock()
+
+		trackCtx, trackCancel := context.WithTimeout(context.Background(), time.Second)
+		defer trackCancel()
+		_, err = tracker.Track(trackCtx, 2, message.MsgTypeHeartbeatRequest, data)
+		assert.NoError(t, err)
+	})
+}
+
+func TestManager_CancelledEstablishmentIsNotRetransmitted(t *testing.T) {
+	transport := &fakeTransport{}
+	tracker := network.NewTransactionTracker(transport, 20, 2)
+	mgr, err := NewManager(testConfig(), transport, nil, tracker, stats.NewCollector(), nil, nil)
+	require.NoError(t, err)
+
+	netCtx, stop := context.WithCancel(context.Background())
+	defer stop()
+	tracker.StartTimeoutMonitor(netCtx)
+
+	req := message.NewSessionEstablishmentRequest(0, 0, 0, 1, 0,
+		ie.NewNodeID("10.0.0.1", "", ""),
+		ie.NewFSEID(0x10, net.ParseIP("10.0.0.1"), nil),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, mgr.handleSessionEstablishment(ctx, req), context.DeadlineExceeded)
+
+	// The identifiers are released only once the request can no longer be
+	// retransmitted, and accepted, under them
+	assert.Zero(t, tracker.PendingCount())
+	assert.Zero(t, mgr.seidAlloc.(*SequentialSEIDAllocator).AllocatedCount())
+	assert.Zero(t, mgr.ipPool.(*UEIPPool).AllocatedCount())
+	time.Sleep(100 * time.Millisecond)
+	transport.mu.Lock()
+	assert.Len(t, transport.sent, 1)
+	transport.mu.Unlock()
+}
+
+func TestManager_RejectsUnknownMessageType(t *testing.T) {
+	cfg := testConfig()
+	cfg.Input.IncludeTypes = []string{"SessionEstablishmentRequests"}
+
+	_, err := NewManager(cfg, nil, nil, nil, stats.NewCollector(), nil, nil)
+	assert.ErrorContains(t, err, `unknown message type "SessionEstablishmentRequests"`)
+}
+
+func TestSequenceCounter_SkipsPendingAfterWrap(t *testing.T) {
+	cfg := testConfig()
+	transport := &fakeTransport{}
+	tracker := network.NewTransactionTracker(transport, 60000, 0)
+
+	mgr, err := NewManager(cfg, transport, nil, tracker, stats.NewCollector(), nil, nil)
+	require.NoError(t, err)
+
+	// A long-lived transaction holds sequence 1 while the counter wraps
+	first := mgr.seqCounter.Next()
+	require.Equal(t, uint32(1), first)
+	tracker.Track(context.Background(), first, message.MsgTypeSessionEstablishmentRequest, []byte{0x01})
+	mgr.seqCounter.current = maxSequence - 1
+
+	assert.Equal(t, uint32(maxSequence), mgr.seqCounter.Next())
+	assert.Equal(t, uint32(2), mgr.seqCounter.Next())
+
+	// Once resolved, the number is used again on the next wrap
+	tracker.Resolve(first, message.NewSessionEstablishmentResponse(0, 0, 1, first, 0), nil)
+	mgr.seqCounter.current = maxSequence
+	assert.Equal(t, uint32(1), mgr.seqCounter.Next())
+}
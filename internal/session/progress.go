@@ -0,0 +1,73 @@
+package session
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// replayProgress counts the messages a replay has sent and logs the count
+// every progress interval. A nil *replayProgress ignores every call.
+type replayProgress struct {
+	sent   atomic.Int64
+	total  int // 0 when unknown, as when streaming
+	cancel context.CancelFunc
+}
+
+// startProgress starts logging the progress of a replay of total messages,
+// or returns nil if progress logging is off or in dry-run mode.
+func (m *Manager) startProgress(ctx context.Context, total int) *replayProgress {
+	if m.progressInterval <= 0 || m.dryRun {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	p := &replayProgress{total: total, cancel: cancel}
+	go func() {
+		ticker := time.NewTicker(m.progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.logProgress(p)
+			}
+		}
+	}()
+	return p
+}
+
+// logProgress logs the number of messages sent (out of the total, if known),
+// the number of sessions established and active, and the number of UE IPs
+// left in the default pool.
+func (m *Manager) logProgress(p *replayProgress) {
+	established, active := m.stats.SessionCounts()
+	fields := log.Fields{
+		"sent":             p.sent.Load(),
+		"established":      established,
+		"active":           active,
+		"ue_ips_available": m.ipPool.Available(),
+	}
+	if p.total > 0 {
+		fields["total"] = p.total
+		fields["percent"] = int(100 * p.sent.Load() / int64(p.total))
+	}
+	log.WithFields(fields).Info("Replay progress")
+}
+
+// advance counts one more message as sent.
+func (p *replayProgress) advance() {
+	if p != nil {
+		p.sent.Add(1)
+	}
+}
+
+// stop ends progress logging.
+func (p *replayProgress) stop() {
+	if p != nil {
+		p.cancel()
+	}
+}
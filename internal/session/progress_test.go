@@ -0,0 +1,43 @@
+package session
+
+import (
+	"context"
+	"testing"
+
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"pfcp-generator/internal/stats"
+)
+
+func TestManager_LogProgress(t *testing.T) {
+	collector := stats.NewCollector()
+	collector.RecordSessionEstablished()
+	collector.RecordSessionEstablished()
+	collector.RecordSessionDeleted()
+	mgr, err := NewManager(testConfig(), &fakeTransport{}, nil, nil, collector, nil, nil)
+	require.NoError(t, err)
+
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+
+	p := &replayProgress{total: 8}
+	for i := 0; i < 2; i++ {
+		p.advance()
+	}
+	mgr.logProgress(p)
+
+	entry := hook.LastEntry()
+	require.NotNil(t, entry)
+	assert.Equal(t, "Replay progress", entry.Message)
+	assert.Equal(t, int64(2), entry.Data["sent"])
+	assert.Equal(t, 8, entry.Data["total"])
+	assert.Equal(t, 25, entry.Data["percent"])
+	assert.Equal(t, uint64(2), entry.Data["established"])
+	assert.Equal(t, uint64(1), entry.Data["active"])
+	assert.Equal(t, 255, entry.Data["ue_ips_available"])
+
+	// Progress is off unless an interval is set
+	assert.Nil(t, mgr.startProgress(context.Background(), 8))
+}
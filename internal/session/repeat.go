@@ -0,0 +1,152 @@
+package session
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/wmnsk/go-pfcp/message"
+
+	"pfcp-generator/internal/stats"
+	"pfcp-generator/pkg/types"
+)
+
+// Repeat replays messages iterations times (0 = until ctx is cancelled). The
+// pcap's SEID mappings are reused by every iteration, and every iteration
+// allocates its own SEIDs, TEIDs and UE IPs. The Association Setup Request is
+// only sent by the first iteration.
+//
+// After each iteration, with session.cleanup_on_exit the sessions the pcap
+// left open are deleted. If none is left active the manager is Reset, so the
+// next iteration reuses the same identifiers; otherwise the open sessions stay
+// on the UPF, no longer reachable by the pcap's SEIDs, and are deleted by
+// CleanupSessions like any other session.
+func (m *Manager) Repeat(ctx context.Context, messages []types.RawPFCPMessage, iterations int) error {
+	messages = m.filterTypes(messages)
+	var again []types.RawPFCPMessage
+	for _, raw := range messages {
+		if len(raw.Data) < 2 || raw.Data[1] != message.MsgTypeAssociationSetupRequest {
+			again = append(again, raw)
+		}
+	}
+
+	stop := m.startReplay(ctx)
+	defer stop()
+	total := 0
+	if iterations > 0 {
+		total = len(messages) + (iterations-1)*len(again)
+	}
+	progress := m.startProgress(ctx, total)
+	defer progress.stop()
+
+	start := m.stats.Snapshot()
+	sent := 0
+	for iteration := 1; iterations == 0 || iteration <= iterations; iteration++ {
+		before := m.stats.Snapshot()
+		batch := messages
+		if iteration > 1 {
+			batch = again
+		}
+		for _, raw := range batch {
+			if err := m.replayMessage(ctx, sent, raw); err != nil {
+				log.WithField("iteration", iteration).Info("Repeat stopped mid-iteration")
+				return err
+			}
+			sent++
+			progress.advance()
+		}
+
+		closed := 0
+		if m.cfg.Session.CleanupOnExit && !m.dryRun {
+			closed = m.closeIteration(ctx)
+		}
+		if m.ActiveSessionCount() == 0 {
+			m.Reset()
+		} else {
+			m.resetIteration()
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		m.stats.RecordRepeatIteration()
+		after := m.stats.Snapshot()
+		fields := iterationFields(before, after)
+		fields["iteration"] = iteration
+		fields["cleaned_up"] = closed
+		log.WithFields(fields).Info("Repeat iteration complete")
+		log.WithFields(iterationFields(start, after)).Info("Repeat totals")
+	}
+	return nil
+}
+
+// iterationFields returns the request and session counts recorded between the
+// snapshots from and to as log fields.
+func iterationFields(from, to *stats.Collector) log.Fields {
+	return log.Fields{
+		"sent":        to.TotalSent() - from.TotalSent(),
+		"received":    to.TotalReceived() - from.TotalReceived(),
+		"established": to.SessionsEstablished - from.SessionsEstablished,
+		"deleted":     to.SessionsDeleted - from.SessionsDeleted,
+		"failed":      to.SessionsFailed - from.SessionsFailed,
+	}
+}
+
+// closeIteration deletes the sessions established by the current iteration
+// that the pcap left open, and returns how many were deleted.
+func (m *Manager) closeIteration(ctx context.Context) int {
+	m.mu.RLock()
+	var open []*types.SessionInfo
+	for _, session := range m.byOriginalCPSEID {
+		if session.State == "established" {
+			open = append(open, session)
+		}
+	}
+	m.mu.RUnlock()
+
+	batchSize := 1
+	if m.cfg.Network.SendBatch > 1 {
+		batchSize = m.cfg.Network.SendBatch
+	}
+
+	deleted := 0
+	for i := 0; i < len(open) && ctx.Err() == nil; i += batchSize {
+		batch := open[i:min(i+batchSize, len(open))]
+		for j, err := range m.cleanupBatch(ctx, batch) {
+			if err != nil {
+				log.WithError(err).WithField("local_seid", batch[j].LocalSEID).Warn("Repeat cleanup deletion failed")
+				continue
+			}
+			m.releaseSession(batch[j])
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// resetIteration unlinks the current iteration's sessions from the pcap's
+// SEIDs, so the next iteration's requests reach its own sessions, while
+// sessions still active on the UPF keep their identifiers and stay known to
+// CleanupSessions. Deleted sessions are forgotten, and failed ones release
+// their identifiers too.
+func (m *Manager) resetIteration() {
+	m.mu.Lock()
+	var failed []*types.SessionInfo
+	for _, session := range m.byOriginalCPSEID {
+		if session.State == "established" {
+			continue
+		}
+		if session.State == "failed" {
+			failed = append(failed, session)
+		}
+		if m.byLocalSEID[session.LocalSEID] == session {
+			delete(m.byLocalSEID, session.LocalSEID)
+		}
+	}
+	m.byOriginalCPSEID = make(map[uint64]*types.SessionInfo)
+	m.byOriginalRemoteSEID = make(map[uint64]*types.SessionInfo)
+	m.mu.Unlock()
+
+	for _, session := range failed {
+		m.releaseSession(session)
+	}
+}
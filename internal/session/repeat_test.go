@@ -0,0 +1,166 @@
+package session
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+
+	"pfcp-generator/internal/network"
+	"pfcp-generator/internal/stats"
+	"pfcp-generator/pkg/types"
+)
+
+// repeatPcap returns the requests of a pcap that establishes sessions 0x10
+// and 0x20 and deletes the first, whose Establishment Response assigned it
+// the UP SEID 0x9010, with its SEID mappings.
+func repeatPcap(t *testing.T) ([]types.RawPFCPMessage, []types.SEIDMapping) {
+	t.Helper()
+	var messages []types.RawPFCPMessage
+	for _, msg := range []message.Message{
+		message.NewAssociationSetupRequest(1, ie.NewNodeID("10.0.0.1", "", "")),
+		message.NewSessionEstablishmentRequest(0, 0, 0, 2, 0,
+			ie.NewNodeID("10.0.0.1", "", ""),
+			ie.NewFSEID(0x10, net.ParseIP("10.0.0.1"), nil),
+		),
+		message.NewSessionEstablishmentRequest(0, 0, 0, 3, 0,
+			ie.NewNodeID("10.0.0.1", "", ""),
+			ie.NewFSEID(0x20, net.ParseIP("10.0.0.1"), nil),
+		),
+		message.NewSessionDeletionRequest(0, 0, 0x9010, 4, 0),
+	} {
+		data := make([]byte, msg.MarshalLen())
+		require.NoError(t, msg.MarshalTo(data))
+		messages = append(messages, types.RawPFCPMessage{Data: data})
+	}
+	mappings := []types.SEIDMapping{
+		{OriginalCPSEID: 0x10, OriginalRemoteSEID: 0x9010},
+		{OriginalCPSEID: 0x20, OriginalRemoteSEID: 0x9020},
+	}
+	return messages, mappings
+}
+
+// sentRequests returns the message types and header SEIDs of the requests
+// sent to upf.
+func sentRequests(t *testing.T, upf *acceptingUPF) (msgTypes []uint8, deletionSEIDs []uint64) {
+	t.Helper()
+	for _, sent := range upf.sent {
+		msg, err := message.Parse(sent)
+		require.NoError(t, err)
+		msgTypes = append(msgTypes, msg.MessageType())
+		if msg.MessageType() == message.MsgTypeSessionDeletionRequest {
+			deletionSEIDs = append(deletionSEIDs, msg.SEID())
+		}
+	}
+	return msgTypes, deletionSEIDs
+}
+
+func TestManager_RepeatKeepsOpenSessions(t *testing.T) {
+	cfg := testConfig()
+	cfg.Timing.ResponseTimeoutMs = 1000
+
+	upf := &acceptingUPF{}
+	upf.tracker = network.NewTransactionTracker(upf, cfg.Timing.ResponseTimeoutMs, 0)
+	collector := stats.NewCollector()
+	mgr, err := NewManager(cfg, upf, nil, upf.tracker, collector, nil, nil)
+	require.NoError(t, err)
+
+	messages, mappings := repeatPcap(t)
+	mgr.SetSEIDMappings(mappings)
+	require.NoError(t, mgr.Repeat(context.Background(), messages[1:], 3))
+
+	// Each iteration deletes its own first session, at the SEID the UPF
+	// returned for it
+	_, deletionSEIDs := sentRequests(t, upf)
+	assert.Equal(t, []uint64{0x1001, 0x1003, 0x1005}, deletionSEIDs)
+
+	snap := collector.Snapshot()
+	assert.Equal(t, uint64(3), snap.RepeatIterations)
+	assert.Equal(t, uint64(6), snap.SessionsEstablished)
+	assert.Equal(t, uint64(3), snap.SessionsDeleted)
+	assert.Equal(t, 3, mgr.ActiveSessionCount())
+	assert.Equal(t, 3, mgr.ipPool.(*UEIPPool).AllocatedCount())
+	assert.Empty(t, mgr.byOriginalCPSEID)
+	assert.Len(t, mgr.byLocalSEID, 3)
+}
+
+func TestManager_RepeatCleansUpEachIteration(t *testing.T) {
+	cfg := testConfig()
+	cfg.Timing.ResponseTimeoutMs = 1000
+	cfg.Association.Enabled = true
+	cfg.Session.CleanupOnExit = true
+
+	upf := &acceptingUPF{}
+	upf.tracker = network.NewTransactionTracker(upf, cfg.Timing.ResponseTimeoutMs, 0)
+	collector := stats.NewCollector()
+	mgr, err := NewManager(cfg, upf, nil, upf.tracker, collector, nil, nil)
+	require.NoError(t, err)
+
+	messages, mappings := repeatPcap(t)
+	mgr.SetSEIDMappings(mappings)
+	require.NoError(t, mgr.Repeat(context.Background(), messages, 2))
+
+	// Association Setup is only sent by the first iteration
+	msgTypes, deletionSEIDs := sentRequests(t, upf)
+	assert.Equal(t, []uint8{
+		message.MsgTypeAssociationSetupRequest,
+		message.MsgTypeSessionEstablishmentRequest,
+		message.MsgTypeSessionEstablishmentRequest,
+		message.MsgTypeSessionDeletionRequest,
+		message.MsgTypeSessionDeletionRequest,
+		message.MsgTypeSessionEstablishmentRequest,
+		message.MsgTypeSessionEstablishmentRequest,
+		message.MsgTypeSessionDeletionRequest,
+		message.MsgTypeSessionDeletionRequest,
+	}, msgTypes)
+	assert.Equal(t, []uint64{0x1001, 0x1002, 0x1003, 0x1004}, deletionSEIDs)
+
+	snap := collector.Snapshot()
+	assert.Equal(t, uint64(2), snap.RepeatIterations)
+	assert.Equal(t, uint64(4), snap.SessionsEstablished)
+	assert.Equal(t, uint64(4), snap.SessionsDeleted)
+	assert.Zero(t, mgr.ActiveSessionCount())
+	assert.Zero(t, mgr.ipPool.(*UEIPPool).AllocatedCount())
+	assert.Zero(t, mgr.seidAlloc.(*SequentialSEIDAllocator).AllocatedCount())
+	assert.Empty(t, mgr.byLocalSEID)
+}
+
+func TestManager_ResetReleasesAllocations(t *testing.T) {
+	cfg := testConfig()
+	cfg.Timing.ResponseTimeoutMs = 1000
+
+	upf := &acceptingUPF{}
+	upf.tracker = network.NewTransactionTracker(upf, cfg.Timing.ResponseTimeoutMs, 0)
+	collector := stats.NewCollector()
+	mgr, err := NewManager(cfg, upf, nil, upf.tracker, collector, nil, nil)
+	require.NoError(t, err)
+
+	messages, mappings := repeatPcap(t)
+	mgr.SetSEIDMappings(mappings)
+	require.NoError(t, mgr.Replay(context.Background(), messages))
+	require.Equal(t, 1, mgr.ActiveSessionCount())
+
+	mgr.Reset()
+	assert.Zero(t, mgr.ActiveSessionCount())
+	assert.Zero(t, mgr.seidAlloc.(*SequentialSEIDAllocator).AllocatedCount())
+	assert.Zero(t, mgr.ipPool.(*UEIPPool).AllocatedCount())
+	assert.Empty(t, mgr.byOriginalCPSEID)
+	assert.Empty(t, mgr.byOriginalRemoteSEID)
+	assert.Empty(t, mgr.byLocalSEID)
+	assert.Len(t, mgr.originalSEIDMappings, 2)
+
+	// The pcap's SEID mappings still address the second run's sessions
+	require.NoError(t, mgr.Replay(context.Background(), messages))
+	_, deletionSEIDs := sentRequests(t, upf)
+	assert.Equal(t, []uint64{0x1001, 0x1003}, deletionSEIDs)
+
+	snap := collector.Snapshot()
+	assert.Equal(t, uint64(4), snap.SessionsEstablished)
+	assert.Equal(t, uint64(2), snap.SessionsDeleted)
+	assert.Equal(t, 1, mgr.ActiveSessionCount())
+	assert.Equal(t, 1, mgr.seidAlloc.(*SequentialSEIDAllocator).AllocatedCount())
+}
@@ -6,36 +6,142 @@ import (
 	"sync"
 )
 
-// SEIDAllocator manages allocation and release of SEIDs.
-type SEIDAllocator struct {
-	strategy  string
-	nextSEID  uint64
+// SEIDStrategy allocates the local SEIDs of new sessions, and takes back the
+// SEIDs of deleted ones. Allocate must never return 0 or a SEID that is still
+// allocated. Implementations must be safe for concurrent use.
+type SEIDStrategy interface {
+	Allocate() (uint64, error)
+	Release(seid uint64)
+}
+
+// seidSeeder is implemented by SEID strategies whose allocations can be saved
+// to and restored from the session state file.
+type seidSeeder interface {
+	Allocated() []uint64
+	Seed(seids []uint64)
+}
+
+// NewSEIDStrategy returns the built-in SEIDStrategy selected by
+// session.seid_strategy, allocating from startSEID. A non-zero endSEID bounds
+// allocation as SetRangeEnd does.
+func NewSEIDStrategy(strategy string, startSEID, endSEID uint64) (SEIDStrategy, error) {
+	switch strategy {
+	case "sequential":
+		alloc := NewSequentialSEIDAllocator(startSEID)
+		alloc.SetRangeEnd(endSEID)
+		return alloc, nil
+	case "random":
+		alloc := NewRandomSEIDAllocator(startSEID)
+		alloc.SetRangeEnd(endSEID)
+		return alloc, nil
+	default:
+		return nil, fmt.Errorf("unknown SEID strategy: %s", strategy)
+	}
+}
+
+// seidAllocations is the bookkeeping shared by the built-in SEID strategies:
+// the allocation range and the SEIDs in use.
+type seidAllocations struct {
+	startSEID uint64
+	endSEID   uint64 // last SEID of the range, 0 = unbounded
 	usedSEIDs map[uint64]bool
 	mu        sync.Mutex
 }
 
-// NewSEIDAllocator creates a new SEID allocator with the given strategy and start value.
-func NewSEIDAllocator(strategy string, startSEID uint64) *SEIDAllocator {
+func newSEIDAllocations(startSEID uint64) seidAllocations {
 	if startSEID == 0 {
 		startSEID = 1 // SEID 0 is reserved
 	}
-	return &SEIDAllocator{
-		strategy:  strategy,
-		nextSEID:  startSEID,
+	return seidAllocations{
+		startSEID: startSEID,
 		usedSEIDs: make(map[uint64]bool),
 	}
 }
 
+// SetRangeEnd bounds allocation to [start, end]. Once every SEID in the range
+// is in use, Allocate fails. An end of 0 removes the bound.
+func (s *seidAllocations) SetRangeEnd(end uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.endSEID = end
+}
+
+// rangeSize returns the number of SEIDs in the range, or an error if all of
+// them are allocated. It must be called with endSEID set and mu held.
+func (s *seidAllocations) rangeSize() (uint64, error) {
+	size := s.endSEID - s.startSEID + 1
+	if size != 0 && uint64(len(s.usedSEIDs)) >= size {
+		return 0, fmt.Errorf("SEID range [%d, %d] exhausted (all %d SEIDs allocated)", s.startSEID, s.endSEID, len(s.usedSEIDs))
+	}
+	return size, nil
+}
+
+// Release frees a previously allocated SEID for reuse.
+func (s *seidAllocations) Release(seid uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.usedSEIDs, seid)
+}
+
+// AllocatedCount returns the number of currently allocated SEIDs.
+func (s *seidAllocations) AllocatedCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.usedSEIDs)
+}
+
+// Allocated returns the currently allocated SEIDs in no particular order.
+func (s *seidAllocations) Allocated() []uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seids := make([]uint64, 0, len(s.usedSEIDs))
+	for seid := range s.usedSEIDs {
+		seids = append(seids, seid)
+	}
+	return seids
+}
+
+// Seed marks seids as allocated, e.g. to restore allocations saved by a
+// previous run.
+func (s *seidAllocations) Seed(seids []uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, seid := range seids {
+		if seid != 0 {
+			s.usedSEIDs[seid] = true
+		}
+	}
+}
+
+// SequentialSEIDAllocator is the built-in "sequential" SEIDStrategy: it
+// allocates SEIDs in order from the start SEID, skipping those in use. With
+// a range end, allocation wraps back to the start SEID after it.
+type SequentialSEIDAllocator struct {
+	seidAllocations
+	nextSEID uint64
+}
+
+// NewSequentialSEIDAllocator creates a sequential SEID allocator starting at
+// startSEID.
+func NewSequentialSEIDAllocator(startSEID uint64) *SequentialSEIDAllocator {
+	s := &SequentialSEIDAllocator{seidAllocations: newSEIDAllocations(startSEID)}
+	s.nextSEID = s.startSEID
+	return s
+}
+
 // Allocate returns a new unique SEID.
-func (s *SEIDAllocator) Allocate() (uint64, error) {
+func (s *SequentialSEIDAllocator) Allocate() (uint64, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	switch s.strategy {
-	case "sequential":
-		for i := 0; i < 1000000; i++ {
-			if s.nextSEID == 0 {
-				s.nextSEID = 1
+	if s.endSEID != 0 {
+		if _, err := s.rangeSize(); err != nil {
+			return 0, err
+		}
+		// The range has a free SEID, so this finds it within one pass
+		for {
+			if s.nextSEID < s.startSEID || s.nextSEID > s.endSEID {
+				s.nextSEID = s.startSEID
 			}
 			seid := s.nextSEID
 			s.nextSEID++
@@ -44,32 +150,67 @@ func (s *SEIDAllocator) Allocate() (uint64, error) {
 				return seid, nil
 			}
 		}
-		return 0, fmt.Errorf("failed to allocate sequential SEID: too many collisions")
-	case "random":
-		for attempts := 0; attempts < 10000; attempts++ {
-			seid := rand.Uint64()
-			if seid == 0 || s.usedSEIDs[seid] {
-				continue
-			}
+	}
+
+	for i := 0; i < 1000000; i++ {
+		if s.nextSEID == 0 {
+			s.nextSEID = 1
+		}
+		seid := s.nextSEID
+		s.nextSEID++
+		if !s.usedSEIDs[seid] {
 			s.usedSEIDs[seid] = true
 			return seid, nil
 		}
-		return 0, fmt.Errorf("failed to allocate random SEID after 10000 attempts")
-	default:
-		return 0, fmt.Errorf("unknown SEID strategy: %s", s.strategy)
 	}
+	return 0, fmt.Errorf("failed to allocate sequential SEID: too many collisions")
 }
 
-// Release frees a previously allocated SEID for reuse.
-func (s *SEIDAllocator) Release(seid uint64) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	delete(s.usedSEIDs, seid)
+// RandomSEIDAllocator is the built-in "random" SEIDStrategy: it allocates
+// random SEIDs not in use. With a range end, it draws within [start, end].
+type RandomSEIDAllocator struct {
+	seidAllocations
 }
 
-// AllocatedCount returns the number of currently allocated SEIDs.
-func (s *SEIDAllocator) AllocatedCount() int {
+// NewRandomSEIDAllocator creates a random SEID allocator. startSEID only
+// takes effect with a range end.
+func NewRandomSEIDAllocator(startSEID uint64) *RandomSEIDAllocator {
+	return &RandomSEIDAllocator{seidAllocations: newSEIDAllocations(startSEID)}
+}
+
+// Allocate returns a new unique SEID.
+func (s *RandomSEIDAllocator) Allocate() (uint64, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return len(s.usedSEIDs)
+
+	if s.endSEID != 0 {
+		size, err := s.rangeSize()
+		if err != nil {
+			return 0, err
+		}
+		// Start at a random SEID and take the next free one, wrapping in the range
+		seid := rand.Uint64()
+		if size != 0 {
+			seid = s.startSEID + seid%size
+		}
+		for seid == 0 || s.usedSEIDs[seid] {
+			if seid == s.endSEID {
+				seid = s.startSEID
+			} else {
+				seid++
+			}
+		}
+		s.usedSEIDs[seid] = true
+		return seid, nil
+	}
+
+	for attempts := 0; attempts < 10000; attempts++ {
+		seid := rand.Uint64()
+		if seid == 0 || s.usedSEIDs[seid] {
+			continue
+		}
+		s.usedSEIDs[seid] = true
+		return seid, nil
+	}
+	return 0, fmt.Errorf("failed to allocate random SEID after 10000 attempts")
 }
@@ -9,14 +9,14 @@ import (
 )
 
 func TestSEIDAllocator_Sequential_StartsFromBase(t *testing.T) {
-	alloc := NewSEIDAllocator("sequential", 100)
+	alloc := NewSequentialSEIDAllocator(100)
 	seid, err := alloc.Allocate()
 	require.NoError(t, err)
 	assert.Equal(t, uint64(100), seid)
 }
 
 func TestSEIDAllocator_Sequential_Increments(t *testing.T) {
-	alloc := NewSEIDAllocator("sequential", 1)
+	alloc := NewSequentialSEIDAllocator(1)
 	seid1, err := alloc.Allocate()
 	require.NoError(t, err)
 	seid2, err := alloc.Allocate()
@@ -30,7 +30,7 @@ func TestSEIDAllocator_Sequential_Increments(t *testing.T) {
 }
 
 func TestSEIDAllocator_Sequential_SkipsZero(t *testing.T) {
-	alloc := NewSEIDAllocator("sequential", 0)
+	alloc := NewSequentialSEIDAllocator(0)
 	seid, err := alloc.Allocate()
 	require.NoError(t, err)
 	assert.NotEqual(t, uint64(0), seid)
@@ -38,7 +38,7 @@ func TestSEIDAllocator_Sequential_SkipsZero(t *testing.T) {
 }
 
 func TestSEIDAllocator_Random_NeverZero(t *testing.T) {
-	alloc := NewSEIDAllocator("random", 1)
+	alloc := NewRandomSEIDAllocator(1)
 	for i := 0; i < 100; i++ {
 		seid, err := alloc.Allocate()
 		require.NoError(t, err)
@@ -48,7 +48,7 @@ func TestSEIDAllocator_Random_NeverZero(t *testing.T) {
 }
 
 func TestSEIDAllocator_Random_NoDuplicates(t *testing.T) {
-	alloc := NewSEIDAllocator("random", 1)
+	alloc := NewRandomSEIDAllocator(1)
 	seen := make(map[uint64]bool)
 	for i := 0; i < 100; i++ {
 		seid, err := alloc.Allocate()
@@ -59,7 +59,7 @@ func TestSEIDAllocator_Random_NoDuplicates(t *testing.T) {
 }
 
 func TestSEIDAllocator_Release_AllowsReuse(t *testing.T) {
-	alloc := NewSEIDAllocator("sequential", 1)
+	alloc := NewSequentialSEIDAllocator(1)
 	seid1, err := alloc.Allocate()
 	require.NoError(t, err)
 	assert.Equal(t, uint64(1), seid1)
@@ -69,14 +69,26 @@ func TestSEIDAllocator_Release_AllowsReuse(t *testing.T) {
 }
 
 func TestSEIDAllocator_UnknownStrategy(t *testing.T) {
-	alloc := NewSEIDAllocator("unknown", 1)
-	_, err := alloc.Allocate()
+	_, err := NewSEIDStrategy("unknown", 1, 0)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "unknown SEID strategy")
 }
 
+func TestNewSEIDStrategy_SelectsBuiltIn(t *testing.T) {
+	alloc, err := NewSEIDStrategy("sequential", 10, 12)
+	require.NoError(t, err)
+	sequential, ok := alloc.(*SequentialSEIDAllocator)
+	require.True(t, ok, "got %T", alloc)
+	assert.Equal(t, uint64(12), sequential.endSEID)
+
+	alloc, err = NewSEIDStrategy("random", 10, 0)
+	require.NoError(t, err)
+	_, ok = alloc.(*RandomSEIDAllocator)
+	assert.True(t, ok, "got %T", alloc)
+}
+
 func TestSEIDAllocator_ConcurrentAccess(t *testing.T) {
-	alloc := NewSEIDAllocator("sequential", 1)
+	alloc := NewSequentialSEIDAllocator(1)
 	var wg sync.WaitGroup
 	results := make(chan uint64, 100)
 
@@ -100,3 +112,48 @@ func TestSEIDAllocator_ConcurrentAccess(t *testing.T) {
 	}
 	assert.Equal(t, 100, len(seen))
 }
+
+func TestSEIDAllocator_Range_SequentialWraps(t *testing.T) {
+	alloc := NewSequentialSEIDAllocator(10)
+	alloc.SetRangeEnd(12)
+
+	for _, want := range []uint64{10, 11, 12} {
+		seid, err := alloc.Allocate()
+		require.NoError(t, err)
+		assert.Equal(t, want, seid)
+	}
+
+	// After a release, allocation wraps back to the start of the range
+	alloc.Release(11)
+	seid, err := alloc.Allocate()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(11), seid)
+}
+
+func TestSEIDAllocator_Range_Exhaustion(t *testing.T) {
+	for _, strategy := range []string{"sequential", "random"} {
+		t.Run(strategy, func(t *testing.T) {
+			alloc, err := NewSEIDStrategy(strategy, 100, 103)
+			require.NoError(t, err)
+
+			seen := make(map[uint64]bool)
+			for i := 0; i < 4; i++ {
+				seid, err := alloc.Allocate()
+				require.NoError(t, err)
+				assert.GreaterOrEqual(t, seid, uint64(100))
+				assert.LessOrEqual(t, seid, uint64(103))
+				assert.False(t, seen[seid], "duplicate SEID allocated: %d", seid)
+				seen[seid] = true
+			}
+
+			_, err = alloc.Allocate()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "SEID range [100, 103] exhausted")
+
+			alloc.Release(102)
+			seid, err := alloc.Allocate()
+			require.NoError(t, err)
+			assert.Equal(t, uint64(102), seid)
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/wmnsk/go-pfcp/message"
+
+	"pfcp-generator/internal/pfcp"
+	"pfcp-generator/pkg/types"
+)
+
+// Soak runs soak mode: every cycle establishes soak.batch_size sessions cloned
+// from the Session Establishment Requests in messages, holds them for
+// soak.hold_sec, and deletes them again, until soak.iterations cycles have run
+// or ctx is cancelled. The pcap's other requests, such as Association Setup,
+// are sent once before the first cycle; its Modification and Deletion
+// Requests are not used. When ctx is cancelled the batch being held is left
+// active for CleanupSessions to delete.
+func (m *Manager) Soak(ctx context.Context, messages []types.RawPFCPMessage) error {
+	messages = m.filterTypes(messages)
+	var templates [][]byte
+	var setup []types.RawPFCPMessage
+	for _, raw := range messages {
+		if len(raw.Data) < 2 {
+			continue
+		}
+		switch raw.Data[1] {
+		case message.MsgTypeSessionEstablishmentRequest:
+			templates = append(templates, raw.Data)
+		case message.MsgTypeSessionModificationRequest, message.MsgTypeSessionDeletionRequest:
+		default:
+			setup = append(setup, raw)
+		}
+	}
+	if len(templates) == 0 {
+		return fmt.Errorf("no Session Establishment Requests in the pcap to soak with")
+	}
+
+	stop := m.startReplay(ctx)
+	defer stop()
+
+	for i, raw := range setup {
+		if err := m.replayMessage(ctx, i, raw); err != nil {
+			return err
+		}
+	}
+
+	cfg := m.cfg.Soak
+	hold := time.Duration(cfg.HoldSec) * time.Second
+
+	// Every cloned session gets an original CP SEID of its own, so sessions of
+	// consecutive batches never share map entries
+	var originalSEID uint64
+	for cycle := 1; cfg.Iterations == 0 || cycle <= cfg.Iterations; cycle++ {
+		var batch []*types.SessionInfo
+		for i := 0; i < cfg.BatchSize; i++ {
+			originalSEID++
+			data, err := pfcp.CloneSessionRequest(templates[i%len(templates)], func(seid uint64) uint64 {
+				if seid == 0 {
+					return 0
+				}
+				return originalSEID
+			})
+			if err != nil {
+				return fmt.Errorf("failed to clone Session Establishment: %w", err)
+			}
+			if err := m.replayMessage(ctx, len(setup)+i, types.RawPFCPMessage{Data: data}); err != nil {
+				return err
+			}
+
+			m.mu.RLock()
+			if session := m.byOriginalCPSEID[originalSEID]; session != nil && session.State == "established" {
+				batch = append(batch, session)
+			}
+			m.mu.RUnlock()
+		}
+
+		log.WithFields(log.Fields{
+			"cycle":       cycle,
+			"established": len(batch),
+			"failed":      cfg.BatchSize - len(batch),
+			"hold":        hold,
+		}).Info("Soak batch established")
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(hold):
+		}
+
+		// Deletions go through the regular handler, which finds each session
+		// by its original CP SEID and sends the UPF's real SEID
+		deleted := 0
+		for i, session := range batch {
+			req := message.NewSessionDeletionRequest(0, 0, session.OriginalCPSEID, 0, 0)
+			data, err := pfcp.Encode(req)
+			if err != nil {
+				return fmt.Errorf("failed to encode Session Deletion: %w", err)
+			}
+			if err := m.replayMessage(ctx, i, types.RawPFCPMessage{Data: data}); err != nil {
+				return err
+			}
+			if m.forgetSession(session) {
+				deleted++
+			}
+		}
+
+		m.stats.RecordSoakCycle()
+		log.WithFields(log.Fields{
+			"cycle":   cycle,
+			"deleted": deleted,
+			"failed":  len(batch) - deleted,
+		}).Info("Soak cycle complete")
+	}
+	return nil
+}
+
+// forgetSession removes a deleted session from the session maps and its
+// request from those kept for re-establishment, so that repeated soak cycles
+// do not accumulate them, and reports whether it did. Sessions that are not
+// deleted are kept for CleanupSessions.
+func (m *Manager) forgetSession(session *types.SessionInfo) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if session.State != "deleted" {
+		return false
+	}
+	delete(m.establishmentRequests, session.OriginalCPSEID)
+	delete(m.byOriginalCPSEID, session.OriginalCPSEID)
+	if m.byLocalSEID[session.LocalSEID] == session {
+		delete(m.byLocalSEID, session.LocalSEID)
+	}
+	return true
+}
@@ -0,0 +1,348 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+
+	"pfcp-generator/internal/network"
+	"pfcp-generator/internal/stats"
+	"pfcp-generator/pkg/types"
+)
+
+// acceptingUPF answers Heartbeat, Association Setup, PFD Management and
+// Session Establishment, Modification and Deletion Requests like a UPF that accepts everything,
+// resolving the tracker instead of using a receiver.
+type acceptingUPF struct {
+	fakeTransport
+	tracker  *network.TransactionTracker
+	nextSEID uint64
+
+	// causes replaces the Cause of the responses to a request type; a Cause
+	// of 0 leaves it out
+	causes map[uint8]uint8
+}
+
+// cause returns the Cause IE of the response to a request of msgType, if any.
+func (u *acceptingUPF) cause(msgType uint8) []*ie.IE {
+	cause, ok := u.causes[msgType]
+	if !ok {
+		cause = ie.CauseRequestAccepted
+	}
+	if cause == 0 {
+		return nil
+	}
+	return []*ie.IE{ie.NewCause(cause)}
+}
+
+func (u *acceptingUPF) Send(data []byte) error {
+	if err := u.fakeTransport.Send(data); err != nil {
+		return err
+	}
+	msg, err := message.Parse(data)
+	if err != nil {
+		return err
+	}
+
+	var resp message.Message
+	switch req := msg.(type) {
+	case *message.HeartbeatRequest:
+		resp = message.NewHeartbeatResponse(req.Sequence(), ie.NewRecoveryTimeStamp(time.Now()))
+	case *message.AssociationSetupRequest:
+		resp = message.NewAssociationSetupResponse(req.Sequence(),
+			append(u.cause(req.MessageType()), ie.NewNodeID("10.0.0.2", "", ""))...,
+		)
+	case *message.SessionEstablishmentRequest:
+		fseid, err := req.CPFSEID.FSEID()
+		if err != nil {
+			return err
+		}
+		u.nextSEID++
+		resp = message.NewSessionEstablishmentResponse(0, 0, fseid.SEID, req.Sequence(), 0,
+			append(u.cause(req.MessageType()), ie.NewFSEID(0x1000+u.nextSEID, net.ParseIP("10.0.0.2"), nil))...,
+		)
+	case *message.SessionModificationRequest:
+		resp = message.NewSessionModificationResponse(0, 0, 0, req.Sequence(), 0, u.cause(req.MessageType())...)
+	case *message.SessionDeletionRequest:
+		resp = message.NewSessionDeletionResponse(0, 0, 0, req.Sequence(), 0, u.cause(req.MessageType())...)
+	case *message.PFDManagementRequest:
+		var cause *ie.IE
+		if ies := u.cause(req.MessageType()); len(ies) > 0 {
+			cause = ies[0]
+		}
+		resp = message.NewPFDManagementResponse(req.Sequence(), cause, nil)
+	default:
+		return nil
+	}
+
+	b := make([]byte, resp.MarshalLen())
+	if err := resp.MarshalTo(b); err != nil {
+		return err
+	}
+	go u.tracker.Resolve(msg.Sequence(), resp, b)
+	return nil
+}
+
+func TestManager_SoakCyclesBatches(t *testing.T) {
+	cfg := testConfig()
+	cfg.Timing.ResponseTimeoutMs = 1000
+	cfg.Soak.BatchSize = 3
+	cfg.Soak.Iterations = 2
+	cfg.Association.ReconnectOnRestart = true
+
+	upf := &acceptingUPF{}
+	upf.tracker = network.NewTransactionTracker(upf, cfg.Timing.ResponseTimeoutMs, 0)
+	collector := stats.NewCollector()
+	mgr, err := NewManager(cfg, upf, nil, upf.tracker, collector, nil, nil)
+	require.NoError(t, err)
+
+	template := message.NewSessionEstablishmentRequest(0, 0, 0, 1, 0,
+		ie.NewNodeID("10.0.0.1", "", ""),
+		ie.NewFSEID(0x10, net.ParseIP("10.0.0.1"), nil),
+	)
+	data := make([]byte, template.MarshalLen())
+	require.NoError(t, template.MarshalTo(data))
+
+	require.NoError(t, mgr.Soak(context.Background(), []types.RawPFCPMessage{{Data: data}}))
+
+	// Deletions are addressed to the SEIDs the UPF returned
+	var deletionSEIDs []uint64
+	for _, sent := range upf.sent {
+		msg, err := message.Parse(sent)
+		require.NoError(t, err)
+		if msg.MessageType() == message.MsgTypeSessionDeletionRequest {
+			deletionSEIDs = append(deletionSEIDs, msg.SEID())
+		}
+	}
+	assert.Equal(t, []uint64{0x1001, 0x1002, 0x1003, 0x1004, 0x1005, 0x1006}, deletionSEIDs)
+
+	snap := collector.Snapshot()
+	assert.Equal(t, uint64(2), snap.SoakCycles)
+	assert.Equal(t, uint64(6), snap.SessionsEstablished)
+	assert.Equal(t, uint64(6), snap.SessionsDeleted)
+	assert.Len(t, snap.SessionLifetimes, 6)
+	assert.Zero(t, mgr.ActiveSessionCount())
+	assert.Empty(t, mgr.byOriginalCPSEID)
+	assert.Empty(t, mgr.establishmentRequests)
+}
+
+func TestManager_SoakReleasesFailedEstablishments(t *testing.T) {
+	cfg := testConfig()
+	cfg.Timing.ResponseTimeoutMs = 1000
+	cfg.Session.UEIPPool = "10.60.0.0/29"
+	cfg.Soak.BatchSize = 3
+	cfg.Soak.Iterations = 5
+	cfg.Association.ReconnectOnRestart = true
+
+	upf := &acceptingUPF{causes: map[uint8]uint8{message.MsgTypeSessionEstablishmentRequest: ie.CauseRequestRejected}}
+	upf.tracker = network.NewTransactionTracker(upf, cfg.Timing.ResponseTimeoutMs, 0)
+	collector := stats.NewCollector()
+	mgr, err := NewManager(cfg, upf, nil, upf.tracker, collector, nil, nil)
+	require.NoError(t, err)
+
+	template := message.NewSessionEstablishmentRequest(0, 0, 0, 1, 0,
+		ie.NewNodeID("10.0.0.1", "", ""),
+		ie.NewFSEID(0x10, net.ParseIP("10.0.0.1"), nil),
+	)
+	data := make([]byte, template.MarshalLen())
+	require.NoError(t, template.MarshalTo(data))
+
+	require.NoError(t, mgr.Soak(context.Background(), []types.RawPFCPMessage{{Data: data}}))
+
+	// More establishments are rejected than the pool has addresses, and
+	// every one of them is still sent: rejected sessions give theirs back
+	snap := collector.Snapshot()
+	assert.Equal(t, uint64(15), snap.MessageStats["SessionEstablishmentRequest"].Sent)
+	assert.Equal(t, uint64(15), snap.SessionsFailed)
+	assert.Zero(t, mgr.ipPool.(*UEIPPool).AllocatedCount())
+	assert.Zero(t, mgr.seidAlloc.(*SequentialSEIDAllocator).AllocatedCount())
+	assert.Empty(t, mgr.byOriginalCPSEID)
+	assert.Empty(t, mgr.byLocalSEID)
+	assert.Empty(t, mgr.establishmentRequests)
+}
+
+// batchingUPF is an acceptingUPF that records the size of every batched send.
+type batchingUPF struct {
+	acceptingUPF
+	batches []int
+
+	// limit, if not 0, is how many batched messages are sent in total
+	// before SendBatch fails
+	limit int
+}
+
+func (u *batchingUPF) SendBatch(batch [][]byte) (int, error) {
+	u.mu.Lock()
+	u.batches = append(u.batches, len(batch))
+	u.mu.Unlock()
+	for i, data := range batch {
+		u.mu.Lock()
+		full := u.limit != 0 && len(u.sent) >= u.limit
+		u.mu.Unlock()
+		if full {
+			return i, errors.New("no buffer space available")
+		}
+		if err := u.Send(data); err != nil {
+			return i, err
+		}
+	}
+	return len(batch), nil
+}
+
+func TestManager_CleanupSessionsSendsBatches(t *testing.T) {
+	cfg := testConfig()
+	cfg.Timing.ResponseTimeoutMs = 1000
+	cfg.Network.SendBatch = 2
+
+	upf := &batchingUPF{}
+	upf.tracker = network.NewTransactionTracker(upf, cfg.Timing.ResponseTimeoutMs, 0)
+	mgr, err := NewManager(cfg, upf, nil, upf.tracker, stats.NewCollector(), nil, nil)
+	require.NoError(t, err)
+
+	for seid := uint64(1); seid <= 5; seid++ {
+		mgr.byLocalSEID[seid] = &types.SessionInfo{LocalSEID: seid, RemoteSEID: seid + 100, State: "established"}
+	}
+
+	deleted, failed := mgr.CleanupSessions(context.Background())
+	assert.Equal(t, 5, deleted)
+	assert.Equal(t, 0, failed)
+	// The last session is left on its own and sent without batching
+	assert.Equal(t, []int{2, 2}, upf.batches)
+	upf.mu.Lock()
+	assert.Len(t, upf.sent, 5)
+	upf.mu.Unlock()
+}
+
+func TestManager_CleanupSessionsPartiallySentBatch(t *testing.T) {
+	cfg := testConfig()
+	cfg.Timing.ResponseTimeoutMs = 20
+	cfg.Network.SendBatch = 3
+
+	upf := &batchingUPF{limit: 2}
+	upf.tracker = network.NewTransactionTracker(upf, cfg.Timing.ResponseTimeoutMs, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	upf.tracker.StartTimeoutMonitor(ctx)
+	mgr, err := NewManager(cfg, upf, nil, upf.tracker, stats.NewCollector(), nil, nil)
+	require.NoError(t, err)
+
+	for seid := uint64(1); seid <= 3; seid++ {
+		mgr.byLocalSEID[seid] = &types.SessionInfo{LocalSEID: seid, RemoteSEID: seid + 100, State: "established"}
+	}
+
+	// The deletions sent before the failure are still answered; only the
+	// unsent one fails, and it is not retransmitted later
+	deleted, failed := mgr.CleanupSessions(context.Background())
+	assert.Equal(t, 2, deleted)
+	assert.Equal(t, 1, failed)
+	assert.Zero(t, upf.tracker.PendingCount())
+	time.Sleep(100 * time.Millisecond)
+	upf.mu.Lock()
+	assert.Len(t, upf.sent, 2)
+	upf.mu.Unlock()
+}
+
+func TestManager_CleanupSessionsChecksCause(t *testing.T) {
+	cfg := testConfig()
+	cfg.Timing.ResponseTimeoutMs = 1000
+
+	// A Session Deletion Response without its mandatory Cause is no proof of
+	// deletion
+	upf := &acceptingUPF{causes: map[uint8]uint8{message.MsgTypeSessionDeletionRequest: 0}}
+	upf.tracker = network.NewTransactionTracker(upf, cfg.Timing.ResponseTimeoutMs, 0)
+	collector := stats.NewCollector()
+	mgr, err := NewManager(cfg, upf, nil, upf.tracker, collector, nil, nil)
+	require.NoError(t, err)
+
+	session := &types.SessionInfo{LocalSEID: 1, RemoteSEID: 101, State: "established"}
+	mgr.byLocalSEID[1] = session
+
+	deleted, failed := mgr.CleanupSessions(context.Background())
+	assert.Equal(t, 0, deleted)
+	assert.Equal(t, 1, failed)
+	assert.Equal(t, "established", session.State)
+	assert.Zero(t, collector.Snapshot().SessionsDeleted)
+}
+
+func TestManager_CleanupSessionsBatchesFitInFlightWindow(t *testing.T) {
+	cfg := testConfig()
+	cfg.Timing.ResponseTimeoutMs = 300
+	cfg.Timing.MaxInFlight = 5
+	cfg.Network.SendBatch = 2
+	cfg.Session.CleanupConcurrency = 8
+
+	upf := &batchingUPF{}
+	upf.tracker = network.NewTransactionTracker(upf, cfg.Timing.ResponseTimeoutMs, 0)
+	upf.tracker.SetMaxInFlight(cfg.Timing.MaxInFlight)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	upf.tracker.StartTimeoutMonitor(ctx)
+	mgr, err := NewManager(cfg, upf, nil, upf.tracker, stats.NewCollector(), nil, nil)
+	require.NoError(t, err)
+
+	for seid := uint64(1); seid <= 200; seid++ {
+		mgr.byLocalSEID[seid] = &types.SessionInfo{LocalSEID: seid, RemoteSEID: seid + 100, State: "established"}
+	}
+
+	// Workers tracking parts of their batches must not fill the window
+	// between them with deletions none of them can send
+	deleted, failed := mgr.CleanupSessions(context.Background())
+	assert.Equal(t, 200, deleted)
+	assert.Equal(t, 0, failed)
+	upf.mu.Lock()
+	assert.Len(t, upf.sent, 200)
+	upf.mu.Unlock()
+}
+
+// droppingUPF is an acceptingUPF that never answers the Session Deletion
+// Requests addressed to the SEIDs in drop.
+type droppingUPF struct {
+	acceptingUPF
+	drop map[uint64]bool
+}
+
+func (u *droppingUPF) Send(data []byte) error {
+	msg, err := message.Parse(data)
+	if err != nil {
+		return err
+	}
+	if msg.MessageType() == message.MsgTypeSessionDeletionRequest && u.drop[msg.SEID()] {
+		return u.fakeTransport.Send(data)
+	}
+	return u.acceptingUPF.Send(data)
+}
+
+func TestManager_CleanupSessionsNotHeldUpByUnansweredDeletions(t *testing.T) {
+	cfg := testConfig()
+	cfg.Timing.ResponseTimeoutMs = 1000
+	cfg.Session.CleanupConcurrency = 4
+
+	upf := &droppingUPF{drop: map[uint64]bool{102: true, 105: true}}
+	upf.tracker = network.NewTransactionTracker(upf, cfg.Timing.ResponseTimeoutMs, 0)
+	collector := stats.NewCollector()
+	mgr, err := NewManager(cfg, upf, nil, upf.tracker, collector, nil, nil)
+	require.NoError(t, err)
+
+	for seid := uint64(1); seid <= 6; seid++ {
+		mgr.byLocalSEID[seid] = &types.SessionInfo{LocalSEID: seid, RemoteSEID: seid + 100, State: "established"}
+	}
+
+	// The unanswered deletions wait until the cleanup times out, while the
+	// others are deleted in the meantime
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	deleted, failed := mgr.CleanupSessions(ctx)
+	assert.Equal(t, 4, deleted)
+	assert.Equal(t, 2, failed)
+	assert.Equal(t, uint64(4), collector.Snapshot().SessionsDeleted)
+	assert.Equal(t, "established", mgr.byLocalSEID[2].State)
+	assert.Equal(t, "established", mgr.byLocalSEID[5].State)
+	assert.Equal(t, 2, mgr.ActiveSessionCount())
+}
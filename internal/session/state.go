@@ -0,0 +1,214 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"pfcp-generator/pkg/types"
+)
+
+// persistedState is the JSON layout of the session state file.
+type persistedState struct {
+	SavedAt  time.Time          `json:"saved_at"`
+	Sessions []persistedSession `json:"sessions"`
+	SEIDs    []uint64           `json:"seids"`
+	UEIPs    []string           `json:"ue_ips"`
+}
+
+// persistedSession is one entry of the byLocalSEID map.
+type persistedSession struct {
+	OriginalCPSEID     uint64            `json:"original_cp_seid"`
+	OriginalRemoteSEID uint64            `json:"original_remote_seid,omitempty"`
+	LocalSEID          uint64            `json:"local_seid"`
+	RemoteSEID         uint64            `json:"remote_seid"`
+	UEIP               string            `json:"ue_ip,omitempty"`
+	TEIDs              map[uint32]uint32 `json:"teids,omitempty"`
+	UEMACs             map[string]string `json:"ue_macs,omitempty"`
+	State              string            `json:"state"`
+	CreatedAt          time.Time         `json:"created_at"`
+}
+
+// SaveState writes the current sessions and SEID/UE IP allocations to
+// filename. The file is replaced atomically so an interrupted write never
+// leaves a truncated state behind.
+func (m *Manager) SaveState(filename string) error {
+	state := persistedState{SavedAt: time.Now()}
+	if seeder, ok := m.seidAlloc.(seidSeeder); ok {
+		state.SEIDs = seeder.Allocated()
+	}
+	for _, pool := range m.ueIPPools() {
+		seeder, ok := pool.(ipSeeder)
+		if !ok {
+			continue
+		}
+		for _, ip := range seeder.Allocated() {
+			state.UEIPs = append(state.UEIPs, ip.String())
+		}
+	}
+
+	m.mu.RLock()
+	for _, s := range m.byLocalSEID {
+		if s.State == "deleted" {
+			continue
+		}
+		ps := persistedSession{
+			OriginalCPSEID:     s.OriginalCPSEID,
+			OriginalRemoteSEID: s.OriginalRemoteSEID,
+			LocalSEID:          s.LocalSEID,
+			RemoteSEID:         s.RemoteSEID,
+			TEIDs:              s.TEIDs,
+			State:              s.State,
+			CreatedAt:          s.CreatedAt,
+		}
+		if s.UEIP != nil {
+			ps.UEIP = s.UEIP.String()
+		}
+		for original, mac := range s.UEMACs {
+			if ps.UEMACs == nil {
+				ps.UEMACs = make(map[string]string)
+			}
+			ps.UEMACs[original] = mac.String()
+		}
+		state.Sessions = append(state.Sessions, ps)
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	m.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode session state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create session state file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write session state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write session state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to replace session state file %s: %w", filename, err)
+	}
+	return nil
+}
+
+// LoadState restores sessions and SEID/UE IP allocations saved by a previous
+// run. A missing file means a fresh start; a corrupt file is logged and
+// ignored so the run starts clean. Only established sessions are restored to
+// the session maps, but every saved SEID and UE IP stays allocated.
+func (m *Manager) LoadState(filename string) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.WithField("file", filename).Info("No session state file, starting fresh")
+		return
+	}
+	if err != nil {
+		log.WithError(err).WithField("file", filename).Warn("Failed to read session state file, starting clean")
+		return
+	}
+
+	var state persistedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.WithError(err).WithField("file", filename).Warn("Corrupt session state file, starting clean")
+		return
+	}
+
+	ueIPs := make([]net.IP, 0, len(state.UEIPs))
+	for _, s := range state.UEIPs {
+		if ip := net.ParseIP(s); ip != nil {
+			ueIPs = append(ueIPs, ip)
+		}
+	}
+	if seeder, ok := m.seidAlloc.(seidSeeder); ok {
+		seeder.Seed(state.SEIDs)
+	}
+	for _, pool := range m.ueIPPools() {
+		if seeder, ok := pool.(ipSeeder); ok {
+			seeder.Seed(ueIPs)
+		}
+	}
+
+	restored := 0
+	m.mu.Lock()
+	for _, ps := range state.Sessions {
+		var teids []uint32
+		for _, teid := range ps.TEIDs {
+			teids = append(teids, teid)
+		}
+		m.teidAlloc.Seed(teids)
+		ueMACs := make(map[string]net.HardwareAddr, len(ps.UEMACs))
+		for original, s := range ps.UEMACs {
+			if mac, err := net.ParseMAC(s); err == nil {
+				ueMACs[original] = mac
+				if m.macPool != nil {
+					m.macPool.Seed([]net.HardwareAddr{mac})
+				}
+			}
+		}
+
+		if ps.State != "established" || ps.LocalSEID == 0 {
+			continue
+		}
+		session := &types.SessionInfo{
+			OriginalCPSEID:     ps.OriginalCPSEID,
+			OriginalRemoteSEID: ps.OriginalRemoteSEID,
+			LocalSEID:          ps.LocalSEID,
+			RemoteSEID:         ps.RemoteSEID,
+			UEIP:               net.ParseIP(ps.UEIP),
+			TEIDs:              ps.TEIDs,
+			UEMACs:             ueMACs,
+			State:              ps.State,
+			CreatedAt:          ps.CreatedAt,
+		}
+		if session.TEIDs == nil {
+			session.TEIDs = make(map[uint32]uint32)
+		}
+		m.byLocalSEID[session.LocalSEID] = session
+		m.byOriginalCPSEID[session.OriginalCPSEID] = session
+		if session.OriginalRemoteSEID != 0 {
+			m.byOriginalRemoteSEID[session.OriginalRemoteSEID] = session
+		}
+		restored++
+	}
+	m.mu.Unlock()
+
+	log.WithFields(log.Fields{
+		"file":     filename,
+		"saved_at": state.SavedAt.Format(time.RFC3339),
+		"sessions": restored,
+		"seids":    len(state.SEIDs),
+		"ue_ips":   len(ueIPs),
+	}).Info("Restored session state")
+}
+
+// RunStateSaver writes the session state to filename every interval until ctx
+// is cancelled. The final state is written separately by the caller on exit.
+func (m *Manager) RunStateSaver(ctx context.Context, filename string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.SaveState(filename); err != nil {
+				log.WithError(err).Warn("Failed to save session state")
+			}
+		}
+	}
+}
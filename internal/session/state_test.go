@@ -0,0 +1,125 @@
+package session
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+
+	"pfcp-generator/internal/network"
+	"pfcp-generator/internal/stats"
+	"pfcp-generator/pkg/types"
+)
+
+func TestSaveAndLoadState(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "state.json")
+
+	m, err := NewManager(testConfig(), nil, nil, nil, stats.NewCollector(), nil, nil)
+	require.NoError(t, err)
+
+	seid, err := m.seidAlloc.Allocate()
+	require.NoError(t, err)
+	ip, err := m.ipPool.Allocate()
+	require.NoError(t, err)
+	session := &types.SessionInfo{
+		OriginalCPSEID:     100,
+		OriginalRemoteSEID: 200,
+		LocalSEID:          seid,
+		RemoteSEID:         300,
+		UEIP:               ip,
+		TEIDs:              map[uint32]uint32{0x10: 1},
+		State:              "established",
+		CreatedAt:          time.Now(),
+	}
+	m.byLocalSEID[seid] = session
+	require.NoError(t, m.SaveState(filename))
+
+	restored, err := NewManager(testConfig(), nil, nil, nil, stats.NewCollector(), nil, nil)
+	require.NoError(t, err)
+	restored.LoadState(filename)
+
+	got := restored.byLocalSEID[seid]
+	require.NotNil(t, got)
+	assert.Equal(t, uint64(300), got.RemoteSEID)
+	assert.True(t, ip.Equal(got.UEIP))
+	assert.Same(t, got, restored.findSessionByOriginalRemoteSEID(200))
+
+	// Restored allocations are not handed out again
+	next, err := restored.seidAlloc.Allocate()
+	require.NoError(t, err)
+	assert.NotEqual(t, seid, next)
+	nextIP, err := restored.ipPool.Allocate()
+	require.NoError(t, err)
+	assert.False(t, ip.Equal(nextIP))
+	teid, err := restored.teidAlloc.Allocate()
+	require.NoError(t, err)
+	assert.NotEqual(t, uint32(1), teid)
+}
+
+func TestLoadStateMissingOrCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	corrupt := filepath.Join(dir, "corrupt.json")
+	require.NoError(t, os.WriteFile(corrupt, []byte("{not json"), 0o644))
+
+	for _, filename := range []string{filepath.Join(dir, "missing.json"), corrupt} {
+		m, err := NewManager(testConfig(), nil, nil, nil, stats.NewCollector(), nil, nil)
+		require.NoError(t, err)
+		m.LoadState(filename)
+
+		assert.Empty(t, m.byLocalSEID)
+		seid, err := m.seidAlloc.Allocate()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), seid)
+	}
+}
+
+func TestUEIPPoolSeed(t *testing.T) {
+	pool, err := NewUEIPPool("10.0.0.0/30")
+	require.NoError(t, err)
+
+	pool.Seed([]net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("192.168.0.1")})
+	assert.Len(t, pool.Allocated(), 1)
+
+	ip, err := pool.Allocate()
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.2", ip.String())
+}
+
+func TestRunStateSaverDuringRejectedEstablishment(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "state.json")
+	cfg := testConfig()
+	cfg.Timing.ResponseTimeoutMs = 1000
+
+	upf := &acceptingUPF{causes: map[uint8]uint8{message.MsgTypeSessionEstablishmentRequest: ie.CauseRequestRejected}}
+	upf.tracker = network.NewTransactionTracker(upf, cfg.Timing.ResponseTimeoutMs, 0)
+	mgr, err := NewManager(cfg, upf, nil, upf.tracker, stats.NewCollector(), nil, nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mgr.RunStateSaver(ctx, filename, time.Millisecond)
+	}()
+
+	// The saver reads every session's state while the replay marks the
+	// rejected ones failed; run with -race to check they do not race
+	messages, _ := repeatPcap(t)
+	for i := 0; i < 50; i++ {
+		require.NoError(t, mgr.Replay(context.Background(), messages[1:2]))
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	_, err = os.Stat(filename)
+	assert.NoError(t, err)
+	assert.Zero(t, mgr.ActiveSessionCount())
+}
@@ -0,0 +1,69 @@
+package session
+
+import (
+	"fmt"
+	"sync"
+)
+
+// TEIDAllocator manages allocation and release of local GTP-U TEIDs.
+type TEIDAllocator struct {
+	nextTEID  uint32
+	usedTEIDs map[uint32]bool
+	mu        sync.Mutex
+}
+
+// NewTEIDAllocator creates a new sequential TEID allocator starting at startTEID.
+func NewTEIDAllocator(startTEID uint32) *TEIDAllocator {
+	if startTEID == 0 {
+		startTEID = 1 // TEID 0 is reserved
+	}
+	return &TEIDAllocator{
+		nextTEID:  startTEID,
+		usedTEIDs: make(map[uint32]bool),
+	}
+}
+
+// Allocate returns a new unique TEID.
+func (t *TEIDAllocator) Allocate() (uint32, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := 0; i < 1000000; i++ {
+		if t.nextTEID == 0 {
+			t.nextTEID = 1
+		}
+		teid := t.nextTEID
+		t.nextTEID++
+		if !t.usedTEIDs[teid] {
+			t.usedTEIDs[teid] = true
+			return teid, nil
+		}
+	}
+	return 0, fmt.Errorf("failed to allocate TEID: too many collisions")
+}
+
+// Release frees a previously allocated TEID for reuse.
+func (t *TEIDAllocator) Release(teid uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.usedTEIDs, teid)
+}
+
+// AllocatedCount returns the number of currently allocated TEIDs.
+func (t *TEIDAllocator) AllocatedCount() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.usedTEIDs)
+}
+
+// Seed marks teids as allocated, e.g. to restore allocations saved by a
+// previous run.
+func (t *TEIDAllocator) Seed(teids []uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, teid := range teids {
+		if teid != 0 {
+			t.usedTEIDs[teid] = true
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTEIDAllocator_StartsFromBase(t *testing.T) {
+	alloc := NewTEIDAllocator(500)
+	teid, err := alloc.Allocate()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(500), teid)
+
+	teid, err = alloc.Allocate()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(501), teid)
+}
+
+func TestTEIDAllocator_SkipsZero(t *testing.T) {
+	alloc := NewTEIDAllocator(0xFFFFFFFF)
+	teid1, err := alloc.Allocate()
+	require.NoError(t, err)
+	teid2, err := alloc.Allocate()
+	require.NoError(t, err)
+
+	assert.Equal(t, uint32(0xFFFFFFFF), teid1)
+	assert.Equal(t, uint32(1), teid2)
+}
+
+func TestTEIDAllocator_Release(t *testing.T) {
+	alloc := NewTEIDAllocator(1)
+	teid, err := alloc.Allocate()
+	require.NoError(t, err)
+	assert.Equal(t, 1, alloc.AllocatedCount())
+
+	alloc.Release(teid)
+	assert.Equal(t, 0, alloc.AllocatedCount())
+}
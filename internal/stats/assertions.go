@@ -0,0 +1,92 @@
+package stats
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Expectations are the pass/fail criteria of a run, checked against the final
+// statistics. A zero value disables a check, except MaxFailed, which is
+// disabled by a negative value.
+type Expectations struct {
+	MinEstablished uint64        // Sessions established
+	MaxFailed      int           // Sessions that could not be established
+	MaxP99         time.Duration // P99 response time over all request types
+	MinThroughput  float64       // Average send rate in msg/s, after the warm-up
+}
+
+// AssertionResult is the outcome of one check of Expectations.
+type AssertionResult struct {
+	Name     string
+	Expected string
+	Actual   string
+	Passed   bool
+}
+
+// CheckExpectations checks e against snap, a snapshot taken with
+// Collector.Snapshot, and returns the result of every enabled check. Like the
+// report, the response times and throughput leave out the warm-up.
+func CheckExpectations(snap *Collector, e Expectations) []AssertionResult {
+	var results []AssertionResult
+	if e.MinEstablished > 0 {
+		results = append(results, AssertionResult{
+			Name:     "sessions established",
+			Expected: fmt.Sprintf(">= %d", e.MinEstablished),
+			Actual:   fmt.Sprintf("%d", snap.SessionsEstablished),
+			Passed:   snap.SessionsEstablished >= e.MinEstablished,
+		})
+	}
+	if e.MaxFailed >= 0 {
+		results = append(results, AssertionResult{
+			Name:     "sessions failed",
+			Expected: fmt.Sprintf("<= %d", e.MaxFailed),
+			Actual:   fmt.Sprintf("%d", snap.SessionsFailed),
+			Passed:   snap.SessionsFailed <= uint64(e.MaxFailed),
+		})
+	}
+	if e.MaxP99 > 0 {
+		_, _, _, p99 := snap.ResponseTimeStats()
+		actual := "no responses"
+		if snap.responses.count > 0 {
+			actual = p99.String()
+		}
+		results = append(results, AssertionResult{
+			Name:     "p99 response time",
+			Expected: fmt.Sprintf("<= %v", e.MaxP99),
+			Actual:   actual,
+			Passed:   snap.responses.count > 0 && p99 <= e.MaxP99,
+		})
+	}
+	if e.MinThroughput > 0 {
+		var throughput float64
+		if sent, steady := snap.SteadyState(); steady > 0 {
+			throughput = float64(sent) / steady.Seconds()
+		}
+		results = append(results, AssertionResult{
+			Name:     "throughput",
+			Expected: fmt.Sprintf(">= %.1f msg/s", e.MinThroughput),
+			Actual:   fmt.Sprintf("%.1f msg/s", throughput),
+			Passed:   throughput >= e.MinThroughput,
+		})
+	}
+	return results
+}
+
+// FormatAssertions formats the results of CheckExpectations as a report
+// section, one line per check with its expected and actual value.
+func FormatAssertions(results []AssertionResult) string {
+	var sb strings.Builder
+	sb.WriteString("Assertions:\n")
+	passed := 0
+	for _, r := range results {
+		status := "FAIL"
+		if r.Passed {
+			status = "PASS"
+			passed++
+		}
+		sb.WriteString(fmt.Sprintf("  %-4s  %-22s expected %-16s actual %s\n", status, r.Name, r.Expected, r.Actual))
+	}
+	sb.WriteString(fmt.Sprintf("  %d of %d passed\n", passed, len(results)))
+	return sb.String()
+}
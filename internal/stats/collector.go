@@ -1,8 +1,10 @@
 package stats
 
 import (
+	"math/rand"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,6 +16,55 @@ type MessageTypeStats struct {
 	Failed     uint64
 	Timeout    uint64
 	Retransmit uint64
+
+	responses responseTimes
+}
+
+// ResponseTimeStats returns min, avg, max, and p99 response times of the
+// message type's successful transactions (see Collector.ResponseTimeStats).
+func (s *MessageTypeStats) ResponseTimeStats() (min, avg, max, p99 time.Duration) {
+	r := s.responses.clone()
+	return r.stats()
+}
+
+// messageCounters are the live counters of one message type, updated
+// atomically so that the send and receive paths do not contend on a lock.
+type messageCounters struct {
+	sent       atomic.Uint64
+	received   atomic.Uint64
+	success    atomic.Uint64
+	failed     atomic.Uint64
+	timeout    atomic.Uint64
+	retransmit atomic.Uint64
+
+	responses responseTimes // Guarded by Collector.timesMu
+}
+
+// load returns the current values of the counters. The caller holds
+// Collector.timesMu.
+func (m *messageCounters) load() *MessageTypeStats {
+	return &MessageTypeStats{
+		Sent:       m.sent.Load(),
+		Received:   m.received.Load(),
+		Success:    m.success.Load(),
+		Failed:     m.failed.Load(),
+		Timeout:    m.timeout.Load(),
+		Retransmit: m.retransmit.Load(),
+		responses:  m.responses.clone(),
+	}
+}
+
+// counters returns live counters holding the values of s.
+func (s *MessageTypeStats) counters() *messageCounters {
+	m := &messageCounters{}
+	m.sent.Store(s.Sent)
+	m.received.Store(s.Received)
+	m.success.Store(s.Success)
+	m.failed.Store(s.Failed)
+	m.timeout.Store(s.Timeout)
+	m.retransmit.Store(s.Retransmit)
+	m.responses = s.responses.clone()
+	return m
 }
 
 // Collector aggregates operational statistics.
@@ -21,75 +72,269 @@ type Collector struct {
 	StartTime time.Time
 	EndTime   time.Time
 
+	// Per message type statistics, filled in by Snapshot
 	MessageStats map[string]*MessageTypeStats
 
+	// Rejection Cause values per request message type
+	Causes map[string]map[uint8]uint64
+
 	SessionsEstablished uint64
 	SessionsModified    uint64
 	SessionsDeleted     uint64
 	SessionsFailed      uint64
 	ActiveSessions      uint64
 
+	UPFRestarts      uint64
+	SoakCycles       uint64
+	RepeatIterations uint64
+	ReceiveDrops     uint64 // Received messages dropped because the receive buffer was full
+
+	// Responses that matched no pending request, by response message type
+	UnexpectedResponses map[string]uint64
+
+	// Modification and Deletion Requests addressed to a session that was never
+	// established, by request message type and by their header SEID in the pcap
+	OrphanedRequests map[string]uint64
+	OrphanedSEIDs    map[uint64]uint64
+
+	// A uniform sample of at most SetResponseTimeSamples response times of
+	// all message types, filled in by Snapshot
 	ResponseTimes []time.Duration
 
-	mu sync.Mutex
+	// Response times of all message types, and the sample size of these and
+	// the per message type ones (guarded by timesMu rather than mu)
+	responses       responseTimes
+	responseSamples int
+	rng             *rand.Rand
+
+	// A uniform sample of at most SetResponseTimeSamples session lifetimes,
+	// from establishment to accepted deletion, filled in by Snapshot
+	SessionLifetimes []time.Duration
+	lifetimes        responseTimes // Guarded by timesMu
+
+	// Send rates in msg/s over the last second and the last 10 seconds, filled
+	// in by Snapshot
+	SendRate1s  float64
+	SendRate10s float64
+	sendRate    sendRate
+
+	// InFlight is the number of pending transactions when the snapshot was
+	// taken, read from the source set with SetInFlightSource
+	InFlight       int
+	inFlightSource func() int
+
+	// Live per message type counters; countersMu only guards the maps, which
+	// gain an entry the first time a message type is recorded
+	counters   map[string]*messageCounters
+	countersMu sync.RWMutex
+
+	// During the warm-up, between StartWarmup and EndWarmup, transactions are
+	// counted in warmupCounters and warmupResponses instead, and left out of
+	// MessageStats, the response times and the throughput. WarmupStats is
+	// filled in by Snapshot.
+	WarmupStart     time.Time
+	WarmupEnd       time.Time
+	WarmupStats     map[string]*MessageTypeStats
+	warming         atomic.Bool
+	warmupCounters  map[string]*messageCounters
+	warmupResponses responseTimes // Guarded by timesMu
+
+	mu      sync.Mutex
+	timesMu sync.Mutex
 }
 
+// DefaultResponseTimeSamples is the default number of response times kept
+// for percentile estimation.
+const DefaultResponseTimeSamples = 10000
+
 // NewCollector creates a new statistics collector.
 func NewCollector() *Collector {
 	return &Collector{
-		StartTime:    time.Now(),
-		MessageStats: make(map[string]*MessageTypeStats),
+		StartTime:           time.Now(),
+		responseSamples:     DefaultResponseTimeSamples,
+		MessageStats:        make(map[string]*MessageTypeStats),
+		Causes:              make(map[string]map[uint8]uint64),
+		UnexpectedResponses: make(map[string]uint64),
+		OrphanedRequests:    make(map[string]uint64),
+		OrphanedSEIDs:       make(map[uint64]uint64),
+		counters:            make(map[string]*messageCounters),
+		warmupCounters:      make(map[string]*messageCounters),
+	}
+}
+
+// counter returns the live counters of msgType, creating them on first use:
+// the warm-up ones while warming up, the main ones otherwise.
+func (c *Collector) counter(msgType string) *messageCounters {
+	return c.counterFor(msgType, c.warming.Load())
+}
+
+// counterFor returns the warm-up or the main counters of msgType, creating
+// them on first use.
+func (c *Collector) counterFor(msgType string, warmup bool) *messageCounters {
+	c.countersMu.RLock()
+	counters := c.counters
+	if warmup {
+		counters = c.warmupCounters
 	}
+	m, ok := counters[msgType]
+	c.countersMu.RUnlock()
+	if ok {
+		return m
+	}
+
+	c.countersMu.Lock()
+	defer c.countersMu.Unlock()
+	if m, ok = counters[msgType]; !ok {
+		m = &messageCounters{}
+		counters[msgType] = m
+	}
+	return m
 }
 
-func (c *Collector) getOrCreate(msgType string) *MessageTypeStats {
-	if _, ok := c.MessageStats[msgType]; !ok {
-		c.MessageStats[msgType] = &MessageTypeStats{}
+// messageStats returns the current values of the main and the warm-up per
+// message type counters.
+func (c *Collector) messageStats() (main, warmup map[string]*MessageTypeStats) {
+	c.countersMu.RLock()
+	defer c.countersMu.RUnlock()
+	c.timesMu.Lock()
+	defer c.timesMu.Unlock()
+	load := func(counters map[string]*messageCounters) map[string]*MessageTypeStats {
+		stats := make(map[string]*MessageTypeStats, len(counters))
+		for msgType, m := range counters {
+			stats[msgType] = m.load()
+		}
+		return stats
 	}
-	return c.MessageStats[msgType]
+	return load(c.counters), load(c.warmupCounters)
+}
+
+// StartWarmup begins the warm-up: until EndWarmup, transactions are recorded
+// in the warm-up statistics rather than the main ones. Sessions are counted as
+// usual.
+func (c *Collector) StartWarmup() {
+	c.mu.Lock()
+	c.WarmupStart = time.Now()
+	c.mu.Unlock()
+	c.warming.Store(true)
+}
+
+// EndWarmup ends the warm-up. Transactions already under way are recorded in
+// the main statistics once they complete.
+func (c *Collector) EndWarmup() {
+	c.mu.Lock()
+	c.WarmupEnd = time.Now()
+	c.mu.Unlock()
+	c.warming.Store(false)
+}
+
+// Warming reports whether the warm-up is in progress.
+func (c *Collector) Warming() bool {
+	return c.warming.Load()
 }
 
 // RecordSent records a message being sent.
 func (c *Collector) RecordSent(msgType string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.getOrCreate(msgType).Sent++
+	c.counter(msgType).sent.Add(1)
+	c.sendRate.record(time.Now())
 }
 
 // RecordReceived records a response being received.
 func (c *Collector) RecordReceived(msgType string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.getOrCreate(msgType).Received++
+	c.counter(msgType).received.Add(1)
 }
 
 // RecordSuccess records a successful transaction.
 func (c *Collector) RecordSuccess(msgType string, responseTime time.Duration) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.getOrCreate(msgType).Success++
-	c.ResponseTimes = append(c.ResponseTimes, responseTime)
+	warmup := c.warming.Load()
+	m := c.counterFor(msgType, warmup)
+	m.success.Add(1)
+
+	c.timesMu.Lock()
+	defer c.timesMu.Unlock()
+	if c.rng == nil {
+		c.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	if warmup {
+		c.warmupResponses.record(responseTime, c.responseSamples, c.rng)
+	} else {
+		c.responses.record(responseTime, c.responseSamples, c.rng)
+	}
+	m.responses.record(responseTime, c.responseSamples, c.rng)
+}
+
+// SetResponseTimeSamples sets the number of response times kept for the
+// percentile estimate (default DefaultResponseTimeSamples), bounding the
+// collector's memory however many transactions are recorded.
+func (c *Collector) SetResponseTimeSamples(n int) {
+	// countersMu is always taken before timesMu
+	c.countersMu.RLock()
+	defer c.countersMu.RUnlock()
+	c.timesMu.Lock()
+	defer c.timesMu.Unlock()
+
+	c.responseSamples = n
+	c.responses.truncate(n)
+	c.warmupResponses.truncate(n)
+	c.lifetimes.truncate(n)
+	for _, m := range c.counters {
+		m.responses.truncate(n)
+	}
+	for _, m := range c.warmupCounters {
+		m.responses.truncate(n)
+	}
 }
 
 // RecordFailure records a failed transaction (cause != accepted).
 func (c *Collector) RecordFailure(msgType string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.getOrCreate(msgType).Failed++
+	c.counter(msgType).failed.Add(1)
 }
 
 // RecordTimeout records a transaction timeout.
 func (c *Collector) RecordTimeout(msgType string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.getOrCreate(msgType).Timeout++
+	c.counter(msgType).timeout.Add(1)
 }
 
 // RecordRetransmit records a retransmission.
 func (c *Collector) RecordRetransmit(msgType string) {
+	c.counter(msgType).retransmit.Add(1)
+}
+
+// RecordCause records the Cause value of a response that rejected a request.
+func (c *Collector) RecordCause(msgType string, cause uint8) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Causes[msgType] == nil {
+		c.Causes[msgType] = make(map[uint8]uint64)
+	}
+	c.Causes[msgType][cause]++
+}
+
+// CauseCount is the number of rejections with one Cause value.
+type CauseCount struct {
+	Cause uint8
+	Count uint64
+}
+
+// TopCauses returns the rejection causes recorded for msgType, most frequent
+// first, limited to n entries (n <= 0 returns all).
+func (c *Collector) TopCauses(msgType string, n int) []CauseCount {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.getOrCreate(msgType).Retransmit++
+
+	counts := make([]CauseCount, 0, len(c.Causes[msgType]))
+	for cause, count := range c.Causes[msgType] {
+		counts = append(counts, CauseCount{Cause: cause, Count: count})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Cause < counts[j].Cause
+	})
+	if n > 0 && len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
 }
 
 // RecordSessionEstablished increments established session count.
@@ -117,6 +362,16 @@ func (c *Collector) RecordSessionDeleted() {
 	}
 }
 
+// RecordSessionLifetime records the time a deleted session existed for.
+func (c *Collector) RecordSessionLifetime(lifetime time.Duration) {
+	c.timesMu.Lock()
+	defer c.timesMu.Unlock()
+	if c.rng == nil {
+		c.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	c.lifetimes.record(lifetime, c.responseSamples, c.rng)
+}
+
 // RecordSessionFailed increments failed session count.
 func (c *Collector) RecordSessionFailed() {
 	c.mu.Lock()
@@ -124,6 +379,79 @@ func (c *Collector) RecordSessionFailed() {
 	c.SessionsFailed++
 }
 
+// RecordSessionLost records an active session lost to a UPF restart.
+func (c *Collector) RecordSessionLost() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.SessionsFailed++
+	if c.ActiveSessions > 0 {
+		c.ActiveSessions--
+	}
+}
+
+// SessionCounts returns the number of sessions established so far and the
+// number currently active.
+func (c *Collector) SessionCounts() (established, active uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.SessionsEstablished, c.ActiveSessions
+}
+
+// RecordUPFRestart increments the detected UPF restart count.
+func (c *Collector) RecordUPFRestart() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.UPFRestarts++
+}
+
+// RecordSoakCycle increments the completed soak cycle count.
+func (c *Collector) RecordSoakCycle() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.SoakCycles++
+}
+
+// RecordRepeatIteration increments the completed --repeat iteration count.
+func (c *Collector) RecordRepeatIteration() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.RepeatIterations++
+}
+
+// RecordReceiveDrop increments the count of received messages dropped
+// because the receive buffer was full.
+func (c *Collector) RecordReceiveDrop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ReceiveDrops++
+}
+
+// RecordUnexpectedResponse counts a response that did not echo the sequence
+// number and type of a pending request.
+func (c *Collector) RecordUnexpectedResponse(msgType string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.UnexpectedResponses[msgType]++
+}
+
+// RecordOrphanedRequest counts a session request from the pcap whose header
+// SEID matches no established session, usually because the capture lacks the
+// session's establishment or its response.
+func (c *Collector) RecordOrphanedRequest(msgType string, seid uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.OrphanedRequests[msgType]++
+	c.OrphanedSEIDs[seid]++
+}
+
+// SetInFlightSource registers a function returning the number of pending
+// transactions, sampled by every Snapshot.
+func (c *Collector) SetInFlightSource(fn func() int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inFlightSource = fn
+}
+
 // Finish marks the end of the collection period.
 func (c *Collector) Finish() {
 	c.mu.Lock()
@@ -141,39 +469,113 @@ func (c *Collector) Duration() time.Duration {
 	return c.EndTime.Sub(c.StartTime)
 }
 
-// TotalSent returns the total number of messages sent.
+// TotalSent returns the total number of messages sent, including during the
+// warm-up.
 func (c *Collector) TotalSent() uint64 {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.countersMu.RLock()
+	defer c.countersMu.RUnlock()
 	var total uint64
-	for _, s := range c.MessageStats {
-		total += s.Sent
+	for _, counters := range []map[string]*messageCounters{c.counters, c.warmupCounters} {
+		for _, m := range counters {
+			total += m.sent.Load()
+		}
 	}
 	return total
 }
 
-// TotalReceived returns the total number of responses received.
-func (c *Collector) TotalReceived() uint64 {
+// SteadyState returns the number of messages sent after the warm-up, and the
+// time since it ended. Without a warm-up, that is the whole run; during one,
+// nothing.
+func (c *Collector) SteadyState() (sent uint64, elapsed time.Duration) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	warmupStart, warmupEnd := c.WarmupStart, c.WarmupEnd
+	c.mu.Unlock()
+	if !warmupStart.IsZero() && warmupEnd.IsZero() {
+		return 0, 0
+	}
+
+	elapsed = c.Duration()
+	if !warmupEnd.IsZero() {
+		elapsed -= warmupEnd.Sub(c.StartTime)
+	}
+	c.countersMu.RLock()
+	defer c.countersMu.RUnlock()
+	for _, m := range c.counters {
+		sent += m.sent.Load()
+	}
+	return sent, elapsed
+}
+
+// Failures returns the number of failed sessions plus the number of other
+// requests that were rejected or timed out, including during the warm-up. A
+// failed Session Establishment is counted once, as a failed session.
+func (c *Collector) Failures() uint64 {
+	c.mu.Lock()
+	total := c.SessionsFailed
+	c.mu.Unlock()
+
+	c.countersMu.RLock()
+	defer c.countersMu.RUnlock()
+	for _, counters := range []map[string]*messageCounters{c.counters, c.warmupCounters} {
+		for msgType, m := range counters {
+			if msgType != "SessionEstablishmentRequest" {
+				total += m.failed.Load() + m.timeout.Load()
+			}
+		}
+	}
+	return total
+}
+
+// TotalReceived returns the total number of responses received, including
+// during the warm-up.
+func (c *Collector) TotalReceived() uint64 {
+	c.countersMu.RLock()
+	defer c.countersMu.RUnlock()
 	var total uint64
-	for _, s := range c.MessageStats {
-		total += s.Received
+	for _, counters := range []map[string]*messageCounters{c.counters, c.warmupCounters} {
+		for _, m := range counters {
+			total += m.received.Load()
+		}
 	}
 	return total
 }
 
-// ResponseTimeStats returns min, avg, max, and p99 response times.
+// ResponseTimeStats returns min, avg, max, and p99 response times of all
+// message types, after the warm-up. Min, avg and max are exact; p99 is
+// estimated from the sampled response times.
 func (c *Collector) ResponseTimeStats() (min, avg, max, p99 time.Duration) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	// Sort a copy, so that RecordSuccess is not held up
+	c.timesMu.Lock()
+	r := c.responses.clone()
+	c.timesMu.Unlock()
+	return r.stats()
+}
+
+// WarmupResponseTimeStats returns min, avg, max, and p99 response times of all
+// message types during the warm-up.
+func (c *Collector) WarmupResponseTimeStats() (min, avg, max, p99 time.Duration) {
+	c.timesMu.Lock()
+	r := c.warmupResponses.clone()
+	c.timesMu.Unlock()
+	return r.stats()
+}
+
+// SessionLifetimeStats returns min, avg, max, and p99 session lifetimes. Min,
+// avg and max are exact; p99 is estimated from the sampled lifetimes.
+func (c *Collector) SessionLifetimeStats() (min, avg, max, p99 time.Duration) {
+	c.timesMu.Lock()
+	r := c.lifetimes.clone()
+	c.timesMu.Unlock()
+	return r.stats()
+}
 
-	if len(c.ResponseTimes) == 0 {
+// durationStats returns min, avg, max, and p99 of durations, sorting them in
+// place.
+func durationStats(sorted []time.Duration) (min, avg, max, p99 time.Duration) {
+	if len(sorted) == 0 {
 		return 0, 0, 0, 0
 	}
 
-	sorted := make([]time.Duration, len(c.ResponseTimes))
-	copy(sorted, c.ResponseTimes)
 	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
 
 	min = sorted[0]
@@ -194,32 +596,85 @@ func (c *Collector) ResponseTimeStats() (min, avg, max, p99 time.Duration) {
 	return
 }
 
-// Snapshot returns a copy of the current statistics (thread-safe).
+// Snapshot returns a copy of the current statistics (thread-safe). The
+// per message type counters are read atomically, one at a time, without
+// stopping concurrent updates.
 func (c *Collector) Snapshot() *Collector {
+	messageStats, warmupStats := c.messageStats()
+
+	c.timesMu.Lock()
+	responses := c.responses.clone()
+	warmupResponses := c.warmupResponses.clone()
+	lifetimes := c.lifetimes.clone()
+	responseSamples := c.responseSamples
+	c.timesMu.Unlock()
+
+	now := time.Now()
+	sendRate1s := c.sendRate.rate(now, c.StartTime, time.Second)
+	sendRate10s := c.sendRate.rate(now, c.StartTime, 10*time.Second)
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	snap := &Collector{
 		StartTime:           c.StartTime,
 		EndTime:             c.EndTime,
-		MessageStats:        make(map[string]*MessageTypeStats),
+		MessageStats:        messageStats,
+		Causes:              make(map[string]map[uint8]uint64),
 		SessionsEstablished: c.SessionsEstablished,
 		SessionsModified:    c.SessionsModified,
 		SessionsDeleted:     c.SessionsDeleted,
 		SessionsFailed:      c.SessionsFailed,
 		ActiveSessions:      c.ActiveSessions,
-		ResponseTimes:       make([]time.Duration, len(c.ResponseTimes)),
-	}
-	copy(snap.ResponseTimes, c.ResponseTimes)
-
-	for k, v := range c.MessageStats {
-		snap.MessageStats[k] = &MessageTypeStats{
-			Sent:       v.Sent,
-			Received:   v.Received,
-			Success:    v.Success,
-			Failed:     v.Failed,
-			Timeout:    v.Timeout,
-			Retransmit: v.Retransmit,
+		UPFRestarts:         c.UPFRestarts,
+		SoakCycles:          c.SoakCycles,
+		RepeatIterations:    c.RepeatIterations,
+		ReceiveDrops:        c.ReceiveDrops,
+		UnexpectedResponses: make(map[string]uint64, len(c.UnexpectedResponses)),
+		OrphanedRequests:    make(map[string]uint64, len(c.OrphanedRequests)),
+		OrphanedSEIDs:       make(map[uint64]uint64, len(c.OrphanedSEIDs)),
+		SendRate1s:          sendRate1s,
+		SendRate10s:         sendRate10s,
+		ResponseTimes:       append([]time.Duration(nil), responses.sample...),
+		responses:           responses,
+		responseSamples:     responseSamples,
+		SessionLifetimes:    append([]time.Duration(nil), lifetimes.sample...),
+		lifetimes:           lifetimes,
+		inFlightSource:      c.inFlightSource,
+		counters:            make(map[string]*messageCounters, len(messageStats)),
+		WarmupStart:         c.WarmupStart,
+		WarmupEnd:           c.WarmupEnd,
+		WarmupStats:         warmupStats,
+		warmupCounters:      make(map[string]*messageCounters, len(warmupStats)),
+		warmupResponses:     warmupResponses,
+	}
+	snap.warming.Store(c.warming.Load())
+	if c.inFlightSource != nil {
+		snap.InFlight = c.inFlightSource()
+	}
+	for k, v := range c.UnexpectedResponses {
+		snap.UnexpectedResponses[k] = v
+	}
+	for k, v := range c.OrphanedRequests {
+		snap.OrphanedRequests[k] = v
+	}
+	for k, v := range c.OrphanedSEIDs {
+		snap.OrphanedSEIDs[k] = v
+	}
+
+	// The snapshot's own counters hold the same values, so that TotalSent and
+	// the other totals work on it too
+	for msgType, s := range messageStats {
+		snap.counters[msgType] = s.counters()
+	}
+	for msgType, s := range warmupStats {
+		snap.warmupCounters[msgType] = s.counters()
+	}
+
+	for msgType, causes := range c.Causes {
+		snap.Causes[msgType] = make(map[uint8]uint64, len(causes))
+		for cause, count := range causes {
+			snap.Causes[msgType][cause] = count
 		}
 	}
 
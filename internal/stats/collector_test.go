@@ -0,0 +1,438 @@
+package stats
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollector_TopCauses(t *testing.T) {
+	c := NewCollector()
+	for i := 0; i < 3; i++ {
+		c.RecordCause("SessionEstablishmentRequest", 73)
+	}
+	c.RecordCause("SessionEstablishmentRequest", 72)
+	c.RecordCause("SessionEstablishmentRequest", 66)
+	c.RecordCause("SessionDeletionRequest", 65)
+
+	assert.Equal(t, []CauseCount{{73, 3}, {66, 1}, {72, 1}}, c.TopCauses("SessionEstablishmentRequest", 0))
+	assert.Equal(t, []CauseCount{{73, 3}}, c.TopCauses("SessionEstablishmentRequest", 1))
+	assert.Empty(t, c.TopCauses("HeartbeatRequest", 0))
+
+	// Snapshots do not share the cause maps
+	snap := c.Snapshot()
+	c.RecordCause("SessionDeletionRequest", 65)
+	assert.Equal(t, uint64(1), snap.Causes["SessionDeletionRequest"][65])
+}
+
+func TestReporter_FormatReportShowsCauses(t *testing.T) {
+	c := NewCollector()
+	c.RecordCause("SessionEstablishmentRequest", 72)
+	c.RecordCause("SessionEstablishmentRequest", 72)
+
+	report := NewReporter(c, 0, "").FormatReport()
+	assert.True(t, strings.Contains(report, "Rejection Causes:"))
+	assert.Contains(t, report, "2     No established PFCP Association (72)")
+}
+
+func TestCollector_SessionLifetimes(t *testing.T) {
+	c := NewCollector()
+	assert.NotContains(t, NewReporter(c, 0, "").FormatReport(), "Session Lifetimes:")
+
+	for _, d := range []time.Duration{3 * time.Second, time.Second, 2 * time.Second} {
+		c.RecordSessionLifetime(d)
+	}
+
+	min, avg, max, p99 := c.SessionLifetimeStats()
+	assert.Equal(t, time.Second, min)
+	assert.Equal(t, 2*time.Second, avg)
+	assert.Equal(t, 3*time.Second, max)
+	assert.Equal(t, 3*time.Second, p99)
+
+	report := NewReporter(c, 0, "").FormatReport()
+	assert.Contains(t, report, "Session Lifetimes:")
+	assert.Contains(t, report, "Min: 1s  |  Avg: 2s  |  Max: 3s  |  P99: 3s")
+}
+
+func TestCollector_Failures(t *testing.T) {
+	c := NewCollector()
+	assert.Zero(t, c.Failures())
+
+	// A timed-out establishment is one failed session, not two failures
+	c.RecordTimeout("SessionEstablishmentRequest")
+	c.RecordSessionFailed()
+	c.RecordFailure("SessionModificationRequest")
+	c.RecordTimeout("HeartbeatRequest")
+	c.RecordSuccess("SessionDeletionRequest", time.Millisecond)
+
+	assert.Equal(t, uint64(3), c.Failures())
+}
+
+func TestReporter_FormatReportShowsUnexpectedResponses(t *testing.T) {
+	c := NewCollector()
+	c.RecordUnexpectedResponse("SessionEstablishmentResponse")
+	c.RecordUnexpectedResponse("SessionEstablishmentResponse")
+
+	report := NewReporter(c, 0, "").FormatReport()
+	assert.Contains(t, report, "Unexpected Responses (no matching request):")
+	assert.Contains(t, report, "SessionEstablishmentResponse   2")
+}
+
+func TestReporter_FormatReportListsOrphanedSEIDs(t *testing.T) {
+	c := NewCollector()
+	for seid := uint64(1); seid <= 12; seid++ {
+		c.RecordOrphanedRequest("SessionDeletionRequest", seid)
+	}
+	c.RecordOrphanedRequest("SessionModificationRequest", 1)
+
+	report := NewReporter(c, 0, "").FormatReport()
+	assert.Contains(t, report, "Orphaned Requests (session not established, check the capture):")
+	assert.Contains(t, report, "SessionDeletionRequest         12")
+	assert.Contains(t, report, "Unmatched SEIDs: 0x1 0x2 0x3 0x4 0x5 0x6 0x7 0x8 0x9 0xa (and 2 more)")
+}
+
+func TestCollector_ConcurrentRecordAndSnapshot(t *testing.T) {
+	c := NewCollector()
+	stop := make(chan struct{})
+	snapshots := make(chan struct{})
+	go func() {
+		defer close(snapshots)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				snap := c.Snapshot()
+				assert.GreaterOrEqual(t, snap.TotalSent(), snap.TotalReceived())
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.RecordSent("SessionEstablishmentRequest")
+				c.RecordReceived("SessionEstablishmentRequest")
+				c.RecordSuccess("SessionEstablishmentRequest", time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	<-snapshots
+
+	snap := c.Snapshot()
+	assert.Equal(t, uint64(8000), snap.TotalSent())
+	assert.Equal(t, uint64(8000), snap.TotalReceived())
+	assert.Equal(t, uint64(8000), snap.MessageStats["SessionEstablishmentRequest"].Success)
+	assert.Len(t, snap.ResponseTimes, 8000)
+}
+
+// recordParallel records a sent and received request from every benchmark
+// goroutine, as the replay and the response handler do.
+func recordParallel(b *testing.B, c *Collector) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.RecordSent("SessionModificationRequest")
+			c.RecordReceived("SessionModificationRequest")
+		}
+	})
+}
+
+// Recording should cost about the same whether or not a reporter takes
+// snapshots at the same time; compare ns/op of the two benchmarks.
+func BenchmarkCollector_Record(b *testing.B) {
+	c := NewCollector()
+	recordParallel(b, c)
+}
+
+func BenchmarkCollector_RecordWhileSnapshotting(b *testing.B) {
+	c := NewCollector()
+	for i := 0; i < 10000; i++ {
+		c.RecordSuccess("HeartbeatRequest", time.Millisecond)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				c.Snapshot()
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	recordParallel(b, c)
+	b.StopTimer()
+	close(stop)
+	<-done
+}
+
+func TestCollector_ResponseTimesBounded(t *testing.T) {
+	c := NewCollector()
+	c.SetResponseTimeSamples(1000)
+
+	// 1µs .. 100ms, in increasing order so that a biased sample would show
+	for i := 1; i <= 100000; i++ {
+		c.RecordSuccess("SessionEstablishmentRequest", time.Duration(i)*time.Microsecond)
+	}
+	assert.Len(t, c.Snapshot().ResponseTimes, 1000)
+
+	min, avg, max, p99 := c.ResponseTimeStats()
+	assert.Equal(t, time.Microsecond, min)
+	assert.Equal(t, 100*time.Millisecond, max)
+	assert.Equal(t, 50000500*time.Nanosecond, avg)
+	assert.InDelta(t, float64(99*time.Millisecond), float64(p99), float64(2*time.Millisecond))
+
+	// Snapshots report the same exact values
+	smin, savg, smax, _ := c.Snapshot().ResponseTimeStats()
+	assert.Equal(t, []time.Duration{min, avg, max}, []time.Duration{smin, savg, smax})
+}
+
+func TestCollector_SessionLifetimesBounded(t *testing.T) {
+	c := NewCollector()
+	c.SetResponseTimeSamples(1000)
+
+	for i := 1; i <= 100000; i++ {
+		c.RecordSessionLifetime(time.Duration(i) * time.Millisecond)
+	}
+	assert.Len(t, c.Snapshot().SessionLifetimes, 1000)
+
+	min, avg, max, p99 := c.SessionLifetimeStats()
+	assert.Equal(t, time.Millisecond, min)
+	assert.Equal(t, 100*time.Second, max)
+	assert.Equal(t, 50000500*time.Microsecond, avg)
+	assert.InDelta(t, float64(99*time.Second), float64(p99), float64(2*time.Second))
+
+	smin, savg, smax, _ := c.Snapshot().SessionLifetimeStats()
+	assert.Equal(t, []time.Duration{min, avg, max}, []time.Duration{smin, savg, smax})
+}
+
+func TestCollector_ResponseTimesPerMessageType(t *testing.T) {
+	c := NewCollector()
+	for _, d := range []time.Duration{10 * time.Millisecond, 30 * time.Millisecond} {
+		c.RecordSuccess("SessionEstablishmentRequest", d)
+	}
+	c.RecordSuccess("SessionModificationRequest", time.Millisecond)
+	c.RecordFailure("SessionDeletionRequest")
+
+	snap := c.Snapshot()
+	min, avg, max, _ := snap.MessageStats["SessionEstablishmentRequest"].ResponseTimeStats()
+	assert.Equal(t, []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond}, []time.Duration{min, avg, max})
+	min, _, max, _ = snap.MessageStats["SessionModificationRequest"].ResponseTimeStats()
+	assert.Equal(t, []time.Duration{time.Millisecond, time.Millisecond}, []time.Duration{min, max})
+	min, _, max, _ = snap.ResponseTimeStats()
+	assert.Equal(t, []time.Duration{time.Millisecond, 30 * time.Millisecond}, []time.Duration{min, max})
+
+	report := NewReporter(c, 0, "").FormatReport()
+	assert.Contains(t, report, "SessionEstablishmentRequest:   min=10ms")
+	assert.Contains(t, report, "SessionModificationRequest:    min=1ms")
+	assert.NotContains(t, report, "SessionDeletionRequest:        min=")
+
+	path := filepath.Join(t.TempDir(), "stats.json")
+	require.NoError(t, NewReporter(c, 0, path).ExportJSON())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var export struct {
+		Messages map[string]struct {
+			ResponseTimesMs map[string]float64 `json:"response_times_ms"`
+		} `json:"messages"`
+		ResponseTimesMs map[string]float64 `json:"response_times_ms"`
+	}
+	require.NoError(t, json.Unmarshal(data, &export))
+	assert.Equal(t, 20.0, export.Messages["SessionEstablishmentRequest"].ResponseTimesMs["avg"])
+	assert.Nil(t, export.Messages["SessionDeletionRequest"].ResponseTimesMs)
+	assert.Equal(t, 30.0, export.ResponseTimesMs["max"])
+}
+
+func TestSendRate_Windows(t *testing.T) {
+	start := time.Unix(1000, 0)
+	var r sendRate
+
+	// 10 msg/s for 20s, then a burst of 100 sends in one slot
+	for i := 0; i < 200; i++ {
+		r.record(start.Add(time.Duration(i) * 100 * time.Millisecond))
+	}
+	burst := start.Add(20 * time.Second)
+	for i := 0; i < 100; i++ {
+		r.record(burst)
+	}
+
+	// The burst's slot is not complete yet
+	now := burst.Add(50 * time.Millisecond)
+	assert.InDelta(t, 10.0, r.rate(now, start, time.Second), 0.01)
+
+	now = burst.Add(time.Second)
+	assert.InDelta(t, 100.0, r.rate(now, start, time.Second), 0.01)
+	assert.InDelta(t, 19.0, r.rate(now, start, 10*time.Second), 0.01)
+
+	// Early in a run, the window is shortened to the time since the start
+	var early sendRate
+	for i := 0; i < 20; i++ {
+		early.record(start.Add(time.Duration(i) * 100 * time.Millisecond))
+	}
+	assert.InDelta(t, 10.0, early.rate(start.Add(2*time.Second), start, 10*time.Second), 0.01)
+	assert.Zero(t, early.rate(start, start, time.Second))
+}
+
+func TestReporter_FormatReportShowsRecentThroughput(t *testing.T) {
+	c := NewCollector()
+	c.RecordSent("SessionEstablishmentRequest")
+
+	report := NewReporter(c, 0, "").FormatReport()
+	assert.Contains(t, report, "msg/s (last 1s)")
+	assert.Contains(t, report, "msg/s (last 10s)")
+
+	// The final report only has the average
+	c.Finish()
+	report = NewReporter(c, 0, "").FormatReport()
+	assert.NotContains(t, report, "last 1s")
+	assert.Contains(t, report, "msg/s\n")
+}
+
+func TestCollector_Warmup(t *testing.T) {
+	c := NewCollector()
+	c.StartWarmup()
+	c.RecordSent("AssociationSetupRequest")
+	c.RecordSuccess("AssociationSetupRequest", 50*time.Millisecond)
+	c.RecordSent("SessionDeletionRequest")
+	c.RecordTimeout("SessionDeletionRequest")
+	assert.True(t, c.Warming())
+
+	// Nothing is steady state yet
+	sent, elapsed := c.SteadyState()
+	assert.Zero(t, sent)
+	assert.Zero(t, elapsed)
+	assert.Contains(t, NewReporter(c, 0, "").FormatReport(), "Warm-up (in progress, not included above):")
+
+	c.EndWarmup()
+	c.RecordSent("SessionEstablishmentRequest")
+	c.RecordSuccess("SessionEstablishmentRequest", time.Millisecond)
+	assert.False(t, c.Warming())
+
+	snap := c.Snapshot()
+	assert.Equal(t, []string{"SessionEstablishmentRequest"}, mapKeys(snap.MessageStats))
+	assert.Equal(t, []string{"AssociationSetupRequest", "SessionDeletionRequest"}, mapKeys(snap.WarmupStats))
+	_, _, max, _ := snap.ResponseTimeStats()
+	assert.Equal(t, time.Millisecond, max)
+	_, _, max, _ = snap.WarmupResponseTimeStats()
+	assert.Equal(t, 50*time.Millisecond, max)
+
+	// Totals and failures still cover the whole run
+	assert.Equal(t, uint64(3), snap.TotalSent())
+	assert.Equal(t, uint64(1), snap.Failures())
+	sent, elapsed = snap.SteadyState()
+	assert.Equal(t, uint64(1), sent)
+	assert.Greater(t, elapsed, time.Duration(0))
+
+	c.Finish()
+	report := NewReporter(c, 0, "").FormatReport()
+	assert.Contains(t, report, "s, not included above):\n  AssociationSetupRequest:")
+	assert.Contains(t, report, "msg/s after warm-up\n")
+}
+
+// mapKeys returns the sorted keys of stats.
+func mapKeys(stats map[string]*MessageTypeStats) []string {
+	var keys []string
+	for k := range stats {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestReporter_ExportPrometheus(t *testing.T) {
+	c := NewCollector()
+	for _, rt := range []time.Duration{800 * time.Microsecond, 3 * time.Millisecond, 8 * time.Second} {
+		c.RecordSent("SessionEstablishmentRequest")
+		c.RecordReceived("SessionEstablishmentResponse")
+		c.RecordSuccess("SessionEstablishmentRequest", rt)
+		c.RecordSessionEstablished()
+	}
+	c.RecordSent("SessionEstablishmentRequest")
+	c.RecordFailure("SessionEstablishmentRequest")
+	c.RecordCause("SessionEstablishmentRequest", 72)
+	c.Finish()
+
+	file := filepath.Join(t.TempDir(), "pfcp_generator.prom")
+	r := NewReporter(c, 0, "")
+	r.SetPromFile(file)
+	require.NoError(t, r.ExportPrometheus())
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	prom := string(data)
+	for _, line := range []string{
+		"# TYPE pfcp_generator_messages_sent_total counter",
+		`pfcp_generator_messages_sent_total{type="SessionEstablishmentRequest"} 4`,
+		`pfcp_generator_requests_total{type="SessionEstablishmentRequest",result="success"} 3`,
+		`pfcp_generator_requests_total{type="SessionEstablishmentRequest",result="failed"} 1`,
+		`pfcp_generator_rejections_total{type="SessionEstablishmentRequest",cause="72"} 1`,
+		`pfcp_generator_sessions_total{event="established"} 3`,
+		"# TYPE pfcp_generator_response_time_seconds histogram",
+		`pfcp_generator_response_time_seconds_bucket{type="SessionEstablishmentRequest",le="0.0005"} 0`,
+		`pfcp_generator_response_time_seconds_bucket{type="SessionEstablishmentRequest",le="0.001"} 1`,
+		`pfcp_generator_response_time_seconds_bucket{type="SessionEstablishmentRequest",le="5"} 2`,
+		`pfcp_generator_response_time_seconds_bucket{type="SessionEstablishmentRequest",le="+Inf"} 3`,
+		`pfcp_generator_response_time_seconds_sum{type="SessionEstablishmentRequest"} 8.0038`,
+		`pfcp_generator_response_time_seconds_count{type="SessionEstablishmentRequest"} 3`,
+	} {
+		assert.Contains(t, prom, line+"\n")
+	}
+
+	// No temporary file is left next to it
+	entries, err := os.ReadDir(filepath.Dir(file))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestCheckExpectations(t *testing.T) {
+	c := NewCollector()
+	for i := 0; i < 3; i++ {
+		c.RecordSent("SessionEstablishmentRequest")
+		c.RecordSuccess("SessionEstablishmentRequest", 10*time.Millisecond)
+		c.RecordSessionEstablished()
+	}
+	c.RecordSessionFailed()
+	c.Finish()
+	snap := c.Snapshot()
+
+	// Nothing is checked by default
+	assert.Empty(t, CheckExpectations(snap, Expectations{MaxFailed: -1}))
+
+	results := CheckExpectations(snap, Expectations{
+		MinEstablished: 3,
+		MaxFailed:      0,
+		MaxP99:         50 * time.Millisecond,
+	})
+	require.Len(t, results, 3)
+	assert.True(t, results[0].Passed)
+	assert.Equal(t, AssertionResult{Name: "sessions failed", Expected: "<= 0", Actual: "1"}, results[1])
+	assert.True(t, results[2].Passed)
+
+	report := FormatAssertions(results)
+	assert.Contains(t, report, "FAIL  sessions failed")
+	assert.Contains(t, report, "2 of 3 passed")
+
+	// No response at all cannot satisfy a response time limit
+	results = CheckExpectations(NewCollector().Snapshot(), Expectations{MaxFailed: -1, MaxP99: time.Second})
+	require.Len(t, results, 1)
+	assert.False(t, results[0].Passed)
+	assert.Equal(t, "no responses", results[0].Actual)
+}
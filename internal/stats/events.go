@@ -0,0 +1,74 @@
+package stats
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Transaction results reported in events.
+const (
+	ResultSuccess  = "success"
+	ResultTimeout  = "timeout"
+	ResultRejected = "rejected"
+	ResultError    = "error"
+)
+
+// Event describes one request sent to the UPF and its outcome.
+type Event struct {
+	Timestamp      time.Time `json:"timestamp"`
+	MsgType        string    `json:"msg_type"`
+	SeqNum         uint32    `json:"seq"`
+	LocalSEID      uint64    `json:"local_seid,omitempty"`
+	RemoteSEID     uint64    `json:"remote_seid,omitempty"`
+	UEIP           string    `json:"ue_ip,omitempty"`
+	ResponseTimeMs float64   `json:"response_time_ms,omitempty"`
+	Result         string    `json:"result"`
+	Cause          uint8     `json:"cause,omitempty"`
+	OffendingIE    string    `json:"offending_ie,omitempty"`
+	FailedRule     string    `json:"failed_rule,omitempty"`
+}
+
+// EventWriter writes events to a file as JSON lines. Writes are buffered and
+// only flushed to the file on Close.
+type EventWriter struct {
+	file *os.File
+	buf  *bufio.Writer
+	enc  *json.Encoder
+	mu   sync.Mutex
+}
+
+// NewEventWriter creates (or truncates) filename for writing events.
+func NewEventWriter(filename string) (*EventWriter, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create events file %s: %w", filename, err)
+	}
+	buf := bufio.NewWriterSize(f, 64*1024)
+	return &EventWriter{
+		file: f,
+		buf:  buf,
+		enc:  json.NewEncoder(buf),
+	}, nil
+}
+
+// Write appends one event as a line of JSON.
+func (w *EventWriter) Write(ev Event) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.enc.Encode(ev)
+}
+
+// Close flushes buffered events and closes the file.
+func (w *EventWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.buf.Flush(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("failed to flush events file: %w", err)
+	}
+	return w.file.Close()
+}
@@ -0,0 +1,54 @@
+package stats
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventWriter_WritesJSONLines(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "events.jsonl")
+	w, err := NewEventWriter(filename)
+	require.NoError(t, err)
+
+	ts := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, w.Write(Event{Timestamp: ts, MsgType: "HeartbeatRequest", SeqNum: 1, Result: ResultTimeout}))
+	require.NoError(t, w.Write(Event{
+		Timestamp:      ts,
+		MsgType:        "SessionEstablishmentRequest",
+		SeqNum:         2,
+		LocalSEID:      1,
+		UEIP:           "10.60.0.1",
+		ResponseTimeMs: 1.5,
+		Result:         ResultRejected,
+		Cause:          72,
+	}))
+	require.NoError(t, w.Close())
+
+	f, err := os.Open(filename)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	require.Len(t, lines, 2)
+
+	assert.Equal(t, "timeout", lines[0]["result"])
+	assert.NotContains(t, lines[0], "local_seid")
+	assert.NotContains(t, lines[0], "cause")
+
+	assert.Equal(t, "SessionEstablishmentRequest", lines[1]["msg_type"])
+	assert.Equal(t, "10.60.0.1", lines[1]["ue_ip"])
+	assert.Equal(t, float64(72), lines[1]["cause"])
+}
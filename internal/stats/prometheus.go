@@ -0,0 +1,200 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// metricPrefix starts the name of every metric written in the Prometheus
+// format.
+const metricPrefix = "pfcp_generator_"
+
+// promWriter writes metric families in the Prometheus text exposition format.
+type promWriter struct {
+	sb strings.Builder
+}
+
+// family starts a metric family with its HELP and TYPE lines.
+func (w *promWriter) family(name, typ, help string) {
+	fmt.Fprintf(&w.sb, "# HELP %s%s %s\n# TYPE %s%s %s\n", metricPrefix, name, help, metricPrefix, name, typ)
+}
+
+// sample writes one sample. labels alternate names and values.
+func (w *promWriter) sample(name string, value float64, labels ...string) {
+	w.sb.WriteString(metricPrefix + name)
+	if len(labels) > 0 {
+		w.sb.WriteString("{")
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.sb.WriteString(",")
+			}
+			fmt.Fprintf(&w.sb, "%s=%q", labels[i], labels[i+1])
+		}
+		w.sb.WriteString("}")
+	}
+	w.sb.WriteString(" " + strconv.FormatFloat(value, 'g', -1, 64) + "\n")
+}
+
+// histogram writes the buckets, sum and count of r.
+func (w *promWriter) histogram(name string, r *responseTimes, labels ...string) {
+	var cumulative uint64
+	for i, bound := range histogramBounds {
+		cumulative += r.buckets[i]
+		w.sample(name+"_bucket", float64(cumulative), append(labels, "le", strconv.FormatFloat(bound.Seconds(), 'g', -1, 64))...)
+	}
+	w.sample(name+"_bucket", float64(r.count), append(labels, "le", "+Inf")...)
+	w.sample(name+"_sum", r.sum.Seconds(), labels...)
+	w.sample(name+"_count", float64(r.count), labels...)
+}
+
+// writePrometheus writes the metrics of snap, a snapshot taken with
+// Collector.Snapshot, in the Prometheus text exposition format. Like the
+// report, the per message type metrics leave out the warm-up.
+func writePrometheus(out io.Writer, snap *Collector) error {
+	w := &promWriter{}
+
+	w.family("start_time_seconds", "gauge", "Start time of the replay since the Unix epoch.")
+	w.sample("start_time_seconds", float64(snap.StartTime.Unix()))
+	w.family("duration_seconds", "gauge", "Duration of the replay.")
+	w.sample("duration_seconds", snap.Duration().Seconds())
+	if sent, steady := snap.SteadyState(); steady > 0 {
+		w.family("throughput_messages_per_second", "gauge", "Average send rate, after the warm-up.")
+		w.sample("throughput_messages_per_second", float64(sent)/steady.Seconds())
+	}
+
+	typeNames := make([]string, 0, len(snap.MessageStats))
+	for name := range snap.MessageStats {
+		typeNames = append(typeNames, name)
+	}
+	sort.Strings(typeNames)
+
+	w.family("messages_sent_total", "counter", "PFCP messages sent, by message type.")
+	for _, name := range typeNames {
+		w.sample("messages_sent_total", float64(snap.MessageStats[name].Sent), "type", name)
+	}
+	w.family("messages_received_total", "counter", "PFCP messages received, by message type.")
+	for _, name := range typeNames {
+		w.sample("messages_received_total", float64(snap.MessageStats[name].Received), "type", name)
+	}
+	w.family("requests_total", "counter", "Requests by message type and outcome: success, failed (rejected) or timeout.")
+	for _, name := range typeNames {
+		s := snap.MessageStats[name]
+		if s.Success+s.Failed+s.Timeout == 0 {
+			continue
+		}
+		w.sample("requests_total", float64(s.Success), "type", name, "result", "success")
+		w.sample("requests_total", float64(s.Failed), "type", name, "result", "failed")
+		w.sample("requests_total", float64(s.Timeout), "type", name, "result", "timeout")
+	}
+	w.family("retransmissions_total", "counter", "Request retransmissions, by message type.")
+	for _, name := range typeNames {
+		w.sample("retransmissions_total", float64(snap.MessageStats[name].Retransmit), "type", name)
+	}
+	w.family("response_time_seconds", "histogram", "Response times of successful requests, by message type.")
+	for _, name := range typeNames {
+		if s := snap.MessageStats[name]; s.responses.count > 0 {
+			w.histogram("response_time_seconds", &s.responses, "type", name)
+		}
+	}
+
+	w.family("rejections_total", "counter", "Rejected requests, by message type and Cause value.")
+	causeTypes := make([]string, 0, len(snap.Causes))
+	for name := range snap.Causes {
+		causeTypes = append(causeTypes, name)
+	}
+	sort.Strings(causeTypes)
+	for _, name := range causeTypes {
+		causes := make([]int, 0, len(snap.Causes[name]))
+		for cause := range snap.Causes[name] {
+			causes = append(causes, int(cause))
+		}
+		sort.Ints(causes)
+		for _, cause := range causes {
+			w.sample("rejections_total", float64(snap.Causes[name][uint8(cause)]), "type", name, "cause", strconv.Itoa(cause))
+		}
+	}
+
+	w.family("sessions_total", "counter", "Sessions by event: established, modified, deleted or failed.")
+	w.sample("sessions_total", float64(snap.SessionsEstablished), "event", "established")
+	w.sample("sessions_total", float64(snap.SessionsModified), "event", "modified")
+	w.sample("sessions_total", float64(snap.SessionsDeleted), "event", "deleted")
+	w.sample("sessions_total", float64(snap.SessionsFailed), "event", "failed")
+	w.family("sessions_active", "gauge", "Sessions established and not deleted.")
+	w.sample("sessions_active", float64(snap.ActiveSessions))
+
+	w.family("upf_restarts_total", "counter", "UPF restarts detected from its Recovery Time Stamp.")
+	w.sample("upf_restarts_total", float64(snap.UPFRestarts))
+	w.family("soak_cycles_total", "counter", "Completed soak mode cycles.")
+	w.sample("soak_cycles_total", float64(snap.SoakCycles))
+	w.family("repeat_iterations_total", "counter", "Completed iterations of a repeated replay.")
+	w.sample("repeat_iterations_total", float64(snap.RepeatIterations))
+	w.family("receive_drops_total", "counter", "Received messages dropped because the receive buffer was full.")
+	w.sample("receive_drops_total", float64(snap.ReceiveDrops))
+	w.family("unexpected_responses_total", "counter", "Responses that matched no pending request, by message type.")
+	for _, name := range sortedKeys(snap.UnexpectedResponses) {
+		w.sample("unexpected_responses_total", float64(snap.UnexpectedResponses[name]), "type", name)
+	}
+	w.family("orphaned_requests_total", "counter", "Requests not sent because their session was never established, by message type.")
+	for _, name := range sortedKeys(snap.OrphanedRequests) {
+		w.sample("orphaned_requests_total", float64(snap.OrphanedRequests[name]), "type", name)
+	}
+
+	_, err := io.WriteString(out, w.sb.String())
+	return err
+}
+
+// sortedKeys returns the keys of counts in ascending order.
+func sortedKeys(counts map[string]uint64) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SetPromFile sets a file to write the final statistics to in the Prometheus
+// text format, for node_exporter's textfile collector. Empty disables it.
+func (r *Reporter) SetPromFile(filename string) {
+	r.promFile = filename
+}
+
+// ExportPrometheus writes the statistics to the file set with SetPromFile. The
+// file is written under a temporary name and renamed, so the textfile
+// collector never reads a partial file.
+func (r *Reporter) ExportPrometheus() error {
+	if r.promFile == "" {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(r.promFile), filepath.Base(r.promFile)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create Prometheus file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := writePrometheus(tmp, r.collector.Snapshot()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write Prometheus file %s: %w", r.promFile, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write Prometheus file %s: %w", r.promFile, err)
+	}
+	// CreateTemp makes the file private; node_exporter may run as another user
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to write Prometheus file %s: %w", r.promFile, err)
+	}
+	if err := os.Rename(tmp.Name(), r.promFile); err != nil {
+		return fmt.Errorf("failed to write Prometheus file %s: %w", r.promFile, err)
+	}
+
+	log.WithField("file", r.promFile).Info("Statistics exported in Prometheus format")
+	return nil
+}
@@ -10,21 +10,27 @@ import (
 	"time"
 
 	log "github.com/sirupsen/logrus"
+
+	"pfcp-generator/internal/pfcp"
 )
 
+// topCauses is the number of rejection causes shown per message type.
+const topCauses = 5
+
 // Reporter outputs statistics to console and/or file.
 type Reporter struct {
-	collector  *Collector
+	collector   *Collector
 	intervalSec int
-	exportFile string
+	exportFile  string
+	promFile    string
 }
 
 // NewReporter creates a new statistics reporter.
 func NewReporter(collector *Collector, intervalSec int, exportFile string) *Reporter {
 	return &Reporter{
-		collector:  collector,
+		collector:   collector,
 		intervalSec: intervalSec,
-		exportFile: exportFile,
+		exportFile:  exportFile,
 	}
 }
 
@@ -62,13 +68,11 @@ func (r *Reporter) ExportJSON() error {
 	}
 
 	snap := r.collector.Snapshot()
-	min, avg, max, p99 := snap.ResponseTimeStats()
 
 	export := map[string]interface{}{
 		"start_time":   snap.StartTime.Format(time.RFC3339),
 		"end_time":     snap.EndTime.Format(time.RFC3339),
 		"duration_sec": snap.Duration().Seconds(),
-		"messages":     map[string]interface{}{},
 		"sessions": map[string]interface{}{
 			"established": snap.SessionsEstablished,
 			"modified":    snap.SessionsModified,
@@ -76,32 +80,51 @@ func (r *Reporter) ExportJSON() error {
 			"failed":      snap.SessionsFailed,
 			"active":      snap.ActiveSessions,
 		},
-		"response_times_ms": map[string]interface{}{
-			"min": float64(min) / float64(time.Millisecond),
-			"avg": float64(avg) / float64(time.Millisecond),
-			"max": float64(max) / float64(time.Millisecond),
-			"p99": float64(p99) / float64(time.Millisecond),
-		},
+		"upf_restarts":         snap.UPFRestarts,
+		"soak_cycles":          snap.SoakCycles,
+		"repeat_iterations":    snap.RepeatIterations,
+		"receive_drops":        snap.ReceiveDrops,
+		"unexpected_responses": snap.UnexpectedResponses,
+		"orphaned_requests":    snap.OrphanedRequests,
+		"orphaned_seids":       sortedSEIDs(snap.OrphanedSEIDs),
+		"response_times_ms":    durationsMs(snap.ResponseTimeStats()),
 	}
 
-	totalSent := snap.TotalSent()
-	duration := snap.Duration().Seconds()
-	if duration > 0 {
-		export["throughput_msg_per_sec"] = float64(totalSent) / duration
+	if snap.lifetimes.count > 0 {
+		export["session_lifetimes_ms"] = durationsMs(snap.SessionLifetimeStats())
 	}
 
-	msgs := export["messages"].(map[string]interface{})
-	for name, s := range snap.MessageStats {
-		msgs[name] = map[string]interface{}{
-			"sent":       s.Sent,
-			"received":   s.Received,
-			"success":    s.Success,
-			"failed":     s.Failed,
-			"timeout":    s.Timeout,
-			"retransmit": s.Retransmit,
+	if sent, steady := snap.SteadyState(); steady > 0 {
+		export["throughput_msg_per_sec"] = float64(sent) / steady.Seconds()
+	}
+
+	export["messages"] = messagesJSON(snap.MessageStats)
+	if !snap.WarmupStart.IsZero() {
+		end := snap.WarmupEnd
+		if end.IsZero() {
+			end = snap.StartTime.Add(snap.Duration())
+		}
+		export["warmup"] = map[string]interface{}{
+			"duration_sec":      end.Sub(snap.WarmupStart).Seconds(),
+			"messages":          messagesJSON(snap.WarmupStats),
+			"response_times_ms": durationsMs(snap.WarmupResponseTimeStats()),
 		}
 	}
 
+	causes := map[string]interface{}{}
+	for name := range snap.Causes {
+		var list []map[string]interface{}
+		for _, cc := range snap.TopCauses(name, 0) {
+			list = append(list, map[string]interface{}{
+				"cause": cc.Cause,
+				"name":  pfcp.CauseDescription(cc.Cause),
+				"count": cc.Count,
+			})
+		}
+		causes[name] = list
+	}
+	export["rejection_causes"] = causes
+
 	data, err := json.MarshalIndent(export, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal stats JSON: %w", err)
@@ -132,29 +155,199 @@ func (r *Reporter) FormatReport() string {
 	}
 	sort.Strings(typeNames)
 
-	for _, name := range typeNames {
-		s := snap.MessageStats[name]
-		sb.WriteString(fmt.Sprintf("  %-30s sent=%-5d recv=%-5d success=%-5d fail=%-5d timeout=%-5d\n",
-			name+":", s.Sent, s.Received, s.Success, s.Failed, s.Timeout))
+	writeMessageStats(&sb, snap.MessageStats, typeNames)
+
+	if len(snap.Causes) > 0 {
+		causeTypes := make([]string, 0, len(snap.Causes))
+		for name := range snap.Causes {
+			causeTypes = append(causeTypes, name)
+		}
+		sort.Strings(causeTypes)
+
+		sb.WriteString("Rejection Causes:\n")
+		for _, name := range causeTypes {
+			sb.WriteString(fmt.Sprintf("  %s\n", name+":"))
+			for _, cc := range snap.TopCauses(name, topCauses) {
+				sb.WriteString(fmt.Sprintf("    %-5d %s (%d)\n", cc.Count, pfcp.CauseDescription(cc.Cause), cc.Cause))
+			}
+		}
 	}
 
 	sb.WriteString("Sessions:\n")
 	sb.WriteString(fmt.Sprintf("  Established: %d  |  Active: %d  |  Deleted: %d  |  Failed: %d\n",
 		snap.SessionsEstablished, snap.ActiveSessions, snap.SessionsDeleted, snap.SessionsFailed))
+	if snap.UPFRestarts > 0 {
+		sb.WriteString(fmt.Sprintf("  UPF restarts detected: %d\n", snap.UPFRestarts))
+	}
+	if snap.SoakCycles > 0 {
+		sb.WriteString(fmt.Sprintf("  Soak cycles completed: %d\n", snap.SoakCycles))
+	}
+	if snap.RepeatIterations > 0 {
+		sb.WriteString(fmt.Sprintf("  Repeat iterations completed: %d\n", snap.RepeatIterations))
+	}
 
 	if len(snap.ResponseTimes) > 0 {
 		sb.WriteString("Response Times:\n")
 		sb.WriteString(fmt.Sprintf("  Min: %s  |  Avg: %s  |  Max: %s  |  P99: %s\n",
 			min.Round(time.Microsecond), avg.Round(time.Microsecond),
 			max.Round(time.Microsecond), p99.Round(time.Microsecond)))
+		for _, name := range typeNames {
+			s := snap.MessageStats[name]
+			if s.Success == 0 {
+				continue
+			}
+			tmin, tavg, tmax, tp99 := s.ResponseTimeStats()
+			sb.WriteString(fmt.Sprintf("  %-30s min=%-9s avg=%-9s max=%-9s p99=%s\n", name+":",
+				tmin.Round(time.Microsecond), tavg.Round(time.Microsecond),
+				tmax.Round(time.Microsecond), tp99.Round(time.Microsecond)))
+		}
+	}
+
+	if snap.lifetimes.count > 0 {
+		lmin, lavg, lmax, lp99 := snap.SessionLifetimeStats()
+		sb.WriteString("Session Lifetimes:\n")
+		sb.WriteString(fmt.Sprintf("  Min: %s  |  Avg: %s  |  Max: %s  |  P99: %s\n",
+			lmin.Round(time.Millisecond), lavg.Round(time.Millisecond),
+			lmax.Round(time.Millisecond), lp99.Round(time.Millisecond)))
+	}
+
+	if snap.inFlightSource != nil {
+		sb.WriteString(fmt.Sprintf("In-flight Transactions: %d\n", snap.InFlight))
+	}
+	if snap.ReceiveDrops > 0 {
+		sb.WriteString(fmt.Sprintf("Dropped Responses (receive buffer full): %d\n", snap.ReceiveDrops))
+	}
+	if len(snap.UnexpectedResponses) > 0 {
+		sb.WriteString("Unexpected Responses (no matching request):\n")
+		var msgTypes []string
+		for msgType := range snap.UnexpectedResponses {
+			msgTypes = append(msgTypes, msgType)
+		}
+		sort.Strings(msgTypes)
+		for _, msgType := range msgTypes {
+			sb.WriteString(fmt.Sprintf("  %-30s %d\n", msgType, snap.UnexpectedResponses[msgType]))
+		}
+	}
+
+	if len(snap.OrphanedRequests) > 0 {
+		sb.WriteString("Orphaned Requests (session not established, check the capture):\n")
+		var msgTypes []string
+		for msgType := range snap.OrphanedRequests {
+			msgTypes = append(msgTypes, msgType)
+		}
+		sort.Strings(msgTypes)
+		for _, msgType := range msgTypes {
+			sb.WriteString(fmt.Sprintf("  %-30s %d\n", msgType, snap.OrphanedRequests[msgType]))
+		}
+		seids := sortedSEIDs(snap.OrphanedSEIDs)
+		listed := seids
+		if len(listed) > maxListedSEIDs {
+			listed = listed[:maxListedSEIDs]
+		}
+		names := make([]string, len(listed))
+		for i, seid := range listed {
+			names[i] = fmt.Sprintf("0x%x", seid)
+		}
+		sb.WriteString(fmt.Sprintf("  Unmatched SEIDs: %s", strings.Join(names, " ")))
+		if more := len(seids) - len(listed); more > 0 {
+			sb.WriteString(fmt.Sprintf(" (and %d more)", more))
+		}
+		sb.WriteString("\n")
+	}
+
+	if !snap.WarmupStart.IsZero() {
+		duration := "in progress"
+		if !snap.WarmupEnd.IsZero() {
+			duration = snap.WarmupEnd.Sub(snap.WarmupStart).Round(time.Second).String()
+		}
+		sb.WriteString(fmt.Sprintf("Warm-up (%s, not included above):\n", duration))
+		warmupTypes := make([]string, 0, len(snap.WarmupStats))
+		for name := range snap.WarmupStats {
+			warmupTypes = append(warmupTypes, name)
+		}
+		sort.Strings(warmupTypes)
+		writeMessageStats(&sb, snap.WarmupStats, warmupTypes)
+		if wmin, wavg, wmax, wp99 := snap.WarmupResponseTimeStats(); wmax > 0 {
+			sb.WriteString(fmt.Sprintf("  Response Times: Min: %s  |  Avg: %s  |  Max: %s  |  P99: %s\n",
+				wmin.Round(time.Microsecond), wavg.Round(time.Microsecond),
+				wmax.Round(time.Microsecond), wp99.Round(time.Microsecond)))
+		}
 	}
 
-	totalSent := snap.TotalSent()
 	if elapsed.Seconds() > 0 {
 		sb.WriteString("Throughput:\n")
-		sb.WriteString(fmt.Sprintf("  %.1f msg/s\n", float64(totalSent)/elapsed.Seconds()))
+		average := "warming up"
+		if sent, steady := snap.SteadyState(); steady > 0 {
+			average = fmt.Sprintf("%.1f msg/s", float64(sent)/steady.Seconds())
+			if !snap.WarmupEnd.IsZero() {
+				average += " after warm-up"
+			}
+		}
+		// Recent rates only mean something while the replay is running
+		if snap.EndTime.IsZero() {
+			sb.WriteString(fmt.Sprintf("  %.1f msg/s (last 1s)  |  %.1f msg/s (last 10s)  |  %s (average)\n",
+				snap.SendRate1s, snap.SendRate10s, average))
+		} else {
+			sb.WriteString(fmt.Sprintf("  %s\n", average))
+		}
 	}
 
 	sb.WriteString("================================================\n")
 	return sb.String()
 }
+
+// writeMessageStats writes a line of counts per message type, in the order of
+// typeNames.
+func writeMessageStats(sb *strings.Builder, stats map[string]*MessageTypeStats, typeNames []string) {
+	for _, name := range typeNames {
+		s := stats[name]
+		sb.WriteString(fmt.Sprintf("  %-30s sent=%-5d recv=%-5d success=%-5d fail=%-5d timeout=%-5d retx=%-5d\n",
+			name+":", s.Sent, s.Received, s.Success, s.Failed, s.Timeout, s.Retransmit))
+	}
+}
+
+// messagesJSON returns the per message type counts and response times as
+// exported to JSON.
+func messagesJSON(stats map[string]*MessageTypeStats) map[string]interface{} {
+	msgs := map[string]interface{}{}
+	for name, s := range stats {
+		entry := map[string]interface{}{
+			"sent":       s.Sent,
+			"received":   s.Received,
+			"success":    s.Success,
+			"failed":     s.Failed,
+			"timeout":    s.Timeout,
+			"retransmit": s.Retransmit,
+		}
+		if s.Success > 0 {
+			entry["response_times_ms"] = durationsMs(s.ResponseTimeStats())
+		}
+		msgs[name] = entry
+	}
+	return msgs
+}
+
+// durationsMs returns min, avg, max and p99 durations in milliseconds, as
+// exported to JSON.
+func durationsMs(min, avg, max, p99 time.Duration) map[string]interface{} {
+	return map[string]interface{}{
+		"min": float64(min) / float64(time.Millisecond),
+		"avg": float64(avg) / float64(time.Millisecond),
+		"max": float64(max) / float64(time.Millisecond),
+		"p99": float64(p99) / float64(time.Millisecond),
+	}
+}
+
+// maxListedSEIDs is the number of unmatched SEIDs listed in the console report;
+// the JSON export lists them all.
+const maxListedSEIDs = 10
+
+// sortedSEIDs returns the keys of seids in ascending order.
+func sortedSEIDs(seids map[uint64]uint64) []uint64 {
+	sorted := make([]uint64, 0, len(seids))
+	for seid := range seids {
+		sorted = append(sorted, seid)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
@@ -0,0 +1,91 @@
+package stats
+
+import (
+	"math/rand"
+	"time"
+)
+
+// histogramBounds are the upper bounds of the response time histogram
+// buckets, from sub-millisecond answers of an idle UPF to retransmission
+// territory.
+var histogramBounds = [...]time.Duration{
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// responseTimes tracks the response times of successful transactions: the
+// count, sum, min and max exactly, a histogram, and a uniform sample of
+// bounded size for percentiles. It is not safe for concurrent use.
+type responseTimes struct {
+	sample []time.Duration
+	count  uint64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+
+	// Response times up to each of histogramBounds and above the last one
+	buckets [len(histogramBounds) + 1]uint64
+}
+
+// record adds a response time, keeping at most limit samples; rng picks the
+// sample to replace once the limit is reached.
+func (r *responseTimes) record(d time.Duration, limit int, rng *rand.Rand) {
+	r.count++
+	r.sum += d
+	if r.count == 1 || d < r.min {
+		r.min = d
+	}
+	if d > r.max {
+		r.max = d
+	}
+	i := 0
+	for i < len(histogramBounds) && d > histogramBounds[i] {
+		i++
+	}
+	r.buckets[i]++
+
+	// Reservoir sampling: the n-th response time replaces a random sample
+	// with probability limit/n, so the sample stays uniform
+	if len(r.sample) < limit {
+		r.sample = append(r.sample, d)
+		return
+	}
+	if i := rng.Int63n(int64(r.count)); i < int64(limit) {
+		r.sample[i] = d
+	}
+}
+
+// truncate drops samples beyond limit.
+func (r *responseTimes) truncate(limit int) {
+	if len(r.sample) > limit {
+		r.sample = r.sample[:limit]
+	}
+}
+
+// clone returns a deep copy of r.
+func (r *responseTimes) clone() responseTimes {
+	c := *r
+	c.sample = append([]time.Duration(nil), r.sample...)
+	return c
+}
+
+// stats returns min, avg, max and p99. Min, avg and max are exact; p99 is
+// estimated from the sample, which is sorted in place.
+func (r *responseTimes) stats() (min, avg, max, p99 time.Duration) {
+	if r.count == 0 {
+		return 0, 0, 0, 0
+	}
+	_, _, _, p99 = durationStats(r.sample)
+	return r.min, r.sum / time.Duration(r.count), r.max, p99
+}
@@ -0,0 +1,64 @@
+package stats
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// rateBucketWidth is the time covered by one bucket of sendRate.
+	rateBucketWidth = 100 * time.Millisecond
+
+	// rateBuckets is the number of buckets in sendRate, covering the longest
+	// window reported.
+	rateBuckets = int(10 * time.Second / rateBucketWidth)
+)
+
+// sendRate counts sends in a ring of fixed-width time buckets, so the send rate
+// over the last few seconds can be reported next to the average of the whole
+// run. Buckets are updated atomically, like the message counters; a send
+// racing with the reuse of its bucket for a new time slot may go uncounted,
+// which does not noticeably change a rate high enough for that to happen.
+type sendRate struct {
+	buckets [rateBuckets]rateBucket
+}
+
+// rateBucket counts the sends of one time slot, numbered from the Unix epoch
+// in units of rateBucketWidth.
+type rateBucket struct {
+	slot  atomic.Int64
+	count atomic.Uint64
+}
+
+// record counts a send at now.
+func (r *sendRate) record(now time.Time) {
+	slot := now.UnixNano() / int64(rateBucketWidth)
+	b := &r.buckets[slot%int64(rateBuckets)]
+	if old := b.slot.Load(); old != slot && b.slot.CompareAndSwap(old, slot) {
+		b.count.Store(0)
+	}
+	b.count.Add(1)
+}
+
+// rate returns the sends per second over the window ending at the start of the
+// time slot now falls in, so only complete slots are counted. The window is
+// shortened to the time since start if that is less. window must not exceed
+// the time covered by the ring.
+func (r *sendRate) rate(now, start time.Time, window time.Duration) float64 {
+	cur := now.UnixNano() / int64(rateBucketWidth)
+	end := time.Unix(0, cur*int64(rateBucketWidth))
+	span := min(window, end.Sub(start))
+	if span <= 0 {
+		return 0
+	}
+
+	first := cur - int64(window/rateBucketWidth)
+	var total uint64
+	for i := range r.buckets {
+		b := &r.buckets[i]
+		if slot := b.slot.Load(); slot >= first && slot < cur {
+			total += b.count.Load()
+		}
+	}
+	return float64(total) / span.Seconds()
+}
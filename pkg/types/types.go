@@ -13,17 +13,21 @@ type RawPFCPMessage struct {
 	DstIP     net.IP
 	SrcPort   uint16
 	DstPort   uint16
+	VLANIDs   []uint16 // 802.1Q VLAN IDs, outermost first (nil if untagged)
 }
 
 // SessionInfo holds the state of a single PFCP session.
 type SessionInfo struct {
-	OriginalCPSEID     uint64    // CP SEID from pcap (F-SEID IE in Establishment Request)
-	OriginalRemoteSEID uint64    // Remote SEID from pcap (header SEID in Modification/Deletion)
-	LocalSEID          uint64    // Newly allocated CP SEID
-	RemoteSEID         uint64    // UP SEID from UPF response
-	UEIP               net.IP    // Allocated UE IP
-	State              string    // "establishing", "established", "modifying", "deleting", "deleted"
+	OriginalCPSEID     uint64                      // CP SEID from pcap (F-SEID IE in Establishment Request)
+	OriginalRemoteSEID uint64                      // Remote SEID from pcap (header SEID in Modification/Deletion)
+	LocalSEID          uint64                      // Newly allocated CP SEID
+	RemoteSEID         uint64                      // UP SEID from UPF response
+	UEIP               net.IP                      // Allocated UE IP
+	TEIDs              map[uint32]uint32           // Original TEID from pcap → allocated TEID
+	UEMACs             map[string]net.HardwareAddr // Original UE MAC from pcap → allocated MAC (Ethernet PDU sessions)
+	State              string                      // "establishing", "established", "modifying", "deleting", "deleted"
 	CreatedAt          time.Time
+	DeletedAt          time.Time // Set when the UPF accepts the session's deletion
 }
 
 // TransactionResult holds the outcome of a PFCP transaction.
@@ -43,10 +47,10 @@ type SEIDMapping struct {
 
 // MessageStats holds per-message-type statistics.
 type MessageStats struct {
-	Sent         uint64
-	Received     uint64
-	Success      uint64
-	Failed       uint64
-	Timeout      uint64
-	Retransmit   uint64
+	Sent       uint64
+	Received   uint64
+	Success    uint64
+	Failed     uint64
+	Timeout    uint64
+	Retransmit uint64
 }
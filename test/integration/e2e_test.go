@@ -0,0 +1,340 @@
+//go:build integration
+
+// Package integration runs the generator against the mock UPF end to end:
+// pcap parsing, message modification, the network client and receiver, the
+// transaction tracker and the session manager, as one unit.
+package integration
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"pfcp-generator/internal/config"
+	"pfcp-generator/internal/network"
+	"pfcp-generator/internal/pcap"
+	"pfcp-generator/internal/session"
+	"pfcp-generator/internal/stats"
+)
+
+// moduleRoot is the repository root, relative to this package.
+const moduleRoot = "../.."
+
+// mockStats are the counters the mock UPF logs on shutdown.
+type mockStats struct {
+	received, sent, errors, rejected, dropped, malformed, activeSessions int
+}
+
+// mockUPF is a mock UPF process listening on an ephemeral port.
+type mockUPF struct {
+	cmd  *exec.Cmd
+	addr string
+
+	mu    sync.Mutex
+	lines []string
+	done  chan struct{}
+}
+
+// startMockUPF builds the mock UPF and starts it on 127.0.0.1 with an
+// ephemeral port, returning once it is listening.
+func startMockUPF(t *testing.T, args ...string) *mockUPF {
+	t.Helper()
+	bin := filepath.Join(t.TempDir(), "mockupf")
+	goCmd(t, "build", "-o", bin, "./test/mockupf/")
+
+	cmd := exec.Command(bin, append([]string{"--addr", "127.0.0.1:0"}, args...)...)
+	stderr, err := cmd.StderrPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+
+	m := &mockUPF{cmd: cmd, done: make(chan struct{})}
+	listening := make(chan string, 1)
+	go m.readLog(stderr, listening)
+	t.Cleanup(func() {
+		if m.cmd.ProcessState == nil {
+			_ = m.cmd.Process.Kill()
+			_ = m.cmd.Wait()
+		}
+	})
+
+	select {
+	case m.addr = <-listening:
+	case <-m.done:
+		t.Fatalf("mock UPF exited before listening:\n%s", m.log())
+	case <-time.After(10 * time.Second):
+		t.Fatalf("mock UPF did not start listening:\n%s", m.log())
+	}
+	return m
+}
+
+// readLog collects the mock's log lines, sending its listen address on
+// listening once it is logged.
+func (m *mockUPF) readLog(r io.Reader, listening chan<- string) {
+	defer close(m.done)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		m.mu.Lock()
+		m.lines = append(m.lines, line)
+		m.mu.Unlock()
+		if _, addr, ok := strings.Cut(line, "Mock UPF listening on "); ok {
+			listening <- addr
+		}
+	}
+}
+
+func (m *mockUPF) log() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return strings.Join(m.lines, "\n")
+}
+
+// stop shuts the mock down with SIGINT and returns the stats it logs.
+func (m *mockUPF) stop(t *testing.T) mockStats {
+	t.Helper()
+	require.NoError(t, m.cmd.Process.Signal(syscall.SIGINT))
+	select {
+	case <-m.done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("mock UPF did not shut down:\n%s", m.log())
+	}
+	require.NoError(t, m.cmd.Wait())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, line := range m.lines {
+		_, fields, ok := strings.Cut(line, "Stats: ")
+		if !ok {
+			continue
+		}
+		s, err := parseMockStats(fields)
+		require.NoError(t, err, "parse mock stats %q", line)
+		return s
+	}
+	t.Fatalf("mock UPF logged no stats:\n%s", strings.Join(m.lines, "\n"))
+	return mockStats{}
+}
+
+// parseMockStats parses the key=value counters of the mock's stats line by
+// name, so that counters added to the line do not break the parse.
+func parseMockStats(fields string) (mockStats, error) {
+	var s mockStats
+	counters := map[string]*int{
+		"received":       &s.received,
+		"sent":           &s.sent,
+		"errors":         &s.errors,
+		"rejected":       &s.rejected,
+		"dropped":        &s.dropped,
+		"malformed":      &s.malformed,
+		"activeSessions": &s.activeSessions,
+	}
+	found := 0
+	for _, field := range strings.Fields(fields) {
+		key, value, ok := strings.Cut(field, "=")
+		counter, known := counters[key]
+		if !ok || !known {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return s, fmt.Errorf("%s: %w", key, err)
+		}
+		*counter = n
+		found++
+	}
+	if found != len(counters) {
+		return s, fmt.Errorf("found %d of %d counters", found, len(counters))
+	}
+	return s, nil
+}
+
+// goCmd runs the go tool in the repository root.
+func goCmd(t *testing.T, args ...string) {
+	t.Helper()
+	cmd := exec.Command("go", args...)
+	cmd.Dir = moduleRoot
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, "go %s:\n%s", strings.Join(args, " "), out)
+}
+
+// generateSamplePcap writes the sample pcap of test/testdata to a temporary
+// file: an association, 3 establishments, a modification and a deletion of
+// the first session, and a heartbeat, each with its response.
+func generateSamplePcap(t *testing.T) string {
+	t.Helper()
+	path, err := filepath.Abs(filepath.Join(t.TempDir(), "sample.pcap"))
+	require.NoError(t, err)
+	goCmd(t, "run", "test/testdata/generate_pcap.go", path)
+	return path
+}
+
+// testConfig returns the default configuration, replaying pcapFile to the
+// UPF at upfAddr from an ephemeral local port.
+func testConfig(t *testing.T, pcapFile, upfAddr string) *config.Config {
+	t.Helper()
+	cfg, err := config.Load("")
+	require.NoError(t, err)
+
+	host, port, ok := strings.Cut(upfAddr, ":")
+	require.True(t, ok, "mock address %q", upfAddr)
+	cfg.SMF.Address = "127.0.0.1"
+	cfg.SMF.Port = 0
+	cfg.UPF.Address = host
+	_, err = fmt.Sscan(port, &cfg.UPF.Port)
+	require.NoError(t, err)
+	cfg.Input.PcapFile = pcapFile
+	cfg.Session.UEIPPool = "10.60.0.0/24"
+	cfg.Timing.MessageIntervalMs = 10
+	cfg.Timing.ResponseTimeoutMs = 1000
+	cfg.Stats.Enabled = false
+	require.NoError(t, cfg.Validate())
+	return cfg
+}
+
+// replay runs the generator in-process against the UPF in cfg, the way the
+// CLI does, and returns its statistics.
+func replay(t *testing.T, cfg *config.Config) *stats.Collector {
+	t.Helper()
+	got, err := tryReplay(t, cfg)
+	require.NoError(t, err)
+	return got
+}
+
+// tryReplay is replay for runs that may fail, returning the replay's error.
+func tryReplay(t *testing.T, cfg *config.Config) (*stats.Collector, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	pcapFiles, err := cfg.Input.Files()
+	require.NoError(t, err)
+	parser := pcap.NewParser()
+	parser.SetPorts(cfg.Input.Ports())
+	parseResult, err := parser.ParseWithMappings(ctx, pcapFiles...)
+	require.NoError(t, err)
+
+	client, err := network.NewTransport(cfg.Network.Transport, cfg.SMF.BindAddress(), cfg.SMF.Port, cfg.UPF.Address, cfg.UPF.Port)
+	require.NoError(t, err)
+	defer client.Close()
+
+	receiver := network.NewReceiver(client.Conn(), cfg.Network.ReceiveBuffer)
+	tracker := network.NewTransactionTracker(client, cfg.Timing.ResponseTimeoutMs, cfg.Timing.MaxRetries)
+	tracker.StartTimeoutMonitor(ctx)
+
+	collector := stats.NewCollector()
+	receiver.SetDropHandler(collector.RecordReceiveDrop)
+	receiver.Start(ctx)
+
+	mgr, err := session.NewManager(cfg, client, receiver, tracker, collector, nil, nil)
+	require.NoError(t, err)
+	mgr.SetSEIDMappings(parseResult.SEIDMappings)
+	var replayErr error
+	if cfg.Soak.Enabled {
+		replayErr = mgr.Soak(ctx, parseResult.Messages)
+	} else {
+		replayErr = mgr.Replay(ctx, parseResult.Messages)
+	}
+	collector.Finish()
+	return collector.Snapshot(), replayErr
+}
+
+func TestEndToEnd_SamplePcap(t *testing.T) {
+	pcapFile := generateSamplePcap(t)
+	upf := startMockUPF(t)
+	got := replay(t, testConfig(t, pcapFile, upf.addr))
+	mock := upf.stop(t)
+
+	// Generator: every request is answered and every session operation succeeds
+	assert.Equal(t, uint64(7), got.TotalSent())
+	assert.Equal(t, uint64(7), got.TotalReceived())
+	assert.Zero(t, got.Failures())
+	assert.Equal(t, uint64(3), got.SessionsEstablished)
+	assert.Equal(t, uint64(1), got.SessionsModified)
+	assert.Equal(t, uint64(1), got.SessionsDeleted)
+	assert.Zero(t, got.SessionsFailed)
+	assert.Equal(t, uint64(2), got.ActiveSessions)
+	for msgType, s := range got.MessageStats {
+		assert.Zero(t, s.Retransmit, "%s retransmissions", msgType)
+		assert.Zero(t, s.Timeout, "%s timeouts", msgType)
+	}
+	assert.Empty(t, got.UnexpectedResponses)
+	assert.Empty(t, got.OrphanedRequests)
+
+	// Mock UPF: it saw the same traffic and holds the same sessions
+	assert.Equal(t, int(got.TotalSent()), mock.received)
+	assert.Equal(t, int(got.TotalReceived()), mock.sent)
+	assert.Zero(t, mock.errors)
+	assert.Equal(t, int(got.ActiveSessions), mock.activeSessions)
+}
+
+func TestEndToEnd_RejectedEstablishment(t *testing.T) {
+	pcapFile := generateSamplePcap(t)
+	upf := startMockUPF(t, "--reject-sessions", "2")
+	got := replay(t, testConfig(t, pcapFile, upf.addr))
+	mock := upf.stop(t)
+
+	assert.Equal(t, uint64(7), got.TotalSent())
+	assert.Equal(t, uint64(7), got.TotalReceived())
+	assert.Equal(t, uint64(2), got.SessionsEstablished)
+	assert.Equal(t, uint64(1), got.SessionsFailed)
+	assert.Equal(t, uint64(1), got.SessionsModified)
+	assert.Equal(t, uint64(1), got.SessionsDeleted)
+	assert.Equal(t, uint64(1), got.ActiveSessions)
+
+	assert.Equal(t, 1, mock.rejected)
+	assert.Equal(t, int(got.ActiveSessions), mock.activeSessions)
+	assert.Equal(t, int(got.TotalSent()), mock.received)
+}
+
+func TestEndToEnd_SoakWithRejections(t *testing.T) {
+	pcapFile := generateSamplePcap(t)
+	upf := startMockUPF(t, "--reject-rate", "0.5")
+	cfg := testConfig(t, pcapFile, upf.addr)
+	cfg.Timing.MessageIntervalMs = 0
+	cfg.Session.UEIPPool = "10.60.0.0/28"
+	cfg.Soak = config.SoakConfig{Enabled: true, BatchSize: 10, Iterations: 6}
+	got := replay(t, cfg)
+	mock := upf.stop(t)
+
+	// Rejected establishments give back their UE IPs: every one of the 60 is
+	// sent although more are rejected than the pool has addresses
+	assert.Equal(t, uint64(60), got.MessageStats["SessionEstablishmentRequest"].Sent)
+	assert.Equal(t, uint64(60), got.SessionsEstablished+got.SessionsFailed)
+	assert.Equal(t, int(got.SessionsFailed), mock.rejected)
+	assert.Equal(t, got.SessionsEstablished, got.SessionsDeleted)
+	assert.Equal(t, uint64(6), got.SoakCycles)
+	assert.Zero(t, got.ActiveSessions)
+	assert.Zero(t, mock.activeSessions)
+}
+
+func TestEndToEnd_RejectedAssociation(t *testing.T) {
+	pcapFile := generateSamplePcap(t)
+	upf := startMockUPF(t, "--reject-association", "64")
+	cfg := testConfig(t, pcapFile, upf.addr)
+	cfg.Association.OnSetupFailure = "abort"
+	got, err := tryReplay(t, cfg)
+	mock := upf.stop(t)
+
+	// The rejection is visible and stops the replay before any session
+	require.ErrorIs(t, err, session.ErrAssociationFailed)
+	assert.Contains(t, err.Error(), "Association Setup rejected")
+	assert.Equal(t, uint64(1), got.TotalSent())
+	assert.Equal(t, uint64(1), got.MessageStats["AssociationSetupRequest"].Failed)
+	assert.Zero(t, got.SessionsEstablished)
+
+	assert.Equal(t, 1, mock.rejected)
+	assert.Equal(t, 1, mock.received)
+	assert.Zero(t, mock.activeSessions)
+}
@@ -3,16 +3,29 @@
 //
 // Usage:
 //
-//	go run test/mockupf/main.go [--addr 127.0.0.1:8805]
+//	go run test/mockupf/main.go [--addr 127.0.0.1:8805] [--reject-rate 0.2 --reject-cause 72]
+//	    [--reject-association 64]
+//	    [--latency-ms 20 --jitter-ms 5 --drop-rate 0.01] [--report-interval 10s]
+//	    [--seid-strategy random] [--malformed-rate 0.05] [--seed 1]
+//	    [--ignore-unhandled] [--echo-cause 64] [--state-file mockupf-state.json]
+//
+// Send SIGUSR1 to trigger a Session Report Request, or SIGUSR2 to simulate a
+// restart: the recovery time stamp moves forward and all sessions are lost.
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"math/rand"
 	"net"
 	"os"
 	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -24,26 +37,79 @@ import (
 type session struct {
 	cpSEID uint64
 	upSEID uint64
+	urSeqN uint32 // Last UR-SEQN reported
 }
 
 type mockUPF struct {
-	addr       string
-	conn       *net.UDPConn
-	localIP    net.IP
-	recoveryTS time.Time
-
-	mu         sync.Mutex
-	sessions   map[uint64]*session // UP SEID → session
-	nextUPSEID uint64
+	addr    string
+	conn    *net.UDPConn
+	localIP net.IP
+
+	// Session Establishment rejection: the nth establishment (from 1) is
+	// rejected with rejectCause if it is in rejectSessions, or at random with
+	// probability rejectRate
+	rejectRate     float64
+	rejectCause    uint8
+	rejectSessions map[int]bool
+
+	// Reject every Association Setup with this Cause (0 = accept)
+	rejectAssociation uint8
+
+	// Response timing: each response is written latency ± jitter after the
+	// request, and requests are dropped unanswered with probability dropRate
+	latency  time.Duration
+	jitter   time.Duration
+	dropRate float64
+
+	// Send a Session Report Request for a random session this often (0 = never)
+	reportInterval time.Duration
+
+	// Allocate UP SEIDs at random instead of sequentially from 1
+	randomSEIDs bool
+
+	// Fraction of responses made malformed: an establishment response without
+	// its F-SEID, or a response cut short
+	malformedRate float64
+
+	// Drop requests of types the mock does not handle without counting them
+	// as errors
+	ignoreUnhandled bool
+
+	// Answer every session request with this Cause, leaving the sessions
+	// untouched (0 = handle them normally)
+	echoCause uint8
+
+	mu             sync.Mutex
+	rng            *rand.Rand
+	recoveryTS     time.Time           // Time of the last (simulated) restart
+	sessions       map[uint64]*session // UP SEID → session
+	nextUPSEID     uint64
+	establishments int
+
+	smfAddr        *net.UDPAddr         // Source of the last request, where reports are sent
+	nextSeq        uint32               // Sequence number of the next UPF-initiated request
+	pendingReports map[uint32]time.Time // Report sequence number → send time
+
+	stats mockStats
+}
 
-	stats struct {
-		received int
-		sent     int
-		errors   int
-	}
+// mockStats counts what the mock did, for the shutdown log and --state-file.
+type mockStats struct {
+	Received  int `json:"received"`
+	Sent      int `json:"sent"`
+	Errors    int `json:"errors"`
+	Rejected  int `json:"rejected"`
+	Dropped   int `json:"dropped"`
+	Malformed int `json:"malformed"`
+	Ignored   int `json:"ignored"`
+	Echoed    int `json:"echoed"`
+	Restarts  int `json:"restarts"`
+
+	ReportsSent     int `json:"reports_sent"`
+	ReportsAnswered int `json:"reports_answered"`
 }
 
-func newMockUPF(addr string) *mockUPF {
+func newMockUPF(addr string, seed int64) *mockUPF {
 	host, _, err := net.SplitHostPort(addr)
 	if err != nil {
 		host = "127.0.0.1"
@@ -52,12 +118,57 @@ func newMockUPF(addr string) *mockUPF {
 		addr:       addr,
 		localIP:    net.ParseIP(host),
 		recoveryTS: time.Now(),
+		rng:        rand.New(rand.NewSource(seed)),
 		sessions:   make(map[uint64]*session),
 		nextUPSEID: 1,
+
+		nextSeq:        1,
+		pendingReports: make(map[uint32]time.Time),
+	}
+}
+
+// drop decides whether to drop a request without answering it.
+func (u *mockUPF) drop() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.dropRate > 0 && u.rng.Float64() < u.dropRate {
+		u.stats.Dropped++
+		return true
+	}
+	return false
+}
+
+// responseDelay returns how long to hold a response: latency plus a uniform
+// jitter in [-jitter, +jitter], never negative.
+func (u *mockUPF) responseDelay() time.Duration {
+	delay := u.latency
+	if u.jitter > 0 {
+		u.mu.Lock()
+		delay += time.Duration(u.rng.Int63n(int64(2*u.jitter)+1)) - u.jitter
+		u.mu.Unlock()
 	}
+	return max(delay, 0)
 }
 
+// rejectEstablishment counts a Session Establishment and decides whether to
+// reject it. The caller must hold u.mu.
+func (u *mockUPF) rejectEstablishment() bool {
+	u.establishments++
+	if u.rejectSessions[u.establishments] {
+		return true
+	}
+	return u.rejectRate > 0 && u.rng.Float64() < u.rejectRate
+}
+
+// allocateUPSEID returns an unused UP SEID. The caller must hold u.mu.
 func (u *mockUPF) allocateUPSEID() uint64 {
+	if u.randomSEIDs {
+		for {
+			if seid := u.rng.Uint64(); seid != 0 && u.sessions[seid] == nil {
+				return seid
+			}
+		}
+	}
 	seid := u.nextUPSEID
 	u.nextUPSEID++
 	return seid
@@ -83,7 +194,7 @@ func (u *mockUPF) run() error {
 	}
 	defer u.conn.Close()
 
-	log.Printf("Mock UPF listening on %s", u.addr)
+	log.Printf("Mock UPF listening on %s", u.conn.LocalAddr())
 
 	buf := make([]byte, 65535)
 	for {
@@ -98,33 +209,49 @@ func (u *mockUPF) run() error {
 		}
 
 		u.mu.Lock()
-		u.stats.received++
+		u.stats.Received++
+		u.smfAddr = remoteAddr
 		u.mu.Unlock()
 
+		// Only requests are dropped, not the SMF's answers to our reports
+		if n > 1 && buf[1] != message.MsgTypeSessionReportResponse && u.drop() {
+			log.Printf("✗ dropped request (%d bytes)", n)
+			continue
+		}
+
 		resp, err := u.handleMessage(buf[:n])
 		if err != nil {
 			log.Printf("handle error: %v", err)
 			u.mu.Lock()
-			u.stats.errors++
+			u.stats.Errors++
 			u.mu.Unlock()
 			continue
 		}
 
 		if resp != nil {
-			if _, err := u.conn.WriteToUDP(resp, remoteAddr); err != nil {
-				log.Printf("write error: %v", err)
-				u.mu.Lock()
-				u.stats.errors++
-				u.mu.Unlock()
-				continue
+			if delay := u.responseDelay(); delay > 0 {
+				time.AfterFunc(delay, func() { u.send(resp, remoteAddr) })
+			} else {
+				u.send(resp, remoteAddr)
 			}
-			u.mu.Lock()
-			u.stats.sent++
-			u.mu.Unlock()
 		}
 	}
 }
 
+// send writes a response and counts it.
+func (u *mockUPF) send(resp []byte, remoteAddr *net.UDPAddr) {
+	if _, err := u.conn.WriteToUDP(resp, remoteAddr); err != nil {
+		log.Printf("write error: %v", err)
+		u.mu.Lock()
+		u.stats.Errors++
+		u.mu.Unlock()
+		return
+	}
+	u.mu.Lock()
+	u.stats.Sent++
+	u.mu.Unlock()
+}
+
 func (u *mockUPF) handleMessage(data []byte) ([]byte, error) {
 	msg, err := message.Parse(data)
 	if err != nil {
@@ -133,6 +260,15 @@ func (u *mockUPF) handleMessage(data []byte) ([]byte, error) {
 
 	var resp message.Message
 
+	if u.echoCause != 0 && isSessionRequest(msg) {
+		resp = u.handleEcho(msg)
+		b := make([]byte, resp.MarshalLen())
+		if err := resp.MarshalTo(b); err != nil {
+			return nil, fmt.Errorf("marshal response: %w", err)
+		}
+		return b, nil
+	}
+
 	switch req := msg.(type) {
 	case *message.AssociationSetupRequest:
 		resp = u.handleAssociationSetup(req)
@@ -140,6 +276,9 @@ func (u *mockUPF) handleMessage(data []byte) ([]byte, error) {
 	case *message.HeartbeatRequest:
 		resp = u.handleHeartbeat(req)
 
+	case *message.PFDManagementRequest:
+		resp = u.handlePFDManagement(req)
+
 	case *message.SessionEstablishmentRequest:
 		resp, err = u.handleSessionEstablishment(req)
 		if err != nil {
@@ -158,7 +297,17 @@ func (u *mockUPF) handleMessage(data []byte) ([]byte, error) {
 			return nil, err
 		}
 
+	case *message.SessionReportResponse:
+		u.handleSessionReportResponse(req)
+		return nil, nil
+
 	default:
+		if u.ignoreUnhandled {
+			u.mu.Lock()
+			u.stats.Ignored++
+			u.mu.Unlock()
+			return nil, nil
+		}
 		return nil, fmt.Errorf("unhandled message type: %d", msg.MessageType())
 	}
 
@@ -167,17 +316,107 @@ func (u *mockUPF) handleMessage(data []byte) ([]byte, error) {
 	if err := resp.MarshalTo(b); err != nil {
 		return nil, fmt.Errorf("marshal response: %w", err)
 	}
-	return b, nil
+	return u.malform(resp, b), nil
+}
+
+// malform returns b unchanged, or with probability malformedRate a broken
+// version of resp: a Session Establishment Response loses its F-SEID half of
+// the time, any other response is cut off halfway through its body.
+func (u *mockUPF) malform(resp message.Message, b []byte) []byte {
+	u.mu.Lock()
+	if u.malformedRate == 0 || u.rng.Float64() >= u.malformedRate {
+		u.mu.Unlock()
+		return b
+	}
+	u.stats.Malformed++
+	dropFSEID := u.rng.Intn(2) == 0
+	u.mu.Unlock()
+
+	if est, ok := resp.(*message.SessionEstablishmentResponse); ok && dropFSEID {
+		est.UPFSEID = nil
+		if nb, err := est.Marshal(); err == nil {
+			log.Printf("✗ malformed SessionEstablishmentResponse seq=%d: no F-SEID", est.Sequence())
+			return nb
+		}
+	}
+	// Keep the header, so the generator can still tell which request it answers
+	headerLen := 8
+	if b[0]&0x01 != 0 { // S flag: the header carries a SEID
+		headerLen = 16
+	}
+	cut := headerLen + (len(b)-headerLen)/2
+	log.Printf("✗ malformed response type=%d seq=%d: truncated to %d of %d bytes", resp.MessageType(), resp.Sequence(), cut, len(b))
+	return b[:cut]
+}
+
+// isSessionRequest reports whether msg is a Session Establishment, Modification
+// or Deletion Request.
+func isSessionRequest(msg message.Message) bool {
+	switch msg.MessageType() {
+	case message.MsgTypeSessionEstablishmentRequest,
+		message.MsgTypeSessionModificationRequest,
+		message.MsgTypeSessionDeletionRequest:
+		return true
+	}
+	return false
+}
+
+// handleEcho answers a session request with echoCause. No session is created,
+// changed or removed, and responses carry no F-SEID. The header SEID is the
+// CP SEID of the addressed session if it exists, or for an establishment the
+// SEID of its CP F-SEID, and 0 otherwise.
+func (u *mockUPF) handleEcho(msg message.Message) message.Message {
+	seq := msg.Sequence()
+	cause := ie.NewCause(u.echoCause)
+
+	u.mu.Lock()
+	u.stats.Echoed++
+	cpSEID, _ := u.lookupCPSEID(msg.SEID())
+	u.mu.Unlock()
+
+	log.Printf("← %s seq=%d seid=%d (echoing cause %d)", msg.MessageTypeName(), seq, msg.SEID(), u.echoCause)
+
+	switch req := msg.(type) {
+	case *message.SessionEstablishmentRequest:
+		cpSEID = 0
+		if req.CPFSEID != nil {
+			if fseid, err := req.CPFSEID.FSEID(); err == nil {
+				cpSEID = fseid.SEID
+			}
+		}
+		return message.NewSessionEstablishmentResponse(0, 0, cpSEID, seq, 0,
+			ie.NewNodeID(u.localIP.String(), "", ""), cause)
+	case *message.SessionModificationRequest:
+		return message.NewSessionModificationResponse(0, 0, cpSEID, seq, 0, cause)
+	default:
+		return message.NewSessionDeletionResponse(0, 0, cpSEID, seq, 0, cause)
+	}
 }
 
 func (u *mockUPF) handleAssociationSetup(req *message.AssociationSetupRequest) message.Message {
 	seq := req.Sequence()
-	log.Printf("← AssociationSetupRequest seq=%d", seq)
+	nodeID := ""
+	if req.NodeID != nil {
+		nodeID, _ = req.NodeID.NodeID()
+	}
+	log.Printf("← AssociationSetupRequest seq=%d node_id=%s", seq, nodeID)
+
+	if u.rejectAssociation != 0 {
+		u.mu.Lock()
+		u.stats.Rejected++
+		u.mu.Unlock()
+		log.Printf("→ AssociationSetupResponse seq=%d cause=%d (rejected)", seq, u.rejectAssociation)
+		return message.NewAssociationSetupResponse(seq,
+			ie.NewNodeID(u.localIP.String(), "", ""),
+			ie.NewCause(u.rejectAssociation),
+			ie.NewRecoveryTimeStamp(u.recoveryTimeStamp()),
+		)
+	}
 
 	resp := message.NewAssociationSetupResponse(seq,
 		ie.NewNodeID(u.localIP.String(), "", ""),
 		ie.NewCause(ie.CauseRequestAccepted),
-		ie.NewRecoveryTimeStamp(u.recoveryTS),
+		ie.NewRecoveryTimeStamp(u.recoveryTimeStamp()),
 	)
 
 	log.Printf("→ AssociationSetupResponse seq=%d cause=Accepted", seq)
@@ -189,13 +428,26 @@ func (u *mockUPF) handleHeartbeat(req *message.HeartbeatRequest) message.Message
 	log.Printf("← HeartbeatRequest seq=%d", seq)
 
 	resp := message.NewHeartbeatResponse(seq,
-		ie.NewRecoveryTimeStamp(u.recoveryTS),
+		ie.NewRecoveryTimeStamp(u.recoveryTimeStamp()),
 	)
 
 	log.Printf("→ HeartbeatResponse seq=%d", seq)
 	return resp
 }
 
+func (u *mockUPF) handlePFDManagement(req *message.PFDManagementRequest) message.Message {
+	seq := req.Sequence()
+	log.Printf("← PFDManagementRequest seq=%d applications=%d", seq, len(req.ApplicationIDsPFDs))
+
+	resp := message.NewPFDManagementResponse(seq,
+		ie.NewCause(ie.CauseRequestAccepted),
+		nil,
+	)
+
+	log.Printf("→ PFDManagementResponse seq=%d cause=Accepted", seq)
+	return resp
+}
+
 func (u *mockUPF) handleSessionEstablishment(req *message.SessionEstablishmentRequest) (message.Message, error) {
 	seq := req.Sequence()
 
@@ -210,11 +462,28 @@ func (u *mockUPF) handleSessionEstablishment(req *message.SessionEstablishmentRe
 	cpSEID := fseid.SEID
 
 	u.mu.Lock()
+	index := u.establishments + 1
+	if u.rejectEstablishment() {
+		u.stats.Rejected++
+		u.mu.Unlock()
+
+		log.Printf("← SessionEstablishmentRequest seq=%d cpSEID=%d (session %d)", seq, cpSEID, index)
+		resp := message.NewSessionEstablishmentResponse(
+			0, 0,
+			cpSEID,
+			seq,
+			0,
+			ie.NewNodeID(u.localIP.String(), "", ""),
+			ie.NewCause(u.rejectCause),
+		)
+		log.Printf("→ SessionEstablishmentResponse seq=%d cause=%d (rejected)", seq, u.rejectCause)
+		return resp, nil
+	}
 	upSEID := u.allocateUPSEID()
 	u.sessions[upSEID] = &session{cpSEID: cpSEID, upSEID: upSEID}
 	u.mu.Unlock()
 
-	log.Printf("← SessionEstablishmentRequest seq=%d cpSEID=%d", seq, cpSEID)
+	log.Printf("← SessionEstablishmentRequest seq=%d cpSEID=%d (session %d)", seq, cpSEID, index)
 
 	resp := message.NewSessionEstablishmentResponse(
 		0, 0,
@@ -285,27 +554,324 @@ func (u *mockUPF) handleSessionDeletion(req *message.SessionDeletionRequest) (me
 	return resp, nil
 }
 
+// recoveryTimeStamp returns the time the mock last (re)started.
+func (u *mockUPF) recoveryTimeStamp() time.Time {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.recoveryTS
+}
+
+// restart simulates a UPF restart: the recovery time stamp moves forward, so
+// the next Heartbeat and Association Setup Responses tell the generator, and
+// all sessions and outstanding reports are forgotten. The Recovery Time Stamp
+// IE has a resolution of one second, so the new time stamp is at least one
+// second after the old one.
+func (u *mockUPF) restart() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	lost := len(u.sessions)
+	ts := time.Now().Truncate(time.Second)
+	if next := u.recoveryTS.Truncate(time.Second).Add(time.Second); ts.Before(next) {
+		ts = next
+	}
+	u.recoveryTS = ts
+	u.sessions = make(map[uint64]*session)
+	u.pendingReports = make(map[uint32]time.Time)
+	u.stats.Restarts++
+	log.Printf("⟳ simulated restart: recovery time stamp %s, %d sessions lost", u.recoveryTS.Format(time.RFC3339), lost)
+}
+
+// restartOn calls restart whenever trigger receives, until done is closed.
+func (u *mockUPF) restartOn(trigger <-chan os.Signal, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-trigger:
+			u.restart()
+		}
+	}
+}
+
+// sendReports sends a Session Report Request every reportInterval, and one
+// whenever trigger receives, until done is closed.
+func (u *mockUPF) sendReports(trigger <-chan os.Signal, done <-chan struct{}) {
+	var tick <-chan time.Time
+	if u.reportInterval > 0 {
+		ticker := time.NewTicker(u.reportInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-tick:
+		case <-trigger:
+		case <-done:
+			return
+		}
+		if err := u.sendReport(); err != nil {
+			log.Printf("session report: %v", err)
+		}
+	}
+}
+
+// sendReport sends a usage report for a random active session to the SMF.
+func (u *mockUPF) sendReport() error {
+	u.mu.Lock()
+	if u.smfAddr == nil || len(u.sessions) == 0 {
+		u.mu.Unlock()
+		return fmt.Errorf("no active session to report on")
+	}
+	upSEIDs := make([]uint64, 0, len(u.sessions))
+	for upSEID := range u.sessions {
+		upSEIDs = append(upSEIDs, upSEID)
+	}
+	sort.Slice(upSEIDs, func(i, j int) bool { return upSEIDs[i] < upSEIDs[j] })
+	sess := u.sessions[upSEIDs[u.rng.Intn(len(upSEIDs))]]
+	sess.urSeqN++
+	seq := u.nextSeq
+	u.nextSeq++
+	u.pendingReports[seq] = time.Now()
+	u.stats.ReportsSent++
+	addr, cpSEID, urSeqN := u.smfAddr, sess.cpSEID, sess.urSeqN
+	u.mu.Unlock()
+
+	req := message.NewSessionReportRequest(0, 0, cpSEID, seq, 0,
+		ie.NewReportType(0, 0, 1, 0), // USAR
+		ie.NewUsageReportWithinSessionReportRequest(
+			ie.NewURRID(1),
+			ie.NewURSEQN(urSeqN),
+			ie.NewUsageReportTrigger(0x01), // PERIO
+			ie.NewVolumeMeasurement(0x07, 3000, 1000, 2000, 0, 0, 0),
+		),
+	)
+	b, err := req.Marshal()
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	log.Printf("→ SessionReportRequest seq=%d cpSEID=%d ur_seqn=%d", seq, cpSEID, urSeqN)
+	u.send(b, addr)
+	return nil
+}
+
+func (u *mockUPF) handleSessionReportResponse(resp *message.SessionReportResponse) {
+	seq := resp.Sequence()
+	cause := uint8(0)
+	if resp.Cause != nil {
+		cause, _ = resp.Cause.Cause()
+	}
+
+	u.mu.Lock()
+	sentAt, ok := u.pendingReports[seq]
+	if ok {
+		delete(u.pendingReports, seq)
+		u.stats.ReportsAnswered++
+	}
+	u.mu.Unlock()
+
+	if !ok {
+		log.Printf("← SessionReportResponse seq=%d cause=%d (no pending report)", seq, cause)
+		return
+	}
+	log.Printf("← SessionReportResponse seq=%d cause=%d rtt=%s", seq, cause, time.Since(sentAt).Round(time.Microsecond))
+}
+
 func (u *mockUPF) printStats() {
 	u.mu.Lock()
 	defer u.mu.Unlock()
-	log.Printf("Stats: received=%d sent=%d errors=%d activeSessions=%d",
-		u.stats.received, u.stats.sent, u.stats.errors, len(u.sessions))
+	log.Printf("Stats: received=%d sent=%d errors=%d rejected=%d dropped=%d malformed=%d ignored=%d echoed=%d activeSessions=%d reports=%d/%d answered restarts=%d",
+		u.stats.Received, u.stats.Sent, u.stats.Errors, u.stats.Rejected, u.stats.Dropped, u.stats.Malformed,
+		u.stats.Ignored, u.stats.Echoed, len(u.sessions), u.stats.ReportsAnswered, u.stats.ReportsSent, u.stats.Restarts)
+}
+
+// state is what --state-file keeps across restarts of the mock: the session
+// table, and the recovery time stamp, so a restart is not reported to the
+// generator as a UPF restart.
+type state struct {
+	RecoveryTimeStamp time.Time      `json:"recovery_time_stamp"`
+	NextUPSEID        uint64         `json:"next_up_seid"`
+	Establishments    int            `json:"establishments"`
+	Sessions          []savedSession `json:"sessions"`
+	Stats             mockStats      `json:"stats"`
+}
+
+type savedSession struct {
+	CPSEID uint64 `json:"cp_seid"`
+	UPSEID uint64 `json:"up_seid"`
+	URSeqN uint32 `json:"ur_seqn"`
+}
+
+// saveState writes the session table and stats to filename. The file is
+// replaced only once completely written.
+func (u *mockUPF) saveState(filename string) error {
+	u.mu.Lock()
+	st := state{
+		RecoveryTimeStamp: u.recoveryTS,
+		NextUPSEID:        u.nextUPSEID,
+		Establishments:    u.establishments,
+		Sessions:          make([]savedSession, 0, len(u.sessions)),
+		Stats:             u.stats,
+	}
+	for _, sess := range u.sessions {
+		st.Sessions = append(st.Sessions, savedSession{CPSEID: sess.cpSEID, UPSEID: sess.upSEID, URSeqN: sess.urSeqN})
+	}
+	u.mu.Unlock()
+	sort.Slice(st.Sessions, func(i, j int) bool { return st.Sessions[i].UPSEID < st.Sessions[j].UPSEID })
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal state: %w", err)
+	}
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	return nil
+}
+
+// loadState restores the session table and stats saved by saveState. A
+// missing file is not an error: the mock starts empty and creates it on
+// shutdown.
+func (u *mockUPF) loadState(filename string) error {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read state: %w", err)
+	}
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("parse state %s: %w", filename, err)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !st.RecoveryTimeStamp.IsZero() {
+		u.recoveryTS = st.RecoveryTimeStamp
+	}
+	u.nextUPSEID = max(st.NextUPSEID, 1)
+	u.establishments = st.Establishments
+	u.stats = st.Stats
+	for _, sess := range st.Sessions {
+		u.sessions[sess.UPSEID] = &session{cpSEID: sess.CPSEID, upSEID: sess.UPSEID, urSeqN: sess.URSeqN}
+	}
+	return nil
+}
+
+// parseSessionIndexes parses a comma-separated list of session indexes.
+func parseSessionIndexes(s string) (map[int]bool, error) {
+	indexes := make(map[int]bool)
+	if s == "" {
+		return indexes, nil
+	}
+	for _, field := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid session index %q", field)
+		}
+		indexes[n] = true
+	}
+	return indexes, nil
 }
 
 func main() {
 	addr := flag.String("addr", "127.0.0.1:8805", "UDP address to listen on")
+	rejectRate := flag.Float64("reject-rate", 0, "Fraction of Session Establishments to reject at random (0-1)")
+	rejectCause := flag.Int("reject-cause", int(ie.CauseRequestRejected), "Cause value of rejected Session Establishments")
+	rejectSessions := flag.String("reject-sessions", "", "Also reject these Session Establishments, counted from 1 (e.g. \"2,5\")")
+	rejectAssociation := flag.Int("reject-association", 0, "Reject every Association Setup with this Cause value (0 = accept)")
+	latencyMs := flag.Int("latency-ms", 0, "Hold each response for this long before writing it")
+	jitterMs := flag.Int("jitter-ms", 0, "Vary the response delay by up to this much either way")
+	dropRate := flag.Float64("drop-rate", 0, "Fraction of requests to drop without a response (0-1)")
+	reportInterval := flag.Duration("report-interval", 0, "Send a Session Report Request for a random active session this often (0 = only on SIGUSR1)")
+	seidStrategy := flag.String("seid-strategy", "sequential", "UP SEID allocation: sequential (from 1) or random")
+	malformedRate := flag.Float64("malformed-rate", 0, "Fraction of responses to malform: missing F-SEID or truncated (0-1)")
+	ignoreUnhandled := flag.Bool("ignore-unhandled", false, "Silently drop requests of types the mock does not handle instead of logging an error")
+	echoCause := flag.Int("echo-cause", 0, "Answer every session request with this Cause value, without creating or removing sessions (0 = off)")
+	stateFile := flag.String("state-file", "", "Load the session table and stats from this JSON file on startup and save them to it on shutdown")
+	seed := flag.Int64("seed", 1, "Random seed, for reproducible runs")
 	flag.Parse()
 
-	upf := newMockUPF(*addr)
+	if *rejectRate < 0 || *rejectRate > 1 {
+		log.Fatalf("--reject-rate must be between 0 and 1, got %v", *rejectRate)
+	}
+	if *rejectCause < 2 || *rejectCause > 255 {
+		log.Fatalf("--reject-cause must be a rejection cause (2-255), got %d", *rejectCause)
+	}
+	if *rejectAssociation != 0 && (*rejectAssociation < 2 || *rejectAssociation > 255) {
+		log.Fatalf("--reject-association must be a rejection cause (2-255), got %d", *rejectAssociation)
+	}
+	if *latencyMs < 0 || *jitterMs < 0 {
+		log.Fatalf("--latency-ms and --jitter-ms must not be negative")
+	}
+	if *dropRate < 0 || *dropRate > 1 {
+		log.Fatalf("--drop-rate must be between 0 and 1, got %v", *dropRate)
+	}
+	if *seidStrategy != "sequential" && *seidStrategy != "random" {
+		log.Fatalf("--seid-strategy must be sequential or random, got %q", *seidStrategy)
+	}
+	if *malformedRate < 0 || *malformedRate > 1 {
+		log.Fatalf("--malformed-rate must be between 0 and 1, got %v", *malformedRate)
+	}
+	if *echoCause < 0 || *echoCause > 255 {
+		log.Fatalf("--echo-cause must be a Cause value (1-255), got %d", *echoCause)
+	}
+	indexes, err := parseSessionIndexes(*rejectSessions)
+	if err != nil {
+		log.Fatalf("--reject-sessions: %v", err)
+	}
+
+	upf := newMockUPF(*addr, *seed)
+	upf.rejectRate = *rejectRate
+	upf.rejectCause = uint8(*rejectCause)
+	upf.rejectSessions = indexes
+	upf.rejectAssociation = uint8(*rejectAssociation)
+	upf.latency = time.Duration(*latencyMs) * time.Millisecond
+	upf.jitter = time.Duration(*jitterMs) * time.Millisecond
+	upf.dropRate = *dropRate
+	upf.reportInterval = *reportInterval
+	upf.randomSEIDs = *seidStrategy == "random"
+	upf.malformedRate = *malformedRate
+	upf.ignoreUnhandled = *ignoreUnhandled
+	upf.echoCause = uint8(*echoCause)
+	if *stateFile != "" {
+		if err := upf.loadState(*stateFile); err != nil {
+			log.Fatalf("--state-file: %v", err)
+		}
+		log.Printf("Loaded %d sessions from %s", len(upf.sessions), *stateFile)
+	}
 
 	// Handle graceful shutdown
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
+	// Session Report Requests on a timer, or on demand with SIGUSR1
+	reportCh := make(chan os.Signal, 1)
+	signal.Notify(reportCh, syscall.SIGUSR1)
+	done := make(chan struct{})
+	go upf.sendReports(reportCh, done)
+
+	// Simulated restarts on demand with SIGUSR2
+	restartCh := make(chan os.Signal, 1)
+	signal.Notify(restartCh, syscall.SIGUSR2)
+	go upf.restartOn(restartCh, done)
+
 	go func() {
 		<-sigCh
 		log.Println("Shutting down...")
+		close(done)
 		upf.printStats()
+		if *stateFile != "" {
+			if err := upf.saveState(*stateFile); err != nil {
+				log.Printf("--state-file: %v", err)
+			} else {
+				log.Printf("Saved state to %s", *stateFile)
+			}
+		}
 		upf.conn.Close()
 	}()
 